@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// client makes requests to the API on behalf of a user
+type client struct {
+	url   string
+	token string
+	org   string
+}
+
+type response struct {
+	Code       int             `json:"code"`
+	Message    string          `json:"msg"`
+	Payload    json.RawMessage `json:"payload"`
+	NextCursor string          `json:"nextCursor"`
+}
+
+func (c *client) newRequest(path string, query url.Values) (*http.Request, error) {
+	u := fmt.Sprintf("%s/api/v1/%s/%s", strings.TrimSuffix(c.url, "/"), url.PathEscape(c.org), path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Key "+c.token)
+	return req, nil
+}
+
+// get decodes payload of the response into v, and returns cursor of the next page if there is one
+func (c *client) get(path string, query url.Values, v any) (string, error) {
+	req, err := c.newRequest(path, query)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", err
+	}
+	if r.Code != http.StatusOK {
+		return "", fmt.Errorf("%d %s", r.Code, r.Message)
+	}
+	if err := json.Unmarshal(r.Payload, v); err != nil {
+		return "", err
+	}
+
+	return r.NextCursor, nil
+}
+
+// download copies plain text response into w
+func (c *client) download(path string, query url.Values, w io.Writer) error {
+	req, err := c.newRequest(path, query)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// errors are reported as JSON like any other response
+		var r response
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return errors.New(resp.Status)
+		}
+		return fmt.Errorf("%d %s", r.Code, r.Message)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
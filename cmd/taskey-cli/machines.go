@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// listMachines prints machines of the organization with their presence
+func listMachines(c *client, args []string) error {
+	fs := flag.NewFlagSet("machines", flag.ExitOnError)
+	status := fs.String("status", "", "only machines with status online, stale or offline")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	query := url.Values{}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+
+	var machines []types.Machine
+	if _, err := c.get("machines/", query, &machines); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tLAST SEEN\tVERSION\tHOST")
+	for _, m := range machines {
+		lastSeen := "never"
+		if m.LastSeen != nil {
+			lastSeen = m.LastSeen.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Name, m.Status, lastSeen, m.Version, m.Hostname)
+	}
+	return w.Flush()
+}
@@ -1,9 +1,69 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 )
 
+const (
+	urlEnvKey   = "TASKEY_URL"
+	tokenEnvKey = "TASKEY_TOKEN"
+	orgEnvKey   = "TASKEY_ORGANIZATION"
+)
+
+// errUsage is returned by commands given wrong number of arguments
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	usage string
+	run   func(c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"machines": {usage: "machines [-status online|stale|offline]", run: listMachines},
+	"records":  {usage: "records [-task name] [-limit n] <machine>", run: listRecords},
+	"record":   {usage: "record <machine> <record id>", run: showRecord},
+	"output":   {usage: "output [-stream stdout|stderr] [-step n] <machine> <record id>", run: showOutput},
+	"runs":     {usage: "runs <machine>", run: listRuns},
+	"follow":   {usage: "follow <machine> <run id>", run: followRun},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <command> [arguments]\n\nflags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintln(flag.CommandLine.Output(), "\ncommands:")
+	for _, name := range []string{"machines", "records", "record", "output", "runs", "follow"} {
+		fmt.Fprintln(flag.CommandLine.Output(), "  "+commands[name].usage)
+	}
+}
+
 func main() {
-	fmt.Println("I don't do anything yet")
+	url := flag.String("url", os.Getenv(urlEnvKey), "URL of taskey service, "+urlEnvKey+" by default")
+	token := flag.String("token", os.Getenv(tokenEnvKey), "API token of the user, "+tokenEnvKey+" by default")
+	org := flag.String("org", os.Getenv(orgEnvKey), "organization, "+orgEnvKey+" by default")
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, found := commands[flag.Arg(0)]
+	if !found {
+		usage()
+		os.Exit(2)
+	}
+	if *url == "" || *token == "" || *org == "" {
+		fmt.Fprintln(os.Stderr, "service URL, token and organization must be given")
+		os.Exit(2)
+	}
+
+	c := &client{url: *url, token: *token, org: *org}
+	err := cmd.run(c, flag.Args()[1:])
+	if err == errUsage {
+		fmt.Fprintln(os.Stderr, "usage:", cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func recordPath(machine string, recordID string) string {
+	return fmt.Sprintf("machines/%s/records/%s/", url.PathEscape(machine), url.PathEscape(recordID))
+}
+
+// listRecords prints latest records of a machine, newest first
+func listRecords(c *client, args []string) error {
+	fs := flag.NewFlagSet("records", flag.ExitOnError)
+	task := fs.String("task", "", "only records of this task")
+	limit := fs.Int("limit", 20, "how many records to list")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	query := url.Values{}
+	query.Set("sort", "-executedAt")
+	query.Set("limit", strconv.Itoa(*limit))
+	if *task != "" {
+		query.Set("task", *task)
+	}
+
+	var records []types.Record
+	if _, err := c.get(fmt.Sprintf("machines/%s/records/", url.PathEscape(fs.Arg(0))), query, &records); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTASK\tSTARTED\tDURATION\tSTATUS")
+	for _, r := range records {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.ID, r.TaskName, r.ExecutedAt.Local().Format(time.RFC3339), r.Duration.Duration, status(r.Outcome, r.Status, r.Signal))
+	}
+	return w.Flush()
+}
+
+// showRecord prints details of a record, with stdout and stderr of the record and each of its steps
+func showRecord(c *client, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	var r types.Record
+	if _, err := c.get(recordPath(args[0], args[1]), nil, &r); err != nil {
+		return err
+	}
+
+	fmt.Printf("record:   %d\n", r.ID)
+	fmt.Printf("task:     %s\n", r.TaskName)
+	fmt.Printf("started:  %s\n", r.ExecutedAt.Local().Format(time.RFC3339))
+	fmt.Printf("finished: %s\n", r.FinishedAt.Local().Format(time.RFC3339))
+	fmt.Printf("duration: %s\n", r.Duration.Duration)
+	fmt.Printf("status:   %s\n", status(r.Outcome, r.Status, r.Signal))
+	if r.Error != "" {
+		fmt.Printf("error:    %s\n", r.Error)
+	}
+	printStream("stdout", r.Output, r.OutputTruncated, r.OutputStored)
+	printStream("stderr", r.Stderr, r.StderrTruncated, r.StderrStored)
+
+	for i, step := range r.Steps {
+		fmt.Printf("\nstep %d: %s in %s\n", i+1, status(step.Outcome, step.Code, step.Signal), step.Duration.Duration)
+		if step.Error != "" {
+			fmt.Printf("error: %s\n", step.Error)
+		}
+		printStream("stdout", step.Output, step.OutputTruncated, step.OutputStored)
+		printStream("stderr", step.Stderr, step.StderrTruncated, step.StderrStored)
+	}
+	return nil
+}
+
+// showOutput writes full stdout or stderr of a record or one of its steps as is
+func showOutput(c *client, args []string) error {
+	fs := flag.NewFlagSet("output", flag.ExitOnError)
+	stream := fs.String("stream", "stdout", "stdout or stderr")
+	step := fs.Int("step", 0, "number of the step, starting from 1, instead of the whole record")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	query := url.Values{}
+	query.Set("stream", *stream)
+	if *step > 0 {
+		query.Set("step", strconv.Itoa(*step))
+	}
+
+	return c.download(recordPath(fs.Arg(0), fs.Arg(1))+"output/", query, os.Stdout)
+}
+
+func status(outcome string, code int, signal int) string {
+	switch {
+	case outcome == types.RecordOutcomeSkipped:
+		return "skipped"
+	case outcome == types.RecordOutcomeTimedOut:
+		return "timed out"
+	case signal != 0:
+		return fmt.Sprintf("%d (signal %d)", code, signal)
+	default:
+		return strconv.Itoa(code)
+	}
+}
+
+func printStream(name string, output string, truncated int64, stored bool) {
+	if output == "" {
+		return
+	}
+	fmt.Printf("--- %s ---\n", name)
+	_, _ = io.WriteString(os.Stdout, output)
+	if len(output) > 0 && output[len(output)-1] != '\n' {
+		fmt.Println()
+	}
+	if stored {
+		fmt.Printf("--- %s continues, use output command to see all of it ---\n", name)
+	}
+	if truncated > 0 {
+		fmt.Printf("--- %d bytes of %s were truncated by the machine ---\n", truncated, name)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// listRuns prints runs of a machine in progress
+func listRuns(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var runs []types.Run
+	if _, err := c.get(fmt.Sprintf("machines/%s/runs/", url.PathEscape(args[0])), nil, &runs); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTASK\tSTARTED")
+	for _, r := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.ID, r.TaskName, r.StartedAt.Local().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// followRun prints output of a run as it arrives, stdout to stdout and stderr to stderr, until the run ends
+func followRun(c *client, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	path := fmt.Sprintf("machines/%s/runs/%s/output/", url.PathEscape(args[0]), url.PathEscape(args[1]))
+
+	lastEventID := ""
+	retry := time.Second
+	for {
+		end, err := c.followEvents(path, &lastEventID, &retry)
+		if err != nil {
+			return err
+		}
+		if end != nil {
+			fmt.Fprintf(os.Stderr, "run ended with status %s, see record %d\n", status(end.Outcome, end.Status, 0), end.RecordID)
+			return nil
+		}
+		// server ends the stream every now and then, continue where it left off
+		time.Sleep(retry)
+	}
+}
+
+// followEvents reads one response of server-sent events. End of the run is returned if it was received.
+func (c *client) followEvents(path string, lastEventID *string, retry *time.Duration) (*types.RunEnd, error) {
+	req, err := c.newRequest(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var r response
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return nil, errors.New(resp.Status)
+		}
+		return nil, fmt.Errorf("%d %s", r.Code, r.Message)
+	}
+
+	var id, event, data string
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "id":
+				id = value
+			case "event":
+				event = value
+			case "data":
+				data += value
+			case "retry":
+				var ms int
+				if _, err := fmt.Sscan(value, &ms); err == nil {
+					*retry = time.Duration(ms) * time.Millisecond
+				}
+			}
+			continue
+		}
+
+		// blank line dispatches the event
+		switch event {
+		case "output":
+			var chunk types.OutputChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return nil, err
+			}
+			var w io.Writer = os.Stdout
+			if chunk.Stream == types.StreamStderr {
+				w = os.Stderr
+			}
+			_, _ = io.WriteString(w, chunk.Data)
+		case "end":
+			var end types.RunEnd
+			if err := json.Unmarshal([]byte(data), &end); err != nil {
+				return nil, err
+			}
+			return &end, nil
+		}
+		if id != "" {
+			*lastEventID = id
+		}
+		id, event, data = "", "", ""
+	}
+
+	return nil, s.Err()
+}
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"time"
+)
+
 const (
 	defaultDbHost    = "127.0.0.1"
 	defaultDbPort    = 5432
@@ -7,4 +11,15 @@ const (
 	defaultDbSslMode = "disable"
 
 	defaultHttpPort = 80
+
+	defaultRetentionInterval  = time.Hour
+	defaultRetentionBatchSize = 1000
+	defaultTriggerTimeout     = 24 * time.Hour
+
+	defaultAccessTokenValidity  = 15 * time.Minute
+	defaultRefreshTokenValidity = 30 * 24 * time.Hour
+
+	defaultKeyRotationInterval = 30 * 24 * time.Hour
+	defaultKeyGracePeriod      = 24 * time.Hour
+	defaultKeyCheckInterval    = time.Hour
 )
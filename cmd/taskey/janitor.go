@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+)
+
+// janitor periodically deletes records exceeding retention policies and tokens which have expired,
+// and fails triggers which machines have not reported a result for
+type janitor struct {
+	d              db.Controller
+	interval       time.Duration
+	batchSize      int
+	triggerTimeout time.Duration
+}
+
+func newJanitor(d db.Controller, interval time.Duration, triggerTimeout time.Duration) *janitor {
+	return &janitor{
+		d:              d,
+		interval:       interval,
+		batchSize:      defaultRetentionBatchSize,
+		triggerTimeout: triggerTimeout,
+	}
+}
+
+// run prunes records once right away and then every interval, until ctx is done
+func (j *janitor) run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.prune(ctx)
+		j.pruneTokens()
+		j.failStaleTriggers()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune enforces every retention policy, and logs how many records were deleted under each
+func (j *janitor) prune(ctx context.Context) {
+	policies, err := j.d.ReadRetentionPolicies()
+	if err != nil {
+		log.Println("janitor: error reading retention policies:", err)
+		return
+	}
+
+	start := time.Now()
+	var total int64
+	for i := range policies {
+		p := &policies[i]
+
+		var pruned int64
+		for ctx.Err() == nil {
+			// deleting in batches keeps transactions short, so that records can be written meanwhile
+			n, err := j.d.PruneRecords(p, start, j.batchSize)
+			if err != nil {
+				log.Println("janitor: error pruning records of", j.describe(p), ":", err)
+				break
+			}
+			pruned += n
+			if n < int64(j.batchSize) {
+				break
+			}
+		}
+
+		if pruned > 0 {
+			log.Println("janitor: pruned", pruned, "record(s) of", j.describe(p))
+		}
+		total += pruned
+	}
+
+	log.Println("janitor: pruned", total, "record(s) under", len(policies), "retention policy(s) in", time.Since(start))
+}
+
+// pruneTokens deletes expired refresh tokens, password resets and token revocations
+func (j *janitor) pruneTokens() {
+	n, err := j.d.PruneExpiredTokens(time.Now())
+	if err != nil {
+		log.Println("janitor: error pruning expired tokens:", err)
+		return
+	}
+	log.Println("janitor: pruned", n, "expired token(s)")
+}
+
+// failStaleTriggers fails triggers which have been running longer than the trigger timeout
+func (j *janitor) failStaleTriggers() {
+	n, err := j.d.FailStaleTriggers(time.Now().Add(-j.triggerTimeout))
+	if err != nil {
+		log.Println("janitor: error failing stale triggers:", err)
+		return
+	}
+	log.Println("janitor: failed", n, "stale trigger(s)")
+}
+
+// describe names what policy applies to, for logging
+func (j *janitor) describe(p *db.RetentionPolicy) string {
+	var o db.Organization
+	if err := j.d.LoadModel(&o, p.OrganizationID); err != nil {
+		o.Name = fmt.Sprint("#", p.OrganizationID)
+	}
+	if p.TaskID == 0 {
+		return fmt.Sprintf("organization %s", o.Name)
+	}
+
+	var t db.Task
+	if err := j.d.LoadModel(&t, p.TaskID); err != nil {
+		t.Name = fmt.Sprint("#", p.TaskID)
+	}
+	return fmt.Sprintf("task %s of organization %s", t.Name, o.Name)
+}
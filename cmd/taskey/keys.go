@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/internal/auth"
+	"github.com/LassiHeikkila/taskey/internal/db"
+)
+
+// keyRotator keeps the signing keys of the auth controller up to date with the database.
+// New key is created ahead of time every interval, and old keys are deleted once grace period after they were replaced is over.
+type keyRotator struct {
+	a auth.Controller
+	d db.Controller
+
+	algorithm string
+	// signing keys are encrypted with this in the database
+	encryptionKey []byte
+	interval      time.Duration
+	grace         time.Duration
+	checkInterval time.Duration
+}
+
+func newKeyRotator(a auth.Controller, d db.Controller, algorithm string, encryptionKey []byte, interval time.Duration, grace time.Duration) *keyRotator {
+	return &keyRotator{
+		a:             a,
+		d:             d,
+		algorithm:     algorithm,
+		encryptionKey: encryptionKey,
+		interval:      interval,
+		grace:         grace,
+		checkInterval: defaultKeyCheckInterval,
+	}
+}
+
+// run rotates keys every checkInterval until ctx is done.
+// rotate should be called once before, so that there is a key to sign with from the start.
+func (r *keyRotator) run(ctx context.Context) {
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.rotate(time.Now()); err != nil {
+			log.Println("key rotator: error rotating signing keys:", err)
+		}
+	}
+}
+
+// rotate loads keys from database, creates the next key if it's due and deletes keys which aren't needed anymore.
+// Keys of other instances are picked up as well, which is why next key is created two checks before it activates.
+func (r *keyRotator) rotate(now time.Time) error {
+	stored, err := r.d.ReadSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]auth.SigningKey, 0, len(stored)+1)
+	for i := range stored {
+		s := &stored[i]
+		// stored is ordered by activation, so key was replaced when the one before it activated
+		if i > 0 && !stored[i-1].ActivatesAt.After(now.Add(-r.grace)) {
+			if err := r.d.DeleteSigningKey(s.KeyID); err != nil {
+				return err
+			}
+			log.Println("key rotator: deleted signing key", s.KeyID)
+			continue
+		}
+
+		k, err := auth.DecryptSigningKey(r.encryptionKey, s.KeyID, s.Algorithm, s.ActivatesAt, s.PrivateKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, *k)
+	}
+
+	var activatesAt time.Time
+	switch {
+	case len(keys) == 0 || keys[0].Algorithm != r.algorithm:
+		// nothing to sign with, or algorithm was changed
+		activatesAt = now
+	case !keys[0].ActivatesAt.Add(r.interval).After(now.Add(2 * r.checkInterval)):
+		activatesAt = keys[0].ActivatesAt.Add(r.interval)
+		if activatesAt.Before(now) {
+			activatesAt = now
+		}
+	}
+	if !activatesAt.IsZero() {
+		k, err := auth.GenerateSigningKey(r.algorithm, activatesAt)
+		if err != nil {
+			return err
+		}
+		sealed, err := auth.EncryptSigningKey(r.encryptionKey, k)
+		if err != nil {
+			return err
+		}
+		err = r.d.CreateSigningKey(&db.SigningKey{
+			KeyID:       k.ID,
+			Algorithm:   k.Algorithm,
+			PrivateKey:  sealed,
+			ActivatesAt: k.ActivatesAt,
+		})
+		if err != nil {
+			return err
+		}
+		log.Println("key rotator: created signing key", k.ID, "activating at", k.ActivatesAt)
+		keys = append([]auth.SigningKey{*k}, keys...)
+	}
+
+	return r.a.SetSigningKeys(keys)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"flag"
@@ -22,15 +23,24 @@ import (
 )
 
 const (
-	dbHostEnvKey             = "TASKEYDBHOST"
-	dbPortEnvKey             = "TASKEYDBPORT"
-	dbUserEnvKey             = "TASKEYDBUSER"
-	dbPasswordEnvKey         = "TASKEYDBPASSWORD"
-	dbDbEnvKey               = "TASKEYDBDB"
-	dbSslModeEnvKey          = "TASKEYDBSSLMODE"
-	dbUrlEnvKey              = "DATABASE_URL"
-	jwtKeyEnvKey             = "TASKEYJWTKEY"
-	allowedCORSOriginsEnvKey = "TASKEYCORSORIGINS"
+	dbHostEnvKey               = "TASKEYDBHOST"
+	dbPortEnvKey               = "TASKEYDBPORT"
+	dbUserEnvKey               = "TASKEYDBUSER"
+	dbPasswordEnvKey           = "TASKEYDBPASSWORD"
+	dbDbEnvKey                 = "TASKEYDBDB"
+	dbSslModeEnvKey            = "TASKEYDBSSLMODE"
+	dbUrlEnvKey                = "DATABASE_URL"
+	jwtKeyEnvKey               = "TASKEYJWTKEY"
+	jwtEncryptionKeyEnvKey     = "TASKEYJWTENCRYPTIONKEY"
+	secretsKeyEnvKey           = "TASKEYSECRETSKEY"
+	allowedCORSOriginsEnvKey   = "TASKEYCORSORIGINS"
+	retentionIntervalEnvKey    = "TASKEYRETENTIONINTERVAL"
+	accessTokenValidityEnvKey  = "TASKEYACCESSTOKENVALIDITY"
+	refreshTokenValidityEnvKey = "TASKEYREFRESHTOKENVALIDITY"
+	jwtAlgorithmEnvKey         = "TASKEYJWTALGORITHM"
+	jwtRotationIntervalEnvKey  = "TASKEYJWTROTATIONINTERVAL"
+	jwtGracePeriodEnvKey       = "TASKEYJWTGRACEPERIOD"
+	triggerTimeoutEnvKey       = "TASKEYTRIGGERTIMEOUT"
 )
 
 var (
@@ -45,7 +55,18 @@ var (
 	dbUrl = os.Getenv(dbUrlEnvKey)
 
 	privateKey         = os.Getenv(jwtKeyEnvKey)
+	secretsKey         = os.Getenv(secretsKeyEnvKey)
 	allowedCORSOrigins = os.Getenv(allowedCORSOriginsEnvKey)
+	retentionInterval  = os.Getenv(retentionIntervalEnvKey)
+	triggerTimeout     = os.Getenv(triggerTimeoutEnvKey)
+
+	accessTokenValidity  = os.Getenv(accessTokenValidityEnvKey)
+	refreshTokenValidity = os.Getenv(refreshTokenValidityEnvKey)
+
+	jwtAlgorithm        = getEnvOrDefault(jwtAlgorithmEnvKey, auth.AlgorithmHS256)
+	jwtEncryptionKey    = os.Getenv(jwtEncryptionKeyEnvKey)
+	jwtRotationInterval = os.Getenv(jwtRotationIntervalEnvKey)
+	jwtGracePeriod      = os.Getenv(jwtGracePeriodEnvKey)
 
 	httpPort = defaultHttpPort
 )
@@ -106,6 +127,22 @@ func run(ctx context.Context) int {
 
 	log.Println("db handler initialized")
 
+	interval := defaultRetentionInterval
+	if retentionInterval != "" {
+		v, err := time.ParseDuration(retentionInterval)
+		if err != nil || v <= 0 {
+			log.Println("TASKEYRETENTIONINTERVAL is not a valid duration!")
+			return 1
+		}
+		interval = v
+	}
+	staleAfter, err := parseDurationOrDefault(triggerTimeout, defaultTriggerTimeout)
+	if err != nil || staleAfter <= 0 {
+		log.Println("TASKEYTRIGGERTIMEOUT is not a valid duration!")
+		return 1
+	}
+	go newJanitor(c, interval, staleAfter).run(ctx)
+
 	privKey, err := hex.DecodeString(privateKey)
 	if err != nil {
 		log.Println("TASKEYJWTKEY not in hex encoded format!")
@@ -126,6 +163,52 @@ func run(ctx context.Context) int {
 		return 1
 	}
 
+	access, err := parseDurationOrDefault(accessTokenValidity, defaultAccessTokenValidity)
+	if err != nil {
+		log.Println("TASKEYACCESSTOKENVALIDITY is not a valid duration!")
+		return 1
+	}
+	refresh, err := parseDurationOrDefault(refreshTokenValidity, defaultRefreshTokenValidity)
+	if err != nil {
+		log.Println("TASKEYREFRESHTOKENVALIDITY is not a valid duration!")
+		return 1
+	}
+	if err := h.SetTokenValidity(access, refresh); err != nil {
+		log.Println("invalid token validity:", err)
+		return 1
+	}
+	if jwtAlgorithm != auth.AlgorithmHS256 {
+		// TASKEYJWTKEY only protects the signing keys stored in database then,
+		// unless a dedicated TASKEYJWTENCRYPTIONKEY is given
+		encryptionKey, err := keyEncryptionKey(jwtEncryptionKey, privKey)
+		if err != nil {
+			log.Println("unable to protect signing keys:", err)
+			return 1
+		}
+		rotation, err := parseDurationOrDefault(jwtRotationInterval, defaultKeyRotationInterval)
+		if err != nil || rotation <= 2*defaultKeyCheckInterval {
+			log.Println("TASKEYJWTROTATIONINTERVAL is not a valid duration, or shorter than", 2*defaultKeyCheckInterval)
+			return 1
+		}
+		grace, err := parseDurationOrDefault(jwtGracePeriod, defaultKeyGracePeriod)
+		if err != nil || grace < access {
+			log.Println("TASKEYJWTGRACEPERIOD is not a valid duration, or shorter than access token validity")
+			return 1
+		}
+
+		r := newKeyRotator(a, c, jwtAlgorithm, encryptionKey, rotation, grace)
+		if err := r.rotate(time.Now()); err != nil {
+			log.Println("failed to set up signing keys:", err)
+			return 1
+		}
+		go r.run(ctx)
+		log.Println("signing tokens with", jwtAlgorithm, "keys rotated every", rotation)
+	}
+	if err := h.LoadTokenRevocations(); err != nil {
+		log.Println("failed to load token revocations:", err)
+		return 1
+	}
+
 	if err := h.RegisterOrganizationHandlers(); err != nil {
 		log.Println("failed to register organization routes!")
 		return 1
@@ -150,6 +233,27 @@ func run(ctx context.Context) int {
 		log.Println("failed to register record routes!")
 		return 1
 	}
+	if err := h.RegisterTriggerHandlers(); err != nil {
+		log.Println("failed to register trigger routes!")
+		return 1
+	}
+	if err := h.RegisterGroupHandlers(); err != nil {
+		log.Println("failed to register group routes!")
+		return 1
+	}
+	if secretsKey != "" {
+		key, err := hex.DecodeString(secretsKey)
+		if err != nil {
+			log.Println("TASKEYSECRETSKEY not in hex encoded format!")
+			return 1
+		}
+		if err := h.RegisterSecretHandlers(key); err != nil {
+			log.Println("failed to register secret routes:", err)
+			return 1
+		}
+	} else {
+		log.Println("TASKEYSECRETSKEY not set, secrets are disabled")
+	}
 	if err := h.RegisterAuthenticationHandlers(); err != nil {
 		log.Println("failed to register authentication routes!")
 		return 1
@@ -243,6 +347,25 @@ func validateArgs() error {
 	return nil
 }
 
+// keyEncryptionKey derives the key which signing keys are encrypted with in database.
+// Dedicated encryption key is preferred, JWT key is used if it isn't given.
+// Either must be set, otherwise the keys would be encrypted with a publicly known key.
+func keyEncryptionKey(encryptionKey string, jwtKey []byte) ([]byte, error) {
+	secret := jwtKey
+	if encryptionKey != "" {
+		k, err := hex.DecodeString(encryptionKey)
+		if err != nil {
+			return nil, errors.New("TASKEYJWTENCRYPTIONKEY not in hex encoded format")
+		}
+		secret = k
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("neither TASKEYJWTENCRYPTIONKEY nor TASKEYJWTKEY is set")
+	}
+	sum := sha256.Sum256(secret)
+	return sum[:], nil
+}
+
 func getEnvOrDefault(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -259,6 +382,13 @@ func getEnvOrDefaultInt(key string, def int) int {
 	return i
 }
 
+func parseDurationOrDefault(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
 func parseAllowedOrigins(confString string) []string {
 	return strings.Split(confString, ",")
 }
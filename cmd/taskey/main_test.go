@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestKeyEncryptionKey(t *testing.T) {
+	if _, err := keyEncryptionKey("", nil); err == nil {
+		t.Fatal("signing keys would be encrypted without a secret")
+	}
+	if _, err := keyEncryptionKey("", []byte{}); err == nil {
+		t.Fatal("signing keys would be encrypted with an empty secret")
+	}
+	if _, err := keyEncryptionKey("not hex", []byte("jwt key")); err == nil {
+		t.Fatal("malformed encryption key accepted")
+	}
+
+	jwtSum := sha256.Sum256([]byte("jwt key"))
+	k, err := keyEncryptionKey("", []byte("jwt key"))
+	if err != nil || !bytes.Equal(k, jwtSum[:]) {
+		t.Fatal("JWT key not used when encryption key isn't set:", err)
+	}
+
+	encSum := sha256.Sum256([]byte{0x01, 0x02, 0x03})
+	k, err = keyEncryptionKey("010203", []byte("jwt key"))
+	if err != nil || !bytes.Equal(k, encSum[:]) {
+		t.Fatal("dedicated encryption key not preferred:", err)
+	}
+	k, err = keyEncryptionKey("010203", nil)
+	if err != nil || !bytes.Equal(k, encSum[:]) {
+		t.Fatal("dedicated encryption key not enough on its own:", err)
+	}
+}
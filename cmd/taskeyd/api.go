@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	stdjson "encoding/json"
 	"errors"
 	"fmt"
@@ -39,7 +40,7 @@ var (
 		PeriodicTasks: []types.PeriodicTask{
 			types.PeriodicTask{
 				What:     "task456",
-				Interval: json.Duration{mustParseDuration("3m")},
+				Interval: json.Duration{Duration: mustParseDuration("3m")},
 			},
 		},
 		CronTasks: []types.CronTask{
@@ -67,13 +68,14 @@ var (
 		"task456": &types.Task{
 			Name:        "task456",
 			Description: "example task 456",
-			Content: &types.CmdTask{
+			Content: &types.HttpTask{
 				TaskProperties: types.TaskProperties{
-					Type:           types.TaskTypeCmd,
-					CombinedOutput: false,
+					Type:    types.TaskTypeHttp,
+					Timeout: json.Duration{Duration: 10 * time.Second},
 				},
-				Program: "/usr/bin/curl",
-				Args:    []string{"https://taskey-service.herokuapp.com/api/v1/health/"},
+				Method:         http.MethodGet,
+				URL:            "https://taskey-service.herokuapp.com/api/v1/health/",
+				ExpectedStatus: []int{http.StatusOK},
 			},
 		},
 		"task789": &types.Task{
@@ -112,7 +114,44 @@ func doGetRequest(req *http.Request, v any) error {
 	return nil
 }
 
-func fetchSchedule(token string, url string, org string) (*types.Schedule, error) {
+// errNotModified is returned by conditional fetches when the content has not changed since last time
+var errNotModified = errors.New("not modified")
+
+// doConditionalGetRequest works like doGetRequest, but if etag is not nil,
+// the request is only fulfilled if the content has changed since the given ETag.
+// On success, etag is updated to match the received content.
+func doConditionalGetRequest(req *http.Request, etag *string, v any) error {
+	if etag != nil && *etag != "" {
+		req.Header.Set("If-None-Match", *etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return errNotModified
+	}
+
+	dec := stdjson.NewDecoder(resp.Body)
+
+	err = dec.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if etag != nil {
+		*etag = resp.Header.Get("ETag")
+	}
+
+	return nil
+}
+
+// fetchSchedule fetches the schedule of the machine.
+// If etag is not nil, errNotModified is returned when the schedule has not changed.
+func fetchSchedule(token string, url string, org string, etag *string) (*types.Schedule, error) {
 	if token == "" && url == "" {
 		return dummySchedule, nil
 	}
@@ -138,7 +177,7 @@ func fetchSchedule(token string, url string, org string) (*types.Schedule, error
 
 	var resp scheduleResponse
 
-	err = doGetRequest(req, &resp)
+	err = doConditionalGetRequest(req, etag, &resp)
 	if err != nil {
 		return nil, err
 	}
@@ -150,7 +189,9 @@ func fetchSchedule(token string, url string, org string) (*types.Schedule, error
 	return resp.Payload, nil
 }
 
-func fetchTasks(token string, url string, org string) (map[string]*types.Task, error) {
+// fetchTasks fetches the task definitions available to the machine.
+// If etag is not nil, errNotModified is returned when the tasks have not changed.
+func fetchTasks(token string, url string, org string, etag *string) (map[string]*types.Task, error) {
 	if token == "" && url == "" {
 		return dummyTasks, nil
 	}
@@ -176,7 +217,7 @@ func fetchTasks(token string, url string, org string) (map[string]*types.Task, e
 
 	var resp tasksResponse
 
-	err = doGetRequest(req, &resp)
+	err = doConditionalGetRequest(req, etag, &resp)
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +235,88 @@ func fetchTasks(token string, url string, org string) (map[string]*types.Task, e
 	return m, nil
 }
 
+func fetchTriggers(token string, url string, org string) ([]types.Trigger, error) {
+	if token == "" && url == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/self/triggers/",
+			url, org,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	setAuthorizationHeader(req, token)
+
+	type triggersResponse struct {
+		Code    int             `json:"code"`
+		Message string          `json:"msg"`
+		Payload []types.Trigger `json:"payload"`
+	}
+
+	var resp triggersResponse
+
+	err = doGetRequest(req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != http.StatusOK {
+		return nil, fmt.Errorf("non-ok response: %d", resp.Code)
+	}
+
+	return resp.Payload, nil
+}
+
+// fetchTaskSecrets fetches values of secrets referenced by the task, keyed by name
+func fetchTaskSecrets(token string, url string, org string, taskName string) (map[string]string, error) {
+	if token == "" && url == "" {
+		return map[string]string{}, nil
+	}
+
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/self/tasks/%s/secrets/",
+			url, org, taskName,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	setAuthorizationHeader(req, token)
+
+	type secretsResponse struct {
+		Code    int            `json:"code"`
+		Message string         `json:"msg"`
+		Payload []types.Secret `json:"payload"`
+	}
+
+	var resp secretsResponse
+
+	err = doGetRequest(req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Code != http.StatusOK {
+		return nil, fmt.Errorf("non-ok response: %d", resp.Code)
+	}
+
+	secrets := make(map[string]string, len(resp.Payload))
+	for _, secret := range resp.Payload {
+		secrets[secret.Name] = secret.Value
+	}
+
+	return secrets, nil
+}
+
 func getValue[V any](m map[string]interface{}, key string) V {
 	val, ok := m[key]
 	if !ok {
@@ -228,6 +351,24 @@ func getSlice[V any](m map[string]interface{}, key string) []V {
 	return r
 }
 
+func getStringMap(m map[string]interface{}, key string) map[string]string {
+	v := getValue[map[string]interface{}](m, key)
+	if v == nil {
+		return nil
+	}
+
+	r := make(map[string]string, len(v))
+	for k, item := range v {
+		s, ok := item.(string)
+		if !ok {
+			continue
+		}
+		r[k] = s
+	}
+
+	return r
+}
+
 func unmarshalTask(v map[string]interface{}) *types.Task {
 	// TODO: fix unsafe unmarshalling
 	name := getValue[string](v, "name")
@@ -235,91 +376,289 @@ func unmarshalTask(v map[string]interface{}) *types.Task {
 
 	c := getValue[map[string]interface{}](v, "content")
 
-	tp := getValue[string](c, "type")
-	combo := getValue[bool](c, "combinedOutput")
+	return &types.Task{
+		Name:        name,
+		Description: description,
+		Content:     unmarshalTaskContent(c),
+	}
+}
+
+func unmarshalTaskProperties(c map[string]interface{}) types.TaskProperties {
+	timeout, _ := time.ParseDuration(getValue[string](c, "timeout"))
+	return types.TaskProperties{
+		Type:            getValue[string](c, "type"),
+		CombinedOutput:  getValue[bool](c, "combinedOutput"),
+		ContinueOnError: getValue[bool](c, "continueOnError"),
+		Timeout:         json.Duration{Duration: timeout},
+		Env:             getStringMap(c, "env"),
+		WorkingDir:      getValue[string](c, "workingDir"),
+		User:            getValue[string](c, "user"),
+		Group:           getValue[string](c, "group"),
+		Secrets:         getSlice[string](c, "secrets"),
+	}
+}
+
+func unmarshalTaskContent(c map[string]interface{}) any {
+	props := unmarshalTaskProperties(c)
 
 	var content any
-	switch tp {
+	switch props.Type {
 	case types.TaskTypeCmd:
 		program := getValue[string](c, "program")
 		args := getSlice[string](c, "args")
 		content = &types.CmdTask{
-			TaskProperties: types.TaskProperties{
-				Type:           tp,
-				CombinedOutput: combo,
-			},
-			Program: program,
-			Args:    args,
+			TaskProperties: props,
+			Program:        program,
+			Args:           args,
 		}
 	case types.TaskTypeScript:
 		interpreter := getValue[string](c, "interpreter")
 		scriptBody := getValue[string](c, "script")
 		content = &types.ScriptTask{
-			TaskProperties: types.TaskProperties{
-				Type:           tp,
-				CombinedOutput: combo,
-			},
-			Interpreter: interpreter,
-			Script:      scriptBody,
+			TaskProperties: props,
+			Interpreter:    interpreter,
+			Script:         scriptBody,
+		}
+	case types.TaskTypeHttp:
+		method := getValue[string](c, "method")
+		u := getValue[string](c, "url")
+		headers := getStringMap(c, "headers")
+		form := getStringMap(c, "form")
+		body := getValue[string](c, "body")
+		expectedStatus := make([]int, 0)
+		// numbers in unmarshalled JSON are always float64
+		for _, code := range getSlice[float64](c, "expectedStatus") {
+			expectedStatus = append(expectedStatus, int(code))
+		}
+		content = &types.HttpTask{
+			TaskProperties: props,
+			Method:         method,
+			URL:            u,
+			Headers:        headers,
+			Form:           form,
+			Body:           body,
+			ExpectedStatus: expectedStatus,
+		}
+	case types.TaskTypeMulti:
+		actions := make([]any, 0)
+		for _, a := range getSlice[map[string]interface{}](c, "actions") {
+			actions = append(actions, unmarshalTaskContent(a))
+		}
+		content = &types.MultiTask{
+			TaskProperties: props,
+			Actions:        actions,
 		}
 	}
 
-	return &types.Task{
-		Name:        name,
-		Description: description,
-		Content:     content,
-	}
+	return content
+}
+
+// rejectedError is returned when server refuses to accept a request, and retrying it won't help
+type rejectedError struct {
+	code int
 }
 
-func postResult(token string, url string, org string, record *types.Record) error {
-	if record == nil {
-		return errors.New("nil record")
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("rejected with response: %d", e.code)
+}
+
+// rejected tells if a request refused by the server with code won't succeed when retried.
+// Authentication failures and rate limiting are temporary, e.g. until the machine is given a new token.
+func rejected(code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	default:
+		return code >= 400 && code < 500
 	}
+}
 
+// postResults uploads records in a single batch.
+// Error is returned if the batch as a whole failed, otherwise result of each record is returned in the same order.
+// Records the server found invalid get a *rejectedError. The batch as a whole is never considered rejected,
+// as refusing all of it is more likely caused by the machine's credentials or load on the server than by the records.
+func postResults(token string, url string, org string, records []types.Record) ([]error, error) {
 	if token == "" && url == "" {
-		log.Println("posting record:", *record)
-		return nil
+		for i := range records {
+			log.Println("posting record:", records[i])
+		}
+		return make([]error, len(records)), nil
 	}
 
 	body := bytes.Buffer{}
 	enc := stdjson.NewEncoder(&body)
 
-	err := enc.Encode(record)
+	err := enc.Encode(records)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := http.NewRequest(
 		http.MethodPost,
 		fmt.Sprintf(
-			"%s/api/v1/%s/machines/self/records/",
+			"%s/api/v1/%s/machines/self/records/batch/",
 			url, org,
 		),
 		&body,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	setAuthorizationHeader(req, token)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	type response struct {
-		Code    int    `json:"code"`
-		Message string `json:"msg"`
+		Code    int                  `json:"code"`
+		Message string               `json:"msg"`
+		Payload []types.RecordResult `json:"payload"`
 	}
 
 	dec := stdjson.NewDecoder(resp.Body)
 	var v response
 	err = dec.Decode(&v)
 	if err != nil {
+		return nil, err
+	}
+
+	if v.Code != http.StatusOK {
+		return nil, fmt.Errorf("non-ok response: %d", v.Code)
+	}
+	if len(v.Payload) != len(records) {
+		return nil, fmt.Errorf("got %d results for %d records", len(v.Payload), len(records))
+	}
+
+	errs := make([]error, len(records))
+	for i, result := range v.Payload {
+		switch {
+		case result.Code == http.StatusOK:
+		case result.Code == http.StatusBadRequest || result.Code == http.StatusNotFound:
+			// record is invalid, or refers to a task or trigger which doesn't exist anymore
+			errs[i] = &rejectedError{code: result.Code}
+		default:
+			errs[i] = fmt.Errorf("non-ok response: %d", result.Code)
+		}
+	}
+
+	return errs, nil
+}
+
+// startRun tells server that a task is starting, and returns ID for streaming its output
+func startRun(ctx context.Context, token string, url string, org string, run *types.Run) (string, error) {
+	if token == "" && url == "" {
+		return "", errors.New("no server to stream output to")
+	}
+
+	body := bytes.Buffer{}
+	if err := stdjson.NewEncoder(&body).Encode(run); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/self/runs/",
+			url, org,
+		),
+		&body,
+	)
+	if err != nil {
+		return "", err
+	}
+	setAuthorizationHeader(req, token)
+
+	type response struct {
+		Code    int       `json:"code"`
+		Message string    `json:"msg"`
+		Payload types.Run `json:"payload"`
+	}
+
+	var v response
+	if err := doGetRequest(req, &v); err != nil {
+		return "", err
+	}
+	if v.Code != http.StatusOK {
+		return "", fmt.Errorf("non-ok response: %d", v.Code)
+	}
+
+	return v.Payload.ID, nil
+}
+
+// postRunOutput uploads output of a run in progress.
+// *rejectedError is returned if server no longer accepts output of the run.
+func postRunOutput(ctx context.Context, token string, url string, org string, runID string, chunks []types.OutputChunk) error {
+	body := bytes.Buffer{}
+	if err := stdjson.NewEncoder(&body).Encode(chunks); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/self/runs/%s/output/",
+			url, org, runID,
+		),
+		&body,
+	)
+	if err != nil {
+		return err
+	}
+	setAuthorizationHeader(req, token)
+
+	type response struct {
+		Code    int    `json:"code"`
+		Message string `json:"msg"`
+	}
+
+	var v response
+	if err := doGetRequest(req, &v); err != nil {
+		return err
+	}
+	if rejected(v.Code) {
+		return &rejectedError{code: v.Code}
+	}
+	if v.Code != http.StatusOK {
+		return fmt.Errorf("non-ok response: %d", v.Code)
+	}
+
+	return nil
+}
+
+// postHeartbeat tells the server this machine is alive, and how it is doing
+func postHeartbeat(token string, url string, org string, heartbeat *types.Heartbeat) error {
+	body := bytes.Buffer{}
+	if err := stdjson.NewEncoder(&body).Encode(heartbeat); err != nil {
 		return err
 	}
 
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/self/heartbeat/",
+			url, org,
+		),
+		&body,
+	)
+	if err != nil {
+		return err
+	}
+	setAuthorizationHeader(req, token)
+
+	type response struct {
+		Code    int    `json:"code"`
+		Message string `json:"msg"`
+	}
+
+	var v response
+	if err := doGetRequest(req, &v); err != nil {
+		return err
+	}
 	if v.Code != http.StatusOK {
 		return fmt.Errorf("non-ok response: %d", v.Code)
 	}
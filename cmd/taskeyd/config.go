@@ -9,6 +9,15 @@ type Config struct {
 	URL          string `json:"serviceURL"`
 	AccessToken  string `json:"accessToken"`
 	Organization string `json:"organization"`
+
+	// where records are kept until they are delivered, defaults to user cache directory
+	QueuePath string `json:"queuePath,omitempty"`
+	// how many records are kept if server can't be reached, oldest are dropped first
+	MaxQueuedRecords int `json:"maxQueuedRecords,omitempty"`
+	// how many bytes of output are kept per task or action, beginning and end are kept when it is exceeded
+	MaxOutputBytes int `json:"maxOutputBytes,omitempty"`
+	// output of running tasks is streamed to the server unless disabled
+	DisableLiveOutput bool `json:"disableLiveOutput,omitempty"`
 }
 
 func loadConfig(path string, c *Config) error {
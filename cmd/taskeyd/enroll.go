@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// enroll registers this machine using an enrollment token, and writes configuration with the token it receives
+func enroll(args []string) error {
+	hostname, _ := os.Hostname()
+
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	url := fs.String("url", "", "URL of taskey service")
+	org := fs.String("org", "", "organization to enroll to")
+	token := fs.String("token", "", "enrollment token")
+	name := fs.String("name", hostname, "name of the machine")
+	description := fs.String("description", "", "description of the machine")
+	conf := fs.String("c", "", "path to write configuration JSON file to")
+	force := fs.Bool("force", false, "overwrite existing configuration file")
+	_ = fs.Parse(args)
+
+	if *url == "" || *org == "" || *token == "" || *name == "" || *conf == "" {
+		fs.Usage()
+		return errors.New("url, organization, token, name and configuration path must be given")
+	}
+	if !*force {
+		if _, err := os.Stat(*conf); err == nil {
+			return fmt.Errorf("configuration file %s exists already, use -force to overwrite it", *conf)
+		}
+	}
+
+	credential, err := postEnrollment(*token, strings.TrimSuffix(*url, "/"), *org, &types.Enrollment{
+		Name:        *name,
+		Description: *description,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Hostname:    hostname,
+	})
+	if err != nil {
+		return err
+	}
+
+	c := Config{
+		URL:          strings.TrimSuffix(*url, "/"),
+		AccessToken:  string(credential.AccessToken),
+		Organization: credential.Organization,
+	}
+	if err := writeConfig(*conf, &c); err != nil {
+		return err
+	}
+
+	log.Println("enrolled as machine", credential.Machine+", configuration written to", *conf)
+	return nil
+}
+
+// writeConfig writes configuration readable only by the current user, as it contains the access token
+func writeConfig(path string, c *Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := stdjson.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// postEnrollment registers machine using enrollment token, and returns the credential it is given
+func postEnrollment(token string, url string, org string, enrollment *types.Enrollment) (*types.MachineCredential, error) {
+	body := bytes.Buffer{}
+	if err := stdjson.NewEncoder(&body).Encode(enrollment); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf(
+			"%s/api/v1/%s/machines/enroll/",
+			url, org,
+		),
+		&body,
+	)
+	if err != nil {
+		return nil, err
+	}
+	setAuthorizationHeader(req, token)
+
+	type response struct {
+		Code    int                     `json:"code"`
+		Message string                  `json:"msg"`
+		Payload types.MachineCredential `json:"payload"`
+	}
+
+	var v response
+	if err := doGetRequest(req, &v); err != nil {
+		return nil, err
+	}
+	switch v.Code {
+	case http.StatusOK:
+		return &v.Payload, nil
+	case http.StatusUnauthorized:
+		return nil, errors.New("enrollment token is invalid, expired or used up")
+	case http.StatusConflict:
+		return nil, fmt.Errorf("machine called %s exists already", enrollment.Name)
+	default:
+		return nil, fmt.Errorf("enrollment failed: %d %s", v.Code, v.Message)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// how often the server is told this machine is alive, well within the window it considers machines online
+const heartbeatInterval = 30 * time.Second
+
+var (
+	// version of taskeyd, set at build time with -ldflags "-X main.version=..."
+	version = ""
+	// when taskeyd was started, to report uptime
+	startedAt = time.Now()
+)
+
+// daemonVersion returns version of taskeyd, falling back to the revision it was built from
+func daemonVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	if info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func newHeartbeat(status string, r *reconciler) *types.Heartbeat {
+	hostname, _ := os.Hostname()
+	return &types.Heartbeat{
+		Status:       status,
+		Version:      daemonVersion(),
+		Uptime:       json.Duration{Duration: time.Since(startedAt).Round(time.Second)},
+		ScheduleHash: r.scheduleHash(),
+		Hostname:     hostname,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+	}
+}
+
+// sendHeartbeats reports this machine online until ctx is cancelled
+func sendHeartbeats(ctx context.Context, r *reconciler) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := postHeartbeat(config.AccessToken, config.URL, config.Organization, newHeartbeat(types.MachineStatusOnline, r)); err != nil {
+			log.Println("error sending heartbeat:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendOfflineHeartbeat tells the server this machine is shutting down,
+// so that it is shown offline right away instead of after heartbeats stop arriving
+func sendOfflineHeartbeat(r *reconciler) {
+	if err := postHeartbeat(config.AccessToken, config.URL, config.Organization, newHeartbeat(types.MachineStatusOffline, r)); err != nil {
+		log.Println("error sending offline heartbeat:", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	// how often output of a running task is sent to the server
+	liveOutputInterval = time.Second
+	// how much output waits to be sent at most, output exceeding it is dropped from live view
+	maxPendingLiveOutput = 256 << 10
+	// how long registering the run or sending its output may take, so that a slow server doesn't hold up tasks
+	liveRequestTimeout = 5 * time.Second
+)
+
+// liveKey is the context key for live output of a task run
+type liveKey struct{}
+
+// liveOutputFrom returns live output of the run ctx belongs to, or nil if output is not streamed
+func liveOutputFrom(ctx context.Context) *liveOutput {
+	l, _ := ctx.Value(liveKey{}).(*liveOutput)
+	return l
+}
+
+// liveOutput streams output of a task to the server while it runs.
+// Streaming is best effort: output which can't be delivered is only left out of the live view,
+// and full output still ends up in the record of the run.
+type liveOutput struct {
+	runID    string
+	redactor *redactor
+
+	mu      sync.Mutex
+	held    map[string][]byte
+	pending []types.OutputChunk
+	size    int
+	dropped int
+	gone    bool // server no longer accepts output of the run
+
+	stop     chan struct{}
+	done     chan struct{}
+	finished sync.Once
+}
+
+// startLiveOutput starts streaming output of a run of task to the server.
+// The run is registered with the server in the background, so that the task doesn't wait for it,
+// and output is held until then. If registration fails, the run just has no live output.
+// Nil is returned if live output is disabled, which the methods of liveOutput accept.
+func startLiveOutput(taskName string, triggerID uint, secrets map[string]string) *liveOutput {
+	if config.DisableLiveOutput || (config.AccessToken == "" && config.URL == "") {
+		return nil
+	}
+
+	l := &liveOutput{
+		redactor: newRedactor(secrets),
+		held:     make(map[string][]byte),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go l.run(&types.Run{
+		TaskName:  taskName,
+		TriggerID: triggerID,
+	})
+	return l
+}
+
+// id returns ID of the run, to be set in its record.
+// Empty if the run couldn't be registered.
+func (l *liveOutput) id() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.runID
+}
+
+// writer returns writer for the given stream, which never fails so that it doesn't disturb the task
+func (l *liveOutput) writer(stream string) io.Writer {
+	if l == nil {
+		return io.Discard
+	}
+	return &liveWriter{l: l, stream: stream}
+}
+
+type liveWriter struct {
+	l      *liveOutput
+	stream string
+}
+
+func (w *liveWriter) Write(p []byte) (int, error) {
+	w.l.write(w.stream, p)
+	return len(p), nil
+}
+
+func (l *liveOutput) write(stream string, p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.gone {
+		return
+	}
+
+	data := append(l.held[stream], p...)
+	out, rest := l.redactor.release(data, false)
+	l.held[stream] = rest
+	l.queue(stream, out)
+}
+
+// queue adds output to be sent on next flush, unless too much is waiting already
+func (l *liveOutput) queue(stream string, data string) {
+	if data == "" {
+		return
+	}
+	if l.size+len(data) > maxPendingLiveOutput {
+		l.dropped += len(data)
+		return
+	}
+	if l.dropped > 0 {
+		l.append(stream, fmt.Sprintf("\n... %d bytes of live output dropped ...\n", l.dropped))
+		l.dropped = 0
+	}
+	l.append(stream, data)
+}
+
+func (l *liveOutput) append(stream string, data string) {
+	l.size += len(data)
+	if n := len(l.pending); n > 0 && l.pending[n-1].Stream == stream {
+		l.pending[n-1].Data += data
+		return
+	}
+	l.pending = append(l.pending, types.OutputChunk{Stream: stream, Data: data})
+}
+
+func (l *liveOutput) run(run *types.Run) {
+	defer close(l.done)
+
+	if !l.register(run) {
+		return
+	}
+
+	ticker := time.NewTicker(liveOutputInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		l.flush()
+	}
+}
+
+// register tells the server that the run has started, and gets the ID to stream its output with
+func (l *liveOutput) register(run *types.Run) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), liveRequestTimeout)
+	defer cancel()
+	runID, err := startRun(ctx, config.AccessToken, config.URL, config.Organization, run)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err != nil {
+		log.Println("live output of task", run.TaskName, "not available:", err)
+		l.gone = true
+		l.pending = nil
+		l.size = 0
+		return false
+	}
+	l.runID = runID
+	return true
+}
+
+// flush sends output waiting to be sent
+func (l *liveOutput) flush() {
+	l.mu.Lock()
+	chunks := l.pending
+	l.pending = nil
+	l.size = 0
+	gone := l.gone
+	runID := l.runID
+	l.mu.Unlock()
+
+	if gone || len(chunks) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), liveRequestTimeout)
+	defer cancel()
+	err := postRunOutput(ctx, config.AccessToken, config.URL, config.Organization, runID, chunks)
+	if _, rejected := err.(*rejectedError); rejected {
+		log.Println("server stopped accepting live output of run", l.runID)
+		l.mu.Lock()
+		l.gone = true
+		l.mu.Unlock()
+		return
+	}
+	if err != nil {
+		log.Println("error sending live output of run", l.runID+":", err)
+	}
+}
+
+// finish sends the rest of the output once the task has exited. Calling it again does nothing.
+// If the run is still being registered, finish waits for it, at most liveRequestTimeout.
+func (l *liveOutput) finish() {
+	if l == nil {
+		return
+	}
+	l.finished.Do(l.end)
+}
+
+func (l *liveOutput) end() {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	for _, stream := range []string{types.StreamStdout, types.StreamStderr} {
+		out, _ := l.redactor.release(l.held[stream], true)
+		l.queue(stream, out)
+		delete(l.held, stream)
+	}
+	l.mu.Unlock()
+
+	l.flush()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func withServer(t *testing.T, url string) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = Config{URL: url, AccessToken: "token", Organization: "org"}
+}
+
+func TestLiveOutputRegistersInBackground(t *testing.T) {
+	var mu sync.Mutex
+	var received []types.OutputChunk
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/machines/self/runs/"):
+			// slow server, task must not wait for it
+			<-release
+			_, _ = w.Write([]byte(`{"code":200,"msg":"ok","payload":{"id":"run1"}}`))
+		case strings.HasSuffix(req.URL.Path, "/machines/self/runs/run1/output/"):
+			var chunks []types.OutputChunk
+			if err := stdjson.NewDecoder(req.Body).Decode(&chunks); err != nil {
+				t.Error("error decoding output:", err)
+			}
+			mu.Lock()
+			received = append(received, chunks...)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"code":200,"msg":"ok"}`))
+		default:
+			t.Error("unexpected request:", req.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	withServer(t, srv.URL)
+
+	start := time.Now()
+	l := startLiveOutput("backup", 0, nil)
+	if l == nil {
+		t.Fatal("live output not started")
+	}
+	_, _ = l.writer(types.StreamStdout).Write([]byte("written while registering\n"))
+	if time.Since(start) > time.Second {
+		t.Fatal("task waited for run to be registered")
+	}
+
+	close(release)
+	l.finish()
+
+	if l.id() != "run1" {
+		t.Fatal("unexpected run ID:", l.id())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].Data != "written while registering\n" {
+		t.Fatal("output held during registration not sent:", received)
+	}
+}
+
+func TestLiveOutputServerUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	withServer(t, url)
+
+	l := startLiveOutput("backup", 0, nil)
+	_, _ = l.writer(types.StreamStdout).Write([]byte("output\n"))
+	l.finish()
+
+	if l.id() != "" {
+		t.Fatal("run ID without registration:", l.id())
+	}
+}
@@ -16,12 +16,21 @@ var (
 
 func main() {
 	log.SetFlags(log.Ldate | log.LUTC | log.Lshortfile | log.Lmicroseconds)
+
+	if len(os.Args) > 1 && os.Args[1] == "enroll" {
+		if err := enroll(os.Args[2:]); err != nil {
+			log.Println("error enrolling machine:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	conf := flag.String("c", "", "path to configuration JSON file")
 	demoMode := flag.Bool("demo", false, "go slow for demo purposes")
 	flag.Parse()
 
 	if *conf == "" {
-		log.Println("you must provide configuration file")
+		log.Println("you must provide configuration file, which \"taskeyd enroll\" can create")
 		flag.Usage()
 		return
 	}
@@ -46,7 +55,7 @@ func main() {
 	signal.Notify(sc, os.Interrupt)
 
 	log.Println("fetching schedule")
-	schedule, err := fetchSchedule(config.AccessToken, config.URL, config.Organization)
+	schedule, err := fetchSchedule(config.AccessToken, config.URL, config.Organization, nil)
 	if err != nil {
 		log.Println("error fetching schedule:", err)
 		return
@@ -61,7 +70,7 @@ func main() {
 	}
 
 	log.Println("fetching tasks")
-	tasks, err := fetchTasks(config.AccessToken, config.URL, config.Organization)
+	tasks, err := fetchTasks(config.AccessToken, config.URL, config.Organization, nil)
 	if err != nil {
 		log.Println("error fetching tasks:", err)
 		return
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const defaultMaxOutputBytes = 1 << 20
+
+// outputBuffer collects output of a task up to a limit.
+// When output exceeds the limit, its beginning and end are kept, as those are usually the interesting parts.
+type outputBuffer struct {
+	mu sync.Mutex
+
+	head []byte
+	tail []byte // ring buffer once full
+	// next write position in tail
+	pos int
+	// total bytes written
+	n int64
+
+	headSize int
+	tailSize int
+}
+
+func newOutputBuffer(limit int) *outputBuffer {
+	if limit <= 0 {
+		limit = defaultMaxOutputBytes
+	}
+	return &outputBuffer{
+		headSize: limit / 2,
+		tailSize: limit - limit/2,
+	}
+}
+
+// Write never fails, output exceeding the limit is dropped from the middle
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	written := len(p)
+	b.n += int64(written)
+
+	if room := b.headSize - len(b.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.head = append(b.head, p[:room]...)
+		p = p[room:]
+	}
+
+	if len(p) >= b.tailSize {
+		// only the last part of p survives anyway
+		b.tail = append(b.tail[:0], p[len(p)-b.tailSize:]...)
+		b.pos = 0
+		return written, nil
+	}
+
+	for len(p) > 0 {
+		if len(b.tail) < b.tailSize {
+			room := b.tailSize - len(b.tail)
+			if room > len(p) {
+				room = len(p)
+			}
+			b.tail = append(b.tail, p[:room]...)
+			p = p[room:]
+			continue
+		}
+		c := copy(b.tail[b.pos:], p)
+		b.pos = (b.pos + c) % b.tailSize
+		p = p[c:]
+	}
+
+	return written, nil
+}
+
+// dropped tells how many bytes were left out of output
+func (b *outputBuffer) dropped() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.n - int64(len(b.head)) - int64(len(b.tail))
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tail := append(append([]byte(nil), b.tail[b.pos:]...), b.tail[:b.pos]...)
+
+	dropped := b.n - int64(len(b.head)) - int64(len(b.tail))
+	if dropped == 0 {
+		return string(b.head) + string(tail)
+	}
+	return fmt.Sprintf("%s\n... %d bytes of output truncated ...\n%s", b.head, dropped, tail)
+}
+
+// redactor replaces secret values in output which arrives in pieces
+type redactor struct {
+	secrets [][]byte
+	// longest secret, output which may be the beginning of a secret is held back until the rest arrives
+	maxSecret int
+}
+
+func newRedactor(secrets map[string]string) *redactor {
+	r := new(redactor)
+	for _, value := range secrets {
+		if value == "" {
+			continue
+		}
+		r.secrets = append(r.secrets, []byte(value))
+		if len(value) > r.maxSecret {
+			r.maxSecret = len(value)
+		}
+	}
+	return r
+}
+
+// release returns redacted output which is safe to pass on, and the rest which must wait for more output.
+// Output is held back if it may be the beginning of a secret or of a multi-byte character.
+// At the end of output, everything is released.
+func (r *redactor) release(data []byte, final bool) (string, []byte) {
+	limit := len(data)
+	if !final {
+		if r.maxSecret > 1 {
+			limit -= r.maxSecret - 1
+			if limit < 0 {
+				limit = 0
+			}
+		}
+		if start := lastRuneStart(data[:limit]); !utf8.FullRune(data[start:limit]) {
+			limit = start
+		}
+	}
+
+	if len(r.secrets) == 0 {
+		return string(data[:limit]), append([]byte(nil), data[limit:]...)
+	}
+
+	var b strings.Builder
+	i := 0
+	for i < limit {
+		if n := r.secretAt(data[i:]); n > 0 {
+			b.WriteString(redacted)
+			i += n
+			continue
+		}
+		b.WriteByte(data[i])
+		i++
+	}
+
+	return b.String(), append([]byte(nil), data[i:]...)
+}
+
+// secretAt returns length of the secret data begins with, or zero
+func (r *redactor) secretAt(data []byte) int {
+	for _, value := range r.secrets {
+		if bytes.HasPrefix(data, value) {
+			return len(value)
+		}
+	}
+	return 0
+}
+
+// lastRuneStart returns index of the byte starting the last character in b,
+// or length of b if b doesn't end with a character
+func lastRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// redactingWriter redacts secrets from output before writing it to w,
+// so that secrets are gone before w gets to truncate the output.
+// Like outputBuffer, it never fails.
+type redactingWriter struct {
+	mu   sync.Mutex
+	r    *redactor
+	w    io.Writer
+	held []byte
+}
+
+func newRedactingWriter(r *redactor, w io.Writer) *redactingWriter {
+	return &redactingWriter{r: r, w: w}
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	out, rest := rw.r.release(append(rw.held, p...), false)
+	rw.held = rest
+	_, _ = io.WriteString(rw.w, out)
+	return len(p), nil
+}
+
+// flush writes output held back, once there is no more output
+func (rw *redactingWriter) flush() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	out, _ := rw.r.release(rw.held, true)
+	rw.held = nil
+	_, _ = io.WriteString(rw.w, out)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputBufferUnderLimit(t *testing.T) {
+	b := newOutputBuffer(10)
+	_, _ = b.Write([]byte("01234"))
+	_, _ = b.Write([]byte("5678"))
+
+	if got := b.String(); got != "012345678" {
+		t.Fatal("unexpected output:", got)
+	}
+	if b.dropped() != 0 {
+		t.Fatal("output dropped under limit:", b.dropped())
+	}
+}
+
+func TestOutputBufferKeepsHeadAndTail(t *testing.T) {
+	b := newOutputBuffer(10)
+	// written in uneven pieces, so that the tail wraps around
+	for _, p := range []string{"012", "3456", "789ab", "cdefg", "hij"} {
+		if n, err := b.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatal("write failed:", n, err)
+		}
+	}
+
+	if b.dropped() != 10 {
+		t.Fatal("unexpected number of dropped bytes:", b.dropped())
+	}
+	want := "01234\n... 10 bytes of output truncated ...\nfghij"
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	// a single write larger than the tail replaces it
+	_, _ = b.Write([]byte(strings.Repeat("x", 20) + "vwxyz"))
+	want = "01234\n... 35 bytes of output truncated ...\nvwxyz"
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output after large write: %q", got)
+	}
+}
+
+func TestRedactingWriterSplitSecret(t *testing.T) {
+	b := newOutputBuffer(0)
+	w := newRedactingWriter(newRedactor(map[string]string{"PASSWORD": "hunter2", "EMPTY": ""}), b)
+
+	// secret arrives in pieces, split at every possible boundary
+	for _, p := range []string{"password is h", "u", "nte", "r2", ", again hunter", "2\n"} {
+		if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatal("write failed:", n, err)
+		}
+		if strings.Contains(b.String(), "hun") {
+			t.Fatal("beginning of secret passed on before the rest arrived:", b.String())
+		}
+	}
+	w.flush()
+
+	if got := b.String(); got != "password is [REDACTED], again [REDACTED]\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
+
+func TestRedactingWriterHeldOutput(t *testing.T) {
+	b := newOutputBuffer(0)
+	w := newRedactingWriter(newRedactor(map[string]string{"TOKEN": "secret7"}), b)
+
+	// output which only looks like the beginning of a secret is released in the end
+	_, _ = w.Write([]byte("the secre"))
+	_, _ = w.Write([]byte("tary: é"[:7]))
+	_, _ = w.Write([]byte("tary: é"[7:]))
+	w.flush()
+
+	if got := b.String(); got != "the secretary: é" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
+
+func TestRedactBeforeTruncate(t *testing.T) {
+	b := newOutputBuffer(16)
+	w := newRedactingWriter(newRedactor(map[string]string{"TOKEN": "0123456789abcdef"}), b)
+
+	// secret straddles the point where output gets truncated
+	_, _ = w.Write([]byte("xxxxx0123456789abcdef"))
+	_, _ = w.Write([]byte(strings.Repeat("y", 20)))
+	w.flush()
+
+	got := b.String()
+	for _, fragment := range []string{"0123", "4567", "89ab", "cdef"} {
+		if strings.Contains(got, fragment) {
+			t.Fatalf("fragment of secret left in truncated output: %q", got)
+		}
+	}
+}
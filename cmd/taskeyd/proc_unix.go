@@ -0,0 +1,104 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup makes cmd run in its own process group,
+// so that any children it spawns can be signalled together with it
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	// negative pid signals the whole process group
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// setCredential makes cmd run as given user and group, either of which may be a name or numeric id.
+// Returned environment variables describe the user, and should be added to the environment of cmd.
+func setCredential(cmd *exec.Cmd, username string, groupname string) ([]string, error) {
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	var env []string
+
+	if username != "" {
+		u, err := lookupUser(username)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid = uint32(uid)
+		cred.Gid = uint32(gid)
+
+		// supplementary groups of taskeyd should not leak to the task
+		groupIDs, err := u.GroupIds()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groupIDs {
+			id, err := strconv.ParseUint(g, 10, 32)
+			if err != nil {
+				continue
+			}
+			cred.Groups = append(cred.Groups, uint32(id))
+		}
+
+		env = append(env, "USER="+u.Username, "LOGNAME="+u.Username, "HOME="+u.HomeDir)
+	}
+
+	if groupname != "" {
+		g, err := lookupGroup(groupname)
+		if err != nil {
+			return nil, err
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		cred.Gid = uint32(gid)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+
+	return env, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+// exitSignal returns number of the signal which terminated the process, or zero if it exited normally
+func exitSignal(state *os.ProcessState) int {
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return 0
+	}
+	return int(ws.Signal())
+}
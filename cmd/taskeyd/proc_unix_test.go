@@ -0,0 +1,121 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("error reading marker file:", err)
+	}
+	return fi.Size()
+}
+
+func TestExecCmdTimeoutKillsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	// background child ignores SIGTERM and keeps writing to marker until killed
+	script := `(trap "" TERM; while :; do echo x >> "$1"; sleep 0.01; done) >/dev/null 2>&1 &
+echo started
+sleep 30`
+	cmd := exec.Command("sh", "-c", script, "sh", marker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var res types.ActionResult
+	if err := execCmd(ctx, cmd, false, &res); err != errTimedOut {
+		t.Fatal("expected timeout, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > killGracePeriod {
+		t.Fatal("process not terminated on timeout:", elapsed)
+	}
+	if res.Signal != int(syscall.SIGTERM) || res.Code != 128+int(syscall.SIGTERM) {
+		t.Fatal("unexpected exit status:", res.Code, res.Signal)
+	}
+	if res.Output != "started\n" {
+		t.Fatalf("output not captured: %q", res.Output)
+	}
+
+	// child was killed along with the process, so marker stops growing
+	var size int64
+	for i := 0; i < 50; i++ {
+		time.Sleep(50 * time.Millisecond)
+		s := fileSize(t, marker)
+		if s == size {
+			return
+		}
+		size = s
+	}
+	t.Fatal("child process still running after timeout")
+}
+
+func TestExecCmdOutput(t *testing.T) {
+	cmd := exec.Command("sh", "-c", `echo out; echo err >&2; exit 3`)
+
+	var res types.ActionResult
+	if err := execCmd(context.Background(), cmd, false, &res); err != nil {
+		t.Fatal("error running command:", err)
+	}
+	if res.Code != 3 || res.Signal != 0 {
+		t.Fatal("unexpected exit status:", res.Code, res.Signal)
+	}
+	if res.Output != "out\n" || res.Stderr != "err\n" {
+		t.Fatalf("unexpected output: %q %q", res.Output, res.Stderr)
+	}
+}
+
+func TestSetCredential(t *testing.T) {
+	uid := strconv.Itoa(os.Getuid())
+	gid := strconv.Itoa(os.Getgid())
+
+	cmd := exec.Command("true")
+	env, err := setCredential(cmd, uid, gid)
+	if err != nil {
+		t.Fatal("error setting credential:", err)
+	}
+	cred := cmd.SysProcAttr.Credential
+	if cred == nil || strconv.Itoa(int(cred.Uid)) != uid || strconv.Itoa(int(cred.Gid)) != gid {
+		t.Fatal("unexpected credential:", cred)
+	}
+	var hasUser, hasHome bool
+	for _, kv := range env {
+		hasUser = hasUser || strings.HasPrefix(kv, "USER=")
+		hasHome = hasHome || strings.HasPrefix(kv, "HOME=")
+	}
+	if !hasUser || !hasHome {
+		t.Fatal("environment of user not returned:", env)
+	}
+
+	// group alone keeps the user taskeyd runs as
+	cmd = exec.Command("true")
+	env, err = setCredential(cmd, "", gid)
+	if err != nil || len(env) != 0 {
+		t.Fatal("unexpected result of setting group:", env, err)
+	}
+	if cred := cmd.SysProcAttr.Credential; int(cred.Uid) != os.Getuid() {
+		t.Fatal("user changed when only group was set:", cred)
+	}
+
+	if _, err := setCredential(exec.Command("true"), "no-such-user-taskeyd", ""); err == nil {
+		t.Fatal("unknown user accepted")
+	}
+	if _, err := setCredential(exec.Command("true"), "", "no-such-group-taskeyd"); err == nil {
+		t.Fatal("unknown group accepted")
+	}
+}
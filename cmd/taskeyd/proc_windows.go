@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// process groups can't be signalled on windows, so only the process itself is killed
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func setCredential(cmd *exec.Cmd, username string, groupname string) ([]string, error) {
+	return nil, errors.New("running tasks as another user is not supported on windows")
+}
+
+// processes are not terminated by signals on windows
+func exitSignal(state *os.ProcessState) int {
+	return 0
+}
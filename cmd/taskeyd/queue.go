@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	defaultMaxQueuedRecords = 10000
+	// must not exceed batch size accepted by the server
+	recordBatchSize = 100
+
+	minRetryBackoff = time.Second
+	maxRetryBackoff = 5 * time.Minute
+
+	// queue file is rewritten once this many delivered records have piled up at its head,
+	// and they outnumber the records still queued
+	minCompactRecords = 1000
+)
+
+// recordQueue holds records until they are delivered to the server.
+// Queued records are persisted in a file with one JSON encoded record per line,
+// so that they survive restarts of taskeyd.
+// Records removed from the queue are not removed from the file right away,
+// instead their number is kept in a separate head file, and the file is compacted every now and then.
+//
+// When the queue is full, oldest records are evicted to make room for new ones.
+type recordQueue struct {
+	path       string
+	maxRecords int
+
+	mu      sync.Mutex
+	records []queuedRecord
+	nextSeq uint64
+	file    *os.File
+	// number of lines at the beginning of file which are no longer in the queue
+	consumed int
+	head     *os.File
+
+	// signalled when records are pushed
+	notify chan struct{}
+}
+
+// queuedRecord is a record in the queue along with its sequence number.
+// Sequence numbers increase in push order, so that records taken from the queue can be
+// told apart from newer ones even if the queue has changed in the meantime.
+type queuedRecord struct {
+	seq    uint64
+	record types.Record
+}
+
+func defaultQueuePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "taskeyd", "records.jsonl")
+}
+
+// openRecordQueue loads records left in the queue file by previous run, if any
+func openRecordQueue(path string, maxRecords int) (*recordQueue, error) {
+	if maxRecords <= 0 {
+		maxRecords = defaultMaxQueuedRecords
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	q := &recordQueue{
+		path:       path,
+		maxRecords: maxRecords,
+		notify:     make(chan struct{}, 1),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	// start from a clean file, in case the previous one was cut short
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	if len(q.records) > 0 {
+		log.Println("loaded", len(q.records), "unsent record(s) from", path)
+		q.signal()
+	}
+
+	return q, nil
+}
+
+func (q *recordQueue) load() error {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	skip, err := q.readHead()
+	if err != nil {
+		return err
+	}
+
+	s := bufio.NewScanner(f)
+	// records may contain a lot of output
+	s.Buffer(nil, 64*1024*1024)
+	for s.Scan() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		var rec types.Record
+		if err := stdjson.Unmarshal(s.Bytes(), &rec); err != nil {
+			// most likely a partially written line from a crash
+			log.Println("skipping corrupt line in record queue:", err)
+			continue
+		}
+		q.add(rec)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	q.evict()
+	return nil
+}
+
+// push appends record to the end of the queue
+func (q *recordQueue) push(rec *types.Record) error {
+	b, err := stdjson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.add(*rec)
+
+	if err := q.appendLine(b); err != nil {
+		return err
+	}
+	if n := q.evict(); n > 0 {
+		err = q.consume(n)
+	}
+
+	q.signal()
+	return err
+}
+
+// add appends record to the end of the queue with the next sequence number.
+// must be called with mu held
+func (q *recordQueue) add(rec types.Record) {
+	q.records = append(q.records, queuedRecord{seq: q.nextSeq, record: rec})
+	q.nextSeq++
+}
+
+// evict drops oldest records if queue is over capacity.
+// Some extra room is made so that records aren't dropped on every push while full.
+// must be called with mu held, returns number of dropped records.
+func (q *recordQueue) evict() int {
+	if len(q.records) <= q.maxRecords {
+		return 0
+	}
+
+	n := len(q.records) - q.maxRecords + q.maxRecords/10
+	if n > len(q.records) {
+		n = len(q.records)
+	}
+	log.Println("record queue full, dropping", n, "oldest record(s)")
+	q.records = append([]queuedRecord(nil), q.records[n:]...)
+	return n
+}
+
+// consume records that n more lines at the head of the file are no longer in the queue,
+// and compacts the file if enough of them have piled up.
+// must be called with mu held
+func (q *recordQueue) consume(n int) error {
+	q.consumed += n
+	if len(q.records) == 0 || (q.consumed >= minCompactRecords && q.consumed >= len(q.records)) {
+		return q.compact()
+	}
+	return q.writeHead(q.consumed)
+}
+
+// readHead returns number of lines to skip at the beginning of queue file
+func (q *recordQueue) readHead() (int, error) {
+	b, err := os.ReadFile(q.path + ".head")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		// partially written, skipping too few only sends some records again
+		log.Println("ignoring corrupt record queue head:", err)
+		return 0, nil
+	}
+	return n, nil
+}
+
+// writeHead persists number of lines to skip at the beginning of queue file.
+// File is truncated first, so that a crash in between leaves it empty rather than with a larger number.
+// must be called with mu held
+func (q *recordQueue) writeHead(n int) error {
+	if q.head == nil {
+		f, err := os.OpenFile(q.path+".head", os.O_WRONLY|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		q.head = f
+	}
+
+	if err := q.head.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := q.head.WriteAt([]byte(strconv.Itoa(n)+"\n"), 0); err != nil {
+		return err
+	}
+	return q.head.Sync()
+}
+
+// must be called with mu held
+func (q *recordQueue) appendLine(b []byte) error {
+	if q.file == nil {
+		f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		q.file = f
+	}
+
+	if _, err := q.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+// compact rewrites queue file to contain only records currently in the queue.
+// must be called with mu held
+func (q *recordQueue) compact() error {
+	if q.file != nil {
+		_ = q.file.Close()
+		q.file = nil
+	}
+
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := stdjson.NewEncoder(w)
+	for i := range q.records {
+		if err := enc.Encode(&q.records[i].record); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// head is reset before the file is replaced: if interrupted in between,
+	// records of the old file are sent again rather than new ones being skipped
+	if err := q.writeHead(0); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	q.consumed = 0
+	return nil
+}
+
+// peek returns up to n records from the head of the queue, without removing them
+func (q *recordQueue) peek(n int) []queuedRecord {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > len(q.records) {
+		n = len(q.records)
+	}
+	return append([]queuedRecord(nil), q.records[:n]...)
+}
+
+// pop removes records up to and including sequence number seq from the head of the queue.
+// Records evicted since they were peeked are already gone, newer records are never removed.
+func (q *recordQueue) pop(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for n < len(q.records) && q.records[n].seq <= seq {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	q.records = q.records[n:]
+
+	return q.consume(n)
+}
+
+func (q *recordQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.records)
+}
+
+func (q *recordQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *recordQueue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.head != nil {
+		_ = q.head.Close()
+		q.head = nil
+	}
+	if q.file != nil {
+		err := q.file.Close()
+		q.file = nil
+		return err
+	}
+	return nil
+}
+
+// run delivers queued records in order using send, until ctx is done.
+// Records are sent in batches of up to recordBatchSize, and send returns result of each record in the batch.
+// If delivery fails, it is retried with exponential backoff.
+// Records rejected by the server one by one are dropped, as retrying won't help them.
+// If the batch as a whole fails, all of its records are kept.
+func (q *recordQueue) run(ctx context.Context, send func(records []types.Record) ([]error, error)) {
+	backoff := minRetryBackoff
+
+	for {
+		if q.len() == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.notify:
+				continue
+			}
+		}
+
+		queued := q.peek(recordBatchSize)
+		records := make([]types.Record, len(queued))
+		for i := range queued {
+			records[i] = queued[i].record
+		}
+		errs, err := send(records)
+
+		// records are done when delivered or rejected.
+		// to keep order, stop at first one that needs to be retried.
+		var rejected *rejectedError
+		done := 0
+		for err == nil && done < len(records) {
+			if errs[done] != nil {
+				if !errors.As(errs[done], &rejected) {
+					err = errs[done]
+					break
+				}
+				log.Println("record of task", records[done].TaskName, "rejected by server, dropping it:", errs[done])
+			}
+			done++
+		}
+
+		if done > 0 {
+			if err := q.pop(queued[done-1].seq); err != nil {
+				log.Println("error updating record queue:", err)
+			}
+		}
+
+		if err != nil {
+			log.Println("error posting results, retrying in", backoff, "with", q.len(), "record(s) queued:", err)
+
+			t := time.NewTimer(jitter(backoff))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+			continue
+		}
+
+		backoff = minRetryBackoff
+	}
+}
+
+// jitter randomizes d by up to ±20% so that machines don't all retry in sync after an outage
+func jitter(d time.Duration) time.Duration {
+	// skipcq: GSC-G404
+	return d + time.Duration((rand.Float64()*0.4-0.2)*float64(d))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func pushRecords(t *testing.T, q *recordQueue, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := q.push(&types.Record{TaskName: name}); err != nil {
+			t.Fatal("error pushing record:", err)
+		}
+	}
+}
+
+func queuedNames(q *recordQueue) []string {
+	var names []string
+	for _, r := range q.peek(q.len()) {
+		names = append(names, r.record.TaskName)
+	}
+	return names
+}
+
+func taskNames(prefix string, from, to int) []string {
+	var names []string
+	for i := from; i < to; i++ {
+		names = append(names, prefix+strconv.Itoa(i))
+	}
+	return names
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("error reading queue file:", err)
+	}
+	return strings.Count(string(b), "\n")
+}
+
+func TestRecordQueueEvictDuringSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	q, err := openRecordQueue(path, 10)
+	if err != nil {
+		t.Fatal("error opening queue:", err)
+	}
+	defer q.close()
+
+	pushRecords(t, q, taskNames("old", 0, 10)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sent [][]string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.run(ctx, func(records []types.Record) ([]error, error) {
+			var names []string
+			for _, r := range records {
+				names = append(names, r.TaskName)
+			}
+			sent = append(sent, names)
+			if len(sent) == 1 {
+				// queue overflows while first batch is in flight, evicting part of it
+				for _, name := range taskNames("new", 0, 5) {
+					if err := q.push(&types.Record{TaskName: name}); err != nil {
+						t.Error("error pushing record:", err)
+					}
+				}
+			} else {
+				cancel()
+			}
+			return make([]error, len(records)), nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue didn't finish sending")
+	}
+
+	if len(sent) != 2 {
+		t.Fatal("unexpected number of batches:", sent)
+	}
+	if strings.Join(sent[0], ",") != strings.Join(taskNames("old", 0, 10), ",") {
+		t.Fatal("unexpected first batch:", sent[0])
+	}
+	// records pushed during the first batch must not be mistaken for delivered ones
+	if strings.Join(sent[1], ",") != strings.Join(taskNames("new", 0, 5), ",") {
+		t.Fatal("unexpected second batch:", sent[1])
+	}
+}
+
+func TestRecordQueuePopAfterEvict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	q, err := openRecordQueue(path, 10)
+	if err != nil {
+		t.Fatal("error opening queue:", err)
+	}
+	defer q.close()
+
+	pushRecords(t, q, taskNames("old", 0, 10)...)
+	batch := q.peek(recordBatchSize)
+	// 6 oldest records are evicted to make room
+	pushRecords(t, q, taskNames("new", 0, 5)...)
+
+	if err := q.pop(batch[len(batch)-1].seq); err != nil {
+		t.Fatal("error popping records:", err)
+	}
+	if got := strings.Join(queuedNames(q), ","); got != strings.Join(taskNames("new", 0, 5), ",") {
+		t.Fatal("unexpected records left in queue:", got)
+	}
+	// nothing more is removed when batch is popped again
+	if err := q.pop(batch[len(batch)-1].seq); err != nil || q.len() != 5 {
+		t.Fatal("records removed by repeated pop:", err, q.len())
+	}
+}
+
+func TestRecordQueueReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	q, err := openRecordQueue(path, 0)
+	if err != nil {
+		t.Fatal("error opening queue:", err)
+	}
+
+	pushRecords(t, q, taskNames("task", 0, 5)...)
+	batch := q.peek(3)
+	if err := q.pop(batch[2].seq); err != nil {
+		t.Fatal("error popping records:", err)
+	}
+	// delivered records stay in the file, head file tells to skip them
+	if n := countLines(t, path); n != 5 {
+		t.Fatal("queue file compacted too early, lines:", n)
+	}
+	if b, err := os.ReadFile(path + ".head"); err != nil || strings.TrimSpace(string(b)) != "3" {
+		t.Fatal("unexpected head file:", string(b), err)
+	}
+	if err := q.close(); err != nil {
+		t.Fatal("error closing queue:", err)
+	}
+
+	q, err = openRecordQueue(path, 0)
+	if err != nil {
+		t.Fatal("error reopening queue:", err)
+	}
+	defer q.close()
+
+	if got := strings.Join(queuedNames(q), ","); got != "task3,task4" {
+		t.Fatal("unexpected records after reload:", got)
+	}
+	// reloaded queue starts from a compacted file
+	if n := countLines(t, path); n != 2 {
+		t.Fatal("queue file not compacted on load, lines:", n)
+	}
+	if b, err := os.ReadFile(path + ".head"); err != nil || strings.TrimSpace(string(b)) != "0" {
+		t.Fatal("head file not reset on load:", string(b), err)
+	}
+
+	// sequence numbers continue after reloaded records
+	pushRecords(t, q, "task5")
+	queued := q.peek(3)
+	if queued[2].seq <= queued[1].seq {
+		t.Fatal("sequence numbers not increasing:", queued)
+	}
+}
+
+func TestRecordQueueCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.jsonl")
+
+	lines := `{"taskName":"task0"}` + "\n" +
+		`{"taskName":"task1"` + "\n" + // cut short by a crash
+		`{"taskName":"task2"}` + "\n"
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// corrupt head skips nothing, so that records are sent again rather than lost
+	if err := os.WriteFile(path+".head", []byte("1x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := openRecordQueue(path, 0)
+	if err != nil {
+		t.Fatal("error opening queue:", err)
+	}
+	defer q.close()
+
+	if got := strings.Join(queuedNames(q), ","); got != "task0,task2" {
+		t.Fatal("unexpected records loaded:", got)
+	}
+}
+
+func TestRecordQueueCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	q, err := openRecordQueue(path, 0)
+	if err != nil {
+		t.Fatal("error opening queue:", err)
+	}
+	defer q.close()
+
+	pushRecords(t, q, taskNames("task", 0, minCompactRecords+10)...)
+
+	// below threshold only head is moved
+	batch := q.peek(minCompactRecords - 1)
+	if err := q.pop(batch[len(batch)-1].seq); err != nil {
+		t.Fatal("error popping records:", err)
+	}
+	if n := countLines(t, path); n != minCompactRecords+10 {
+		t.Fatal("queue file compacted too early, lines:", n)
+	}
+
+	// once delivered records pile up and outnumber queued ones, file is rewritten
+	batch = q.peek(1)
+	if err := q.pop(batch[0].seq); err != nil {
+		t.Fatal("error popping records:", err)
+	}
+	if n := countLines(t, path); n != 10 {
+		t.Fatal("queue file not compacted, lines:", n)
+	}
+	if b, err := os.ReadFile(path + ".head"); err != nil || strings.TrimSpace(string(b)) != "0" {
+		t.Fatal("head file not reset on compaction:", string(b), err)
+	}
+
+	// records pushed after compaction are appended to the new file
+	pushRecords(t, q, "last")
+	if n := countLines(t, path); n != 11 {
+		t.Fatal("record not appended after compaction, lines:", n)
+	}
+
+	// emptied queue is compacted right away
+	batch = q.peek(q.len())
+	if err := q.pop(batch[len(batch)-1].seq); err != nil {
+		t.Fatal("error popping records:", err)
+	}
+	if n := countLines(t, path); n != 0 {
+		t.Fatal("empty queue not compacted, lines:", n)
+	}
+}
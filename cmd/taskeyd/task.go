@@ -1,95 +1,594 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
-type taskExecCallback func(name string, status int, output string)
+type taskExecCallback func(record *types.Record)
 
 func makeTask(task *types.Task, cb taskExecCallback) func() {
+	return makeTriggeredTask(task, 0, cb)
+}
+
+// makeTriggeredTask is like makeTask, but records are reported against the trigger unless triggerID is zero
+func makeTriggeredTask(task *types.Task, triggerID uint, cb taskExecCallback) func() {
+	var run func(ctx context.Context, cb taskExecCallback)
+
 	switch task.Content.(type) {
 	case *types.CmdTask:
-		return makeCmdTask(task, cb)
+		run = makeCmdTask(task)
 	case *types.ScriptTask:
-		return makeScriptTask(task, cb)
+		run = makeScriptTask(task)
+	case *types.HttpTask:
+		run = makeHttpTask(task)
+	case *types.MultiTask:
+		run = makeMultiTask(task)
 	default:
 		return func() {
 			log.Println("unknown task type")
+			cb(failedRecord(task.Name, triggerID, "unknown task type"))
 		}
 	}
-}
 
-func makeCmdTask(task *types.Task, cb taskExecCallback) func() {
+	withSecrets := usesSecrets(task.Content)
+
 	return func() {
+		ctx := context.Background()
+
+		var secrets map[string]string
+		if withSecrets {
+			// secrets are fetched for each run, so that they are only kept in memory while needed
+			var err error
+			secrets, err = fetchTaskSecrets(config.AccessToken, config.URL, config.Organization, task.Name)
+			if err != nil {
+				log.Println("failed to fetch secrets for task", task.Name+":", err)
+				cb(failedRecord(task.Name, triggerID, "failed to fetch secrets: "+err.Error()))
+				return
+			}
+			ctx = context.WithValue(ctx, secretsKey{}, secrets)
+		}
+
+		live := startLiveOutput(task.Name, triggerID, secrets)
+		// not every failure produces a record, so finish in any case
+		defer live.finish()
+		ctx = context.WithValue(ctx, liveKey{}, live)
+
+		run(ctx, timed(func(rec *types.Record) {
+			// rest of live output is sent before the record, which ends the run on server
+			live.finish()
+			rec.RunID = live.id()
+			rec.TriggerID = triggerID
+			if rec.Outcome == "" {
+				rec.Outcome = types.RecordOutcomeCompleted
+			}
+			if withSecrets {
+				redactSecrets(rec, secrets)
+			}
+			cb(rec)
+		}))
+	}
+}
+
+// failedRecord makes record of a run which could not be executed at all
+func failedRecord(taskName string, triggerID uint, reason string) *types.Record {
+	return &types.Record{
+		TaskName:  taskName,
+		TriggerID: triggerID,
+		Outcome:   types.RecordOutcomeError,
+		Error:     reason,
+	}
+}
+
+// timed sets start and end times of the run to records passed to cb.
+// Run is considered to start when timed is called.
+func timed(cb taskExecCallback) taskExecCallback {
+	start := time.Now()
+	return func(rec *types.Record) {
+		rec.ExecutedAt = start
+		rec.FinishedAt = time.Now()
+		rec.Duration.Duration = rec.FinishedAt.Sub(start)
+		cb(rec)
+	}
+}
+
+// secretsKey is the context key for secret values available to a task run
+type secretsKey struct{}
+
+func usesSecrets(content any) bool {
+	if len(getTaskProperties(content).Secrets) > 0 {
+		return true
+	}
+	if multiTask, ok := content.(*types.MultiTask); ok {
+		for _, action := range multiTask.Actions {
+			if usesSecrets(action) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const redacted = "[REDACTED]"
+
+// redactSecrets removes secret values from anything the record might contain them in.
+// Output has been redacted as it was written already, this covers the rest.
+func redactSecrets(rec *types.Record, secrets map[string]string) {
+	redact := func(s string) string {
+		for _, value := range secrets {
+			if value == "" {
+				continue
+			}
+			s = strings.ReplaceAll(s, value, redacted)
+		}
+		return s
+	}
+
+	rec.Output = redact(rec.Output)
+	rec.Stderr = redact(rec.Stderr)
+	rec.Error = redact(rec.Error)
+	for i := range rec.Steps {
+		rec.Steps[i].Output = redact(rec.Steps[i].Output)
+		rec.Steps[i].Stderr = redact(rec.Steps[i].Stderr)
+		rec.Steps[i].Error = redact(rec.Steps[i].Error)
+	}
+}
+
+func makeCmdTask(task *types.Task) func(ctx context.Context, cb taskExecCallback) {
+	return func(ctx context.Context, cb taskExecCallback) {
 		cmdTask := task.Content.(*types.CmdTask)
 
-		cmd := exec.Command(cmdTask.Program, cmdTask.Args...)
-		var status int
-		var output string
-		err := execCmd(cmd, cmdTask.CombinedOutput, &status, &output)
+		res, err := runCmd(ctx, cmdTask)
 		if err != nil {
 			log.Println("failed to execute command task:", err)
+			cb(failedRecord(task.Name, 0, err.Error()))
 			return
 		}
 
-		cb(task.Name, status, output)
+		cb(recordOf(task, &res))
 	}
 }
 
-func makeScriptTask(task *types.Task, cb taskExecCallback) func() {
-	return func() {
+func makeScriptTask(task *types.Task) func(ctx context.Context, cb taskExecCallback) {
+	return func(ctx context.Context, cb taskExecCallback) {
 		scriptTask := task.Content.(*types.ScriptTask)
 
-		// TODO: check errors
-		cmd := exec.Command(scriptTask.Interpreter)
-		in, _ := cmd.StdinPipe()
-		_, _ = in.Write([]byte(scriptTask.Script))
-		_ = in.Close()
-
-		var status int
-		var output string
-		err := execCmd(cmd, scriptTask.CombinedOutput, &status, &output)
+		res, err := runScript(ctx, scriptTask)
 		if err != nil {
 			log.Println("failed to execute script task:", err)
+			cb(failedRecord(task.Name, 0, err.Error()))
 			return
 		}
 
-		cb(task.Name, status, output)
+		cb(recordOf(task, &res))
+	}
+}
+
+func makeHttpTask(task *types.Task) func(ctx context.Context, cb taskExecCallback) {
+	return func(ctx context.Context, cb taskExecCallback) {
+		httpTask := task.Content.(*types.HttpTask)
+
+		// unlike with commands, a failed request is still a result worth reporting
+		res := runHttp(ctx, httpTask)
+		if res.Error != "" {
+			log.Println("failed to execute http task:", res.Error)
+		}
+
+		cb(recordOf(task, &res))
 	}
 }
 
-func execCmd(cmd *exec.Cmd, combinedOutput bool, status *int, output *string) error {
+// recordOf makes record of a single action task from its result
+func recordOf(task *types.Task, res *types.ActionResult) *types.Record {
+	return &types.Record{
+		TaskName: task.Name,
+		Outcome:  res.Outcome,
+		Status:   res.Code,
+		Signal:   res.Signal,
+		Output:   res.Output,
+		Stderr:   res.Stderr,
+		Error:    res.Error,
+
+		OutputTruncated: res.OutputTruncated,
+		StderrTruncated: res.StderrTruncated,
+	}
+}
+
+func makeMultiTask(task *types.Task) func(ctx context.Context, cb taskExecCallback) {
+	return func(ctx context.Context, cb taskExecCallback) {
+		multiTask := task.Content.(*types.MultiTask)
+
+		rec := types.Record{
+			TaskName: task.Name,
+			Steps:    make([]types.ActionResult, 0, len(multiTask.Actions)),
+		}
+
+		// timeout of the multi task applies to all of its actions together
+		ctx, cancel := withTimeout(ctx, multiTask.Timeout.Duration)
+		defer cancel()
+
+		for i, action := range multiTask.Actions {
+			start := time.Now()
+			res, failed := runAction(ctx, action)
+			res.Duration.Duration = time.Since(start)
+			rec.Steps = append(rec.Steps, res)
+			if !failed {
+				continue
+			}
+
+			if getTaskProperties(action).ContinueOnError {
+				log.Printf("step %d of task %s failed, continuing\n", i+1, task.Name)
+				continue
+			}
+
+			// rest of the steps are not executed, and the whole task is considered failed
+			rec.Outcome = res.Outcome
+			rec.Status = res.Code
+			rec.Signal = res.Signal
+			rec.Error = fmt.Sprintf("step %d failed", i+1)
+			if res.Error != "" {
+				rec.Error += ": " + res.Error
+			}
+			break
+		}
+
+		cb(&rec)
+	}
+}
+
+// runAction executes a single action of a multi-action task.
+// Returned bool tells whether the action should be considered failed.
+func runAction(ctx context.Context, action any) (types.ActionResult, bool) {
+	switch a := action.(type) {
+	case *types.CmdTask:
+		res, err := runCmd(ctx, a)
+		if err != nil {
+			res.Error = err.Error()
+			return res, true
+		}
+		return res, res.Code != 0 || res.Outcome == types.RecordOutcomeTimedOut
+	case *types.ScriptTask:
+		res, err := runScript(ctx, a)
+		if err != nil {
+			res.Error = err.Error()
+			return res, true
+		}
+		return res, res.Code != 0 || res.Outcome == types.RecordOutcomeTimedOut
+	case *types.HttpTask:
+		res := runHttp(ctx, a)
+		return res, res.Error != ""
+	default:
+		return types.ActionResult{Error: "unsupported action type"}, true
+	}
+}
+
+func getTaskProperties(content any) types.TaskProperties {
+	switch c := content.(type) {
+	case *types.CmdTask:
+		return c.TaskProperties
+	case *types.ScriptTask:
+		return c.TaskProperties
+	case *types.HttpTask:
+		return c.TaskProperties
+	case *types.MultiTask:
+		return c.TaskProperties
+	default:
+		return types.TaskProperties{}
+	}
+}
+
+// withTimeout is like context.WithTimeout, but zero timeout means no timeout
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func runCmd(ctx context.Context, cmdTask *types.CmdTask) (types.ActionResult, error) {
+	cmd := exec.Command(cmdTask.Program, cmdTask.Args...)
+
+	return runProcess(ctx, cmd, cmdTask.TaskProperties)
+}
+
+func runScript(ctx context.Context, scriptTask *types.ScriptTask) (types.ActionResult, error) {
+	cmd := exec.Command(scriptTask.Interpreter)
+	cmd.Stdin = strings.NewReader(scriptTask.Script)
+
+	return runProcess(ctx, cmd, scriptTask.TaskProperties)
+}
+
+func runProcess(ctx context.Context, cmd *exec.Cmd, props types.TaskProperties) (types.ActionResult, error) {
+	if err := configureProcess(ctx, cmd, props); err != nil {
+		return types.ActionResult{}, err
+	}
+
+	ctx, cancel := withTimeout(ctx, props.Timeout.Duration)
+	defer cancel()
+
+	var res types.ActionResult
+	err := execCmd(ctx, cmd, props.CombinedOutput, &res)
+	if err == errTimedOut {
+		res.Outcome = types.RecordOutcomeTimedOut
+		res.Error = "timed out"
+		return res, nil
+	}
+	return res, err
+}
+
+func runHttp(ctx context.Context, httpTask *types.HttpTask) types.ActionResult {
+	ctx, cancel := withTimeout(ctx, httpTask.Timeout.Duration)
+	defer cancel()
+
+	var res types.ActionResult
+	if err := execHttp(ctx, httpTask, &res.Code, &res.Output, &res.OutputTruncated); err != nil {
+		res.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			res.Outcome = types.RecordOutcomeTimedOut
+		}
+	}
+	return res
+}
+
+// configureProcess applies environment, working directory, credentials and secrets of the task to cmd
+func configureProcess(ctx context.Context, cmd *exec.Cmd, props types.TaskProperties) error {
+	cmd.Dir = props.WorkingDir
+
+	env := os.Environ()
+	if props.User != "" || props.Group != "" {
+		userEnv, err := setCredential(cmd, props.User, props.Group)
+		if err != nil {
+			return err
+		}
+		env = append(env, userEnv...)
+	}
+
+	keys := make([]string, 0, len(props.Env))
+	for k := range props.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+props.Env[k])
+	}
+
+	secrets, _ := ctx.Value(secretsKey{}).(map[string]string)
+	for _, name := range props.Secrets {
+		value, found := secrets[name]
+		if !found {
+			return fmt.Errorf("secret %s not available", name)
+		}
+		env = append(env, name+"="+value)
+	}
+
+	// in case of duplicates, last value is used
+	cmd.Env = env
+
+	return nil
+}
+
+var errTimedOut = errors.New("timed out")
+
+// how long a process is given to exit after SIGTERM before it is killed
+const killGracePeriod = 5 * time.Second
+
+// execCmd runs cmd in its own process group. If ctx is done before it exits,
+// the whole group is terminated and errTimedOut is returned, with exit code of the process still set in res.
+// Stdout and stderr are captured into res separately, unless combinedOutput is set.
+// Each is capped to configured size, and truncated fields tell how many bytes were left out.
+func execCmd(ctx context.Context, cmd *exec.Cmd, combinedOutput bool, res *types.ActionResult) error {
+	if res == nil {
+		res = new(types.ActionResult)
+	}
+
+	// secrets are redacted before output is truncated, as truncation could cut them in half
+	secrets, _ := ctx.Value(secretsKey{}).(map[string]string)
+	r := newRedactor(secrets)
+
+	stdout := newOutputBuffer(config.MaxOutputBytes)
+	stdoutW := newRedactingWriter(r, stdout)
+	stderr, stderrW := stdout, stdoutW
+	if !combinedOutput {
+		stderr = newOutputBuffer(config.MaxOutputBytes)
+		stderrW = newRedactingWriter(r, stderr)
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	if live := liveOutputFrom(ctx); live != nil {
+		if combinedOutput {
+			// same writer for both keeps them in order
+			w := io.MultiWriter(stdoutW, live.writer(types.StreamStdout))
+			cmd.Stdout, cmd.Stderr = w, w
+		} else {
+			cmd.Stdout = io.MultiWriter(stdoutW, live.writer(types.StreamStdout))
+			cmd.Stderr = io.MultiWriter(stderrW, live.writer(types.StreamStderr))
+		}
+	}
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	timedOut := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		close(timedOut)
+
+		_ = terminateProcessGroup(cmd)
+		t := time.NewTimer(killGracePeriod)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+		}
+		// children may still be running even if the process itself exited,
+		// so kill the group in any case
+		_ = killProcessGroup(cmd)
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	stdoutW.flush()
+	if stderrW != stdoutW {
+		stderrW.flush()
+	}
+	res.Output = stdout.String()
+	res.OutputTruncated = stdout.dropped()
+	if stderr != stdout {
+		res.Stderr = stderr.String()
+		res.StderrTruncated = stderr.dropped()
+	}
+	if cmd.ProcessState != nil {
+		res.Signal = exitSignal(cmd.ProcessState)
+	}
+
+	switch e := err.(type) {
+	case nil:
+		res.Code = 0
+	case *exec.ExitError:
+		res.Code = e.ExitCode()
+		if res.Signal != 0 {
+			// like shells report it, exit code alone would be -1
+			res.Code = 128 + res.Signal
+		}
+		err = nil
+	default:
+	}
+
+	select {
+	case <-timedOut:
+		return errTimedOut
+	default:
+	}
+	return err
+}
+
+func execHttp(ctx context.Context, task *types.HttpTask, status *int, output *string, truncated *int64) error {
 	if status == nil {
 		status = new(int)
 	}
 	if output == nil {
 		output = new(string)
 	}
+	if truncated == nil {
+		truncated = new(int64)
+	}
 
-	var b []byte
-	var err error
+	req, err := makeHttpRequest(task)
+	if err != nil {
+		return err
+	}
 
-	if combinedOutput {
-		b, err = cmd.CombinedOutput()
-	} else {
-		b, err = cmd.Output()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	*output = string(b)
+	*status = resp.StatusCode
 
-	if err == nil {
-		*status = 0
-		return nil
+	secrets, _ := ctx.Value(secretsKey{}).(map[string]string)
+	b := newOutputBuffer(config.MaxOutputBytes)
+	rw := newRedactingWriter(newRedactor(secrets), b)
+	var w io.Writer = rw
+	if live := liveOutputFrom(ctx); live != nil {
+		w = io.MultiWriter(rw, live.writer(types.StreamStdout))
+	}
+	_, err = io.Copy(w, resp.Body)
+	rw.flush()
+	*output = b.String()
+	*truncated = b.dropped()
+	if err != nil {
+		return err
 	}
 
-	switch err := err.(type) {
-	case *exec.ExitError:
-		*status = err.ExitCode()
-		return nil
-	default:
+	if !isExpectedStatus(resp.StatusCode, task.ExpectedStatus) {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
-	return err
+
+	return nil
+}
+
+func makeHttpRequest(task *types.HttpTask) (*http.Request, error) {
+	method := strings.ToUpper(task.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	if len(task.Form) > 0 && task.Body != "" {
+		return nil, errors.New("task defines both form and body")
+	}
+
+	u, err := url.Parse(task.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	var contentType string
+
+	if len(task.Form) > 0 {
+		form := url.Values{}
+		for k, v := range task.Form {
+			form.Set(k, v)
+		}
+		if method == http.MethodGet || method == http.MethodHead {
+			// there is no body to put the form in, so use query parameters instead
+			q := u.Query()
+			for k, v := range form {
+				q[k] = v
+			}
+			u.RawQuery = q.Encode()
+		} else {
+			body = strings.NewReader(form.Encode())
+			contentType = "application/x-www-form-urlencoded"
+		}
+	} else if task.Body != "" {
+		body = strings.NewReader(task.Body)
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range task.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
+
+func isExpectedStatus(code int, expected []int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, e := range expected {
+		if code == e {
+			return true
+		}
+	}
+	return false
 }
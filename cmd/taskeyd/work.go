@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
 	"errors"
 	"log"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/LassiHeikkila/taskey/pkg/schedule"
@@ -17,42 +22,279 @@ func executeSchedule(ctx context.Context, sched *types.Schedule, tasks map[strin
 	if len(tasks) == 0 {
 		return errors.New("no tasks defined")
 	}
-	executor, err := schedule.NewExecutor()
+	queuePath := config.QueuePath
+	if queuePath == "" {
+		queuePath = defaultQueuePath()
+	}
+	queue, err := openRecordQueue(queuePath, config.MaxQueuedRecords)
 	if err != nil {
 		return err
 	}
+	defer queue.close()
 
-	if err := executor.SetSchedule(*sched); err != nil {
-		return err
-	}
+	go queue.run(ctx, func(records []types.Record) ([]error, error) {
+		return postResults(config.AccessToken, config.URL, config.Organization, records)
+	})
 
-	execCb := taskExecCallback(func(name string, status int, output string) {
-		log.Println("executed task", name, "with status", status) //, "and output:\n", output)
-		rec := types.Record{
-			TaskName:   name,
-			ExecutedAt: time.Now(),
-			Status:     status,
-			Output:     output,
+	execCb := taskExecCallback(func(rec *types.Record) {
+		log.Println("executed task", rec.TaskName, "with outcome", rec.Outcome, "and status", rec.Status) //, "and output:\n", rec.Output)
+		if rec.ExecutedAt.IsZero() {
+			// nothing was run, so there is no duration either
+			rec.ExecutedAt = time.Now()
+			rec.FinishedAt = rec.ExecutedAt
 		}
-		if err := postResult(config.AccessToken, config.URL, config.Organization, &rec); err != nil {
-			log.Println("error posting result:", err)
+		// records are delivered in order by the queue, and kept until server can be reached
+		if err := queue.push(rec); err != nil {
+			log.Println("error queueing result:", err)
 		}
 	})
 
-	for name, task := range tasks {
-		if err := executor.ConfigureTask(name, makeTask(task, execCb)); err != nil {
-			return err
-		}
+	executor, err := schedule.NewExecutor(schedule.WithSkipHandler(func(task string) {
+		log.Println("skipping run of task", task, "because previous run is still in progress")
+		execCb(&types.Record{
+			TaskName: task,
+			Outcome:  types.RecordOutcomeSkipped,
+			Error:    "skipped: previous run still in progress",
+		})
+	}))
+	if err != nil {
+		return err
+	}
+
+	r := newReconciler(executor, execCb)
+	if err := r.apply(sched, tasks); err != nil {
+		return err
 	}
 
 	err = executor.Start(ctx)
-	defer executor.Stop()
+	defer func() {
+		if err := executor.Stop(); err != nil {
+			log.Println("error stopping executor:", err)
+		}
+	}()
 
 	if err != nil {
 		return err
 	}
 
+	go r.poll(ctx)
+	go pollTriggers(ctx, r, execCb)
+	go sendHeartbeats(ctx, r)
+
 	<-ctx.Done()
 
+	sendOfflineHeartbeat(r)
+
+	return nil
+}
+
+const schedulePollInterval = 30 * time.Second
+
+// reconciler keeps the executor in sync with the schedule and tasks defined on the server.
+// Only entries and tasks which have changed are touched, so runs in progress are not disturbed.
+type reconciler struct {
+	executor schedule.Executor
+	cb       taskExecCallback
+
+	mu sync.Mutex
+	// tasks currently configured, with their definition encoded to detect changes
+	tasks    map[string]*types.Task
+	taskDefs map[string]string
+
+	// hash of schedule and tasks currently applied, reported in heartbeats
+	hash string
+
+	scheduleETag string
+	tasksETag    string
+	schedule     *types.Schedule
+}
+
+func newReconciler(executor schedule.Executor, cb taskExecCallback) *reconciler {
+	return &reconciler{
+		executor: executor,
+		cb:       cb,
+		tasks:    make(map[string]*types.Task),
+		taskDefs: make(map[string]string),
+	}
+}
+
+// task returns the current definition of the named task, or nil if it is not defined
+func (r *reconciler) task(name string) *types.Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tasks[name]
+}
+
+// poll periodically checks the server for changes to schedule or tasks and applies them
+func (r *reconciler) poll(ctx context.Context) {
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// ETags are only updated once changes have been applied,
+		// otherwise a failed poll would hide the changes from the following ones
+		scheduleETag, tasksETag := r.scheduleETag, r.tasksETag
+
+		sched, err := fetchSchedule(config.AccessToken, config.URL, config.Organization, &scheduleETag)
+		if err != nil && err != errNotModified {
+			log.Println("error fetching schedule:", err)
+			continue
+		}
+		tasks, err := fetchTasks(config.AccessToken, config.URL, config.Organization, &tasksETag)
+		if err != nil && err != errNotModified {
+			log.Println("error fetching tasks:", err)
+			continue
+		}
+
+		if sched == nil && tasks == nil {
+			// neither has changed
+			continue
+		}
+
+		if err := r.apply(sched, tasks); err != nil {
+			log.Println("error applying schedule changes:", err)
+			continue
+		}
+		r.scheduleETag, r.tasksETag = scheduleETag, tasksETag
+	}
+}
+
+// apply brings the executor up to date with given schedule and tasks.
+// nil schedule or tasks are interpreted as unchanged.
+func (r *reconciler) apply(sched *types.Schedule, tasks map[string]*types.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tasks != nil {
+		if err := r.applyTasks(tasks); err != nil {
+			return err
+		}
+	}
+	if sched != nil {
+		// executor keeps entries which haven't changed as they are
+		if err := r.executor.SetSchedule(*sched); err != nil {
+			return err
+		}
+		r.schedule = sched
+	}
+	r.hash = r.computeHash()
+	return nil
+}
+
+// scheduleHash returns hash of schedule and tasks currently applied
+func (r *reconciler) scheduleHash() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.hash
+}
+
+func (r *reconciler) computeHash() string {
+	h := sha256.New()
+	b, _ := stdjson.Marshal(r.schedule)
+	h.Write(b)
+
+	names := make([]string, 0, len(r.taskDefs))
+	for name := range r.taskDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(r.taskDefs[name]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *reconciler) applyTasks(tasks map[string]*types.Task) error {
+	for name, task := range tasks {
+		b, err := stdjson.Marshal(task)
+		if err != nil {
+			return err
+		}
+		def := string(b)
+		if old, found := r.taskDefs[name]; found && old == def {
+			continue
+		}
+
+		log.Println("configuring task", name)
+		if err := r.executor.ConfigureTask(name, makeTask(task, r.cb)); err != nil {
+			return err
+		}
+		r.tasks[name] = task
+		r.taskDefs[name] = def
+	}
+
+	for name := range r.tasks {
+		if _, found := tasks[name]; found {
+			continue
+		}
+
+		log.Println("removing task", name)
+		if err := r.executor.ConfigureTask(name, nil); err != nil {
+			return err
+		}
+		delete(r.tasks, name)
+		delete(r.taskDefs, name)
+	}
+
 	return nil
 }
+
+const triggerPollInterval = 15 * time.Second
+
+// pollTriggers periodically checks if any runs have been queued for this machine,
+// and runs them immediately, reporting results against the trigger.
+// Every trigger gets a record, even if it can't be run, as server considers it running until then.
+func pollTriggers(ctx context.Context, r *reconciler, cb taskExecCallback) {
+	ticker := time.NewTicker(triggerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		triggers, err := fetchTriggers(config.AccessToken, config.URL, config.Organization)
+		if err != nil {
+			log.Println("error fetching triggers:", err)
+			continue
+		}
+
+		for _, trigger := range triggers {
+			task := r.task(trigger.TaskName)
+			if task == nil {
+				log.Println("triggered task", trigger.TaskName, "is not defined")
+				cb(failedRecord(trigger.TaskName, trigger.ID, "task is not defined on machine"))
+				continue
+			}
+
+			log.Println("running triggered task", task.Name, "for trigger", trigger.ID)
+			// concurrency policy of the task applies to triggered runs as well
+			err := r.executor.RunTask(task.Name, makeTriggeredTask(task, trigger.ID, cb))
+			switch err {
+			case nil:
+			case schedule.ErrSkipped:
+				log.Println("skipping triggered run of task", task.Name, "because previous run is still in progress")
+				cb(&types.Record{
+					TaskName:  task.Name,
+					TriggerID: trigger.ID,
+					Outcome:   types.RecordOutcomeSkipped,
+					Error:     "skipped: previous run still in progress",
+				})
+			default:
+				log.Println("error running triggered task", task.Name+":", err)
+				cb(failedRecord(task.Name, trigger.ID, err.Error()))
+			}
+		}
+	}
+}
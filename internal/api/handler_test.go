@@ -1,18 +1,23 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgtype"
 	"gorm.io/gorm"
 
+	"github.com/LassiHeikkila/taskey/internal/auth"
 	"github.com/LassiHeikkila/taskey/internal/auth/mock"
 	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/internal/db/mock"
@@ -92,6 +97,13 @@ func TestRouteRegistrationMachine(t *testing.T) {
 	if !matched {
 		t.Fatal("valid route not matched:", rm.MatchErr)
 	}
+
+	// enrolling doesn't require authentication, and must not be mistaken for a machine called enroll
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/org123/machines/enroll/", nil)
+	rm = mux.RouteMatch{}
+	if !h.router.Match(req, &rm) || rm.MatchErr != nil {
+		t.Fatal("enroll route not matched:", rm.MatchErr)
+	}
 }
 
 func TestRouteRegistrationTask(t *testing.T) {
@@ -164,6 +176,87 @@ func TestRouteRegistrationAuth(t *testing.T) {
 	if !matched {
 		t.Fatal("valid route not matched:", rm.MatchErr)
 	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	if !h.router.Match(req, &rm) {
+		t.Fatal("valid route not matched:", rm.MatchErr)
+	}
+}
+
+func TestRouteRegistrationTrigger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if h == nil {
+		t.Fatal("nil handler created")
+	}
+
+	err := h.RegisterTriggerHandlers()
+	if err != nil {
+		t.Fatal("error returned by handler registration method")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineABC/trigger/42/", nil)
+	rm := mux.RouteMatch{}
+
+	matched := h.router.Match(req, &rm)
+	if !matched {
+		t.Fatal("valid route not matched:", rm.MatchErr)
+	}
+}
+
+func TestRouteRegistrationGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if h == nil {
+		t.Fatal("nil handler created")
+	}
+
+	err := h.RegisterGroupHandlers()
+	if err != nil {
+		t.Fatal("error returned by handler registration method")
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, "/api/v1/org123/groups/servers/machines/machineABC/", nil)
+	rm := mux.RouteMatch{}
+
+	matched := h.router.Match(req, &rm)
+	if !matched {
+		t.Fatal("valid route not matched:", rm.MatchErr)
+	}
+}
+
+func TestRouteRegistrationSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if h == nil {
+		t.Fatal("nil handler created")
+	}
+
+	if err := h.RegisterSecretHandlers([]byte("too short")); err == nil {
+		t.Fatal("invalid secret key accepted")
+	}
+
+	err := h.RegisterSecretHandlers(make([]byte, auth.SecretKeySize))
+	if err != nil {
+		t.Fatal("error returned by handler registration method")
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, "/api/v1/org123/secrets/DB_PASSWORD/", nil)
+	rm := mux.RouteMatch{}
+
+	matched := h.router.Match(req, &rm)
+	if !matched {
+		t.Fatal("valid route not matched:", rm.MatchErr)
+	}
 }
 
 func TestProcessRequestGetOrganization(t *testing.T) {
@@ -211,21 +304,14 @@ func TestProcessRequestGetOrganization(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -310,21 +396,14 @@ func TestProcessRequestGetUser(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	}).Times(2)
 
@@ -459,21 +538,14 @@ func TestProcessRequestGetUsers(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -576,21 +648,14 @@ func TestProcessRequestGetMachine(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -687,21 +752,14 @@ func TestProcessRequestGetMachines(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -815,21 +873,14 @@ func TestProcessRequestGetTask(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -925,21 +976,14 @@ func TestProcessRequestGetTasks(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -1048,21 +1092,14 @@ func TestProcessRequestGetRecord(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -1088,33 +1125,7 @@ func TestProcessRequestGetRecord(t *testing.T) {
 		Output:     "success",
 	}
 
-	record2 := db.Record{
-		Model: gorm.Model{
-			ID: 1235,
-		},
-		MachineID:  678,
-		Machine:    machineXYZ,
-		ExecutedAt: time.Now().Add(-30 * time.Minute),
-		Status:     0,
-		Output:     "success",
-	}
-
-	record3 := db.Record{
-		Model: gorm.Model{
-			ID: 1236,
-		},
-		MachineID:  678,
-		Machine:    machineXYZ,
-		ExecutedAt: time.Now(),
-		Status:     2,
-		Output:     "failure",
-	}
-
-	d.EXPECT().ReadRecords("machineXYZ").Return([]db.Record{
-		record1,
-		record2,
-		record3,
-	}, nil)
+	d.EXPECT().ReadRecord(uint(1234)).Return(&record1, nil)
 
 	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{
 		Model: gorm.Model{
@@ -1201,21 +1212,14 @@ func TestProcessRequestGetRecords(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -1263,7 +1267,7 @@ func TestProcessRequestGetRecords(t *testing.T) {
 		Output:     "failure",
 	}
 
-	d.EXPECT().ReadRecords("machineXYZ").Return([]db.Record{
+	d.EXPECT().ReadRecords("machineXYZ", gomock.Any()).Return([]db.Record{
 		record1,
 		record2,
 		record3,
@@ -1354,21 +1358,14 @@ func TestProcessRequestGetMachineSchedule(t *testing.T) {
 	a.EXPECT().ValidateUserToken(
 		"my test key",
 		gomock.Any(),
-		gomock.Any(),
-		gomock.Any(),
-	).DoAndReturn(func(tokenString string, user *string, organization *string, role *int) bool {
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
 		if tokenString != "my test key" {
 			return false
 		}
-		if user != nil {
-			*user = "user456"
-		}
-		if organization != nil {
-			*organization = "org123"
-		}
-		if role != nil {
-			*role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
-		}
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer | types.RoleAdministrator | types.RoleRoot)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
 		return true
 	})
 
@@ -1428,3 +1425,1420 @@ func TestProcessRequestGetMachineSchedule(t *testing.T) {
 		t.Fatal("response not 200:", response)
 	}
 }
+
+func TestProcessRequestCreateMachineTrigger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if h == nil {
+		t.Fatal("nil handler created")
+	}
+
+	if err := h.RegisterTriggerHandlers(); err != nil {
+		t.Fatal("error registering trigger handlers:", err)
+	}
+
+	server := httptest.NewServer(h)
+
+	if server == nil {
+		t.Fatal("failed to create test server")
+	}
+
+	client := http.DefaultClient
+	req, _ := http.NewRequest(
+		http.MethodPost,
+		server.URL+"/api/v1/org123/machines/machineXYZ/trigger/",
+		strings.NewReader(`{"taskName":"taskABC"}`),
+	)
+	req.Header.Set("Authorization", "Bearer my test key")
+
+	a.EXPECT().ValidateUserToken(
+		"my test key",
+		gomock.Any(),
+	).DoAndReturn(func(tokenString string, claims *auth.UserClaims) bool {
+		claims.User = "user456"
+		claims.Organization = "org123"
+		claims.Role = int(types.RoleUser | types.RoleMaintainer)
+		claims.Id = "5a8d2c1e-7b3f-4e6a-9c0d-1f2e3d4c5b6a"
+		return true
+	})
+
+	machineXYZ := db.Machine{
+		Model: gorm.Model{
+			ID: 678,
+		},
+		Name:           "machineXYZ",
+		OrganizationID: 123,
+	}
+	taskABC := db.Task{
+		Model: gorm.Model{
+			ID: 910,
+		},
+		Name:           "taskABC",
+		OrganizationID: 123,
+	}
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{
+		Model: gorm.Model{
+			ID: 123,
+		},
+		Name: "org123",
+	}, nil).Times(2)
+	d.EXPECT().ReadUser("user456").Return(&db.User{
+		Model: gorm.Model{
+			ID: 456,
+		},
+		Name:           "user456",
+		OrganizationID: 123,
+		Role:           types.RoleUser | types.RoleMaintainer,
+	}, nil)
+	d.EXPECT().ReadMachine("machineXYZ").Return(&machineXYZ, nil)
+	d.EXPECT().ReadTask("taskABC").Return(&taskABC, nil)
+	d.EXPECT().CreateTrigger(gomock.Any()).DoAndReturn(func(trigger *db.Trigger) error {
+		if trigger.MachineID != 678 || trigger.TaskID != 910 {
+			return errors.New("wrong machine or task")
+		}
+		if trigger.State != types.TriggerStatePending {
+			return errors.New("trigger not pending")
+		}
+		trigger.ID = 42
+		return nil
+	})
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("error doing request:", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Code    int           `json:"code"`
+		Payload types.Trigger `json:"payload"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+
+	if err := json.Unmarshal(b, &response); err != nil {
+		t.Fatal("failed to decode response as JSON: \"", err, "\", response was: \"", string(b), "\"")
+	}
+
+	if response.Code != 200 {
+		t.Fatal("response not 200:", response)
+	}
+	if response.Payload.ID != 42 {
+		t.Fatal("wrong trigger ID returned:", response.Payload.ID)
+	}
+}
+
+func TestProcessRequestCreateMachineGroupTrigger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	org123 := &db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}
+	servers := &db.MachineGroup{
+		Model:          gorm.Model{ID: 5},
+		Name:           "servers",
+		OrganizationID: 123,
+		Machines: []db.Machine{
+			{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123},
+			{Model: gorm.Model{ID: 679}, Name: "machineXYW", OrganizationID: 123},
+		},
+	}
+	taskABC := &db.Task{Model: gorm.Model{ID: 910}, Name: "taskABC", OrganizationID: 123}
+
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).AnyTimes()
+	trigger := func(group string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/groups/"+group+"/trigger/", strings.NewReader(body))
+		h.createMachineGroupTrigger(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123", groupIDKey: group}))
+		return w
+	}
+
+	// every member gets its own pending trigger
+	d.EXPECT().ReadMachineGroup(uint(123), "servers").Return(servers, nil)
+	d.EXPECT().ReadTask("taskABC").Return(taskABC, nil)
+	d.EXPECT().CreateTriggers(gomock.Any()).DoAndReturn(func(triggers []db.Trigger) error {
+		if len(triggers) != 2 || triggers[0].MachineID != 678 || triggers[1].MachineID != 679 {
+			t.Fatal("unexpected triggers:", triggers)
+		}
+		for i := range triggers {
+			if triggers[i].TaskID != 910 || triggers[i].State != types.TriggerStatePending {
+				t.Fatal("unexpected trigger:", triggers[i])
+			}
+			triggers[i].ID = uint(42 + i)
+		}
+		return nil
+	})
+	w := trigger("servers", `{"taskName":"taskABC"}`)
+	var response struct {
+		Code    int             `json:"code"`
+		Payload []types.Trigger `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+	if len(response.Payload) != 2 ||
+		response.Payload[0].ID != 42 || response.Payload[0].MachineName != "machineXYZ" ||
+		response.Payload[1].ID != 43 || response.Payload[1].MachineName != "machineXYW" {
+		t.Fatal("unexpected triggers returned:", response.Payload)
+	}
+
+	// task of another organization is not found
+	d.EXPECT().ReadMachineGroup(uint(123), "servers").Return(servers, nil)
+	d.EXPECT().ReadTask("taskOther").Return(&db.Task{Model: gorm.Model{ID: 911}, Name: "taskOther", OrganizationID: 124}, nil)
+	if w := trigger("servers", `{"taskName":"taskOther"}`); w.Code != http.StatusNotFound {
+		t.Fatal("task of another organization triggered:", w.Code)
+	}
+
+	// nothing to run the task on
+	d.EXPECT().ReadMachineGroup(uint(123), "empty").Return(&db.MachineGroup{Name: "empty", OrganizationID: 123}, nil)
+	if w := trigger("empty", `{"taskName":"taskABC"}`); w.Code != http.StatusBadRequest {
+		t.Fatal("empty group triggered:", w.Code)
+	}
+
+	// unknown group
+	d.EXPECT().ReadMachineGroup(uint(123), "missing").Return(nil, errors.New("not found"))
+	if w := trigger("missing", `{"taskName":"taskABC"}`); w.Code != http.StatusNotFound {
+		t.Fatal("missing group triggered:", w.Code)
+	}
+}
+
+func TestProcessRequestGetMachineOwnScheduleNotModified(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	machineXYZ := db.Machine{
+		Model: gorm.Model{
+			ID: 678,
+		},
+		Name:           "machineXYZ",
+		OrganizationID: 123,
+	}
+
+	scheduleXYZ := db.Schedule{
+		MachineID: 678,
+		Machine:   machineXYZ,
+		Content:   db.StringToJSON(`{"periodicTasks":[{"what":"task456","interval":"3m"}]}`),
+	}
+
+	d.EXPECT().ReadMachine("machineXYZ").Return(&machineXYZ, nil).Times(2)
+	d.EXPECT().ReadSchedule("machineXYZ").Return(&scheduleXYZ, nil).Times(2)
+	d.EXPECT().ReadMachineGroupsOf(uint(678)).Return(nil, nil).Times(2)
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{
+		Model: gorm.Model{
+			ID: 123,
+		},
+		Name: "org123",
+	}, nil).Times(2)
+
+	self := &types.Machine{Name: "machineXYZ"}
+	vars := map[string]string{orgIDKey: "org123"}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/self/schedule/", nil)
+	h.readMachineOwnSchedule(w, mux.SetURLVars(req, vars), self)
+
+	if w.Code != http.StatusOK {
+		t.Fatal("response not 200:", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag returned")
+	}
+
+	// unchanged schedule shouldn't be sent again
+	w2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/self/schedule/", nil)
+	req2.Header.Set("If-None-Match", etag)
+	h.readMachineOwnSchedule(w2, mux.SetURLVars(req2, vars), self)
+
+	if w2.Code != http.StatusNotModified {
+		t.Fatal("response not 304:", w2.Code)
+	}
+	if w2.Body.Len() != 0 {
+		t.Fatal("body returned with 304:", w2.Body.String())
+	}
+}
+
+func TestProcessRequestSecretRoundTrip(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if err := h.RegisterSecretHandlers(make([]byte, auth.SecretKeySize)); err != nil {
+		t.Fatal("error registering secret handlers:", err)
+	}
+
+	org123 := &db.Organization{
+		Model: gorm.Model{
+			ID: 123,
+		},
+		Name: "org123",
+	}
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).Times(3)
+
+	// create secret, check that it is not stored in plain text
+	var stored db.Secret
+	d.EXPECT().ReadSecret(uint(123), "DB_PASSWORD").Return(nil, errors.New("not found"))
+	d.EXPECT().CreateSecret(gomock.Any()).DoAndReturn(func(s *db.Secret) error {
+		stored = *s
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/secrets/", strings.NewReader(`{"name":"DB_PASSWORD","value":"hunter2"}`))
+	h.createSecret(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+
+	if w.Code != http.StatusOK {
+		t.Fatal("response not 200:", w.Code, w.Body.String())
+	}
+	if strings.Contains(string(stored.Value), "hunter2") || strings.Contains(w.Body.String(), "hunter2") {
+		t.Fatal("secret value leaked")
+	}
+
+	// machine fetches secrets of a task referencing it
+	machineXYZ := &db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}
+	d.EXPECT().ReadMachine("machineXYZ").Return(machineXYZ, nil).Times(2)
+	d.EXPECT().ReadSchedule("machineXYZ").Return(&db.Schedule{
+		MachineID: 678,
+		Content:   db.StringToJSON(`{"cron":[{"cron":"0 0 * * * *","taskID":"task123"}]}`),
+	}, nil).Times(2)
+	d.EXPECT().ReadMachineGroupsOf(uint(678)).Return(nil, nil).Times(2)
+	d.EXPECT().ReadTask("task123").Return(&db.Task{
+		Name:           "task123",
+		OrganizationID: 123,
+		Content:        db.StringToJSON(`{"type":"cmd","program":"psql","secrets":["DB_PASSWORD"]}`),
+	}, nil)
+	d.EXPECT().ReadSecret(uint(123), "DB_PASSWORD").Return(&stored, nil)
+
+	w2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/self/tasks/task123/secrets/", nil)
+	h.readMachineTaskSecrets(w2, mux.SetURLVars(req2, map[string]string{orgIDKey: "org123", taskIDKey: "task123"}), &types.Machine{Name: "machineXYZ"})
+
+	var response struct {
+		Code    int            `json:"code"`
+		Payload []types.Secret `json:"payload"`
+	}
+	if err := json.Unmarshal(w2.Body.Bytes(), &response); err != nil {
+		t.Fatal("failed to decode response:", err)
+	}
+	if response.Code != http.StatusOK || len(response.Payload) != 1 || response.Payload[0].Value != "hunter2" {
+		t.Fatal("unexpected response:", w2.Body.String())
+	}
+
+	// task of the same organization which the machine doesn't run
+	d.EXPECT().ReadTask("task456").Return(&db.Task{
+		Model:          gorm.Model{ID: 456},
+		Name:           "task456",
+		OrganizationID: 123,
+		Content:        db.StringToJSON(`{"type":"cmd","program":"psql","secrets":["DB_PASSWORD"]}`),
+	}, nil)
+	d.EXPECT().ReadTriggers("machineXYZ", types.TriggerStatePending).Return(nil, nil)
+	d.EXPECT().ReadTriggers("machineXYZ", types.TriggerStateRunning).Return([]db.Trigger{{MachineID: 678, TaskID: 123}}, nil)
+
+	w3 := httptest.NewRecorder()
+	req3 := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/self/tasks/task456/secrets/", nil)
+	h.readMachineTaskSecrets(w3, mux.SetURLVars(req3, map[string]string{orgIDKey: "org123", taskIDKey: "task456"}), &types.Machine{Name: "machineXYZ"})
+	if w3.Code != http.StatusNotFound || strings.Contains(w3.Body.String(), "hunter2") {
+		t.Fatal("secrets of task not run by machine returned:", w3.Code, w3.Body.String())
+	}
+}
+
+func TestProcessRequestAddRecordsBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	machineXYZ := &db.Machine{
+		Model: gorm.Model{
+			ID: 678,
+		},
+		Name:           "machineXYZ",
+		OrganizationID: 123,
+	}
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil)
+	d.EXPECT().ReadMachine("machineXYZ").Return(machineXYZ, nil)
+	d.EXPECT().ReadTask("task123").Return(&db.Task{Model: gorm.Model{ID: 42}, Name: "task123", OrganizationID: 123}, nil).Times(5)
+	d.EXPECT().ReadTask("missing").Return(nil, errors.New("not found"))
+	// trigger of another machine
+	d.EXPECT().ReadTrigger(uint(7)).Return(&db.Trigger{Model: gorm.Model{ID: 7}, MachineID: 999, TaskID: 42}, nil)
+
+	// only valid records reach the database, and the last one fails there
+	d.EXPECT().CreateRecords(gomock.Any()).DoAndReturn(func(records []db.Record) ([]error, error) {
+		if len(records) != 3 {
+			t.Fatal("unexpected number of records passed to database:", len(records))
+		}
+		if records[0].Outcome != types.RecordOutcomeCompleted {
+			t.Fatal("outcome not defaulted to completed:", records[0].Outcome)
+		}
+		// older machines report timeouts as status -2
+		if records[1].Outcome != types.RecordOutcomeTimedOut || records[1].Status != 0 {
+			t.Fatal("legacy status not converted to outcome:", records[1].Outcome, records[1].Status)
+		}
+		records[0].ID = 1000
+		records[1].ID = 1001
+		return []error{nil, nil, errors.New("constraint violation")}, nil
+	})
+
+	body := `[
+		{"taskName":"task123","status":0},
+		{"taskName":"missing","status":0},
+		{"taskName":"task123","triggerId":7,"status":0},
+		{"taskName":"task123","outcome":"exploded","status":0},
+		{"taskName":"task123","status":-2},
+		{"taskName":"task123","status":1}
+	]`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/self/records/batch/", strings.NewReader(body))
+	h.addRecords(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}), &types.Machine{Name: "machineXYZ"})
+
+	var response struct {
+		Code    int                  `json:"code"`
+		Payload []types.RecordResult `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("failed to decode response:", err)
+	}
+	if response.Code != http.StatusOK {
+		t.Fatal("response not 200:", w.Body.String())
+	}
+
+	want := []types.RecordResult{
+		{ID: 1000, Code: http.StatusOK, Message: "ok"},
+		{Code: http.StatusNotFound, Message: "not found"},
+		{Code: http.StatusBadRequest, Message: "bad request"},
+		{Code: http.StatusBadRequest, Message: "bad request"},
+		{ID: 1001, Code: http.StatusOK, Message: "ok"},
+		{Code: http.StatusInternalServerError, Message: "failure"},
+	}
+	if !reflect.DeepEqual(response.Payload, want) {
+		t.Fatal("unexpected results:", response.Payload)
+	}
+}
+
+func TestProcessRequestGetRecordsPaginated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil)
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil)
+	d.EXPECT().ReadRecords("machineXYZ", gomock.Any()).DoAndReturn(func(machineName string, query db.RecordQuery) ([]db.Record, error) {
+		if query.TaskName != "backup" || len(query.Statuses) != 1 || query.Statuses[0] != 2 {
+			t.Fatal("filters not passed to database:", query)
+		}
+		if query.SortBy != db.RecordSortStatus || !query.Descending || query.Limit != 3 {
+			t.Fatal("unexpected sort or limit:", query)
+		}
+		return []db.Record{
+			{Model: gorm.Model{ID: 3}, MachineID: 678, Status: 2},
+			{Model: gorm.Model{ID: 2}, MachineID: 678, Status: 2},
+			{Model: gorm.Model{ID: 1}, MachineID: 678, Status: 2},
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineXYZ/records/?task=backup&status=2&sort=-status&limit=2", nil)
+	h.readRecords(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123", machineIDKey: "machineXYZ"}))
+
+	var response struct {
+		Code       int            `json:"code"`
+		Payload    []types.Record `json:"payload"`
+		NextCursor string         `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("failed to decode response:", err)
+	}
+	if response.Code != http.StatusOK || len(response.Payload) != 2 || response.NextCursor == "" {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+
+	cursor, err := decodeRecordCursor(response.NextCursor)
+	if err != nil || cursor.ID != 2 {
+		t.Fatal("cursor does not point at last record of page:", cursor, err)
+	}
+}
+
+func TestProcessRequestRetentionPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	org123 := &db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}
+	task42 := &db.Task{Model: gorm.Model{ID: 42}, Name: "task42", OrganizationID: 123}
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).Times(3)
+	d.EXPECT().ReadTask("task42").Return(task42, nil).Times(3)
+
+	// policy is created when task doesn't have one yet
+	var stored db.RetentionPolicy
+	d.EXPECT().ReadRetentionPolicy(uint(123), uint(42)).Return(nil, errors.New("not found"))
+	d.EXPECT().CreateRetentionPolicy(gomock.Any()).DoAndReturn(func(p *db.RetentionPolicy) error {
+		stored = *p
+		stored.ID = 1
+		return nil
+	})
+
+	vars := map[string]string{orgIDKey: "org123", taskIDKey: "task42"}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/org123/tasks/task42/retention/", strings.NewReader(`{"maxAge":"720h","keepFailures":10}`))
+	h.updateRetentionPolicy(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusOK {
+		t.Fatal("response not 200:", w.Code, w.Body.String())
+	}
+	if stored.OrganizationID != 123 || stored.TaskID != 42 || stored.MaxAge != 720*time.Hour || stored.KeepFailures != 10 {
+		t.Fatal("unexpected policy stored:", stored)
+	}
+
+	// negative limits are rejected
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/org123/tasks/task42/retention/", strings.NewReader(`{"maxCount":-1}`))
+	h.updateRetentionPolicy(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("response not 400:", w.Code, w.Body.String())
+	}
+
+	d.EXPECT().ReadRetentionPolicy(uint(123), uint(42)).Return(&stored, nil)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/org123/tasks/task42/retention/", nil)
+	h.readRetentionPolicy(w, mux.SetURLVars(req, vars))
+
+	var response struct {
+		Code    int                   `json:"code"`
+		Payload types.RetentionPolicy `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal("failed to decode response:", err)
+	}
+	if response.Code != http.StatusOK || response.Payload.Task != "task42" || response.Payload.MaxAge.Duration != 720*time.Hour {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+}
+
+func TestProcessRequestGetRecordOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	full := strings.Repeat("line of output\n", 10000)
+	data, err := compressOutput(full)
+	if err != nil {
+		t.Fatal("error compressing output:", err)
+	}
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadRecord(uint(1234)).Return(&db.Record{
+		Model:        gorm.Model{ID: 1234},
+		MachineID:    678,
+		Output:       outputPreview(full),
+		OutputStored: true,
+		Stderr:       "error output",
+		Steps:        db.StringToJSON(`[{"code":0,"output":"step output","stderr":"step error output"}]`),
+	}, nil).AnyTimes()
+	d.EXPECT().ReadRecordOutput(uint(1234), 0, db.StreamStdout).Return(&db.RecordOutput{
+		RecordID: 1234,
+		Stream:   db.StreamStdout,
+		Size:     int64(len(full)),
+		Data:     data,
+	}, nil).Times(2)
+
+	vars := map[string]string{orgIDKey: "org123", machineIDKey: "machineXYZ", recordIDKey: "1234"}
+	get := func(query string, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineXYZ/records/1234/output/"+query, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		h.readRecordOutput(w, mux.SetURLVars(req, vars))
+		return w
+	}
+
+	// decompressed for clients which don't accept gzip
+	if w := get("", ""); w.Code != http.StatusOK || w.Body.String() != full {
+		t.Fatal("unexpected plain output:", w.Code, w.Body.Len())
+	}
+
+	// passed through as is for clients which do
+	w := get("", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatal("compressed output not passed through")
+	}
+
+	// output of a step which was small enough to keep inline
+	if w := get("?step=1", ""); w.Code != http.StatusOK || w.Body.String() != "step output" {
+		t.Fatal("unexpected step output:", w.Code, w.Body.String())
+	}
+	if w := get("?step=2", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid step accepted:", w.Code)
+	}
+
+	// stderr is kept separately from stdout
+	if w := get("?stream=stderr", ""); w.Code != http.StatusOK || w.Body.String() != "error output" {
+		t.Fatal("unexpected stderr:", w.Code, w.Body.String())
+	}
+	if w := get("?stream=stderr&step=1", ""); w.Code != http.StatusOK || w.Body.String() != "step error output" {
+		t.Fatal("unexpected step stderr:", w.Code, w.Body.String())
+	}
+	if w := get("?stream=stdin", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid stream accepted:", w.Code)
+	}
+}
+
+func TestProcessRequestFollowRunOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	self := &types.Machine{Name: "machineXYZ"}
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadTask("task123").Return(&db.Task{Model: gorm.Model{ID: 42}, Name: "task123", OrganizationID: 123}, nil).Times(2)
+	d.EXPECT().CreateRecords(gomock.Any()).DoAndReturn(func(records []db.Record) ([]error, error) {
+		records[0].ID = 1000
+		return []error{nil}, nil
+	})
+
+	// machine starts a run and streams its output
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/self/runs/", strings.NewReader(`{"taskName":"task123"}`))
+	h.startRun(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}), self)
+
+	var started struct {
+		Code    int       `json:"code"`
+		Payload types.Run `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || started.Code != http.StatusOK || started.Payload.ID == "" {
+		t.Fatal("run not started:", w.Body.String())
+	}
+	runID := started.Payload.ID
+
+	addOutput := func(runID string, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/self/runs/"+runID+"/output/", strings.NewReader(body))
+		h.addRunOutput(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123", runIDKey: runID}), self)
+		return w.Code
+	}
+	if code := addOutput(runID, `[{"stream":"stdout","data":"backing up\n"},{"stream":"stderr","data":"warning\n"}]`); code != http.StatusOK {
+		t.Fatal("output not accepted:", code)
+	}
+	if code := addOutput(runID, `[{"stream":"stdin","data":"x"}]`); code != http.StatusBadRequest {
+		t.Fatal("invalid stream accepted:", code)
+	}
+	if code := addOutput("unknown", `[{"stream":"stdout","data":"x"}]`); code != http.StatusNotFound {
+		t.Fatal("output of unknown run accepted:", code)
+	}
+
+	vars := map[string]string{orgIDKey: "org123", machineIDKey: "machineXYZ", runIDKey: runID}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineXYZ/runs/", nil)
+	h.readRuns(w, mux.SetURLVars(req, vars))
+	if !strings.Contains(w.Body.String(), runID) {
+		t.Fatal("run not listed:", w.Body.String())
+	}
+
+	// record of the run ends it
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/self/records/", strings.NewReader(`{"taskName":"task123","runId":"`+runID+`","status":0}`))
+	h.addRecord(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}), self)
+	if w.Code != http.StatusOK {
+		t.Fatal("record not accepted:", w.Body.String())
+	}
+
+	follow := func(lastEventID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineXYZ/runs/"+runID+"/output/", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		h.followRunOutput(w, mux.SetURLVars(req, vars))
+		return w
+	}
+
+	w = follow("")
+	want := "retry: 1000\n\n" +
+		"id: 0\nevent: output\ndata: {\"stream\":\"stdout\",\"data\":\"backing up\\n\"}\n\n" +
+		"id: 1\nevent: output\ndata: {\"stream\":\"stderr\",\"data\":\"warning\\n\"}\n\n" +
+		"event: end\ndata: {\"recordId\":1000,\"outcome\":\"completed\",\"status\":0}\n\n"
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != want {
+		t.Fatal("unexpected event stream:", w.Body.String())
+	}
+
+	// reconnecting client continues where it left off
+	w = follow("0")
+	if strings.Contains(w.Body.String(), "backing up") || !strings.Contains(w.Body.String(), "warning") {
+		t.Fatal("output not continued from last event:", w.Body.String())
+	}
+}
+
+func TestProcessRequestMachineHeartbeat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	now := time.Now()
+	machines := map[string]*db.Machine{
+		"machineXYZ": {Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123},
+		"machineOld": {Model: gorm.Model{ID: 679}, Name: "machineOld", OrganizationID: 123, Status: types.MachineStatusOnline, LastSeen: now.Add(-5 * time.Minute)},
+		"machineOff": {Model: gorm.Model{ID: 680}, Name: "machineOff", OrganizationID: 123, Status: types.MachineStatusOffline, LastSeen: now},
+	}
+	org := &db.Organization{
+		Model:    gorm.Model{ID: 123},
+		Name:     "org123",
+		Machines: []db.Machine{*machines["machineXYZ"], *machines["machineOld"], *machines["machineOff"]},
+	}
+	d.EXPECT().ReadOrganization("org123").Return(org, nil).AnyTimes()
+	d.EXPECT().ReadMachine(gomock.Any()).DoAndReturn(func(name string) (*db.Machine, error) {
+		return machines[name], nil
+	}).AnyTimes()
+	d.EXPECT().UpdateMachineHeartbeat(gomock.Any()).DoAndReturn(func(m *db.Machine) error {
+		if m.Status != types.MachineStatusOnline || m.Version != "1.2.3" || m.Hostname != "host1" || m.OS != "linux" || m.LastSeen.IsZero() {
+			t.Fatal("unexpected heartbeat update:", m)
+		}
+		return nil
+	})
+
+	heartbeat := func(body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/self/heartbeat/", strings.NewReader(body))
+		h.machineHeartbeat(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}), &types.Machine{Name: "machineXYZ"})
+		return w.Code
+	}
+	if code := heartbeat(`{"version":"1.2.3","uptime":"1h0m0s","scheduleHash":"abc","hostname":"host1","os":"linux","arch":"amd64"}`); code != http.StatusOK {
+		t.Fatal("heartbeat not accepted:", code)
+	}
+	if code := heartbeat(`{"status":"sleeping"}`); code != http.StatusBadRequest {
+		t.Fatal("invalid status accepted:", code)
+	}
+
+	readMachines := func(query string) map[string]string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/"+query, nil)
+		h.readMachines(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+
+		var response struct {
+			Code    int             `json:"code"`
+			Payload []types.Machine `json:"payload"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != http.StatusOK {
+			t.Fatal("unexpected response:", w.Body.String())
+		}
+		statuses := make(map[string]string)
+		for _, m := range response.Payload {
+			statuses[m.Name] = m.Status
+		}
+		return statuses
+	}
+
+	machines["machineXYZ"].LastSeen = now
+	machines["machineXYZ"].Status = types.MachineStatusOnline
+	want := map[string]string{
+		"machineXYZ": types.MachineStatusOnline,
+		"machineOld": types.MachineStatusStale,
+		"machineOff": types.MachineStatusOffline,
+	}
+	if got := readMachines(""); !reflect.DeepEqual(got, want) {
+		t.Fatal("unexpected statuses:", got)
+	}
+	if got := readMachines("?status=stale"); !reflect.DeepEqual(got, map[string]string{"machineOld": types.MachineStatusStale}) {
+		t.Fatal("unexpected filtered machines:", got)
+	}
+}
+
+func TestProcessRequestEnrollMachine(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	org123 := &db.Organization{
+		Model: gorm.Model{
+			ID: 123,
+		},
+		Name: "org123",
+	}
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).AnyTimes()
+
+	// admin creates token for enrolling two machines
+	enrollmentToken := "0c3a1f4e-2d7b-4b8e-9f61-7a0d2b3c4e5f"
+	a.EXPECT().GenerateUUID().Return(enrollmentToken, nil)
+	d.EXPECT().CreateEnrollmentToken(gomock.Any()).DoAndReturn(func(et *db.EnrollmentToken) error {
+		if et.MaxUses != 2 || et.OrganizationID != 123 || time.Until(et.Expiration) > time.Hour {
+			t.Fatal("unexpected enrollment token:", et)
+		}
+		et.ID = 7
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/enrollment-tokens/", strings.NewReader(`{"maxUses":2}`))
+	h.createEnrollmentToken(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+
+	var created struct {
+		Code    int                   `json:"code"`
+		Payload types.EnrollmentToken `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+	if created.Payload.ID != 7 || created.Payload.Value != enrollmentToken {
+		t.Fatal("token value not returned:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/enrollment-tokens/", strings.NewReader(`{"validFor":"720h"}`))
+	h.createEnrollmentToken(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+	if !strings.Contains(w.Body.String(), `"code":400`) {
+		t.Fatal("too long validity accepted:", w.Body.String())
+	}
+
+	enroll := func(key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/enroll/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Key "+key)
+		h.enrollMachine(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+		return w
+	}
+
+	// machine enrolls itself
+	machineToken := "5b7e8a90-1c2d-4e3f-8a9b-0c1d2e3f4a5b"
+	a.EXPECT().GenerateUUID().Return(machineToken, nil)
+	d.EXPECT().EnrollMachine(uint(123), db.StringToUUID(enrollmentToken), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ uint, _ interface{}, _ time.Time, m *db.Machine, mt *db.MachineToken) error {
+			if m.Name != "raspi" || m.OS != "linux" || m.Arch != "arm64" {
+				t.Fatal("unexpected machine:", m)
+			}
+			m.ID = 55
+			return nil
+		})
+
+	w = enroll(enrollmentToken, `{"name":"raspi","os":"linux","arch":"arm64","hostname":"raspi.local"}`)
+	var enrolled struct {
+		Code    int                     `json:"code"`
+		Payload types.MachineCredential `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &enrolled); err != nil || enrolled.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+	want := types.MachineCredential{Machine: "raspi", Organization: "org123", AccessToken: types.MachineToken(machineToken)}
+	if enrolled.Payload != want {
+		t.Fatal("unexpected credential:", enrolled.Payload)
+	}
+
+	// used up or expired token is rejected
+	a.EXPECT().GenerateUUID().Return(machineToken, nil)
+	d.EXPECT().EnrollMachine(uint(123), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(db.ErrEnrollmentTokenInvalid)
+	if w := enroll(enrollmentToken, `{"name":"raspi2"}`); !strings.Contains(w.Body.String(), `"code":401`) {
+		t.Fatal("invalid enrollment token accepted:", w.Body.String())
+	}
+
+	// taken name is only reported once token is found valid
+	a.EXPECT().GenerateUUID().Return(machineToken, nil)
+	d.EXPECT().EnrollMachine(uint(123), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(db.ErrMachineExists)
+	if w := enroll(enrollmentToken, `{"name":"raspi"}`); !strings.Contains(w.Body.String(), `"code":409`) {
+		t.Fatal("existing machine name accepted:", w.Body.String())
+	}
+
+	// invalid names are rejected before token is used
+	if w := enroll(enrollmentToken, `{"name":"my machine"}`); !strings.Contains(w.Body.String(), `"code":400`) {
+		t.Fatal("invalid machine name accepted:", w.Body.String())
+	}
+	if w := enroll("not-a-token", `{"name":"raspi3"}`); !strings.Contains(w.Body.String(), `"code":401`) {
+		t.Fatal("malformed enrollment token accepted:", w.Body.String())
+	}
+}
+
+func TestProcessRequestGetMachineOwnScheduleWithGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	machineXYZ := &db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}
+	d.EXPECT().ReadMachine("machineXYZ").Return(machineXYZ, nil).AnyTimes()
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+
+	groups := []db.MachineGroup{
+		{
+			Name:     "servers",
+			Schedule: db.StringToJSON(`{"cron":[{"taskID":"backup","cron":"0 0 3 * * *"}]}`),
+		},
+		{
+			Name:     "no-schedule",
+			Schedule: pgtype.JSON{Status: pgtype.Null},
+		},
+		{
+			Name:     "monitored",
+			Schedule: db.StringToJSON(`{"periodically":[{"taskID":"health","every":"1m0s"}],"cron":[{"taskID":"backup","cron":"0 0 3 * * *"}]}`),
+		},
+	}
+	d.EXPECT().ReadMachineGroupsOf(uint(678)).Return(groups, nil).AnyTimes()
+
+	readSchedule := func() (int, types.Schedule) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/self/schedule/", nil)
+		h.readMachineOwnSchedule(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}), &types.Machine{Name: "machineXYZ"})
+
+		var response struct {
+			Code    int            `json:"code"`
+			Payload types.Schedule `json:"payload"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal("failed to decode response:", err)
+		}
+		return response.Code, response.Payload
+	}
+
+	// machine without a schedule of its own runs schedules of its groups, backup only once
+	d.EXPECT().ReadSchedule("machineXYZ").Return(nil, errors.New("not found"))
+	code, schedule := readSchedule()
+	if code != http.StatusOK || len(schedule.CronTasks) != 1 || len(schedule.PeriodicTasks) != 1 {
+		t.Fatal("unexpected schedule:", code, schedule)
+	}
+
+	// own entries come first
+	d.EXPECT().ReadSchedule("machineXYZ").Return(&db.Schedule{
+		MachineID: 678,
+		Content:   db.StringToJSON(`{"cron":[{"taskID":"cleanup","cron":"0 0 4 * * *"}]}`),
+	}, nil)
+	code, schedule = readSchedule()
+	if code != http.StatusOK || len(schedule.CronTasks) != 2 || schedule.CronTasks[0].What != "cleanup" || schedule.CronTasks[1].What != "backup" {
+		t.Fatal("unexpected schedule:", code, schedule)
+	}
+}
+
+func TestProcessRequestMachineGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineOther").Return(&db.Machine{Model: gorm.Model{ID: 679}, Name: "machineOther", OrganizationID: 456}, nil).AnyTimes()
+
+	// members from other organizations are left out
+	d.EXPECT().ReadMachineGroup(uint(123), "servers").Return(nil, errors.New("not found"))
+	d.EXPECT().CreateMachineGroup(gomock.Any()).DoAndReturn(func(g *db.MachineGroup) error {
+		if g.Name != "servers" || g.OrganizationID != 123 || g.Schedule.Status != pgtype.Present {
+			t.Fatal("unexpected group:", g)
+		}
+		g.ID = 9
+		return nil
+	})
+	d.EXPECT().AddMachineGroupMember(gomock.Any(), gomock.Any()).DoAndReturn(func(g *db.MachineGroup, m *db.Machine) error {
+		if g.ID != 9 || m.Name != "machineXYZ" {
+			t.Fatal("unexpected member:", g, m)
+		}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/groups/", strings.NewReader(
+		`{"name":"servers","machines":["machineXYZ","machineOther"],"schedule":{"cron":[{"taskID":"backup","cron":"0 0 3 * * *"}]}}`,
+	))
+	h.createMachineGroup(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+
+	var response struct {
+		Code    int                `json:"code"`
+		Payload types.MachineGroup `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+	if !reflect.DeepEqual(response.Payload.Machines, []string{"machineXYZ"}) || response.Payload.Schedule == nil {
+		t.Fatal("unexpected group:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/groups/", strings.NewReader(`{"name":"web servers"}`))
+	h.createMachineGroup(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+	if !strings.Contains(w.Body.String(), `"code":400`) {
+		t.Fatal("invalid group name accepted:", w.Body.String())
+	}
+
+	// machine of another organization can't be added
+	group := &db.MachineGroup{Model: gorm.Model{ID: 9}, Name: "servers", OrganizationID: 123}
+	d.EXPECT().ReadMachineGroup(uint(123), "servers").Return(group, nil).Times(2)
+	d.EXPECT().AddMachineGroupMember(group, gomock.Any()).Return(nil)
+
+	vars := map[string]string{orgIDKey: "org123", groupIDKey: "servers", machineIDKey: "machineXYZ"}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/org123/groups/servers/machines/machineXYZ/", nil)
+	h.addMachineGroupMember(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusOK {
+		t.Fatal("member not added:", w.Body.String())
+	}
+
+	vars[machineIDKey] = "machineOther"
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/org123/groups/servers/machines/machineOther/", nil)
+	h.addMachineGroupMember(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusNotFound {
+		t.Fatal("machine of another organization added:", w.Code)
+	}
+}
+
+func TestProcessRequestPasswordLifecycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	org123 := &db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).AnyTimes()
+
+	// new user without password gets a reset token to set one with
+	resetToken := "3e4f5a6b-7c8d-4e9f-a0b1-c2d3e4f5a6b7"
+	var li db.LoginInfo
+	d.EXPECT().CreateUser(gomock.Any()).DoAndReturn(func(u *db.User) error {
+		u.ID = 42
+		return nil
+	})
+	d.EXPECT().CreateLoginInfo(gomock.Any()).DoAndReturn(func(l *db.LoginInfo) error {
+		if l.Username != "newbie" || l.UserID != 42 || !l.MustChangePassword || l.Password != "" {
+			t.Fatal("unexpected login info:", l)
+		}
+		l.ID = 7
+		li = *l
+		li.User = db.User{Model: gorm.Model{ID: 42}, Name: "newbie"}
+		return nil
+	})
+	a.EXPECT().GenerateUUID().Return(resetToken, nil)
+	d.EXPECT().CreatePasswordReset(gomock.Any()).DoAndReturn(func(r *db.PasswordReset) error {
+		if r.LoginInfoID != 7 || time.Until(r.Expiration) < 24*time.Hour {
+			t.Fatal("unexpected password reset:", r)
+		}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/users/", strings.NewReader(`{"name":"newbie","email":"newbie@example.com","role":1}`))
+	h.createUser(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123"}))
+
+	var created struct {
+		Code    int                 `json:"code"`
+		Payload types.PasswordReset `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+	if created.Payload.Token != resetToken || created.Payload.Username != "newbie" {
+		t.Fatal("reset token not returned:", w.Body.String())
+	}
+
+	d.EXPECT().ReadLoginInfo("newbie").DoAndReturn(func(string) (*db.LoginInfo, error) {
+		l := li
+		return &l, nil
+	}).AnyTimes()
+	// sessions of the user are ended whenever password is set
+	expectEndSessions := func() {
+		d.EXPECT().DeleteRefreshTokens(uint(7)).Return(nil)
+		d.EXPECT().CreateTokenRevocation(gomock.Any()).DoAndReturn(func(r *db.TokenRevocation) error {
+			if r.Username != "newbie" || r.TokenID != "" || r.IssuedBefore.IsZero() {
+				t.Fatal("unexpected revocation:", r)
+			}
+			return nil
+		})
+	}
+
+	// user sets password with the token
+	expectEndSessions()
+	d.EXPECT().ResetPassword("newbie", db.StringToUUID(resetToken), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ string, _ interface{}, _ time.Time, hashed string) error {
+			if !auth.PasswordEqualsHashed("correct horse", hashed) {
+				t.Fatal("password not hashed")
+			}
+			li.Password = hashed
+			li.MustChangePassword = false
+			return nil
+		})
+	reset := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/newbie/resetpassword/", strings.NewReader(body))
+		h.passwordResetHandler(w, mux.SetURLVars(req, map[string]string{usernameKey: "newbie"}))
+		return w
+	}
+	if w := reset(`{"token":"` + resetToken + `","newPassword":"correct horse"}`); w.Code != http.StatusOK {
+		t.Fatal("password not reset:", w.Body.String())
+	}
+	if w := reset(`{"token":"` + resetToken + `","newPassword":"short"}`); w.Code != http.StatusBadRequest {
+		t.Fatal("too short password accepted:", w.Body.String())
+	}
+
+	// token is single use
+	d.EXPECT().ResetPassword("newbie", gomock.Any(), gomock.Any(), gomock.Any()).Return(db.ErrPasswordResetInvalid)
+	if w := reset(`{"token":"` + resetToken + `","newPassword":"battery staple"}`); w.Code != http.StatusUnauthorized {
+		t.Fatal("used reset token accepted:", w.Body.String())
+	}
+
+	// password change requires the current password
+	change := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/newbie/changepassword/", strings.NewReader(body))
+		h.passwordChangeHandler(w, mux.SetURLVars(req, map[string]string{usernameKey: "newbie"}))
+		return w
+	}
+	if w := change(`{"oldPassword":"wrong horse","newPassword":"battery staple"}`); w.Code != http.StatusUnauthorized {
+		t.Fatal("password changed without current password:", w.Body.String())
+	}
+	d.EXPECT().UpdateLoginInfo(gomock.Any()).DoAndReturn(func(l *db.LoginInfo) error {
+		if l.MustChangePassword || !auth.PasswordEqualsHashed("battery staple", l.Password) {
+			t.Fatal("unexpected login info:", l)
+		}
+		return nil
+	})
+	expectEndSessions()
+	if w := change(`{"oldPassword":"correct horse","newPassword":"battery staple"}`); w.Code != http.StatusOK {
+		t.Fatal("password not changed:", w.Body.String())
+	}
+
+	// temporary password can't be used to log in
+	li.MustChangePassword = true
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/auth/", strings.NewReader(`{"username":"newbie","password":"correct horse"}`))
+	h.loginHandler(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "password change required") {
+		t.Fatal("login with temporary password allowed:", w.Body.String())
+	}
+}
+
+func TestProcessRequestSessionLifecycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := auth.NewController([]byte("session-lifecycle-key"))
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if err := h.RegisterAuthenticationHandlers(); err != nil {
+		t.Fatal("error registering authentication routes:", err)
+	}
+	if err := h.RegisterUserHandlers(); err != nil {
+		t.Fatal("error registering user routes:", err)
+	}
+
+	org123 := &db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}
+	alice := db.LoginInfo{
+		Model:    gorm.Model{ID: 7},
+		Username: "alice",
+		Password: auth.HashPassword("correct horse"),
+		User: db.User{
+			Model:          gorm.Model{ID: 42},
+			Name:           "alice",
+			OrganizationID: 123,
+			Role:           types.RoleUser | types.RoleMaintainer | types.RoleAdministrator,
+		},
+	}
+	d.EXPECT().LoadModel(gomock.Any(), uint(123)).DoAndReturn(func(model interface{}, _ uint) error {
+		*model.(*db.Organization) = *org123
+		return nil
+	}).AnyTimes()
+
+	do := func(method string, url string, token string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+	decodeSession := func(w *httptest.ResponseRecorder) types.Session {
+		var resp struct {
+			Payload types.Session `json:"payload"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatal("unexpected response:", w.Code, w.Body.String())
+		}
+		return resp.Payload
+	}
+
+	// login gives a short lived access token and a refresh token
+	var refreshToken db.RefreshToken
+	d.EXPECT().ReadLoginInfo("alice").Return(&alice, nil)
+	d.EXPECT().CreateRefreshToken(gomock.Any()).DoAndReturn(func(r *db.RefreshToken) error {
+		refreshToken = *r
+		return nil
+	})
+	first := decodeSession(do(http.MethodPost, "/api/v1/auth/", "", `{"username":"alice","password":"correct horse"}`))
+	if refreshToken.LoginInfoID != alice.ID || refreshToken.Value != db.StringToUUID(first.RefreshToken) {
+		t.Fatal("refresh token not stored:", refreshToken, first)
+	}
+	if time.Until(first.Expiration) > defaultAccessTokenValidity || time.Until(first.RefreshExpiration) < defaultAccessTokenValidity {
+		t.Fatal("unexpected expiration:", first)
+	}
+	if w := do(http.MethodGet, "/api/v1/auth/", first.Token, ""); w.Code != http.StatusOK {
+		t.Fatal("access token refused:", w.Body.String())
+	}
+
+	// refresh token is exchanged for a new session
+	d.EXPECT().RotateRefreshToken(db.StringToUUID(first.RefreshToken), gomock.Any(), gomock.Any()).Return(&alice, nil)
+	second := decodeSession(do(http.MethodPost, "/api/v1/auth/refresh/", "", `{"refreshToken":"`+first.RefreshToken+`"}`))
+	if second.Token == first.Token || second.RefreshToken == first.RefreshToken {
+		t.Fatal("session not renewed:", second)
+	}
+	d.EXPECT().RotateRefreshToken(db.StringToUUID(first.RefreshToken), gomock.Any(), gomock.Any()).Return(nil, db.ErrRefreshTokenInvalid)
+	if w := do(http.MethodPost, "/api/v1/auth/refresh/", "", `{"refreshToken":"`+first.RefreshToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Fatal("used refresh token accepted:", w.Body.String())
+	}
+
+	// logout revokes the access token, and deletes the refresh token
+	d.EXPECT().CreateTokenRevocation(gomock.Any()).DoAndReturn(func(r *db.TokenRevocation) error {
+		if r.TokenID == "" || r.Username != "alice" || !r.Expiration.After(time.Now()) {
+			t.Fatal("unexpected revocation:", r)
+		}
+		return nil
+	})
+	d.EXPECT().DeleteRefreshToken(db.StringToUUID(second.RefreshToken)).Return(nil)
+	if w := do(http.MethodPost, "/api/v1/auth/logout/", second.Token, `{"refreshToken":"`+second.RefreshToken+`"}`); w.Code != http.StatusOK {
+		t.Fatal("logout failed:", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/api/v1/auth/", second.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("revoked access token accepted:", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/api/v1/auth/", first.Token, ""); w.Code != http.StatusOK {
+		t.Fatal("other access token refused:", w.Body.String())
+	}
+
+	// administrator can end every session of a user
+	d.EXPECT().ReadOrganization("org123").Return(org123, nil).Times(2)
+	d.EXPECT().ReadUser("alice").Return(&alice.User, nil).Times(2)
+	d.EXPECT().ReadLoginInfo("alice").Return(&alice, nil)
+	d.EXPECT().DeleteRefreshTokens(alice.ID).Return(nil)
+	d.EXPECT().CreateTokenRevocation(gomock.Any()).DoAndReturn(func(r *db.TokenRevocation) error {
+		if r.TokenID != "" || r.Username != "alice" || r.IssuedBefore.IsZero() {
+			t.Fatal("unexpected revocation:", r)
+		}
+		return nil
+	})
+	if w := do(http.MethodPost, "/api/v1/org123/users/alice/revoke/", first.Token, ""); w.Code != http.StatusOK {
+		t.Fatal("revoking user tokens failed:", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/api/v1/auth/", first.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("revoked access token accepted:", w.Body.String())
+	}
+}
+
+func TestProcessRequestJWKS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := auth.NewController([]byte("jwks-key"))
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+	if err := h.RegisterAuthenticationHandlers(); err != nil {
+		t.Fatal("error registering authentication routes:", err)
+	}
+
+	key, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA, time.Now())
+	if err != nil {
+		t.Fatal("error generating key:", err)
+	}
+	if err := a.SetSigningKeys([]auth.SigningKey{*key}); err != nil {
+		t.Fatal("error setting keys:", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("unexpected response:", w.Code, w.Header())
+	}
+
+	var set auth.JWKSet
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal("error decoding key set:", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != key.ID || set.Keys[0].Algorithm != auth.AlgorithmEdDSA || set.Keys[0].X == "" {
+		t.Fatal("unexpected key set:", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"d"`) {
+		t.Fatal("private key published:", w.Body.String())
+	}
+}
+
+func TestProcessRequestMachineTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil).AnyTimes()
+	vars := map[string]string{orgIDKey: "org123", machineIDKey: "machineXYZ"}
+
+	// token with label and limited validity
+	a.EXPECT().GenerateUUID().Return("3f8e1c2a-5b7d-4e9f-8a1b-2c3d4e5f6a7b", nil)
+	d.EXPECT().CreateMachineToken(gomock.Any()).DoAndReturn(func(mt *db.MachineToken) error {
+		if mt.MachineID != 678 || mt.Label != "backup server" || mt.Value != db.StringToUUID("3f8e1c2a-5b7d-4e9f-8a1b-2c3d4e5f6a7b") {
+			t.Fatal("unexpected token:", mt)
+		}
+		if d := time.Until(mt.Expiration); d < 47*time.Hour || d > 48*time.Hour {
+			t.Fatal("unexpected expiration:", mt.Expiration)
+		}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/machineXYZ/tokens/", strings.NewReader(`{"label":"backup server","validFor":"48h"}`))
+	h.createMachineToken(w, mux.SetURLVars(req, vars))
+	if !strings.Contains(w.Body.String(), `"payload":"3f8e1c2a-5b7d-4e9f-8a1b-2c3d4e5f6a7b"`) {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+
+	// without body token doesn't expire
+	a.EXPECT().GenerateUUID().Return("9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d", nil)
+	d.EXPECT().CreateMachineToken(gomock.Any()).DoAndReturn(func(mt *db.MachineToken) error {
+		if mt.Label != "" || !mt.Expiration.IsZero() {
+			t.Fatal("unexpected token:", mt)
+		}
+		return nil
+	})
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/machineXYZ/tokens/", nil)
+	h.createMachineToken(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/machineXYZ/tokens/", strings.NewReader(`{"validFor":"-1h"}`))
+	h.createMachineToken(w, mux.SetURLVars(req, vars))
+	if !strings.Contains(w.Body.String(), `"code":400`) {
+		t.Fatal("negative validity accepted:", w.Body.String())
+	}
+
+	// values aren't listed, and unset times are left out
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.EXPECT().ReadMachineTokens(uint(678)).Return([]db.MachineToken{
+		{Model: gorm.Model{ID: 1, CreatedAt: created}, Hash: []byte("hash"), Label: "enrollment"},
+		{Model: gorm.Model{ID: 2, CreatedAt: created}, Label: "backup server", Expiration: created.Add(48 * time.Hour), LastUsedAt: created.Add(time.Hour)},
+	}, nil)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/org123/machines/machineXYZ/tokens/", nil)
+	h.readMachineTokens(w, mux.SetURLVars(req, vars))
+	expected := `{"code":200,"msg":"ok","payload":[` +
+		`{"id":1,"label":"enrollment","createdAt":"2022-03-01T12:00:00Z"},` +
+		`{"id":2,"label":"backup server","createdAt":"2022-03-01T12:00:00Z","expiration":"2022-03-03T12:00:00Z","lastUsedAt":"2022-03-01T13:00:00Z"}]}`
+	if strings.TrimSpace(w.Body.String()) != expected {
+		t.Fatal("unexpected tokens:", w.Body.String())
+	}
+
+	d.EXPECT().DeleteMachineToken(uint(678), uint(2)).Return(nil)
+	d.EXPECT().DeleteMachineToken(uint(678), uint(3)).Return(gorm.ErrRecordNotFound)
+
+	vars[tokenIDKey] = "2"
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/org123/machines/machineXYZ/tokens/2/", nil)
+	h.deleteMachineToken(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusOK {
+		t.Fatal("token not deleted:", w.Body.String())
+	}
+
+	vars[tokenIDKey] = "3"
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/org123/machines/machineXYZ/tokens/3/", nil)
+	h.deleteMachineToken(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusNotFound {
+		t.Fatal("unexpected response to deleting unknown token:", w.Code)
+	}
+}
+
+func TestProcessRequestScopedUserToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+	h := NewHandler(a, d)
+
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadUser("ci").Return(&db.User{Model: gorm.Model{ID: 42}, Name: "ci", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineXYZ").Return(&db.Machine{Model: gorm.Model{ID: 678}, Name: "machineXYZ", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machineOther").Return(&db.Machine{Model: gorm.Model{ID: 679}, Name: "machineOther", OrganizationID: 456}, nil).AnyTimes()
+	vars := map[string]string{orgIDKey: "org123", userIDKey: "ci"}
+
+	a.EXPECT().GenerateUUID().Return("3f8e1c2a-5b7d-4e9f-8a1b-2c3d4e5f6a7b", nil)
+	d.EXPECT().CreateUserToken(gomock.Any()).DoAndReturn(func(ut *db.UserToken) error {
+		if ut.UserID != 42 || ut.Label != "ci" || ut.Scopes != "records:read tasks:write" {
+			t.Fatal("unexpected token:", ut)
+		}
+		if len(ut.Machines) != 1 || ut.Machines[0].ID != 678 {
+			t.Fatal("unexpected machines:", ut.Machines)
+		}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/org123/users/ci/tokens/", strings.NewReader(
+		`{"label":"ci","scopes":["records:read","tasks:write"],"machines":["machineXYZ"]}`,
+	))
+	h.createUserToken(w, mux.SetURLVars(req, vars))
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected response:", w.Body.String())
+	}
+
+	for _, body := range []string{
+		`{"scopes":["records:delete"]}`,
+		`{"scopes":["users:write"]}`,
+		`{"machines":["machineXYZ"]}`,
+		`{"scopes":["records:read"],"machines":["machineOther"]}`,
+	} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/users/ci/tokens/", strings.NewReader(body))
+		h.createUserToken(w, mux.SetURLVars(req, vars))
+		if !strings.Contains(w.Body.String(), `"code":400`) {
+			t.Fatal("invalid token request accepted:", body, w.Body.String())
+		}
+	}
+
+	d.EXPECT().ReadUserTokens(uint(42)).Return([]db.UserToken{
+		{
+			Model:    gorm.Model{ID: 1, CreatedAt: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)},
+			Label:    "ci",
+			Scopes:   "records:read tasks:write",
+			Machines: []db.Machine{{Model: gorm.Model{ID: 678}, Name: "machineXYZ"}},
+		},
+	}, nil)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/org123/users/ci/tokens/", nil)
+	h.readUserTokens(w, mux.SetURLVars(req, vars))
+	expected := `{"code":200,"msg":"ok","payload":[` +
+		`{"id":1,"label":"ci","createdAt":"2022-03-01T12:00:00Z","scopes":["records:read","tasks:write"],"machines":["machineXYZ"]}]}`
+	if strings.TrimSpace(w.Body.String()) != expected {
+		t.Fatal("unexpected tokens:", w.Body.String())
+	}
+
+	// machine tokens can't be scoped
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/org123/machines/machineXYZ/tokens/", strings.NewReader(`{"scopes":["records:read"]}`))
+	h.createMachineToken(w, mux.SetURLVars(req, map[string]string{orgIDKey: "org123", machineIDKey: "machineXYZ"}))
+	if !strings.Contains(w.Body.String(), `"code":400`) {
+		t.Fatal("scoped machine token accepted:", w.Body.String())
+	}
+}
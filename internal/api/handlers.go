@@ -1,7 +1,9 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -25,6 +27,18 @@ type handler struct {
 
 	a auth.Controller
 	d db.Controller
+
+	// key for encrypting secrets at rest, secrets routes are only available if it is set
+	secretKey []byte
+
+	// runs in progress, whose output can be followed live
+	runs *runHub
+
+	// how long issued access and refresh tokens are valid for
+	accessTokenValidity  time.Duration
+	refreshTokenValidity time.Duration
+	// revoked access tokens, which are refused before they expire
+	revocations *revocationCache
 }
 
 func NewHandler(a auth.Controller, d db.Controller) *handler {
@@ -34,6 +48,11 @@ func NewHandler(a auth.Controller, d db.Controller) *handler {
 		router: m,
 		a:      a,
 		d:      d,
+		runs:   newRunHub(),
+
+		accessTokenValidity:  defaultAccessTokenValidity,
+		refreshTokenValidity: defaultRefreshTokenValidity,
+		revocations:          newRevocationCache(),
 	}
 }
 
@@ -83,6 +102,16 @@ func (h *handler) RegisterRecordHandlers() error {
 	return nil
 }
 
+func (h *handler) RegisterTriggerHandlers() error {
+	h.setTriggerRoutesV1()
+	return nil
+}
+
+func (h *handler) RegisterGroupHandlers() error {
+	h.setGroupRoutesV1()
+	return nil
+}
+
 func (h *handler) RegisterSignUpHandlers() error {
 	h.setSignUpRoutesV1()
 	return nil
@@ -92,3 +121,37 @@ func (h *handler) RegisterExtraRoute(path string, handlerFunc func(http.Response
 	h.router.HandleFunc(path, handlerFunc)
 	return nil
 }
+
+// RegisterSecretHandlers enables secrets API, with secrets encrypted using given key
+func (h *handler) RegisterSecretHandlers(key []byte) error {
+	if len(key) != auth.SecretKeySize {
+		return auth.ErrInvalidSecretKey
+	}
+	h.secretKey = key
+	h.setSecretRoutesV1()
+	return nil
+}
+
+// SetTokenValidity sets how long access and refresh tokens issued from now on are valid for
+func (h *handler) SetTokenValidity(access time.Duration, refresh time.Duration) error {
+	if access <= 0 || refresh <= 0 {
+		return errors.New("token validity must be positive")
+	}
+	h.accessTokenValidity = access
+	h.refreshTokenValidity = refresh
+	return nil
+}
+
+// LoadTokenRevocations reads revocations of tokens which haven't expired yet from database.
+// It should be called before serving requests, otherwise revoked tokens are accepted.
+func (h *handler) LoadTokenRevocations() error {
+	now := time.Now()
+	revocations, err := h.d.ReadTokenRevocations(now)
+	if err != nil {
+		return err
+	}
+	for i := range revocations {
+		h.revocations.add(&revocations[i], now)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"log"
+	"time"
+
 	"github.com/jackc/pgtype"
 
 	"github.com/LassiHeikkila/taskey/internal/auth"
@@ -8,39 +11,44 @@ import (
 	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
-func lookupUserByJWT(authController auth.Controller, token string) *types.User {
-	var user, organization string
-	var role int
-	if !authController.ValidateUserToken(
-		token,
-		&user,
-		&organization,
-		&role,
-	) {
+func lookupUserByJWT(authController auth.Controller, revocations *revocationCache, token string) *types.User {
+	var claims auth.UserClaims
+	if !authController.ValidateUserToken(token, &claims) {
+		return nil
+	}
+	if revocations.revoked(&claims) {
 		return nil
 	}
 	return &types.User{
-		Name: user,
-		Role: types.Role(role),
+		Name: claims.User,
+		Role: types.Role(claims.Role),
 	}
 }
 
-func lookupUserByToken(dbController db.Controller, token string) *types.User {
+// lookupUserByToken returns user owning the token, and scope of the token if it has one
+func lookupUserByToken(dbController db.Controller, token string) (*types.User, *tokenScope) {
 	u := pgtype.UUID{}
 	if err := u.Set(token); err != nil {
-		return nil
+		return nil, nil
 	}
 
 	r, err := dbController.ReadUserToken(u)
 	if err != nil {
-		return nil
+		return nil, nil
+	}
+	now := time.Now()
+	if tokenExpired(r.Expiration, now) {
+		return nil, nil
+	}
+	if err := dbController.UpdateUserTokenLastUsed(r.ID, now); err != nil {
+		log.Println("error updating last use of user token:", err)
 	}
 
 	return &types.User{
 		Name:  r.User.Name,
 		Email: r.User.Email,
 		Role:  r.User.Role,
-	}
+	}, newTokenScope(r)
 }
 
 func lookupMachineByToken(dbController db.Controller, token string) *types.Machine {
@@ -53,6 +61,13 @@ func lookupMachineByToken(dbController db.Controller, token string) *types.Machi
 	if err != nil {
 		return nil
 	}
+	now := time.Now()
+	if tokenExpired(r.Expiration, now) {
+		return nil
+	}
+	if err := dbController.UpdateMachineTokenLastUsed(r.ID, now); err != nil {
+		log.Println("error updating last use of machine token:", err)
+	}
 	return &types.Machine{
 		Name:        r.Machine.Name,
 		Description: r.Machine.Description,
@@ -61,6 +76,12 @@ func lookupMachineByToken(dbController db.Controller, token string) *types.Machi
 	}
 }
 
+// tokenExpired tells if token with given expiration can't be used at now.
+// Zero expiration means token doesn't expire.
+func tokenExpired(expiration time.Time, now time.Time) bool {
+	return !expiration.IsZero() && !expiration.After(now)
+}
+
 func lookupOrganizationByID(dbController db.Controller, id string) *types.Organization {
 	o, err := dbController.ReadOrganization(id)
 	if err != nil {
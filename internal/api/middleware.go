@@ -14,26 +14,33 @@ import (
 
 // AuthUserMW implements middleware pattern.
 // It should be chained to match routes requiring a specific role.
-// It also checks that caller is a member of the organization owning the resource
+// It also checks that caller is a member of the organization owning the resource,
+// and that scoped token of the caller allows requiredScope
 type AuthUserMW struct {
 	handler func(http.ResponseWriter, *http.Request)
 
 	authController auth.Controller
 	dbController   db.Controller
+	revocations    *revocationCache
 	requiredRole   types.Role
+	requiredScope  types.Scope
 }
 
 func NewAuthUserMiddleware(
 	next func(http.ResponseWriter, *http.Request),
 	authController auth.Controller,
 	dbController db.Controller,
+	revocations *revocationCache,
 	requiredRole types.Role,
+	requiredScope types.Scope,
 ) *AuthUserMW {
 	return &AuthUserMW{
 		handler:        next,
 		authController: authController,
 		dbController:   dbController,
+		revocations:    revocations,
 		requiredRole:   requiredRole,
+		requiredScope:  requiredScope,
 	}
 }
 
@@ -41,16 +48,17 @@ func (a *AuthUserMW) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// check that token is valid, contains a legit user & organization, and role is what is required
 	scheme, value := auth.GetAuthenticationSchemeAndValue(req.Header.Get("Authorization"))
 	var user *types.User
+	var scope *tokenScope
 	if scheme == auth.AuthenticationSchemeBearer {
 		// validate token and read user info from it,
 		// if its valid, call handler with the User
-		user = lookupUserByJWT(a.authController, value)
+		user = lookupUserByJWT(a.authController, a.revocations, value)
 	}
 	if scheme == auth.AuthenticationSchemeKey {
 		// look up token in db,
 		// if it exists and isn't revoked
 		// call handler with corresponding User
-		user = lookupUserByToken(a.dbController, value)
+		user, scope = lookupUserByToken(a.dbController, value)
 	}
 	if user == nil {
 		_ = encodeUnauthenticatedResponse(w)
@@ -84,9 +92,36 @@ func (a *AuthUserMW) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		_ = encodeForbiddenResponse(w)
 		return
 	}
+	if !a.allowedByScope(scope, vars) {
+		_ = encodeForbiddenResponse(w)
+		return
+	}
 	a.handler(w, req)
 }
 
+// allowedByScope checks that scope allows the route,
+// and if scope is limited to machines, that the route is for one of them
+func (a *AuthUserMW) allowedByScope(scope *tokenScope, vars map[string]string) bool {
+	if !scope.allows(a.requiredScope) {
+		return false
+	}
+	if !scope.limitedToMachines() {
+		return true
+	}
+
+	machineID := sanitizeParameter(vars[machineIDKey])
+	if machineID == "" {
+		// e.g. listing all machines, which would include others too
+		_, ok := machineResources[a.requiredScope.Resource()]
+		return !ok
+	}
+	m, err := a.dbController.ReadMachine(machineID)
+	if err != nil {
+		return false
+	}
+	return scope.allowsMachine(m.ID)
+}
+
 type AuthMachineMW struct {
 	handler AuthenticatedMachineHandler
 
@@ -123,20 +158,20 @@ func (a *AuthMachineMW) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.handler(w, r, machine)
 }
 
-func (h *handler) requiresAdmin(next func(http.ResponseWriter, *http.Request)) http.Handler {
-	return NewAuthUserMiddleware(next, h.a, h.d, types.RoleAdministrator)
+func (h *handler) requiresAdmin(scope types.Scope, next func(http.ResponseWriter, *http.Request)) http.Handler {
+	return NewAuthUserMiddleware(next, h.a, h.d, h.revocations, types.RoleAdministrator, scope)
 }
 
-func (h *handler) requiresMaintainer(next func(http.ResponseWriter, *http.Request)) http.Handler {
-	return NewAuthUserMiddleware(next, h.a, h.d, types.RoleMaintainer)
+func (h *handler) requiresMaintainer(scope types.Scope, next func(http.ResponseWriter, *http.Request)) http.Handler {
+	return NewAuthUserMiddleware(next, h.a, h.d, h.revocations, types.RoleMaintainer, scope)
 }
 
-func (h *handler) requiresRoot(next func(http.ResponseWriter, *http.Request)) http.Handler {
-	return NewAuthUserMiddleware(next, h.a, h.d, types.RoleRoot)
+func (h *handler) requiresRoot(scope types.Scope, next func(http.ResponseWriter, *http.Request)) http.Handler {
+	return NewAuthUserMiddleware(next, h.a, h.d, h.revocations, types.RoleRoot, scope)
 }
 
-func (h *handler) requiresUser(next func(http.ResponseWriter, *http.Request)) http.Handler {
-	return NewAuthUserMiddleware(next, h.a, h.d, types.RoleUser)
+func (h *handler) requiresUser(scope types.Scope, next func(http.ResponseWriter, *http.Request)) http.Handler {
+	return NewAuthUserMiddleware(next, h.a, h.d, h.revocations, types.RoleUser, scope)
 }
 
 func (h *handler) requiresMachine(next AuthenticatedMachineHandler) http.Handler {
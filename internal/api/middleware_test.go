@@ -5,9 +5,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 
 	"github.com/LassiHeikkila/taskey/internal/auth/mock"
 	"github.com/LassiHeikkila/taskey/internal/db"
@@ -22,6 +25,7 @@ func TestAuthenticatedUserMiddleware(t *testing.T) {
 	d := mock_db.NewMockController(ctrl)
 
 	expectedUserToken := &db.UserToken{
+		Model: gorm.Model{ID: 7},
 		User: db.User{
 			Name:           "Lassi",
 			Email:          "lassi@example.com",
@@ -30,6 +34,7 @@ func TestAuthenticatedUserMiddleware(t *testing.T) {
 		},
 	}
 	d.EXPECT().ReadUserToken(db.StringToUUID(`cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2`)).Return(expectedUserToken, nil)
+	d.EXPECT().UpdateUserTokenLastUsed(uint(7), gomock.Any()).Return(nil)
 
 	called := false
 
@@ -41,7 +46,7 @@ func TestAuthenticatedUserMiddleware(t *testing.T) {
 
 	// check that when token validation returns a user, next is called
 
-	mw := NewAuthUserMiddleware(next, a, d, types.RoleUser)
+	mw := NewAuthUserMiddleware(next, a, d, newRevocationCache(), types.RoleUser, types.ScopeRecordsRead)
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/example", nil)
@@ -65,6 +70,19 @@ func TestAuthenticatedUserMiddleware(t *testing.T) {
 	if called {
 		t.Fatal("next called!")
 	}
+
+	// check that expired token is refused
+
+	expiredUserToken := *expectedUserToken
+	expiredUserToken.Expiration = time.Now().Add(-time.Minute)
+	d.EXPECT().ReadUserToken(db.StringToUUID(`cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2`)).Return(&expiredUserToken, nil)
+
+	w3 := httptest.NewRecorder()
+	mw.ServeHTTP(w3, req)
+
+	if called {
+		t.Fatal("next called with expired token")
+	}
 }
 
 func TestAuthenticatedMachineMiddleware(t *testing.T) {
@@ -80,6 +98,9 @@ func TestAuthenticatedMachineMiddleware(t *testing.T) {
 		Arch:        "x86_64",
 	}
 	expectedMachineToken := &db.MachineToken{
+		Model: gorm.Model{ID: 8},
+		// not expired yet
+		Expiration: time.Now().Add(time.Hour),
 		Machine: db.Machine{
 			Name:        "TestMachine",
 			Description: "Machine for testing",
@@ -88,6 +109,7 @@ func TestAuthenticatedMachineMiddleware(t *testing.T) {
 		},
 	}
 	d.EXPECT().ReadMachineToken(db.StringToUUID(`519aa433-418e-4fc2-bd72-5d196a62fc85`)).Return(expectedMachineToken, nil)
+	d.EXPECT().UpdateMachineTokenLastUsed(uint(8), gomock.Any()).Return(nil)
 
 	called := false
 	var calledWithMachine *types.Machine
@@ -126,4 +148,118 @@ func TestAuthenticatedMachineMiddleware(t *testing.T) {
 	if called {
 		t.Fatal("next called")
 	}
+
+	// check that expired token is refused
+
+	expiredMachineToken := *expectedMachineToken
+	expiredMachineToken.Expiration = time.Now().Add(-time.Minute)
+	d.EXPECT().ReadMachineToken(db.StringToUUID(`519aa433-418e-4fc2-bd72-5d196a62fc85`)).Return(&expiredMachineToken, nil)
+
+	w3 := httptest.NewRecorder()
+	mw.ServeHTTP(w3, req)
+
+	if called {
+		t.Fatal("next called with expired token")
+	}
+}
+
+func TestAuthenticatedUserMiddlewareScopes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	a := mock_auth.NewMockController(ctrl)
+	d := mock_db.NewMockController(ctrl)
+
+	user := db.User{
+		Model:          gorm.Model{ID: 1},
+		Name:           "ci",
+		OrganizationID: 123,
+		Role:           types.RoleUser | types.RoleMaintainer | types.RoleAdministrator,
+	}
+	scopedToken := &db.UserToken{
+		Model:    gorm.Model{ID: 7},
+		Scopes:   "records:read tasks:write",
+		Machines: []db.Machine{{Model: gorm.Model{ID: 5}, Name: "machine5"}},
+		User:     user,
+	}
+	fullToken := &db.UserToken{
+		Model: gorm.Model{ID: 8},
+		User:  user,
+	}
+	d.EXPECT().ReadUserToken(db.StringToUUID(`cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2`)).Return(scopedToken, nil).AnyTimes()
+	d.EXPECT().ReadUserToken(db.StringToUUID(`91f2a925-a2c5-4a59-aa13-adeb7950faa8`)).Return(fullToken, nil).AnyTimes()
+	d.EXPECT().UpdateUserTokenLastUsed(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.EXPECT().ReadOrganization("org123").Return(&db.Organization{Model: gorm.Model{ID: 123}, Name: "org123"}, nil).AnyTimes()
+	d.EXPECT().ReadUser("ci").Return(&user, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machine5").Return(&db.Machine{Model: gorm.Model{ID: 5}, Name: "machine5", OrganizationID: 123}, nil).AnyTimes()
+	d.EXPECT().ReadMachine("machine6").Return(&db.Machine{Model: gorm.Model{ID: 6}, Name: "machine6", OrganizationID: 123}, nil).AnyTimes()
+
+	tests := map[string]struct {
+		token    string
+		required types.Scope
+		machine  string
+		want     bool
+	}{
+		"scope and machine allowed": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: types.ScopeRecordsRead,
+			machine:  "machine5",
+			want:     true,
+		},
+		"other machine": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: types.ScopeRecordsRead,
+			machine:  "machine6",
+			want:     false,
+		},
+		"missing scope": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: types.ScopeRecordsWrite,
+			machine:  "machine5",
+			want:     false,
+		},
+		"write includes read": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: types.ScopeTasksRead,
+			want:     true,
+		},
+		"machine resource without machine": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: types.ScopeRecordsRead,
+			want:     false,
+		},
+		"unrestricted route": {
+			token:    "cf6525ce-9fbb-4cd1-a1f1-d96f4220b3d2",
+			required: scopeUnrestricted,
+			want:     false,
+		},
+		"token without scopes": {
+			token:    "91f2a925-a2c5-4a59-aa13-adeb7950faa8",
+			required: scopeUnrestricted,
+			machine:  "machine6",
+			want:     true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			next := func(w http.ResponseWriter, req *http.Request) {
+				called = true
+			}
+			mw := NewAuthUserMiddleware(next, a, d, newRevocationCache(), types.RoleUser, tc.required)
+
+			vars := map[string]string{orgIDKey: "org123"}
+			if tc.machine != "" {
+				vars[machineIDKey] = tc.machine
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/example", nil)
+			req.Header.Set("Authorization", "Key "+tc.token)
+			mw.ServeHTTP(w, mux.SetURLVars(req, vars))
+
+			if called != tc.want {
+				t.Fatalf("next called: %v, want %v (response %d)", called, tc.want, w.Code)
+			}
+		})
+	}
 }
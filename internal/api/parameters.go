@@ -6,12 +6,18 @@ import (
 )
 
 const (
-	orgIDKey     = "organization_id"
-	userIDKey    = "user_id"
-	machineIDKey = "machine_id"
-	recordIDKey  = "record_id"
-	taskIDKey    = "task_id"
-	tokenKey     = "token"
+	orgIDKey             = "organization_id"
+	userIDKey            = "user_id"
+	machineIDKey         = "machine_id"
+	recordIDKey          = "record_id"
+	taskIDKey            = "task_id"
+	triggerIDKey         = "trigger_id"
+	secretIDKey          = "secret_id"
+	tokenIDKey           = "token_id"
+	runIDKey             = "run_id"
+	enrollmentTokenIDKey = "enrollment_token_id"
+	groupIDKey           = "group_id"
+	usernameKey          = "username"
 )
 
 func sanitizeParameter(input string) string {
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"time"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	// machines send heartbeats every 30 seconds, a few can be missed before machine is no longer considered online
+	onlineWindow = 90 * time.Second
+	// machines not heard from in this time are considered offline
+	staleWindow = 10 * time.Minute
+)
+
+// machinePresence tells if machine is online, based on its last heartbeat
+func machinePresence(m *db.Machine, now time.Time) string {
+	if m.LastSeen.IsZero() || m.Status == types.MachineStatusOffline {
+		return types.MachineStatusOffline
+	}
+
+	since := now.Sub(m.LastSeen)
+	switch {
+	case since <= onlineWindow:
+		return types.MachineStatusOnline
+	case since <= staleWindow:
+		return types.MachineStatusStale
+	default:
+		return types.MachineStatusOffline
+	}
+}
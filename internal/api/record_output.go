@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"unicode/utf8"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	// outputs larger than this are stored compressed, separately from the record
+	maxInlineOutput = 64 << 10
+	// how much of a separately stored output is kept in the record as a preview
+	outputPreviewSize = 4 << 10
+)
+
+// separateOutputs replaces outputs of record which are too large to keep inline with previews,
+// and returns the full outputs compressed for storing alongside the record.
+func separateOutputs(record *types.Record) ([]db.RecordOutput, error) {
+	var outputs []db.RecordOutput
+
+	separate := func(output *string, stored *bool, step int, stream string) error {
+		// only server decides what is stored separately
+		*stored = false
+		if len(*output) <= maxInlineOutput {
+			return nil
+		}
+
+		data, err := compressOutput(*output)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, db.RecordOutput{
+			Step:   step,
+			Stream: stream,
+			Size:   int64(len(*output)),
+			Data:   data,
+		})
+
+		*output = outputPreview(*output)
+		*stored = true
+		return nil
+	}
+
+	if err := separate(&record.Output, &record.OutputStored, 0, db.StreamStdout); err != nil {
+		return nil, err
+	}
+	if err := separate(&record.Stderr, &record.StderrStored, 0, db.StreamStderr); err != nil {
+		return nil, err
+	}
+	for i := range record.Steps {
+		step := &record.Steps[i]
+		if err := separate(&step.Output, &step.OutputStored, i+1, db.StreamStdout); err != nil {
+			return nil, err
+		}
+		if err := separate(&step.Stderr, &step.StderrStored, i+1, db.StreamStderr); err != nil {
+			return nil, err
+		}
+	}
+
+	return outputs, nil
+}
+
+func compressOutput(output string) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(output)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// outputPreview returns beginning of output, without cutting a multi-byte character in half
+func outputPreview(output string) string {
+	if len(output) <= outputPreviewSize {
+		return output
+	}
+	n := outputPreviewSize
+	for n > 0 && !utf8.RuneStart(output[n]) {
+		n--
+	}
+	return output[:n]
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func TestSeparateOutputs(t *testing.T) {
+	large := strings.Repeat("ä", maxInlineOutput)
+	record := types.Record{
+		Output:       "short",
+		OutputStored: true, // not for machine to decide
+		Stderr:       large,
+		Steps: []types.ActionResult{
+			{Output: "short"},
+			{Output: large},
+		},
+	}
+
+	outputs, err := separateOutputs(&record)
+	if err != nil {
+		t.Fatal("error separating outputs:", err)
+	}
+
+	if record.Output != "short" || record.OutputStored {
+		t.Fatal("short output modified:", record.Output, record.OutputStored)
+	}
+	if record.Steps[0].OutputStored {
+		t.Fatal("short step output marked stored")
+	}
+	step := record.Steps[1]
+	if !step.OutputStored || len(step.Output) > outputPreviewSize || !utf8.ValidString(step.Output) || !strings.HasPrefix(large, step.Output) {
+		t.Fatal("large output not replaced with a valid preview")
+	}
+
+	if !record.StderrStored || len(record.Stderr) > outputPreviewSize {
+		t.Fatal("large stderr not replaced with a preview")
+	}
+
+	if len(outputs) != 2 {
+		t.Fatal("unexpected stored outputs:", len(outputs))
+	}
+	if outputs[0].Step != 0 || outputs[0].Stream != db.StreamStderr {
+		t.Fatal("unexpected stored stderr:", outputs[0].Step, outputs[0].Stream)
+	}
+	if outputs[1].Step != 2 || outputs[1].Stream != db.StreamStdout || outputs[1].Size != int64(len(large)) {
+		t.Fatal("unexpected stored step output:", outputs[1].Step, outputs[1].Stream)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(outputs[1].Data))
+	if err != nil {
+		t.Fatal("stored output not gzip compressed:", err)
+	}
+	b, _ := io.ReadAll(zr)
+	if string(b) != large {
+		t.Fatal("stored output differs from original")
+	}
+}
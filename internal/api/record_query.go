@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// query parameters accepted when listing records
+const (
+	recordTaskParam    = "task"
+	recordStatusParam  = "status"
+	recordOutcomeParam = "outcome"
+	recordFromParam    = "from"
+	recordToParam      = "to"
+	recordSortParam    = "sort"
+	recordLimitParam   = "limit"
+	recordCursorParam  = "cursor"
+)
+
+const (
+	defaultRecordPageSize = 100
+	maxRecordPageSize     = 1000
+)
+
+// sort parameter values, prefixed with '-' for descending order
+var recordSortFields = map[string]string{
+	"executedAt": db.RecordSortExecutedAt,
+	"status":     db.RecordSortStatus,
+	"id":         db.RecordSortID,
+}
+
+// recordCursor is handed out to clients as an opaque string pointing at the last record of a page
+type recordCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	ExecutedAt time.Time `json:"t"`
+	Status     int       `json:"st,omitempty"`
+	ID         uint      `json:"id"`
+}
+
+// parseRecordQuery builds database query from query parameters of a request listing records.
+// Returned query reads one record more than the page size, so that caller can tell if there are more pages.
+func parseRecordQuery(values url.Values) (db.RecordQuery, int, error) {
+	var query db.RecordQuery
+
+	query.TaskName = sanitizeParameter(values.Get(recordTaskParam))
+
+	if s := values.Get(recordStatusParam); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return query, 0, Error("invalid status: " + field)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+
+	if s := values.Get(recordOutcomeParam); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			outcome := strings.TrimSpace(field)
+			if !types.ValidRecordOutcome(outcome) {
+				return query, 0, Error("invalid outcome: " + field)
+			}
+			query.Outcomes = append(query.Outcomes, outcome)
+		}
+	}
+
+	for param, t := range map[string]*time.Time{
+		recordFromParam: &query.ExecutedAfter,
+		recordToParam:   &query.ExecutedBefore,
+	} {
+		s := values.Get(param)
+		if s == "" {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return query, 0, Error("invalid " + param + " time, expecting RFC3339: " + s)
+		}
+		*t = v
+	}
+
+	sort := values.Get(recordSortParam)
+	if sort == "" {
+		sort = "executedAt"
+	}
+	field, found := recordSortFields[strings.TrimPrefix(sort, "-")]
+	if !found {
+		return query, 0, Error("invalid sort: " + sort)
+	}
+	query.SortBy = field
+	query.Descending = strings.HasPrefix(sort, "-")
+
+	limit := defaultRecordPageSize
+	if s := values.Get(recordLimitParam); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxRecordPageSize {
+			return query, 0, Error("limit must be between 1 and " + strconv.Itoa(maxRecordPageSize))
+		}
+		limit = v
+	}
+	query.Limit = limit + 1
+
+	if s := values.Get(recordCursorParam); s != "" {
+		cursor, err := decodeRecordCursor(s)
+		// cursor is only valid for the order it was created with
+		if err != nil || cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+			return query, 0, Error("invalid cursor")
+		}
+		query.After = &db.RecordCursor{
+			ExecutedAt: cursor.ExecutedAt,
+			Status:     cursor.Status,
+			ID:         cursor.ID,
+		}
+	}
+
+	return query, limit, nil
+}
+
+// encodeRecordCursor creates cursor for continuing query after record r
+func encodeRecordCursor(query db.RecordQuery, r *db.Record) string {
+	c := db.CursorOf(r)
+	b, _ := json.Marshal(recordCursor{
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		ExecutedAt: c.ExecutedAt,
+		Status:     c.Status,
+		ID:         c.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeRecordCursor(s string) (recordCursor, error) {
+	var c recordCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+)
+
+func TestParseRecordQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		valid bool
+	}{
+		{name: "defaults", query: ``, valid: true},
+		{name: "all filters", query: `task=backup&status=0,2&from=2022-01-01T00:00:00Z&to=2022-02-01T00:00:00Z&sort=-status&limit=1000`, valid: true},
+		{name: "invalid status", query: `status=failed`, valid: false},
+		{name: "outcomes", query: `outcome=timedOut,skipped`, valid: true},
+		{name: "invalid outcome", query: `outcome=failed`, valid: false},
+		{name: "invalid time", query: `from=yesterday`, valid: false},
+		{name: "invalid sort", query: `sort=output`, valid: false},
+		{name: "zero limit", query: `limit=0`, valid: false},
+		{name: "too large limit", query: `limit=1001`, valid: false},
+		{name: "invalid cursor", query: `cursor=abc`, valid: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal("invalid test query:", err)
+			}
+
+			_, _, err = parseRecordQuery(values)
+			if tc.valid && err != nil {
+				t.Fatal("valid query rejected:", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("invalid query accepted")
+			}
+		})
+	}
+}
+
+func TestRecordCursor(t *testing.T) {
+	values := url.Values{recordSortParam: []string{"-executedAt"}, recordLimitParam: []string{"10"}}
+	query, limit, err := parseRecordQuery(values)
+	if err != nil {
+		t.Fatal("error parsing query:", err)
+	}
+	if limit != 10 || query.Limit != 11 || query.SortBy != db.RecordSortExecutedAt || !query.Descending {
+		t.Fatal("unexpected query:", limit, query)
+	}
+
+	last := db.Record{
+		Model:      gorm.Model{ID: 42},
+		ExecutedAt: time.Date(2022, 3, 4, 5, 6, 7, 8000, time.UTC),
+	}
+	values.Set(recordCursorParam, encodeRecordCursor(query, &last))
+
+	next, _, err := parseRecordQuery(values)
+	if err != nil {
+		t.Fatal("error parsing query with cursor:", err)
+	}
+	if next.After == nil || next.After.ID != 42 || !next.After.ExecutedAt.Equal(last.ExecutedAt) {
+		t.Fatal("cursor not decoded:", next.After)
+	}
+
+	// cursor can't be used with a different order
+	values.Set(recordSortParam, "executedAt")
+	if _, _, err := parseRecordQuery(values); err == nil {
+		t.Fatal("cursor accepted with different sort order")
+	}
+}
@@ -2,13 +2,23 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgtype"
 
 	"github.com/LassiHeikkila/taskey/internal/auth"
 	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
+const (
+	defaultAccessTokenValidity  = 15 * time.Minute
+	defaultRefreshTokenValidity = 30 * 24 * time.Hour
+)
+
 func (h *handler) loginHandler(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
@@ -29,6 +39,11 @@ func (h *handler) loginHandler(w http.ResponseWriter, req *http.Request) {
 		_ = encodeUnauthenticatedResponse(w)
 		return
 	}
+	if loginInfo.MustChangePassword {
+		// user has to replace temporary password using passwordChangeHandler first
+		_ = encodeResponse(w, Response{Code: http.StatusForbidden, Message: "password change required"})
+		return
+	}
 
 	// look up organization by ID
 	org := db.Organization{}
@@ -37,11 +52,23 @@ func (h *handler) loginHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token, err := h.a.CreateJWT(auth.CreateUserClaims(
-		loginInfo.User.Name,
-		org.Name,
-		int(loginInfo.User.Role),
-	))
+	now := time.Now()
+	genUUID, err := h.a.GenerateUUID()
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	refreshToken := db.RefreshToken{
+		Value:       db.StringToUUID(genUUID),
+		Expiration:  now.Add(h.refreshTokenValidity),
+		LoginInfoID: loginInfo.ID,
+	}
+	if err := h.d.CreateRefreshToken(&refreshToken); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	session, err := h.newSession(&loginInfo.User, org.Name, now, genUUID, refreshToken.Expiration)
 	if err != nil {
 		_ = encodeFailure(w)
 		return
@@ -50,9 +77,167 @@ func (h *handler) loginHandler(w http.ResponseWriter, req *http.Request) {
 	_ = encodeResponse(w, Response{
 		Code:    http.StatusOK,
 		Message: "ok",
-		Payload: map[string]interface{}{
-			"token": token,
-		},
+		Payload: session,
+	})
+}
+
+// refreshHandler exchanges a refresh token for a new session.
+// Refresh tokens are single use, the new session comes with a new one.
+func (h *handler) refreshHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	var refresh types.SessionRefresh
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&refresh); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	value := pgtype.UUID{}
+	if err := value.Set(refresh.RefreshToken); err != nil {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	now := time.Now()
+	genUUID, err := h.a.GenerateUUID()
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	next := db.RefreshToken{
+		Value:      db.StringToUUID(genUUID),
+		Expiration: now.Add(h.refreshTokenValidity),
+	}
+	loginInfo, err := h.d.RotateRefreshToken(value, now, &next)
+	if err != nil {
+		if err == db.ErrRefreshTokenInvalid {
+			_ = encodeUnauthenticatedResponse(w)
+			return
+		}
+		_ = encodeFailure(w)
+		return
+	}
+
+	org := db.Organization{}
+	if err := h.d.LoadModel(&org, loginInfo.User.OrganizationID); err != nil {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	session, err := h.newSession(&loginInfo.User, org.Name, now, genUUID, next.Expiration)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: session,
+	})
+}
+
+// logoutHandler revokes the access token used to call it.
+// Refresh token of the session can be given as well, so that it can't be used anymore either.
+func (h *handler) logoutHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	scheme, value := auth.GetAuthenticationSchemeAndValue(req.Header.Get("Authorization"))
+	var claims auth.UserClaims
+	if scheme != auth.AuthenticationSchemeBearer ||
+		!h.a.ValidateUserToken(value, &claims) ||
+		h.revocations.revoked(&claims) {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	// body is optional
+	var refresh types.SessionRefresh
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&refresh); err != nil && err != io.EOF {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	err := h.revoke(&db.TokenRevocation{
+		TokenID:    claims.Id,
+		Username:   claims.User,
+		Expiration: time.Unix(claims.ExpiresAt, 0),
+	})
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	if refresh.RefreshToken != "" {
+		refreshToken := pgtype.UUID{}
+		if err := refreshToken.Set(refresh.RefreshToken); err == nil {
+			// token may well be used or expired already, which is fine
+			_ = h.d.DeleteRefreshToken(refreshToken)
+		}
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// jwksHandler publishes public keys tokens are signed with, so that other services can verify them.
+// Set is empty if tokens are signed with a shared key.
+func (h *handler) jwksHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	// new keys are published well before they are used, so caching for a while is fine
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(h.a.JWKS())
+}
+
+// newSession issues an access token for user, and returns it with the refresh token, which should be stored already
+func (h *handler) newSession(user *db.User, organization string, now time.Time, refreshToken string, refreshExpiration time.Time) (*types.Session, error) {
+	id, err := h.a.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	token, err := h.a.CreateJWT(auth.CreateUserClaims(
+		user.Name,
+		organization,
+		int(user.Role),
+		id,
+		now,
+		h.accessTokenValidity,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Session{
+		Token:             token,
+		Expiration:        now.Add(h.accessTokenValidity),
+		RefreshToken:      refreshToken,
+		RefreshExpiration: refreshExpiration,
+	}, nil
+}
+
+// revoke stores revocation, and starts refusing the revoked tokens right away
+func (h *handler) revoke(revocation *db.TokenRevocation) error {
+	if err := h.d.CreateTokenRevocation(revocation); err != nil {
+		return err
+	}
+	h.revocations.add(revocation, time.Now())
+	return nil
+}
+
+// endSessions deletes refresh tokens of the login, and refuses access tokens issued to its user so far
+func (h *handler) endSessions(loginInfoID uint, username string) error {
+	if err := h.d.DeleteRefreshTokens(loginInfoID); err != nil {
+		return err
+	}
+	now := time.Now()
+	return h.revoke(&db.TokenRevocation{
+		Username:     username,
+		IssuedBefore: now,
+		// every access token issued before now has expired by then
+		Expiration: now.Add(h.accessTokenValidity),
 	})
 }
 
@@ -64,13 +249,13 @@ func (h *handler) loginChecker(w http.ResponseWriter, req *http.Request) {
 	if scheme == auth.AuthenticationSchemeBearer {
 		// validate token and read user info from it,
 		// if its valid, call handler with the User
-		user = lookupUserByJWT(h.a, value)
+		user = lookupUserByJWT(h.a, h.revocations, value)
 	}
 	if scheme == auth.AuthenticationSchemeKey {
 		// look up token in db,
 		// if it exists and isn't revoked
 		// call handler with corresponding User
-		user = lookupUserByToken(h.d, value)
+		user, _ = lookupUserByToken(h.d, value)
 	}
 	if user == nil {
 		_ = encodeUnauthenticatedResponse(w)
@@ -79,8 +264,120 @@ func (h *handler) loginChecker(w http.ResponseWriter, req *http.Request) {
 	_ = encodeSuccess(w)
 }
 
-func (*handler) passwordChangeHandler(w http.ResponseWriter, req *http.Request) {
-	// TODO: implement
+// passwordChangeHandler replaces password of a user, who proves their identity with the current password.
+// This is also how temporary passwords are replaced, as users can't log in with them.
+// Existing sessions of the user are ended, so that whoever learned the old password is logged out too.
+func (h *handler) passwordChangeHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	username := sanitizeParameter(vars[usernameKey])
+
+	var change types.PasswordChange
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&change); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	loginInfo, err := h.d.ReadLoginInfo(username)
+	if err != nil {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+	if !auth.PasswordEqualsHashed(change.OldPassword, loginInfo.Password) {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	if err := validatePassword(change.NewPassword); err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
+	if change.NewPassword == change.OldPassword {
+		_ = encodeInvalidRequestResponse(w, Error("new password must be different from the old one"))
+		return
+	}
+
+	loginInfo.Password = auth.HashPassword(change.NewPassword)
+	loginInfo.MustChangePassword = false
+	if err := h.d.UpdateLoginInfo(loginInfo); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	if err := h.endSessions(loginInfo.ID, loginInfo.User.Name); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// passwordResetHandler sets password of a user with a reset token given to them by an administrator.
+// Existing sessions of the user are ended, like when changing password.
+func (h *handler) passwordResetHandler(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	_ = encodeUnimplementedResponse(w)
+
+	vars := mux.Vars(req)
+	username := sanitizeParameter(vars[usernameKey])
+
+	var reset types.PasswordReset
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reset); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	token := pgtype.UUID{}
+	if err := token.Set(reset.Token); err != nil {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+	if err := validatePassword(reset.NewPassword); err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
+
+	if err := h.d.ResetPassword(username, token, time.Now(), auth.HashPassword(reset.NewPassword)); err != nil {
+		if err == db.ErrPasswordResetInvalid {
+			_ = encodeUnauthenticatedResponse(w)
+			return
+		}
+		_ = encodeFailure(w)
+		return
+	}
+	loginInfo, err := h.d.ReadLoginInfo(username)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	if err := h.endSessions(loginInfo.ID, loginInfo.User.Name); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// newPasswordReset creates reset token for the login, valid for given time
+func (h *handler) newPasswordReset(loginInfo *db.LoginInfo, validity time.Duration) (*types.PasswordReset, error) {
+	genUUID, err := h.a.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	reset := db.PasswordReset{
+		Value:       db.StringToUUID(genUUID),
+		Expiration:  time.Now().Add(validity),
+		LoginInfoID: loginInfo.ID,
+	}
+	if err := h.d.CreatePasswordReset(&reset); err != nil {
+		return nil, err
+	}
+
+	return &types.PasswordReset{
+		Username:   loginInfo.Username,
+		Token:      genUUID,
+		Expiration: reset.Expiration,
+	}, nil
 }
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgtype"
+
+	"github.com/LassiHeikkila/taskey/internal/auth"
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	defaultEnrollmentValidity = time.Hour
+	maxEnrollmentValidity     = 7 * 24 * time.Hour
+	// one token can be used to enroll a fleet of machines, but not an unlimited one
+	maxEnrollmentUses = 1000
+	// label of machine tokens given out on enrollment
+	enrollmentTokenLabel = "enrollment"
+)
+
+func (h *handler) createEnrollmentToken(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	var reqToken types.EnrollmentTokenRequest
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqToken); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	validFor := reqToken.ValidFor.Duration
+	if validFor == 0 {
+		validFor = defaultEnrollmentValidity
+	}
+	if validFor < 0 || validFor > maxEnrollmentValidity {
+		_ = encodeInvalidRequestResponse(w, Error("validFor must be positive and at most "+maxEnrollmentValidity.String()))
+		return
+	}
+	maxUses := reqToken.MaxUses
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	if maxUses < 0 || maxUses > maxEnrollmentUses {
+		_ = encodeInvalidRequestResponse(w, Error("maxUses must be between 1 and "+strconv.Itoa(maxEnrollmentUses)))
+		return
+	}
+
+	genUUID, err := h.a.GenerateUUID()
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	et := db.EnrollmentToken{
+		Value:          db.StringToUUID(genUUID),
+		Description:    reqToken.Description,
+		Expiration:     time.Now().Add(validFor),
+		MaxUses:        maxUses,
+		OrganizationID: o.ID,
+	}
+	if err := h.d.CreateEnrollmentToken(&et); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	returnedToken := dbconverter.ConvertEnrollmentToken(&et)
+	returnedToken.Value = genUUID
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &returnedToken,
+	})
+}
+
+func (h *handler) readEnrollmentTokens(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadEnrollmentTokens(o.ID)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	tokens := make([]types.EnrollmentToken, 0, len(t))
+	for i := range t {
+		tokens = append(tokens, dbconverter.ConvertEnrollmentToken(&t[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &tokens,
+	})
+}
+
+func (h *handler) deleteEnrollmentToken(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	tokenID := sanitizeParameter(vars[enrollmentTokenIDKey])
+
+	id, err := strconv.ParseUint(tokenID, 10, 64)
+	if err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.d.DeleteEnrollmentToken(o.ID, uint(id)); err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// enrollMachine registers the calling machine using an enrollment token given as its key,
+// and returns a token for the machine to use from then on
+func (h *handler) enrollMachine(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	scheme, value := auth.GetAuthenticationSchemeAndValue(req.Header.Get("Authorization"))
+	enrollmentToken := pgtype.UUID{}
+	if err := enrollmentToken.Set(value); scheme != auth.AuthenticationSchemeKey || err != nil {
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		// same as invalid token, so that organizations can't be probed
+		_ = encodeUnauthenticatedResponse(w)
+		return
+	}
+
+	var enrollment types.Enrollment
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&enrollment); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	// machine name ends up in paths, it must not need escaping or be confused with the calling machine
+	if enrollment.Name == "" || enrollment.Name != sanitizeParameter(enrollment.Name) || enrollment.Name == "self" {
+		_ = encodeInvalidRequestResponse(w, Error("invalid machine name: "+sanitizeParameter(enrollment.Name)))
+		return
+	}
+	genUUID, err := h.a.GenerateUUID()
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	m := db.Machine{
+		Name:        enrollment.Name,
+		Description: enrollment.Description,
+		OS:          enrollment.OS,
+		Arch:        enrollment.Arch,
+		Hostname:    enrollment.Hostname,
+	}
+	mt := db.MachineToken{
+		Value:      db.StringToUUID(genUUID),
+		Label:      enrollmentTokenLabel,
+		Expiration: time.Time{}, // zero time means no expiry
+	}
+	if err := h.d.EnrollMachine(o.ID, enrollmentToken, time.Now(), &m, &mt); err != nil {
+		if err == db.ErrEnrollmentTokenInvalid {
+			_ = encodeUnauthenticatedResponse(w)
+			return
+		}
+		// only reported to callers with a valid token, so that machine names can't be probed
+		if err == db.ErrMachineExists {
+			_ = encodeConflictResponse(w)
+			return
+		}
+		_ = encodeFailure(w)
+		return
+	}
+
+	credential := types.MachineCredential{
+		Machine:      m.Name,
+		Organization: o.Name,
+		AccessToken:  dbconverter.ConvertMachineToken(&mt),
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &credential,
+	})
+}
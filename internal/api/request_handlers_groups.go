@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgtype"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// validGroupName checks that group name can be used in paths as is
+func validGroupName(name string) bool {
+	return name != "" && name == sanitizeParameter(name)
+}
+
+// organizationGroup reads the group in the path, belonging to the organization in the path.
+// If nil is returned, a response has been written already.
+func (h *handler) organizationGroup(w http.ResponseWriter, req *http.Request) *db.MachineGroup {
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	groupID := sanitizeParameter(vars[groupIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	g, err := h.d.ReadMachineGroup(o.ID, groupID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	return g
+}
+
+func (h *handler) createMachineGroup(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	var reqGroup types.MachineGroup
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqGroup); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	if !validGroupName(reqGroup.Name) {
+		_ = encodeInvalidRequestResponse(w, Error("invalid group name: "+sanitizeParameter(reqGroup.Name)))
+		return
+	}
+	if _, err := h.d.ReadMachineGroup(o.ID, reqGroup.Name); err == nil {
+		_ = encodeConflictResponse(w)
+		return
+	}
+
+	group := db.MachineGroup{
+		Name:           reqGroup.Name,
+		Description:    reqGroup.Description,
+		OrganizationID: o.ID,
+		Schedule:       pgtype.JSON{Status: pgtype.Null},
+	}
+	if reqGroup.Schedule != nil {
+		group.Schedule = dbconverter.ConvertScheduleToDB(reqGroup.Schedule).Content
+	}
+	if err := h.d.CreateMachineGroup(&group); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	// members are given by name, and added after the group exists
+	for _, name := range reqGroup.Machines {
+		m, err := h.d.ReadMachine(name)
+		if err != nil || m.OrganizationID != o.ID {
+			continue
+		}
+		if err := h.d.AddMachineGroupMember(&group, m); err != nil {
+			_ = encodeFailure(w)
+			return
+		}
+		group.Machines = append(group.Machines, *m)
+	}
+
+	returnedGroup := dbconverter.ConvertMachineGroup(&group)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &returnedGroup,
+	})
+}
+
+func (h *handler) readMachineGroups(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	g, err := h.d.ReadMachineGroups(o.ID)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	groups := make([]types.MachineGroup, 0, len(g))
+	for i := range g {
+		groups = append(groups, dbconverter.ConvertMachineGroup(&g[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &groups,
+	})
+}
+
+func (h *handler) readMachineGroup(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	group := dbconverter.ConvertMachineGroup(g)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &group,
+	})
+}
+
+// updateMachineGroup changes description of a group, members and schedule have their own endpoints
+func (h *handler) updateMachineGroup(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	var reqGroup types.MachineGroup
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqGroup); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	if reqGroup.Name != "" && reqGroup.Name != g.Name {
+		_ = encodeInvalidRequestResponse(w, Error("group can't be renamed"))
+		return
+	}
+
+	g.Description = reqGroup.Description
+	if err := h.d.UpdateMachineGroup(g); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	group := dbconverter.ConvertMachineGroup(g)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &group,
+	})
+}
+
+func (h *handler) deleteMachineGroup(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	if err := h.d.DeleteMachineGroup(g.OrganizationID, g.Name); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// groupMember reads the group and the machine in the path, both belonging to the organization in the path.
+// If nil is returned, a response has been written already.
+func (h *handler) groupMember(w http.ResponseWriter, req *http.Request) (*db.MachineGroup, *db.Machine) {
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return nil, nil
+	}
+
+	machineID := sanitizeParameter(mux.Vars(req)[machineIDKey])
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil || m.OrganizationID != g.OrganizationID {
+		_ = encodeNotFoundResponse(w)
+		return nil, nil
+	}
+	return g, m
+}
+
+func (h *handler) addMachineGroupMember(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g, m := h.groupMember(w, req)
+	if g == nil {
+		return
+	}
+
+	if err := h.d.AddMachineGroupMember(g, m); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) removeMachineGroupMember(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g, m := h.groupMember(w, req)
+	if g == nil {
+		return
+	}
+
+	if err := h.d.RemoveMachineGroupMember(g, m); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) readMachineGroupSchedule(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	group := dbconverter.ConvertMachineGroup(g)
+	if group.Schedule == nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: group.Schedule,
+	})
+}
+
+// updateMachineGroupSchedule sets schedule of a group, replacing the previous one if there was one
+func (h *handler) updateMachineGroupSchedule(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	var reqSchedule types.Schedule
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqSchedule); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	g.Schedule = dbconverter.ConvertScheduleToDB(&reqSchedule).Content
+	if err := h.d.UpdateMachineGroup(g); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) deleteMachineGroupSchedule(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	g.Schedule = pgtype.JSON{Status: pgtype.Null}
+	if err := h.d.UpdateMachineGroup(g); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
@@ -2,10 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
 	"github.com/LassiHeikkila/taskey/pkg/types"
 )
@@ -17,6 +20,52 @@ func (h *handler) checkMachineToken(w http.ResponseWriter, req *http.Request, se
 	_ = encodeSuccess(w)
 }
 
+// machineHeartbeat records that the machine is running, along with information it reports about itself
+func (h *handler) machineHeartbeat(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
+
+	m := h.ownMachine(w, req, self)
+	if m == nil {
+		return
+	}
+
+	var hb types.Heartbeat
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&hb); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	switch hb.Status {
+	case "":
+		hb.Status = types.MachineStatusOnline
+	case types.MachineStatusOnline, types.MachineStatusOffline:
+	default:
+		_ = encodeInvalidRequestResponse(w, Error("invalid status: "+sanitizeParameter(hb.Status)))
+		return
+	}
+
+	// server time is used, clock of the machine may be off
+	m.LastSeen = time.Now()
+	m.Status = hb.Status
+	m.Version = hb.Version
+	m.Uptime = hb.Uptime.Duration
+	m.ScheduleHash = hb.ScheduleHash
+	m.Hostname = hb.Hostname
+	if hb.OS != "" {
+		m.OS = hb.OS
+	}
+	if hb.Arch != "" {
+		m.Arch = hb.Arch
+	}
+
+	if err := h.d.UpdateMachineHeartbeat(m); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
 func (h *handler) readMachineOwnSchedule(w http.ResponseWriter, req *http.Request, self *types.Machine) {
 	defer req.Body.Close()
 
@@ -39,21 +88,47 @@ func (h *handler) readMachineOwnSchedule(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	sched, err := h.d.ReadSchedule(m.Name)
+	schedule, found, err := h.machineSchedule(m)
 	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	if !found {
 		_ = encodeNotFoundResponse(w)
 		return
 	}
 
-	schedule := dbconverter.ConvertSchedule(sched)
-
-	_ = encodeResponse(w, Response{
+	_ = encodeResponseWithETag(w, req, Response{
 		Code:    http.StatusOK,
 		Message: "ok",
 		Payload: &schedule,
 	})
 }
 
+// machineSchedule returns own schedule of machine m, merged with schedules of groups it belongs to.
+// found is false if neither the machine nor any of its groups has a schedule.
+func (h *handler) machineSchedule(m *db.Machine) (schedule types.Schedule, found bool, err error) {
+	if sched, err := h.d.ReadSchedule(m.Name); err == nil {
+		schedule = dbconverter.ConvertSchedule(sched)
+		found = true
+	}
+
+	groups, err := h.d.ReadMachineGroupsOf(m.ID)
+	if err != nil {
+		return schedule, false, err
+	}
+	for i := range groups {
+		group := dbconverter.ConvertMachineGroup(&groups[i])
+		if group.Schedule == nil {
+			continue
+		}
+		schedule = schedule.Merge(*group.Schedule)
+		found = true
+	}
+
+	return schedule, found, nil
+}
+
 func (h *handler) addRecord(w http.ResponseWriter, req *http.Request, self *types.Machine) {
 	defer req.Body.Close()
 
@@ -82,23 +157,208 @@ func (h *handler) addRecord(w http.ResponseWriter, req *http.Request, self *type
 		return
 	}
 
-	t, err := h.d.ReadTask(reqRecord.TaskName)
+	record, code := h.machineRecordToDB(m, &reqRecord)
+	switch code {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		_ = encodeNotFoundResponse(w)
+		return
+	case http.StatusBadRequest:
+		_ = encodeBadRequestResponse(w)
+		return
+	default:
+		_ = encodeFailure(w)
+		return
+	}
+
+	records := []db.Record{record}
+	errs, err := h.d.CreateRecords(records)
+	if err != nil || errs[0] != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	h.runs.finish(m.ID, reqRecord.RunID, types.RunEnd{RecordID: records[0].ID, Outcome: records[0].Outcome, Status: records[0].Status})
+
+	_ = encodeSuccess(w)
+}
+
+// maxRecordBatchSize limits how many records can be uploaded in one request
+const maxRecordBatchSize = 100
+
+// addRecords creates multiple records in a single transaction.
+// Each record succeeds or fails on its own, and the result of each is returned in the same order as the records.
+func (h *handler) addRecords(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(self.Name)
 	if err != nil {
 		_ = encodeNotFoundResponse(w)
 		return
 	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
 
-	record := dbconverter.ConvertRecordToDB(&reqRecord)
+	var reqRecords []types.Record
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqRecords); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	if len(reqRecords) > maxRecordBatchSize {
+		_ = encodeInvalidRequestResponse(w, Error(fmt.Sprint("at most ", maxRecordBatchSize, " records can be uploaded at once")))
+		return
+	}
+
+	results := make([]types.RecordResult, len(reqRecords))
+	records := make([]db.Record, 0, len(reqRecords))
+	// index in results of each record passed on to database
+	indexes := make([]int, 0, len(reqRecords))
+
+	for i := range reqRecords {
+		record, code := h.machineRecordToDB(m, &reqRecords[i])
+		if code != http.StatusOK {
+			results[i] = recordResult(code)
+			continue
+		}
+		records = append(records, record)
+		indexes = append(indexes, i)
+	}
+
+	if len(records) > 0 {
+		errs, err := h.d.CreateRecords(records)
+		if err != nil {
+			_ = encodeFailure(w)
+			return
+		}
+		for j, i := range indexes {
+			if errs[j] != nil {
+				results[i] = recordResult(http.StatusInternalServerError)
+				continue
+			}
+			results[i] = recordResult(http.StatusOK)
+			results[i].ID = records[j].ID
+			h.runs.finish(m.ID, reqRecords[i].RunID, types.RunEnd{RecordID: records[j].ID, Outcome: records[j].Outcome, Status: records[j].Status})
+		}
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &results,
+	})
+}
+
+// recordResult describes outcome of a single record with the same messages as other responses
+func recordResult(code int) types.RecordResult {
+	r := types.RecordResult{Code: code}
+	switch code {
+	case http.StatusOK:
+		r.Message = "ok"
+	case http.StatusNotFound:
+		r.Message = "not found"
+	case http.StatusBadRequest:
+		r.Message = "bad request"
+	default:
+		r.Message = "failure"
+	}
+	return r
+}
+
+// machineRecordToDB checks that record sent by machine m refers to a task and trigger it may report on,
+// and converts it to database form. Returned code is http.StatusOK if the record is acceptable.
+func (h *handler) machineRecordToDB(m *db.Machine, reqRecord *types.Record) (db.Record, int) {
+	t, err := h.d.ReadTask(reqRecord.TaskName)
+	if err != nil || t.OrganizationID != m.OrganizationID {
+		return db.Record{}, http.StatusNotFound
+	}
+
+	if reqRecord.Outcome != "" && !types.ValidRecordOutcome(reqRecord.Outcome) {
+		return db.Record{}, http.StatusBadRequest
+	}
+
+	if reqRecord.TriggerID != 0 {
+		trigger, err := h.d.ReadTrigger(reqRecord.TriggerID)
+		if err != nil {
+			return db.Record{}, http.StatusNotFound
+		}
+		if trigger.MachineID != m.ID || trigger.TaskID != t.ID {
+			return db.Record{}, http.StatusBadRequest
+		}
+	}
+
+	outputs, err := separateOutputs(reqRecord)
+	if err != nil {
+		return db.Record{}, http.StatusInternalServerError
+	}
+
+	record := dbconverter.ConvertRecordToDB(reqRecord)
+	if record.FinishedAt.IsZero() {
+		// older machines only report when the run finished, as execution time
+		record.FinishedAt = record.ExecutedAt
+	}
 
 	record.MachineID = m.ID
 	record.TaskID = t.ID
+	record.Outputs = outputs
+
+	return record, http.StatusOK
+}
+
+func (h *handler) readMachineOwnTriggers(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
 
-	if err := h.d.CreateRecord(&record); err != nil {
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(self.Name)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	pending, err := h.d.ReadTriggers(m.Name, types.TriggerStatePending)
+	if err != nil {
 		_ = encodeFailure(w)
 		return
 	}
 
-	_ = encodeSuccess(w)
+	// machine is expected to run everything it fetches,
+	// so mark them as running to not hand them out twice
+	triggers := make([]types.Trigger, 0, len(pending))
+	for i := range pending {
+		pending[i].State = types.TriggerStateRunning
+		if err := h.d.UpdateTrigger(&pending[i]); err != nil {
+			continue
+		}
+
+		trigger := dbconverter.ConvertTrigger(&pending[i])
+		triggers = append(triggers, trigger)
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &triggers,
+	})
 }
 
 func (h *handler) readMachineTasks(w http.ResponseWriter, req *http.Request, _ *types.Machine) {
@@ -125,7 +385,7 @@ func (h *handler) readMachineTasks(w http.ResponseWriter, req *http.Request, _ *
 		tasks = append(tasks, task)
 	}
 
-	_ = encodeResponse(w, Response{
+	_ = encodeResponseWithETag(w, req, Response{
 		Code:    http.StatusOK,
 		Message: "ok",
 		Payload: &tasks,
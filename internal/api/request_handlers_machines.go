@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -66,6 +67,7 @@ func (h *handler) readMachine(w http.ResponseWriter, req *http.Request) {
 	}
 
 	machine := dbconverter.ConvertMachine(m)
+	machine.Status = machinePresence(m, time.Now())
 
 	_ = encodeResponse(w, Response{
 		Code:    http.StatusOK,
@@ -86,6 +88,16 @@ func (h *handler) readMachines(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// optionally only machines with given status
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", types.MachineStatusOnline, types.MachineStatusStale, types.MachineStatusOffline:
+	default:
+		_ = encodeInvalidRequestResponse(w, Error("invalid status: "+sanitizeParameter(status)))
+		return
+	}
+
+	now := time.Now()
 	machines := make([]types.Machine, 0, len(o.Machines))
 	for i := range o.Machines {
 		mchn, err := h.d.ReadMachine(o.Machines[i].Name)
@@ -94,6 +106,10 @@ func (h *handler) readMachines(w http.ResponseWriter, req *http.Request) {
 		}
 
 		machine := dbconverter.ConvertMachine(mchn)
+		machine.Status = machinePresence(mchn, now)
+		if status != "" && machine.Status != status {
+			continue
+		}
 		machines = append(machines, machine)
 	}
 
@@ -200,17 +216,25 @@ func (h *handler) createMachineToken(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	tr, expiration, err := decodeTokenRequest(req)
+	if err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
+	if len(tr.Scopes) != 0 {
+		_ = encodeInvalidRequestResponse(w, Error("machine tokens can't have scopes"))
+		return
+	}
+
 	genUUID, err := h.a.GenerateUUID()
 	if err != nil {
 		_ = encodeFailure(w)
 		return
 	}
 
-	// TODO: add option to define token expiration via a body
-	expiration := time.Time{} // zero time means no expiry
-
 	mt := db.MachineToken{
 		Value:      db.StringToUUID(genUUID),
+		Label:      tr.Label,
 		Expiration: expiration,
 		MachineID:  m.ID,
 		Machine:    *m,
@@ -230,8 +254,79 @@ func (h *handler) createMachineToken(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func (*handler) deleteMachineToken(w http.ResponseWriter, req *http.Request) {
-	// TODO: implement
+// readMachineTokens lists tokens of the machine without their values, which are only returned when a token is created
+func (h *handler) readMachineTokens(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	_ = encodeUnimplementedResponse(w)
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadMachineTokens(m.ID)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	tokens := make([]types.TokenInfo, 0, len(t))
+	for i := range t {
+		tokens = append(tokens, dbconverter.ConvertMachineTokenInfo(&t[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &tokens,
+	})
+}
+
+func (h *handler) deleteMachineToken(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+	tokenID := sanitizeParameter(vars[tokenIDKey])
+
+	id, err := strconv.ParseUint(tokenID, 10, 64)
+	if err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.d.DeleteMachineToken(m.ID, uint(id)); err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
 }
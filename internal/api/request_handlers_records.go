@@ -1,11 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
+	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
 	"github.com/LassiHeikkila/taskey/pkg/types"
 )
@@ -18,9 +24,10 @@ func (h *handler) readRecord(w http.ResponseWriter, req *http.Request) {
 	machineID := sanitizeParameter(vars[machineIDKey])
 	recordID := sanitizeParameter(vars[recordIDKey])
 
-	rid, err := strconv.ParseInt(recordID, 10, 64)
+	rid, err := strconv.ParseUint(recordID, 10, 64)
 	if err != nil {
 		_ = encodeBadRequestResponse(w)
+		return
 	}
 
 	m, err := h.d.ReadMachine(machineID)
@@ -40,25 +47,22 @@ func (h *handler) readRecord(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	r, err := h.d.ReadRecords(machineID)
+	r, err := h.d.ReadRecord(uint(rid))
 	if err != nil {
 		_ = encodeNotFoundResponse(w)
 		return
 	}
-
-	for i := range r {
-		rec := r[i]
-		if int64(rec.ID) == rid {
-			record := dbconverter.ConvertRecord(&rec)
-			_ = encodeResponse(w, Response{
-				Code:    http.StatusOK,
-				Message: "ok",
-				Payload: &record,
-			})
-			return
-		}
+	if r.MachineID != m.ID {
+		_ = encodeNotFoundResponse(w)
+		return
 	}
-	_ = encodeNotFoundResponse(w)
+
+	record := dbconverter.ConvertRecord(r)
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &record,
+	})
 }
 
 func (h *handler) readRecords(w http.ResponseWriter, req *http.Request) {
@@ -68,6 +72,12 @@ func (h *handler) readRecords(w http.ResponseWriter, req *http.Request) {
 	orgID := sanitizeParameter(vars[orgIDKey])
 	machineID := sanitizeParameter(vars[machineIDKey])
 
+	query, limit, err := parseRecordQuery(req.URL.Query())
+	if err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
+
 	m, err := h.d.ReadMachine(machineID)
 	if err != nil {
 		_ = encodeNotFoundResponse(w)
@@ -85,12 +95,19 @@ func (h *handler) readRecords(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	r, err := h.d.ReadRecords(machineID)
+	r, err := h.d.ReadRecords(machineID, query)
 	if err != nil {
 		_ = encodeNotFoundResponse(w)
 		return
 	}
 
+	// one extra record is read to find out if there is another page
+	var nextCursor string
+	if len(r) > limit {
+		r = r[:limit]
+		nextCursor = encodeRecordCursor(query, &r[limit-1])
+	}
+
 	records := make([]types.Record, 0, len(r))
 	for i := range r {
 		record := dbconverter.ConvertRecord(&r[i])
@@ -98,12 +115,120 @@ func (h *handler) readRecords(w http.ResponseWriter, req *http.Request) {
 	}
 
 	_ = encodeResponse(w, Response{
-		Code:    http.StatusOK,
-		Message: "ok",
-		Payload: &records,
+		Code:       http.StatusOK,
+		Message:    "ok",
+		Payload:    &records,
+		NextCursor: nextCursor,
 	})
 }
 
+// readRecordOutput serves full output of a record, or of one of its steps with step query parameter.
+// Stdout is served by default, stderr with stream query parameter.
+// Output is sent as plain text rather than JSON, compressed if the client accepts it.
+func (h *handler) readRecordOutput(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+	recordID := sanitizeParameter(vars[recordIDKey])
+
+	rid, err := strconv.ParseUint(recordID, 10, 64)
+	if err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	r, err := h.d.ReadRecord(uint(rid))
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if r.MachineID != m.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	stream := db.StreamStdout
+	if s := req.URL.Query().Get("stream"); s != "" {
+		if s != db.StreamStdout && s != db.StreamStderr {
+			_ = encodeInvalidRequestResponse(w, Error("invalid stream: "+sanitizeParameter(s)))
+			return
+		}
+		stream = s
+	}
+
+	record := dbconverter.ConvertRecord(r)
+	output, stored, name := record.Output, record.OutputStored, "output"
+	if stream == db.StreamStderr {
+		output, stored, name = record.Stderr, record.StderrStored, "stderr"
+	}
+	filename := fmt.Sprintf("record-%d-%s.txt", r.ID, name)
+
+	step := 0
+	if s := req.URL.Query().Get("step"); s != "" {
+		step, err = strconv.Atoi(s)
+		if err != nil || step < 1 || step > len(record.Steps) {
+			_ = encodeInvalidRequestResponse(w, Error("invalid step: "+sanitizeParameter(s)))
+			return
+		}
+		res := &record.Steps[step-1]
+		output, stored = res.Output, res.OutputStored
+		if stream == db.StreamStderr {
+			output, stored = res.Stderr, res.StderrStored
+		}
+		filename = fmt.Sprintf("record-%d-step-%d-%s.txt", r.ID, step, name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if !stored {
+		_, _ = io.WriteString(w, output)
+		return
+	}
+
+	ro, err := h.d.ReadRecordOutput(r.ID, step, stream)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		_ = encodeFailure(w)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept-Encoding")
+	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(ro.Data)
+		return
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(ro.Data))
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		_ = encodeFailure(w)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(ro.Size, 10))
+	_, _ = io.Copy(w, zr)
+}
+
 func (h *handler) deleteRecord(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
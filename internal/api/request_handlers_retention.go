@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// retentionTarget resolves organization and optional task a retention policy request refers to.
+// Task ID is zero for organization wide policy. If false is returned, a response has been written already.
+func (h *handler) retentionTarget(w http.ResponseWriter, req *http.Request) (*db.Organization, *db.Task, bool) {
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil, nil, false
+	}
+
+	taskID, found := vars[taskIDKey]
+	if !found {
+		return o, nil, true
+	}
+
+	t, err := h.d.ReadTask(sanitizeParameter(taskID))
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil, nil, false
+	}
+	if t.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return nil, nil, false
+	}
+
+	return o, t, true
+}
+
+func (h *handler) readRetentionPolicy(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	o, t, ok := h.retentionTarget(w, req)
+	if !ok {
+		return
+	}
+
+	var taskID uint
+	var taskName string
+	if t != nil {
+		taskID = t.ID
+		taskName = t.Name
+	}
+
+	p, err := h.d.ReadRetentionPolicy(o.ID, taskID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	policy := dbconverter.ConvertRetentionPolicy(p, taskName)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &policy,
+	})
+}
+
+// updateRetentionPolicy creates or replaces the policy
+func (h *handler) updateRetentionPolicy(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	o, t, ok := h.retentionTarget(w, req)
+	if !ok {
+		return
+	}
+
+	var reqPolicy types.RetentionPolicy
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqPolicy); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	if reqPolicy.MaxAge.Duration < 0 || reqPolicy.MaxCount < 0 || reqPolicy.KeepFailures < 0 {
+		_ = encodeInvalidRequestResponse(w, Error("limits must not be negative"))
+		return
+	}
+
+	var taskID uint
+	if t != nil {
+		taskID = t.ID
+	}
+
+	p, err := h.d.ReadRetentionPolicy(o.ID, taskID)
+	if err != nil {
+		p = &db.RetentionPolicy{
+			OrganizationID: o.ID,
+			TaskID:         taskID,
+		}
+	}
+	p.MaxAge = reqPolicy.MaxAge.Duration
+	p.MaxCount = reqPolicy.MaxCount
+	p.KeepFailures = reqPolicy.KeepFailures
+
+	if p.ID == 0 {
+		err = h.d.CreateRetentionPolicy(p)
+	} else {
+		err = h.d.UpdateRetentionPolicy(p)
+	}
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) deleteRetentionPolicy(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	o, t, ok := h.retentionTarget(w, req)
+	if !ok {
+		return
+	}
+
+	var taskID uint
+	if t != nil {
+		taskID = t.ID
+	}
+
+	if _, err := h.d.ReadRetentionPolicy(o.ID, taskID); err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.d.DeleteRetentionPolicy(o.ID, taskID); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
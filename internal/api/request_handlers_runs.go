@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	// limits size of a single upload of run output
+	maxRunOutputRequest = 1 << 20
+	// following output of a run ends before write timeout of the server,
+	// clients reconnect and continue from the last event they received
+	maxFollowDuration = 10 * time.Second
+	// how soon clients should reconnect, in milliseconds
+	followRetryInterval = 1000
+)
+
+// ownMachine reads the machine making the request, and checks it belongs to the organization in the path.
+// If nil is returned, a response has been written already.
+func (h *handler) ownMachine(w http.ResponseWriter, req *http.Request, self *types.Machine) *db.Machine {
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	m, err := h.d.ReadMachine(self.Name)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	return m
+}
+
+// organizationMachine reads the machine in the path, and checks it belongs to the organization in the path.
+// If nil is returned, a response has been written already.
+func (h *handler) organizationMachine(w http.ResponseWriter, req *http.Request) *db.Machine {
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return nil
+	}
+	return m
+}
+
+// startRun is called by a machine when it starts running a task, to get an ID for streaming its output
+func (h *handler) startRun(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
+
+	m := h.ownMachine(w, req, self)
+	if m == nil {
+		return
+	}
+
+	var reqRun types.Run
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqRun); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadTask(reqRun.TaskName)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if t.OrganizationID != m.OrganizationID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	run := h.runs.start(m.ID, types.Run{
+		MachineName: m.Name,
+		TaskName:    t.Name,
+		TriggerID:   reqRun.TriggerID,
+	})
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &run,
+	})
+}
+
+// addRunOutput relays output of a run in progress to users following it
+func (h *handler) addRunOutput(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
+
+	m := h.ownMachine(w, req, self)
+	if m == nil {
+		return
+	}
+	runID := sanitizeParameter(mux.Vars(req)[runIDKey])
+
+	var chunks []types.OutputChunk
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRunOutputRequest))
+	if err := dec.Decode(&chunks); err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	for _, c := range chunks {
+		if c.Stream != types.StreamStdout && c.Stream != types.StreamStderr {
+			_ = encodeInvalidRequestResponse(w, Error("invalid stream: "+sanitizeParameter(c.Stream)))
+			return
+		}
+	}
+
+	if !h.runs.appendOutput(m.ID, runID, chunks) {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// readRuns lists runs of a machine which are in progress
+func (h *handler) readRuns(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	m := h.organizationMachine(w, req)
+	if m == nil {
+		return
+	}
+
+	runs := h.runs.list(m.ID)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &runs,
+	})
+}
+
+// followRunOutput streams output of a run as server-sent events, starting from output still buffered.
+// Each output event has an ID, and a reconnecting client continues after the one given in Last-Event-ID header.
+// Once the record of the run has been received, an end event with its ID is sent.
+func (h *handler) followRunOutput(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	m := h.organizationMachine(w, req)
+	if m == nil {
+		return
+	}
+	runID := sanitizeParameter(mux.Vars(req)[runIDKey])
+
+	after := -1
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			_ = encodeBadRequestResponse(w)
+			return
+		}
+		after = n
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		_ = encodeFailure(w)
+		return
+	}
+
+	run, chunks, end, changed := h.runs.follow(m.ID, runID, after)
+	if run == nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// proxies must not buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", followRetryInterval)
+
+	deadline := time.NewTimer(maxFollowDuration)
+	defer deadline.Stop()
+
+	for {
+		for i := range chunks {
+			b, _ := json.Marshal(&chunks[i].OutputChunk)
+			fmt.Fprintf(w, "id: %d\nevent: output\ndata: %s\n\n", chunks[i].seq, b)
+			after = chunks[i].seq
+		}
+		if end != nil {
+			b, _ := json.Marshal(end)
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", b)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return
+		case <-req.Context().Done():
+			return
+		}
+
+		run, chunks, end, changed = h.runs.follow(m.ID, runID, after)
+		if run == nil {
+			// run was abandoned, reconnecting client will find out it is gone
+			return
+		}
+	}
+}
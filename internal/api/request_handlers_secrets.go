@@ -0,0 +1,293 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+
+	"github.com/LassiHeikkila/taskey/internal/auth"
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// secrets are exposed to tasks as environment variables, so names must be valid as such
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// secretBinding ties encrypted value to the secret it belongs to
+func secretBinding(organizationID uint, name string) string {
+	return fmt.Sprintf("%d/%s", organizationID, name)
+}
+
+func (h *handler) createSecret(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	var reqSecret types.Secret
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqSecret); err != nil || !secretNamePattern.MatchString(reqSecret.Name) || reqSecret.Value == "" {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	if _, err := h.d.ReadSecret(o.ID, reqSecret.Name); err == nil {
+		_ = encodeConflictResponse(w)
+		return
+	}
+
+	value, err := auth.EncryptSecret(h.secretKey, []byte(reqSecret.Value), secretBinding(o.ID, reqSecret.Name))
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	secret := db.Secret{
+		Name:           reqSecret.Name,
+		OrganizationID: o.ID,
+		Value:          value,
+	}
+	if err := h.d.CreateSecret(&secret); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	returnedSecret := dbconverter.ConvertSecret(&secret)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &returnedSecret,
+	})
+}
+
+func (h *handler) readSecrets(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	s, err := h.d.ReadSecrets(o.ID)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	secrets := make([]types.Secret, 0, len(s))
+	for i := range s {
+		secrets = append(secrets, dbconverter.ConvertSecret(&s[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &secrets,
+	})
+}
+
+func (h *handler) updateSecret(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	secretID := sanitizeParameter(vars[secretIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	secret, err := h.d.ReadSecret(o.ID, secretID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	var reqSecret types.Secret
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqSecret); err != nil || reqSecret.Value == "" {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	// secrets can't be renamed, as tasks reference them by name
+	value, err := auth.EncryptSecret(h.secretKey, []byte(reqSecret.Value), secretBinding(o.ID, secret.Name))
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	secret.Value = value
+
+	if err := h.d.UpdateSecret(secret); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) deleteSecret(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	secretID := sanitizeParameter(vars[secretIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if _, err := h.d.ReadSecret(o.ID, secretID); err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.d.DeleteSecret(o.ID, secretID); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+func (h *handler) readMachineTaskSecrets(w http.ResponseWriter, req *http.Request, self *types.Machine) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	taskID := sanitizeParameter(vars[taskIDKey])
+
+	m, err := h.d.ReadMachine(self.Name)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	t, err := h.d.ReadTask(taskID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if t.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	// secrets are only given out for tasks the machine is about to run
+	runs, err := h.machineRunsTask(m, t)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+	if !runs {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	task := dbconverter.ConvertTask(t)
+	names := taskSecretNames(task.Content)
+
+	secrets := make([]types.Secret, 0, len(names))
+	for _, name := range names {
+		s, err := h.d.ReadSecret(o.ID, name)
+		if err != nil {
+			_ = encodeNotFoundResponse(w)
+			return
+		}
+		value, err := auth.DecryptSecret(h.secretKey, s.Value, secretBinding(o.ID, s.Name))
+		if err != nil {
+			_ = encodeFailure(w)
+			return
+		}
+
+		secret := dbconverter.ConvertSecret(s)
+		secret.Value = string(value)
+		secrets = append(secrets, secret)
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &secrets,
+	})
+}
+
+// machineRunsTask tells if task t is in the schedule of machine m, or triggered on it and not yet reported
+func (h *handler) machineRunsTask(m *db.Machine, t *db.Task) (bool, error) {
+	schedule, _, err := h.machineSchedule(m)
+	if err != nil {
+		return false, err
+	}
+	if schedule.Includes(t.Name) {
+		return true, nil
+	}
+
+	for _, state := range []string{types.TriggerStatePending, types.TriggerStateRunning} {
+		triggers, err := h.d.ReadTriggers(m.Name, state)
+		if err != nil {
+			return false, err
+		}
+		for i := range triggers {
+			if triggers[i].TaskID == t.ID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// taskSecretNames lists secrets referenced by task content, including actions of multi-action tasks
+func taskSecretNames(content interface{}) []string {
+	c, ok := content.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	add := func(c map[string]interface{}) {
+		refs, _ := c["secrets"].([]interface{})
+		for _, ref := range refs {
+			name, ok := ref.(string)
+			if !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	add(c)
+	actions, _ := c["actions"].([]interface{})
+	for _, action := range actions {
+		if a, ok := action.(map[string]interface{}); ok {
+			add(a)
+		}
+	}
+
+	return names
+}
@@ -29,6 +29,10 @@ func (h *handler) createTask(w http.ResponseWriter, req *http.Request) {
 		_ = encodeBadRequestResponse(w)
 		return
 	}
+	if err := validateTaskContent(reqTask.Content); err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
 
 	task := dbconverter.ConvertTaskToDB(&reqTask)
 	task.OrganizationID = o.ID
@@ -131,6 +135,10 @@ func (h *handler) updateTask(w http.ResponseWriter, req *http.Request) {
 		_ = encodeBadRequestResponse(w)
 		return
 	}
+	if err := validateTaskContent(reqTask.Content); err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
 
 	t.Name = reqTask.Name
 	t.Description = reqTask.Description
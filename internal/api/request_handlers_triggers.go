@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func (h *handler) createMachineTrigger(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	var reqTrigger types.Trigger
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqTrigger); err != nil || reqTrigger.TaskName == "" {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadTask(reqTrigger.TaskName)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if t.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	trigger := db.Trigger{
+		MachineID: m.ID,
+		Machine:   *m,
+		TaskID:    t.ID,
+		Task:      *t,
+		State:     types.TriggerStatePending,
+	}
+	if err := h.d.CreateTrigger(&trigger); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	returnedTrigger := dbconverter.ConvertTrigger(&trigger)
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &returnedTrigger,
+	})
+}
+
+// createMachineGroupTrigger queues the task on every machine in the group.
+// Triggers are created together, so that the task is queued on either all members or none.
+func (h *handler) createMachineGroupTrigger(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	g := h.organizationGroup(w, req)
+	if g == nil {
+		return
+	}
+
+	var reqTrigger types.Trigger
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(&reqTrigger); err != nil || reqTrigger.TaskName == "" {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+	if len(g.Machines) == 0 {
+		_ = encodeInvalidRequestResponse(w, Error("group has no machines to run the task on"))
+		return
+	}
+
+	t, err := h.d.ReadTask(reqTrigger.TaskName)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if t.OrganizationID != g.OrganizationID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	triggers := make([]db.Trigger, 0, len(g.Machines))
+	for i := range g.Machines {
+		triggers = append(triggers, db.Trigger{
+			MachineID: g.Machines[i].ID,
+			Machine:   g.Machines[i],
+			TaskID:    t.ID,
+			Task:      *t,
+			State:     types.TriggerStatePending,
+		})
+	}
+	if err := h.d.CreateTriggers(triggers); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	returnedTriggers := make([]types.Trigger, 0, len(triggers))
+	for i := range triggers {
+		returnedTriggers = append(returnedTriggers, dbconverter.ConvertTrigger(&triggers[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: returnedTriggers,
+	})
+}
+
+func (h *handler) readMachineTrigger(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	machineID := sanitizeParameter(vars[machineIDKey])
+	triggerID := sanitizeParameter(vars[triggerIDKey])
+
+	tid, err := strconv.ParseUint(triggerID, 10, 64)
+	if err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	m, err := h.d.ReadMachine(machineID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if m.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadTrigger(uint(tid))
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if t.MachineID != m.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	trigger := dbconverter.ConvertTrigger(t)
+
+	if t.State == types.TriggerStateDone && t.RecordID != 0 {
+		r := db.Record{}
+		if err := h.d.LoadModel(&r, t.RecordID); err == nil {
+			// record was loaded without its associations, so fill them in from the trigger
+			r.Machine = t.Machine
+			r.Task = t.Task
+			record := dbconverter.ConvertRecord(&r)
+			trigger.Record = &record
+		}
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &trigger,
+	})
+}
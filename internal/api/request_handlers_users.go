@@ -3,10 +3,11 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gorilla/mux"
 
+	"github.com/LassiHeikkila/taskey/internal/auth"
 	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/internal/db/dbconverter"
 	"github.com/LassiHeikkila/taskey/pkg/types"
@@ -24,14 +25,20 @@ func (h *handler) createUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var reqUser types.User
+	var reqUser NewUserRequest
 	dec := json.NewDecoder(req.Body)
 	if err := dec.Decode(&reqUser); err != nil {
 		_ = encodeBadRequestResponse(w)
 		return
 	}
+	if reqUser.Password != "" {
+		if err := validatePassword(reqUser.Password); err != nil {
+			_ = encodeInvalidRequestResponse(w, err)
+			return
+		}
+	}
 
-	user := dbconverter.ConvertUserToDB(&reqUser)
+	user := dbconverter.ConvertUserToDB(&reqUser.User)
 	user.OrganizationID = o.ID
 
 	if err := h.d.CreateUser(&user); err != nil {
@@ -39,7 +46,36 @@ func (h *handler) createUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	_ = encodeSuccess(w)
+	// user must set their own password before logging in
+	li := db.LoginInfo{
+		Username:           user.Name,
+		UserID:             user.ID,
+		MustChangePassword: true,
+	}
+	if reqUser.Password != "" {
+		li.Password = auth.HashPassword(reqUser.Password)
+	}
+	if err := h.d.CreateLoginInfo(&li); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	if reqUser.Password != "" {
+		_ = encodeSuccess(w)
+		return
+	}
+
+	reset, err := h.newPasswordReset(&li, newUserPasswordResetValidity)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: reset,
+	})
 }
 
 func (h *handler) readUser(w http.ResponseWriter, req *http.Request) {
@@ -198,18 +234,33 @@ func (h *handler) createUserToken(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	tr, expiration, err := decodeTokenRequest(req)
+	if err != nil {
+		_ = encodeInvalidRequestResponse(w, err)
+		return
+	}
+	machines := make([]db.Machine, 0, len(tr.Machines))
+	for _, name := range tr.Machines {
+		m, err := h.d.ReadMachine(sanitizeParameter(name))
+		if err != nil || m.OrganizationID != o.ID {
+			_ = encodeInvalidRequestResponse(w, Error("unknown machine: "+name))
+			return
+		}
+		machines = append(machines, *m)
+	}
+
 	genUUID, err := h.a.GenerateUUID()
 	if err != nil {
 		_ = encodeFailure(w)
 		return
 	}
 
-	// TODO: add option to define token expiration via a body
-	expiration := time.Time{} // zero time means no expiry
-
 	ut := db.UserToken{
 		Value:      db.StringToUUID(genUUID),
+		Label:      tr.Label,
 		Expiration: expiration,
+		Scopes:     types.FormatScopes(tr.Scopes),
+		Machines:   machines,
 		UserID:     u.ID,
 		User:       *u,
 	}
@@ -228,8 +279,158 @@ func (h *handler) createUserToken(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func (*handler) deleteUserToken(w http.ResponseWriter, req *http.Request) {
-	// TODO: implement
+// createPasswordReset gives out a token, with which the user can set a new password without knowing the current one.
+// Current password keeps working until the token is used.
+func (h *handler) createPasswordReset(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	userID := sanitizeParameter(vars[userIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	u, err := h.d.ReadUser(userID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if u.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	li, err := h.d.ReadLoginInfo(u.Name)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	reset, err := h.newPasswordReset(li, passwordResetValidity)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: reset,
+	})
+}
+
+// revokeUserTokens ends every session of the user.
+// Access tokens issued so far are refused, and refresh tokens are deleted.
+func (h *handler) revokeUserTokens(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	userID := sanitizeParameter(vars[userIDKey])
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	u, err := h.d.ReadUser(userID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if u.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	li, err := h.d.ReadLoginInfo(u.Name)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.endSessions(li.ID, u.Name); err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
+}
+
+// readUserTokens lists tokens of the user without their values, which are only returned when a token is created
+func (h *handler) readUserTokens(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	userID := sanitizeParameter(vars[userIDKey])
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	u, err := h.d.ReadUser(userID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if u.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	t, err := h.d.ReadUserTokens(u.ID)
+	if err != nil {
+		_ = encodeFailure(w)
+		return
+	}
+
+	tokens := make([]types.TokenInfo, 0, len(t))
+	for i := range t {
+		tokens = append(tokens, dbconverter.ConvertUserTokenInfo(&t[i]))
+	}
+
+	_ = encodeResponse(w, Response{
+		Code:    http.StatusOK,
+		Message: "ok",
+		Payload: &tokens,
+	})
+}
+
+func (h *handler) deleteUserToken(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	_ = encodeUnimplementedResponse(w)
+
+	vars := mux.Vars(req)
+	orgID := sanitizeParameter(vars[orgIDKey])
+	userID := sanitizeParameter(vars[userIDKey])
+	tokenID := sanitizeParameter(vars[tokenIDKey])
+
+	id, err := strconv.ParseUint(tokenID, 10, 64)
+	if err != nil {
+		_ = encodeBadRequestResponse(w)
+		return
+	}
+
+	o, err := h.d.ReadOrganization(orgID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	u, err := h.d.ReadUser(userID)
+	if err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+	if u.OrganizationID != o.ID {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	if err := h.d.DeleteUserToken(u.ID, uint(id)); err != nil {
+		_ = encodeNotFoundResponse(w)
+		return
+	}
+
+	_ = encodeSuccess(w)
 }
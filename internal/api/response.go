@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 )
@@ -9,6 +11,8 @@ type Response struct {
 	Code    int         `json:"code"`
 	Message string      `json:"msg"`
 	Payload interface{} `json:"payload,omitempty"`
+	// NextCursor is set on paginated responses when there are more results
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 func encodeResponse(w http.ResponseWriter, r Response) error {
@@ -23,6 +27,26 @@ func encodeResponse(w http.ResponseWriter, r Response) error {
 	return enc.Encode(&r)
 }
 
+// encodeResponseWithETag works like encodeResponse, but also tags the response with an ETag
+// computed from its content. If the client already has the same version, 304 is returned without a body.
+func encodeResponseWithETag(w http.ResponseWriter, req *http.Request, r Response) error {
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return encodeResponse(w, r)
+}
+
 func encodeSuccess(w http.ResponseWriter) error {
 	return encodeResponse(w, Response{Code: http.StatusOK, Message: "ok"})
 }
@@ -47,6 +71,15 @@ func encodeBadRequestResponse(w http.ResponseWriter) error {
 	return encodeResponse(w, Response{Code: http.StatusBadRequest, Message: "bad request"})
 }
 
+// encodeInvalidRequestResponse is like encodeBadRequestResponse, but tells the client what was wrong
+func encodeInvalidRequestResponse(w http.ResponseWriter, err error) error {
+	return encodeResponse(w, Response{Code: http.StatusBadRequest, Message: "bad request: " + err.Error()})
+}
+
+func encodeConflictResponse(w http.ResponseWriter) error {
+	return encodeResponse(w, Response{Code: http.StatusConflict, Message: "conflict"})
+}
+
 func encodeUnimplementedResponse(w http.ResponseWriter) error {
 	return encodeResponse(w, Response{Code: http.StatusNotImplemented, Message: "not implemented yet"})
 }
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/internal/auth"
+	"github.com/LassiHeikkila/taskey/internal/db"
+)
+
+// revocationCache keeps token revocations in memory, so that access tokens can be checked without hitting the database.
+// Revocations are stored in the database as well, and loaded from there at startup.
+type revocationCache struct {
+	mu sync.RWMutex
+
+	// ID of revoked token -> when it expires
+	tokens map[string]time.Time
+	// username -> tokens of the user issued before this are revoked
+	users map[string]userRevocation
+}
+
+type userRevocation struct {
+	issuedBefore time.Time
+	expiration   time.Time
+}
+
+func newRevocationCache() *revocationCache {
+	return &revocationCache{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+	}
+}
+
+// add caches revocation, and forgets revocations of tokens which have expired by now
+func (c *revocationCache) add(r *db.TokenRevocation, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, expiration := range c.tokens {
+		if !expiration.After(now) {
+			delete(c.tokens, id)
+		}
+	}
+	for user, ur := range c.users {
+		if !ur.expiration.After(now) {
+			delete(c.users, user)
+		}
+	}
+	if !r.Expiration.After(now) {
+		return
+	}
+
+	if r.TokenID != "" {
+		c.tokens[r.TokenID] = r.Expiration
+		return
+	}
+	ur := c.users[r.Username]
+	if r.IssuedBefore.After(ur.issuedBefore) {
+		ur.issuedBefore = r.IssuedBefore
+	}
+	if r.Expiration.After(ur.expiration) {
+		ur.expiration = r.Expiration
+	}
+	c.users[r.Username] = ur
+}
+
+// revoked tells if token with given claims has been revoked
+func (c *revocationCache) revoked(claims *auth.UserClaims) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.tokens[claims.Id]; ok {
+		return true
+	}
+	if ur, ok := c.users[claims.User]; ok {
+		// iat only has second precision, so a token issued within the same second is revoked as well
+		return !time.Unix(claims.IssuedAt, 0).After(ur.issuedBefore)
+	}
+	return false
+}
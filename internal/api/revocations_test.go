@@ -0,0 +1,57 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/LassiHeikkila/taskey/internal/auth"
+	"github.com/LassiHeikkila/taskey/internal/db"
+)
+
+func TestRevocationCache(t *testing.T) {
+	c := newRevocationCache()
+	now := time.Now()
+
+	claims := func(id string, user string, issuedAt time.Time) *auth.UserClaims {
+		return &auth.UserClaims{
+			User: user,
+			StandardClaims: jwt.StandardClaims{
+				Id:        id,
+				IssuedAt:  issuedAt.Unix(),
+				ExpiresAt: issuedAt.Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	if c.revoked(claims("a", "alice", now)) {
+		t.Fatal("token revoked by empty cache")
+	}
+
+	c.add(&db.TokenRevocation{TokenID: "a", Username: "alice", Expiration: now.Add(time.Hour)}, now)
+	if !c.revoked(claims("a", "alice", now)) {
+		t.Fatal("revoked token accepted")
+	}
+	if c.revoked(claims("b", "alice", now)) {
+		t.Fatal("other token of the user revoked")
+	}
+
+	// every token of bob issued until now
+	c.add(&db.TokenRevocation{Username: "bob", IssuedBefore: now, Expiration: now.Add(time.Hour)}, now)
+	if !c.revoked(claims("c", "bob", now.Add(-time.Minute))) || !c.revoked(claims("d", "bob", now)) {
+		t.Fatal("token issued before revocation accepted")
+	}
+	if c.revoked(claims("e", "bob", now.Add(time.Second))) {
+		t.Fatal("token issued after revocation refused")
+	}
+
+	// revocations are forgotten once the tokens have expired
+	c.add(&db.TokenRevocation{TokenID: "f", Username: "carol", Expiration: now.Add(2 * time.Hour)}, now.Add(time.Hour))
+	if c.revoked(claims("a", "alice", now)) || c.revoked(claims("c", "bob", now.Add(-time.Minute))) {
+		t.Fatal("expired revocations not forgotten")
+	}
+	if !c.revoked(claims("f", "carol", now)) {
+		t.Fatal("revoked token accepted")
+	}
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
 /*
@@ -11,57 +13,92 @@ import (
    ${base}/api/v1.0/${org}/machines -> machine management
    ${base}/api/v1.0/${org}/machines/${machine}/schedule -> control machine schedule
    ${base}/api/v1.0/${org}/machines/${machine}/records/ -> get and post machine records
+   ${base}/api/v1.0/${org}/machines/${machine}/runs/ -> follow output of runs in progress
+   ${base}/api/v1.0/${org}/machines/${machine}/trigger/ -> run a task immediately on machine
+   ${base}/api/v1.0/${org}/groups/${group}/ -> manage machine groups and their schedules
+
+   Routes for users are given the role they require, and the scope a scoped user token must have.
+   Scoped tokens can't be used for routes requiring scopeUnrestricted.
 
 */
 
 func (h *handler) setOrgRoutesV1() {
 	// read organization
-	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresAdmin(h.readOrganization)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresAdmin(types.ScopeOrganizationRead, h.readOrganization)).Methods(http.MethodGet)
 	// update organization
-	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresAdmin(h.updateOrganization)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresAdmin(scopeUnrestricted, h.updateOrganization)).Methods(http.MethodPut)
 	// delete organization
-	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresRoot(h.deleteOrganization)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/organizations/{organization_id}/", h.requiresRoot(scopeUnrestricted, h.deleteOrganization)).Methods(http.MethodDelete)
 }
 
 func (h *handler) setUserRoutesV1() {
 	// create user
-	h.router.Handle("/api/v1/{organization_id}/users/", h.requiresAdmin(h.createUser)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/users/", h.requiresAdmin(scopeUnrestricted, h.createUser)).Methods(http.MethodPost)
 	// read users
-	h.router.Handle("/api/v1/{organization_id}/users/", h.requiresAdmin(h.readUsers)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/users/", h.requiresAdmin(types.ScopeUsersRead, h.readUsers)).Methods(http.MethodGet)
 	// read user
-	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresUser(h.readUser)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresUser(types.ScopeUsersRead, h.readUser)).Methods(http.MethodGet)
 	// update user
-	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresAdmin(h.updateUser)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresAdmin(scopeUnrestricted, h.updateUser)).Methods(http.MethodPut)
 	// delete user
-	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresAdmin(h.deleteUser)).Methods(http.MethodDelete)
-	// create token
-	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/tokens/", h.requiresAdmin(h.createUserToken)).Methods(http.MethodPost)
-	// delete / revoke token
-	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/tokens/{token}/", h.requiresAdmin(h.deleteUserToken)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/", h.requiresAdmin(scopeUnrestricted, h.deleteUser)).Methods(http.MethodDelete)
+	// create, list and delete / revoke tokens
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/tokens/", h.requiresAdmin(scopeUnrestricted, h.createUserToken)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/tokens/", h.requiresAdmin(scopeUnrestricted, h.readUserTokens)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/tokens/{token_id}/", h.requiresAdmin(scopeUnrestricted, h.deleteUserToken)).Methods(http.MethodDelete)
+	// let user set a new password without knowing the current one
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/passwordreset/", h.requiresAdmin(scopeUnrestricted, h.createPasswordReset)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/users/{user_id}/revoke/", h.requiresAdmin(scopeUnrestricted, h.revokeUserTokens)).Methods(http.MethodPost)
 }
 
 func (h *handler) setMachineRoutesV1() {
 	// create, read, update and delete machine(s)
-	h.router.Handle("/api/v1/{organization_id}/machines/", h.requiresAdmin(h.createMachine)).Methods(http.MethodPost)
-	h.router.Handle("/api/v1/{organization_id}/machines/", h.requiresAdmin(h.readMachines)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(h.readMachine)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(h.updateMachine)).Methods(http.MethodPut)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(h.deleteMachine)).Methods(http.MethodDelete)
-
-	// create token
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/tokens/", h.requiresAdmin(h.createMachineToken)).Methods(http.MethodPost)
-	// delete token
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/tokens/{token}/", h.requiresAdmin(h.deleteMachineToken)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/machines/", h.requiresAdmin(types.ScopeMachinesWrite, h.createMachine)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/", h.requiresAdmin(types.ScopeMachinesRead, h.readMachines)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(types.ScopeMachinesRead, h.readMachine)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(types.ScopeMachinesWrite, h.updateMachine)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/", h.requiresAdmin(types.ScopeMachinesWrite, h.deleteMachine)).Methods(http.MethodDelete)
+
+	// create, list and delete tokens
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/tokens/", h.requiresAdmin(scopeUnrestricted, h.createMachineToken)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/tokens/", h.requiresAdmin(scopeUnrestricted, h.readMachineTokens)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/tokens/{token_id}/", h.requiresAdmin(scopeUnrestricted, h.deleteMachineToken)).Methods(http.MethodDelete)
+
+	// enrollment tokens let machines register themselves, values are only returned when created
+	h.router.Handle("/api/v1/{organization_id}/enrollment-tokens/", h.requiresAdmin(scopeUnrestricted, h.createEnrollmentToken)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/enrollment-tokens/", h.requiresAdmin(scopeUnrestricted, h.readEnrollmentTokens)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/enrollment-tokens/{enrollment_token_id}/", h.requiresAdmin(scopeUnrestricted, h.deleteEnrollmentToken)).Methods(http.MethodDelete)
+	// machine registers itself using enrollment token, and receives its own token in return
+	h.router.HandleFunc("/api/v1/{organization_id}/machines/enroll/", h.enrollMachine).Methods(http.MethodPost)
+
+	// machine reports periodically that it is running, which is shown as its status
+	h.router.Handle("/api/v1/{organization_id}/machines/self/heartbeat/", h.requiresMachine(h.machineHeartbeat)).Methods(http.MethodPost)
+}
+
+func (h *handler) setGroupRoutesV1() {
+	// create, read, update and delete machine groups
+	h.router.Handle("/api/v1/{organization_id}/groups/", h.requiresAdmin(types.ScopeGroupsWrite, h.createMachineGroup)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/groups/", h.requiresUser(types.ScopeGroupsRead, h.readMachineGroups)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/", h.requiresUser(types.ScopeGroupsRead, h.readMachineGroup)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/", h.requiresAdmin(types.ScopeGroupsWrite, h.updateMachineGroup)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/", h.requiresAdmin(types.ScopeGroupsWrite, h.deleteMachineGroup)).Methods(http.MethodDelete)
+	// add and remove members
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/machines/{machine_id}/", h.requiresAdmin(types.ScopeGroupsWrite, h.addMachineGroupMember)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/machines/{machine_id}/", h.requiresAdmin(types.ScopeGroupsWrite, h.removeMachineGroupMember)).Methods(http.MethodDelete)
+	// schedule of the group is run by all of its members in addition to their own
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/schedule/", h.requiresUser(types.ScopeSchedulesRead, h.readMachineGroupSchedule)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/schedule/", h.requiresMaintainer(types.ScopeSchedulesWrite, h.updateMachineGroupSchedule)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/schedule/", h.requiresMaintainer(types.ScopeSchedulesWrite, h.deleteMachineGroupSchedule)).Methods(http.MethodDelete)
 }
 
 func (h *handler) setScheduleRoutesV1() {
 	// create, read, update or delete machine schedule
 	h.router.Handle("/api/v1/{organization_id}/machines/self/schedule/", h.requiresMachine(h.readMachineOwnSchedule)).Methods(http.MethodGet)
 
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(h.createMachineSchedule)).Methods(http.MethodPost)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresUser(h.readMachineSchedule)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(h.updateMachineSchedule)).Methods(http.MethodPut)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(h.deleteMachineSchedule)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(types.ScopeSchedulesWrite, h.createMachineSchedule)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresUser(types.ScopeSchedulesRead, h.readMachineSchedule)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(types.ScopeSchedulesWrite, h.updateMachineSchedule)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/schedule/", h.requiresMaintainer(types.ScopeSchedulesWrite, h.deleteMachineSchedule)).Methods(http.MethodDelete)
 
 }
 
@@ -69,23 +106,61 @@ func (h *handler) setRecordRoutesV1() {
 	// create and read records
 	// only machines are allowed to create records
 	h.router.Handle("/api/v1/{organization_id}/machines/self/records/", h.requiresMachine(h.addRecord)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/self/records/batch/", h.requiresMachine(h.addRecords)).Methods(http.MethodPost)
 
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/", h.requiresUser(h.readRecords)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/", h.requiresUser(types.ScopeRecordsRead, h.readRecords)).Methods(http.MethodGet)
 
 	// records are immutable so modifying them via PUT is not allowed
 
 	// get and delete a particular record
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/{record_id}/", h.requiresUser(h.readRecord)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/{record_id}/", h.requiresAdmin(h.deleteRecord)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/{record_id}/", h.requiresUser(types.ScopeRecordsRead, h.readRecord)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/{record_id}/", h.requiresAdmin(types.ScopeRecordsWrite, h.deleteRecord)).Methods(http.MethodDelete)
+	// full output of a record, which may be too large to include in the record
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/records/{record_id}/output/", h.requiresUser(types.ScopeRecordsRead, h.readRecordOutput)).Methods(http.MethodGet)
+
+	// machine streams output of runs in progress, and users can follow it live.
+	// run ends once its record is created
+	h.router.Handle("/api/v1/{organization_id}/machines/self/runs/", h.requiresMachine(h.startRun)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/self/runs/{run_id}/output/", h.requiresMachine(h.addRunOutput)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/runs/", h.requiresUser(types.ScopeRecordsRead, h.readRuns)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/runs/{run_id}/output/", h.requiresUser(types.ScopeRecordsRead, h.followRunOutput)).Methods(http.MethodGet)
+
+	// retention policies, for the whole organization or for a single task
+	h.router.Handle("/api/v1/{organization_id}/retention/", h.requiresUser(types.ScopeRecordsRead, h.readRetentionPolicy)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/retention/", h.requiresAdmin(types.ScopeRecordsWrite, h.updateRetentionPolicy)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/retention/", h.requiresAdmin(types.ScopeRecordsWrite, h.deleteRetentionPolicy)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/retention/", h.requiresUser(types.ScopeRecordsRead, h.readRetentionPolicy)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/retention/", h.requiresAdmin(types.ScopeRecordsWrite, h.updateRetentionPolicy)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/retention/", h.requiresAdmin(types.ScopeRecordsWrite, h.deleteRetentionPolicy)).Methods(http.MethodDelete)
+}
+
+func (h *handler) setTriggerRoutesV1() {
+	// queue a task to be run immediately, and poll for its result
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/trigger/", h.requiresMaintainer(types.ScopeTriggersWrite, h.createMachineTrigger)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/machines/{machine_id}/trigger/{trigger_id}/", h.requiresUser(types.ScopeTriggersRead, h.readMachineTrigger)).Methods(http.MethodGet)
+	// queue a task to be run immediately on every member of a group, each member gets its own trigger to poll
+	h.router.Handle("/api/v1/{organization_id}/groups/{group_id}/trigger/", h.requiresMaintainer(types.ScopeTriggersWrite, h.createMachineGroupTrigger)).Methods(http.MethodPost)
+	// machine picks up queued runs, results are reported via records with trigger ID set
+	h.router.Handle("/api/v1/{organization_id}/machines/self/triggers/", h.requiresMachine(h.readMachineOwnTriggers)).Methods(http.MethodGet)
+}
+
+func (h *handler) setSecretRoutesV1() {
+	// create, list, update and delete secrets. values are never returned to users
+	h.router.Handle("/api/v1/{organization_id}/secrets/", h.requiresMaintainer(types.ScopeSecretsWrite, h.createSecret)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/secrets/", h.requiresMaintainer(types.ScopeSecretsRead, h.readSecrets)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/secrets/{secret_id}/", h.requiresMaintainer(types.ScopeSecretsWrite, h.updateSecret)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/secrets/{secret_id}/", h.requiresMaintainer(types.ScopeSecretsWrite, h.deleteSecret)).Methods(http.MethodDelete)
+	// machine fetches values of secrets referenced by a task right before running it
+	h.router.Handle("/api/v1/{organization_id}/machines/self/tasks/{task_id}/secrets/", h.requiresMachine(h.readMachineTaskSecrets)).Methods(http.MethodGet)
 }
 
 func (h *handler) setTaskRoutesV1() {
 	// create, read, update and delete tasks
-	h.router.Handle("/api/v1/{organization_id}/tasks/", h.requiresMaintainer(h.createTask)).Methods(http.MethodPost)
-	h.router.Handle("/api/v1/{organization_id}/tasks/", h.requiresUser(h.readTasks)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresUser(h.readTask)).Methods(http.MethodGet)
-	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresMaintainer(h.updateTask)).Methods(http.MethodPut)
-	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresMaintainer(h.deleteTask)).Methods(http.MethodDelete)
+	h.router.Handle("/api/v1/{organization_id}/tasks/", h.requiresMaintainer(types.ScopeTasksWrite, h.createTask)).Methods(http.MethodPost)
+	h.router.Handle("/api/v1/{organization_id}/tasks/", h.requiresUser(types.ScopeTasksRead, h.readTasks)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresUser(types.ScopeTasksRead, h.readTask)).Methods(http.MethodGet)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresMaintainer(types.ScopeTasksWrite, h.updateTask)).Methods(http.MethodPut)
+	h.router.Handle("/api/v1/{organization_id}/tasks/{task_id}/", h.requiresMaintainer(types.ScopeTasksWrite, h.deleteTask)).Methods(http.MethodDelete)
 	// machine can fetch task definitions mentioned in its own schedule
 	h.router.Handle("/api/v1/{organization_id}/machines/self/tasks/", h.requiresMachine(h.readMachineTasks)).Methods(http.MethodGet)
 }
@@ -95,10 +170,18 @@ func (h *handler) setAuthRoutesV1() {
 	h.router.HandleFunc("/api/v1/auth/", h.loginHandler).Methods(http.MethodPost)
 	// check if JWT is OK
 	h.router.HandleFunc("/api/v1/auth/", h.loginChecker).Methods(http.MethodGet)
+	// exchange refresh token for new JWT and refresh token
+	h.router.HandleFunc("/api/v1/auth/refresh/", h.refreshHandler).Methods(http.MethodPost)
+	// revoke JWT and refresh token
+	h.router.HandleFunc("/api/v1/auth/logout/", h.logoutHandler).Methods(http.MethodPost)
+	// public keys for verifying JWTs
+	h.router.HandleFunc("/.well-known/jwks.json", h.jwksHandler).Methods(http.MethodGet)
 	// check if machine token is OK
 	h.router.Handle("/api/v1/{organization_id}/machines/self/auth/", h.requiresMachine(h.checkMachineToken)).Methods(http.MethodGet)
 	// change password
 	h.router.HandleFunc("/api/v1/auth/{username}/changepassword/", h.passwordChangeHandler).Methods(http.MethodPost)
+	// set password with reset token
+	h.router.HandleFunc("/api/v1/auth/{username}/resetpassword/", h.passwordResetHandler).Methods(http.MethodPost)
 }
 
 func (h *handler) setSignUpRoutesV1() {
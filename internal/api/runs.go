@@ -0,0 +1,169 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const (
+	// how much recent output of a run is kept for clients which start following it late, or reconnect
+	maxRunOutputBuffer = 256 << 10
+	// runs which haven't received output or ended in this time are assumed lost with their machine
+	runIdleTimeout = 15 * time.Minute
+	// ended runs are kept for a while, so that clients following them see the end
+	runLinger = time.Minute
+)
+
+// runChunk is output of a run numbered in order, so that clients can continue where they left off
+type runChunk struct {
+	seq int
+	types.OutputChunk
+}
+
+type liveRun struct {
+	run       types.Run
+	machineID uint
+
+	chunks  []runChunk
+	size    int
+	nextSeq int
+
+	lastActivity time.Time
+	end          *types.RunEnd
+
+	// closed and replaced whenever something happens, to wake up followers
+	changed chan struct{}
+}
+
+func (r *liveRun) notify(now time.Time) {
+	r.lastActivity = now
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// runHub keeps runs in progress in memory.
+// Output is only relayed to users following the run, the record of the run is what is stored.
+type runHub struct {
+	mu   sync.Mutex
+	runs map[string]*liveRun
+	now  func() time.Time
+}
+
+func newRunHub() *runHub {
+	return &runHub{
+		runs: make(map[string]*liveRun),
+		now:  time.Now,
+	}
+}
+
+// start registers a new run of the machine, and returns it with ID assigned
+func (hub *runHub) start(machineID uint, run types.Run) types.Run {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.expire()
+
+	run.ID = uuid.NewString()
+	run.StartedAt = hub.now()
+	hub.runs[run.ID] = &liveRun{
+		run:          run,
+		machineID:    machineID,
+		lastActivity: run.StartedAt,
+		changed:      make(chan struct{}),
+	}
+	return run
+}
+
+// list returns runs of the machine which have not ended
+func (hub *runHub) list(machineID uint) []types.Run {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.expire()
+
+	runs := make([]types.Run, 0)
+	for _, r := range hub.runs {
+		if r.machineID == machineID && r.end == nil {
+			runs = append(runs, r.run)
+		}
+	}
+	return runs
+}
+
+// appendOutput adds output to a run of the machine. False is returned if there is no such run in progress.
+func (hub *runHub) appendOutput(machineID uint, runID string, chunks []types.OutputChunk) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	r, found := hub.runs[runID]
+	if !found || r.machineID != machineID || r.end != nil {
+		return false
+	}
+
+	for _, c := range chunks {
+		if c.Data == "" {
+			continue
+		}
+		r.chunks = append(r.chunks, runChunk{seq: r.nextSeq, OutputChunk: c})
+		r.nextSeq++
+		r.size += len(c.Data)
+	}
+	// oldest output is dropped first, but the latest chunk is kept even if it is too large alone
+	for r.size > maxRunOutputBuffer && len(r.chunks) > 1 {
+		r.size -= len(r.chunks[0].Data)
+		r.chunks = r.chunks[1:]
+	}
+
+	r.notify(hub.now())
+	return true
+}
+
+// finish ends a run of the machine once its record has been created
+func (hub *runHub) finish(machineID uint, runID string, end types.RunEnd) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	r, found := hub.runs[runID]
+	if !found || r.machineID != machineID || r.end != nil {
+		return
+	}
+	r.end = &end
+	r.notify(hub.now())
+}
+
+// follow returns run of the machine, output after chunk seq, and whether the run has ended.
+// Returned channel is closed when there is more to read. Nil run is returned if there is no such run.
+func (hub *runHub) follow(machineID uint, runID string, after int) (*types.Run, []runChunk, *types.RunEnd, <-chan struct{}) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	r, found := hub.runs[runID]
+	if !found || r.machineID != machineID {
+		return nil, nil, nil, nil
+	}
+
+	var chunks []runChunk
+	for i := range r.chunks {
+		if r.chunks[i].seq > after {
+			chunks = r.chunks[i:]
+			break
+		}
+	}
+	run := r.run
+	return &run, append([]runChunk(nil), chunks...), r.end, r.changed
+}
+
+// expire removes runs which have ended a while ago, or which seem abandoned
+func (hub *runHub) expire() {
+	now := hub.now()
+	for id, r := range hub.runs {
+		idle := now.Sub(r.lastActivity)
+		if (r.end != nil && idle > runLinger) || idle > runIdleTimeout {
+			delete(hub.runs, id)
+		}
+	}
+}
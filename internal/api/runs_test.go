@@ -0,0 +1,84 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+func TestRunHubFollow(t *testing.T) {
+	hub := newRunHub()
+	run := hub.start(1, types.Run{TaskName: "task123"})
+
+	if hub.appendOutput(2, run.ID, []types.OutputChunk{{Stream: types.StreamStdout, Data: "x"}}) {
+		t.Fatal("output accepted from another machine")
+	}
+
+	_, chunks, end, changed := hub.follow(1, run.ID, -1)
+	if len(chunks) != 0 || end != nil {
+		t.Fatal("unexpected state of a new run:", chunks, end)
+	}
+
+	hub.appendOutput(1, run.ID, []types.OutputChunk{
+		{Stream: types.StreamStdout, Data: "first"},
+		{Stream: types.StreamStderr, Data: "second"},
+	})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("followers not woken up by output")
+	}
+
+	// continuing after the first chunk
+	_, chunks, _, _ = hub.follow(1, run.ID, 0)
+	if len(chunks) != 1 || chunks[0].seq != 1 || chunks[0].Data != "second" {
+		t.Fatal("unexpected chunks:", chunks)
+	}
+
+	hub.finish(1, run.ID, types.RunEnd{RecordID: 42})
+	if runs := hub.list(1); len(runs) != 0 {
+		t.Fatal("ended run still listed:", runs)
+	}
+	_, _, end, _ = hub.follow(1, run.ID, 1)
+	if end == nil || end.RecordID != 42 {
+		t.Fatal("end of run not reported:", end)
+	}
+	if hub.appendOutput(1, run.ID, []types.OutputChunk{{Stream: types.StreamStdout, Data: "late"}}) {
+		t.Fatal("output accepted after run ended")
+	}
+}
+
+func TestRunHubLimits(t *testing.T) {
+	now := time.Now()
+	hub := newRunHub()
+	hub.now = func() time.Time { return now }
+
+	run := hub.start(1, types.Run{TaskName: "task123"})
+	ended := hub.start(1, types.Run{TaskName: "task123"})
+	hub.finish(1, ended.ID, types.RunEnd{})
+
+	// oldest output is dropped when buffer is full
+	large := strings.Repeat("x", maxRunOutputBuffer/2+1)
+	for i := 0; i < 3; i++ {
+		hub.appendOutput(1, run.ID, []types.OutputChunk{{Stream: types.StreamStdout, Data: large}})
+	}
+	_, chunks, _, _ := hub.follow(1, run.ID, -1)
+	if len(chunks) != 1 || chunks[0].seq != 2 {
+		t.Fatal("buffer not trimmed to latest output:", len(chunks))
+	}
+
+	now = now.Add(2 * runLinger)
+	if runs := hub.list(1); len(runs) != 1 {
+		t.Fatal("unexpected runs:", runs)
+	}
+	if r, _, _, _ := hub.follow(1, ended.ID, -1); r != nil {
+		t.Fatal("ended run kept too long")
+	}
+
+	now = now.Add(runIdleTimeout)
+	if runs := hub.list(1); len(runs) != 0 {
+		t.Fatal("abandoned run not removed:", runs)
+	}
+}
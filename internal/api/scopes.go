@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/LassiHeikkila/taskey/internal/db"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// scopeUnrestricted is required by routes scoped tokens can't be used for at all.
+// Managing users and tokens is among them, so that a scoped token can't be used to get a broader one.
+const scopeUnrestricted types.Scope = ""
+
+// resources belonging to a single machine.
+// Token limited to machines can only access them through path of one of its machines.
+var machineResources = map[string]struct{}{
+	"machines":  {},
+	"schedules": {},
+	"records":   {},
+	"triggers":  {},
+}
+
+// tokenScope limits what a scoped user token can do, in addition to the role of its user.
+// Credentials without scopes have nil tokenScope.
+type tokenScope struct {
+	scopes []types.Scope
+	// IDs of machines token is limited to, empty if it isn't
+	machines []uint
+}
+
+func newTokenScope(t *db.UserToken) *tokenScope {
+	if t.Scopes == "" {
+		return nil
+	}
+	s := &tokenScope{
+		scopes: types.ParseScopes(t.Scopes),
+	}
+	for i := range t.Machines {
+		s.machines = append(s.machines, t.Machines[i].ID)
+	}
+	return s
+}
+
+func (s *tokenScope) allows(required types.Scope) bool {
+	if s == nil {
+		return true
+	}
+	return types.ScopesAllow(s.scopes, required)
+}
+
+func (s *tokenScope) limitedToMachines() bool {
+	return s != nil && len(s.machines) != 0
+}
+
+func (s *tokenScope) allowsMachine(id uint) bool {
+	if !s.limitedToMachines() {
+		return true
+	}
+	for _, m := range s.machines {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
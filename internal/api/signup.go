@@ -1,5 +1,12 @@
 package api
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
 // sign up has to support:
 // - creation of an organization with initial user
 // - creation of new user accounts by org admin
@@ -15,3 +22,33 @@ type SignUpRequest struct {
 func validateSignUpRequest(r *SignUpRequest) bool {
 	return r.OrganizationName != "" && r.Username != "" && r.Email != "" && r.Password != ""
 }
+
+// NewUserRequest creates a user in an existing organization.
+// Password is temporary, and has to be changed on first login.
+// Without it, the user sets their password with a reset token returned when the user is created.
+type NewUserRequest struct {
+	types.User
+	Password string `json:"password,omitempty"`
+}
+
+const (
+	// how long password reset given out by an administrator can be used
+	passwordResetValidity = 24 * time.Hour
+	// new users may not get to setting their password right away
+	newUserPasswordResetValidity = 7 * 24 * time.Hour
+
+	minPasswordLength = 8
+	// bcrypt ignores anything past this
+	maxPasswordLength = 72
+)
+
+// validatePassword returns error describing why password can't be used, or nil
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return Error(fmt.Sprint("password must be at least ", minPasswordLength, " characters"))
+	}
+	if len(password) > maxPasswordLength {
+		return Error(fmt.Sprint("password must be at most ", maxPasswordLength, " bytes"))
+	}
+	return nil
+}
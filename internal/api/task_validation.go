@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// portable user and group names as understood by useradd and groupadd
+var unixNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}\$?$`)
+var unixIDPattern = regexp.MustCompile(`^[0-9]{1,10}$`)
+
+// validateTaskContent checks properties of task content, including actions of multi-action tasks.
+// Content is the task definition as decoded from JSON.
+func validateTaskContent(content interface{}) error {
+	c, ok := content.(map[string]interface{})
+	if !ok {
+		// content schema isn't enforced otherwise, so let it through
+		return nil
+	}
+
+	if err := validateTaskProperties(c); err != nil {
+		return err
+	}
+
+	if c["type"] == types.TaskTypeMulti {
+		actions, _ := c["actions"].([]interface{})
+		for i, action := range actions {
+			if err := validateTaskContent(action); err != nil {
+				return fmt.Errorf("action %d: %w", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateTaskProperties(c map[string]interface{}) error {
+	if v, found := c["timeout"]; found {
+		s, ok := v.(string)
+		if !ok {
+			return Error("timeout must be a string")
+		}
+		if d, err := time.ParseDuration(s); err != nil || d < 0 {
+			return Error("invalid timeout: " + s)
+		}
+	}
+
+	if v, found := c["env"]; found {
+		env, ok := v.(map[string]interface{})
+		if !ok {
+			return Error("env must be an object")
+		}
+		for k, val := range env {
+			if k == "" || strings.ContainsAny(k, "=\x00") {
+				return Error("invalid environment variable name: " + k)
+			}
+			s, ok := val.(string)
+			if !ok {
+				return Error("value of environment variable " + k + " must be a string")
+			}
+			if strings.ContainsRune(s, 0) {
+				return Error("value of environment variable " + k + " contains NUL")
+			}
+		}
+	}
+
+	if v, found := c["workingDir"]; found {
+		dir, ok := v.(string)
+		if !ok || (dir != "" && !path.IsAbs(dir)) {
+			return Error("workingDir must be an absolute path")
+		}
+	}
+
+	if v, found := c["secrets"]; found {
+		refs, ok := v.([]interface{})
+		if !ok {
+			return Error("secrets must be an array")
+		}
+		for _, ref := range refs {
+			name, ok := ref.(string)
+			if !ok || !secretNamePattern.MatchString(name) {
+				return Error(fmt.Sprint("invalid secret name: ", ref))
+			}
+		}
+	}
+
+	for _, key := range []string{"user", "group"} {
+		v, found := c[key]
+		if !found {
+			continue
+		}
+		name, ok := v.(string)
+		if !ok || (name != "" && !unixNamePattern.MatchString(name) && !unixIDPattern.MatchString(name)) {
+			return Error(key + " must be a valid name or numeric id")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateTaskContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{name: "minimal", content: `{"type":"cmd","program":"ls"}`, valid: true},
+		{name: "all properties", content: `{"type":"script","interpreter":"bash","script":"env","timeout":"1m","env":{"FOO":"bar"},"workingDir":"/tmp","user":"nobody","group":"1000"}`, valid: true},
+		{name: "invalid timeout", content: `{"type":"cmd","timeout":"soon"}`, valid: false},
+		{name: "negative timeout", content: `{"type":"cmd","timeout":"-1s"}`, valid: false},
+		{name: "env not object", content: `{"type":"cmd","env":["FOO=bar"]}`, valid: false},
+		{name: "env name with =", content: `{"type":"cmd","env":{"FOO=":"bar"}}`, valid: false},
+		{name: "env value not string", content: `{"type":"cmd","env":{"FOO":1}}`, valid: false},
+		{name: "relative workingDir", content: `{"type":"cmd","workingDir":"tmp"}`, valid: false},
+		{name: "invalid user", content: `{"type":"cmd","user":"root; rm -rf /"}`, valid: false},
+		{name: "invalid group", content: `{"type":"cmd","group":"Wheel Group"}`, valid: false},
+		{name: "secrets", content: `{"type":"cmd","secrets":["DB_PASSWORD","api_key"]}`, valid: true},
+		{name: "invalid secret name", content: `{"type":"cmd","secrets":["DB-PASSWORD"]}`, valid: false},
+		{name: "secrets not array", content: `{"type":"cmd","secrets":"DB_PASSWORD"}`, valid: false},
+		{name: "invalid action", content: `{"type":"multi","actions":[{"type":"cmd","program":"ls"},{"type":"cmd","workingDir":"."}]}`, valid: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var content interface{}
+			if err := json.Unmarshal([]byte(tc.content), &content); err != nil {
+				t.Fatal("invalid test content:", err)
+			}
+
+			err := validateTaskContent(content)
+			if tc.valid && err != nil {
+				t.Fatal("valid content rejected:", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("invalid content accepted")
+			}
+		})
+	}
+}
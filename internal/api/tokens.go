@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+const maxTokenLabelLength = 100
+
+// decodeTokenRequest reads options for a new user or machine token from request body.
+// Body is optional, without it the token has no label, scopes or expiry.
+// Returned error is meant to be shown to the client.
+func decodeTokenRequest(req *http.Request) (tr *types.TokenRequest, expiration time.Time, err error) {
+	tr = &types.TokenRequest{}
+	dec := json.NewDecoder(req.Body)
+	if err := dec.Decode(tr); err != nil && err != io.EOF {
+		return nil, time.Time{}, Error("invalid token request")
+	}
+	if len(tr.Label) > maxTokenLabelLength {
+		return nil, time.Time{}, Error("label must be at most " + strconv.Itoa(maxTokenLabelLength) + " characters")
+	}
+	if tr.ValidFor.Duration < 0 {
+		return nil, time.Time{}, Error("validFor must not be negative")
+	}
+	for _, s := range tr.Scopes {
+		if !s.Valid() {
+			return nil, time.Time{}, Error("invalid scope: " + string(s))
+		}
+	}
+	if len(tr.Machines) != 0 && len(tr.Scopes) == 0 {
+		return nil, time.Time{}, Error("token can only be limited to machines together with scopes")
+	}
+	if tr.ValidFor.Duration > 0 {
+		// zero time means no expiry
+		expiration = time.Now().Add(tr.ValidFor.Duration)
+	}
+	return tr, expiration, nil
+}
@@ -4,6 +4,9 @@ import (
 	"crypto/rand"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -14,16 +17,25 @@ const (
 
 type Controller interface {
 	CreateJWT(claims jwt.Claims) (string, error)
-	ValidateUserToken(tokenString string, user *string, organization *string, role *int) bool
+	ValidateUserToken(tokenString string, claims *UserClaims) bool
 	ValidateMachineToken(tokenString string, machine *string, organization *string) bool
 	GenerateUUID() (string, error)
+	SetSigningKeys(keys []SigningKey) error
+	JWKS() JWKSet
 }
 
 type authController struct {
+	// shared key for HS256, used until signing keys are set
 	key []byte
+
+	mu sync.RWMutex
+	// asymmetric keys, newest first
+	signingKeys []SigningKey
 }
 
-type userClaims struct {
+// UserClaims are the claims of a user access token.
+// StandardClaims carry the token ID (jti), issue time (iat) and expiry (exp), which every user token has.
+type UserClaims struct {
 	User         string `json:"user"`
 	Organization string `json:"organization"`
 	Role         int    `json:"role"`
@@ -58,13 +70,17 @@ func NewController(key []byte) Controller {
 	}
 }
 
-func CreateUserClaims(user string, organization string, role int) jwt.Claims {
-	return &userClaims{
+// CreateUserClaims creates claims for a user token identified by id, valid for given time from issuedAt
+func CreateUserClaims(user string, organization string, role int, id string, issuedAt time.Time, validity time.Duration) jwt.Claims {
+	return &UserClaims{
 		User:         user,
 		Organization: organization,
 		Role:         role,
 		StandardClaims: jwt.StandardClaims{
-			Issuer: IssuerName,
+			Id:        id,
+			Issuer:    IssuerName,
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: issuedAt.Add(validity).Unix(),
 		},
 	}
 }
@@ -79,67 +95,111 @@ func CreateMachineClaims(machine string, organization string) jwt.Claims {
 	}
 }
 
+// CreateJWT signs token with the newest active signing key, or with the shared key if signing keys haven't been set
 func (a *authController) CreateJWT(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	s, err := token.SignedString(a.key)
-	if err != nil {
-		return "", err
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if len(a.signingKeys) == 0 {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(a.key)
 	}
 
-	return s, nil
+	now := time.Now()
+	for i := range a.signingKeys {
+		k := &a.signingKeys[i]
+		if k.ActivatesAt.After(now) {
+			// published already, so that it's known everywhere by the time it's used
+			continue
+		}
+		token := jwt.NewWithClaims(k.method(), claims)
+		token.Header["kid"] = k.ID
+		return token.SignedString(k.PrivateKey)
+	}
+	return "", ErrNoSigningKey
 }
 
-func (a *authController) ValidateUserToken(tokenString string, user *string, organization *string, role *int) bool {
-	// implementation inspired by example at https://pkg.go.dev/github.com/golang-jwt/jwt#example-Parse-Hmac
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+// SetSigningKeys replaces keys used for signing and verifying tokens.
+// Once signing keys are set, tokens signed with the shared key are not accepted anymore.
+func (a *authController) SetSigningKeys(keys []SigningKey) error {
+	for i := range keys {
+		if err := keys[i].validate(); err != nil {
+			return err
+		}
+	}
+	sorted := append([]SigningKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.After(sorted[j].ActivatesAt)
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.signingKeys = sorted
+	return nil
+}
+
+// JWKS returns public parts of the signing keys, for services verifying our tokens
+func (a *authController) JWKS() JWKSet {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(a.signingKeys))}
+	for i := range a.signingKeys {
+		set.Keys = append(set.Keys, a.signingKeys[i].jwk())
+	}
+	return set
+}
+
+// verificationKey returns key for checking signature of token.
+// Algorithm of the token must match the key, so that e.g. a public key can't be passed off as an HMAC secret.
+func (a *authController) verificationKey(token *jwt.Token) (interface{}, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if len(a.signingKeys) == 0 {
 		// Don't forget to validate the alg is what you expect:
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-
 		return a.key, nil
-	})
-	if err != nil {
-		log.Println("error parsing token:", err)
-		return false
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if u, ok := claims["user"]; ok {
-			_, ok := u.(string)
-			if ok && user != nil {
-				*user = u.(string)
-			}
+	kid, _ := token.Header["kid"].(string)
+	for i := range a.signingKeys {
+		k := &a.signingKeys[i]
+		if k.ID != kid {
+			continue
 		}
-		if o, ok := claims["organization"]; ok {
-			_, ok := o.(string)
-			if ok && organization != nil {
-				*organization = o.(string)
-			}
-		}
-		if r, ok := claims["role"]; ok {
-			// unmarshalled JSON has all numbers as float64 so just have to cast to integer
-			_, ok := r.(float64)
-			if ok && role != nil {
-				*role = int(r.(float64))
-			}
+		if token.Method.Alg() != k.Algorithm {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
+		return k.PrivateKey.Public(), nil
+	}
+	return nil, fmt.Errorf("Unknown key: %v", kid)
+}
 
-		return true
+// ValidateUserToken checks that tokenString is a user token signed by us which hasn't expired, and fills in its claims.
+// Tokens without expiry are not accepted.
+func (a *authController) ValidateUserToken(tokenString string, claims *UserClaims) bool {
+	// implementation inspired by example at https://pkg.go.dev/github.com/golang-jwt/jwt#example-Parse-Hmac
+	var c UserClaims
+	token, err := jwt.ParseWithClaims(tokenString, &c, a.verificationKey)
+	if err != nil {
+		log.Println("error parsing token:", err)
+		return false
 	}
-	return false
+	if !token.Valid || !c.VerifyExpiresAt(time.Now().Unix(), true) || c.Id == "" {
+		return false
+	}
+	if claims != nil {
+		*claims = c
+	}
+	return true
 }
 
 func (a *authController) ValidateMachineToken(tokenString string, machine *string, organization *string) bool {
 	// implementation inspired by example at https://pkg.go.dev/github.com/golang-jwt/jwt#example-Parse-Hmac
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return a.key, nil
-	})
+	token, err := jwt.Parse(tokenString, a.verificationKey)
 	if err != nil {
 		log.Println("error parsing token:", err)
 		return false
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// Algorithms tokens can be signed with.
+// HS256 uses the shared key given to NewController, the others use signing keys set with SetSigningKeys.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+const rsaKeyBits = 2048
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrNoSigningKey         = errors.New("no active signing key")
+)
+
+// SigningKey is an asymmetric key for signing tokens, identified in their header by ID (kid).
+// Newest key which has activated signs new tokens, older keys are only used for verifying tokens issued before.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	ActivatesAt time.Time
+}
+
+// GenerateSigningKey creates a new key with random ID, which will be used for signing from activatesAt on
+func GenerateSigningKey(algorithm string, activatesAt time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = k
+	case AlgorithmRS256:
+		k, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = k
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:          id.String(),
+		Algorithm:   algorithm,
+		PrivateKey:  private,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// EncryptSigningKey encodes private key of k in PKCS #8 form and encrypts it with EncryptSecret, bound to the key ID
+func EncryptSigningKey(encryptionKey []byte, k *SigningKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptSecret(encryptionKey, der, signingKeyBinding(k.ID))
+}
+
+// DecryptSigningKey reverses EncryptSigningKey, and checks that the key suits algorithm
+func DecryptSigningKey(encryptionKey []byte, id string, algorithm string, activatesAt time.Time, sealed []byte) (*SigningKey, error) {
+	der, err := DecryptSecret(encryptionKey, sealed, signingKeyBinding(id))
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &SigningKey{
+		ID:          id,
+		Algorithm:   algorithm,
+		ActivatesAt: activatesAt,
+	}
+	switch p := private.(type) {
+	case ed25519.PrivateKey:
+		k.PrivateKey = p
+	case *rsa.PrivateKey:
+		k.PrivateKey = p
+	}
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func signingKeyBinding(id string) string {
+	return "signing key " + id
+}
+
+// validate checks that private key is of the type algorithm needs
+func (k *SigningKey) validate() error {
+	switch k.Algorithm {
+	case AlgorithmEdDSA:
+		if _, ok := k.PrivateKey.(ed25519.PrivateKey); ok {
+			return nil
+		}
+	case AlgorithmRS256:
+		if _, ok := k.PrivateKey.(*rsa.PrivateKey); ok {
+			return nil
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return errors.New("key type doesn't match algorithm " + k.Algorithm)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// Ed25519 keys (RFC 8037)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) jwk() JWK {
+	j := JWK{
+		Use:       "sig",
+		KeyID:     k.ID,
+		Algorithm: k.Algorithm,
+	}
+	switch p := k.PrivateKey.Public().(type) {
+	case ed25519.PublicKey:
+		j.KeyType = "OKP"
+		j.Curve = "Ed25519"
+		j.X = base64.RawURLEncoding.EncodeToString(p)
+	case *rsa.PublicKey:
+		j.KeyType = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(p.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.E)).Bytes())
+	}
+	return j
+}
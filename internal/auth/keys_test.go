@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func mustGenerateSigningKey(t *testing.T, algorithm string, activatesAt time.Time) SigningKey {
+	k, err := GenerateSigningKey(algorithm, activatesAt)
+	if err != nil {
+		t.Fatal("error generating key:", err)
+	}
+	return *k
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			a := NewController([]byte("shared-key"))
+			now := time.Now()
+			claims := func() jwt.Claims {
+				return CreateUserClaims("user", "organization", 1, "0c6e2f34-8f7b-4b57-9d3a-2f1c5e8a9b70", time.Now(), time.Minute)
+			}
+
+			shared, err := a.CreateJWT(claims())
+			if err != nil {
+				t.Fatal("error creating token:", err)
+			}
+
+			old := mustGenerateSigningKey(t, algorithm, now.Add(-time.Hour))
+			if err := a.SetSigningKeys([]SigningKey{old}); err != nil {
+				t.Fatal("error setting keys:", err)
+			}
+			if a.ValidateUserToken(shared, nil) {
+				t.Fatal("token signed with shared key accepted")
+			}
+			oldToken, err := a.CreateJWT(claims())
+			if err != nil {
+				t.Fatal("error creating token:", err)
+			}
+			if h := parseHeader(t, oldToken); h["kid"] != old.ID || h["alg"] != algorithm {
+				t.Fatal("unexpected header:", h)
+			}
+
+			// next key is published before it's used for signing
+			next := mustGenerateSigningKey(t, algorithm, now.Add(time.Hour))
+			if err := a.SetSigningKeys([]SigningKey{old, next}); err != nil {
+				t.Fatal("error setting keys:", err)
+			}
+			if len(a.JWKS().Keys) != 2 {
+				t.Fatal("next key not published:", a.JWKS())
+			}
+			token, _ := a.CreateJWT(claims())
+			if parseHeader(t, token)["kid"] != old.ID {
+				t.Fatal("key used before it activated")
+			}
+
+			next.ActivatesAt = now
+			if err := a.SetSigningKeys([]SigningKey{next, old}); err != nil {
+				t.Fatal("error setting keys:", err)
+			}
+			token, _ = a.CreateJWT(claims())
+			if parseHeader(t, token)["kid"] != next.ID || !a.ValidateUserToken(token, nil) {
+				t.Fatal("new key not used")
+			}
+			if !a.ValidateUserToken(oldToken, nil) {
+				t.Fatal("token signed with old key refused during grace period")
+			}
+
+			// old key is dropped once grace period is over
+			if err := a.SetSigningKeys([]SigningKey{next}); err != nil {
+				t.Fatal("error setting keys:", err)
+			}
+			if a.ValidateUserToken(oldToken, nil) {
+				t.Fatal("token signed with dropped key accepted")
+			}
+		})
+	}
+}
+
+func TestSigningKeyAlgorithmConfusion(t *testing.T) {
+	a := NewController([]byte("shared-key"))
+	k := mustGenerateSigningKey(t, AlgorithmRS256, time.Now())
+	if err := a.SetSigningKeys([]SigningKey{k}); err != nil {
+		t.Fatal("error setting keys:", err)
+	}
+
+	// public key is no secret, so it must not be accepted as HMAC key
+	pub := k.PrivateKey.Public().(*rsa.PublicKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, CreateUserClaims("user", "organization", 1, "0c6e2f34-8f7b-4b57-9d3a-2f1c5e8a9b70", time.Now(), time.Minute))
+	token.Header["kid"] = k.ID
+	forged, err := token.SignedString(pub.N.Bytes())
+	if err != nil {
+		t.Fatal("error creating token:", err)
+	}
+	if a.ValidateUserToken(forged, nil) {
+		t.Fatal("token with mismatching algorithm accepted")
+	}
+
+	if err := a.SetSigningKeys([]SigningKey{{ID: "x", Algorithm: AlgorithmEdDSA, PrivateKey: k.PrivateKey}}); err == nil {
+		t.Fatal("key with wrong type for algorithm accepted")
+	}
+	if _, err := GenerateSigningKey(AlgorithmHS256, time.Now()); err != ErrUnsupportedAlgorithm {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestSigningKeyEncryption(t *testing.T) {
+	encryptionKey := make([]byte, SecretKeySize)
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		k := mustGenerateSigningKey(t, algorithm, time.Now())
+		sealed, err := EncryptSigningKey(encryptionKey, &k)
+		if err != nil {
+			t.Fatal("error encrypting key:", err)
+		}
+
+		opened, err := DecryptSigningKey(encryptionKey, k.ID, algorithm, k.ActivatesAt, sealed)
+		if err != nil {
+			t.Fatal("error decrypting key:", err)
+		}
+		if opened.jwk() != k.jwk() {
+			t.Fatal("key changed:", opened.jwk(), k.jwk())
+		}
+
+		if _, err := DecryptSigningKey(encryptionKey, "other", algorithm, k.ActivatesAt, sealed); err == nil {
+			t.Fatal("key decrypted under another ID")
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	a := NewController([]byte("shared-key"))
+	if keys := a.JWKS().Keys; keys == nil || len(keys) != 0 {
+		t.Fatal("unexpected keys:", keys)
+	}
+
+	ed := mustGenerateSigningKey(t, AlgorithmEdDSA, time.Now())
+	rs := mustGenerateSigningKey(t, AlgorithmRS256, time.Now().Add(-time.Hour))
+	if err := a.SetSigningKeys([]SigningKey{rs, ed}); err != nil {
+		t.Fatal("error setting keys:", err)
+	}
+	keys := a.JWKS().Keys
+	if len(keys) != 2 || keys[0].KeyID != ed.ID || keys[1].KeyID != rs.ID {
+		t.Fatal("unexpected keys:", keys)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(keys[0].X)
+	if err != nil || keys[0].KeyType != "OKP" || keys[0].Curve != "Ed25519" || !ed.PrivateKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		t.Fatal("unexpected Ed25519 JWK:", keys[0])
+	}
+
+	n, _ := base64.RawURLEncoding.DecodeString(keys[1].N)
+	e, _ := base64.RawURLEncoding.DecodeString(keys[1].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if keys[1].KeyType != "RSA" || keys[1].Algorithm != AlgorithmRS256 || !rs.PrivateKey.Public().(*rsa.PublicKey).Equal(pub) {
+		t.Fatal("unexpected RSA JWK:", keys[1])
+	}
+}
+
+func parseHeader(t *testing.T, token string) map[string]interface{} {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &UserClaims{})
+	if err != nil {
+		t.Fatal("error parsing token:", err)
+	}
+	return parsed.Header
+}
@@ -7,6 +7,7 @@ package mock_auth
 import (
 	reflect "reflect"
 
+	auth "github.com/LassiHeikkila/taskey/internal/auth"
 	jwt "github.com/golang-jwt/jwt"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -64,6 +65,34 @@ func (mr *MockControllerMockRecorder) GenerateUUID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateUUID", reflect.TypeOf((*MockController)(nil).GenerateUUID))
 }
 
+// JWKS mocks base method.
+func (m *MockController) JWKS() auth.JWKSet {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "JWKS")
+	ret0, _ := ret[0].(auth.JWKSet)
+	return ret0
+}
+
+// JWKS indicates an expected call of JWKS.
+func (mr *MockControllerMockRecorder) JWKS() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "JWKS", reflect.TypeOf((*MockController)(nil).JWKS))
+}
+
+// SetSigningKeys mocks base method.
+func (m *MockController) SetSigningKeys(arg0 []auth.SigningKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSigningKeys", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSigningKeys indicates an expected call of SetSigningKeys.
+func (mr *MockControllerMockRecorder) SetSigningKeys(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSigningKeys", reflect.TypeOf((*MockController)(nil).SetSigningKeys), arg0)
+}
+
 // ValidateMachineToken mocks base method.
 func (m *MockController) ValidateMachineToken(arg0 string, arg1, arg2 *string) bool {
 	m.ctrl.T.Helper()
@@ -79,15 +108,15 @@ func (mr *MockControllerMockRecorder) ValidateMachineToken(arg0, arg1, arg2 inte
 }
 
 // ValidateUserToken mocks base method.
-func (m *MockController) ValidateUserToken(arg0 string, arg1, arg2 *string, arg3 *int) bool {
+func (m *MockController) ValidateUserToken(arg0 string, arg1 *auth.UserClaims) bool {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ValidateUserToken", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "ValidateUserToken", arg0, arg1)
 	ret0, _ := ret[0].(bool)
 	return ret0
 }
 
 // ValidateUserToken indicates an expected call of ValidateUserToken.
-func (mr *MockControllerMockRecorder) ValidateUserToken(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockControllerMockRecorder) ValidateUserToken(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateUserToken", reflect.TypeOf((*MockController)(nil).ValidateUserToken), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateUserToken", reflect.TypeOf((*MockController)(nil).ValidateUserToken), arg0, arg1)
 }
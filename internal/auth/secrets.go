@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// SecretKeySize is the size of key required for encrypting secrets, AES-256 is used
+const SecretKeySize = 32
+
+var (
+	ErrInvalidSecretKey = errors.New("secret key must be 32 bytes")
+	ErrCorruptSecret    = errors.New("secret can't be decrypted")
+)
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != SecretKeySize {
+		return nil, ErrInvalidSecretKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts and authenticates plain with AES-GCM.
+// binding should identify where the secret is stored, e.g. organization and name of the secret,
+// so that encrypted value can't be swapped with another one without detection.
+// Random nonce is prepended to the returned value.
+func EncryptSecret(key []byte, plain []byte, binding string) ([]byte, error) {
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, []byte(binding)), nil
+}
+
+// DecryptSecret reverses EncryptSecret, binding must be the same as used for encryption
+func DecryptSecret(key []byte, sealed []byte, binding string) ([]byte, error) {
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorruptSecret
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(binding))
+	if err != nil {
+		return nil, ErrCorruptSecret
+	}
+	return plain, nil
+}
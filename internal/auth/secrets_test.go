@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecretRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, SecretKeySize)
+	plain := []byte("hunter2")
+
+	sealed, err := EncryptSecret(key, plain, "org123/DB_PASSWORD")
+	if err != nil {
+		t.Fatal("error encrypting secret:", err)
+	}
+	if bytes.Contains(sealed, plain) {
+		t.Fatal("encrypted secret contains plaintext")
+	}
+
+	got, err := DecryptSecret(key, sealed, "org123/DB_PASSWORD")
+	if err != nil {
+		t.Fatal("error decrypting secret:", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decrypted secret %q != %q", got, plain)
+	}
+
+	// same plaintext must not produce same ciphertext
+	sealed2, _ := EncryptSecret(key, plain, "org123/DB_PASSWORD")
+	if bytes.Equal(sealed, sealed2) {
+		t.Fatal("nonce reused")
+	}
+}
+
+func TestSecretDecryptFailures(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, SecretKeySize)
+	sealed, _ := EncryptSecret(key, []byte("hunter2"), "org123/DB_PASSWORD")
+
+	if _, err := DecryptSecret(key, sealed, "org456/DB_PASSWORD"); err != ErrCorruptSecret {
+		t.Fatal("secret decrypted with wrong binding:", err)
+	}
+
+	otherKey := bytes.Repeat([]byte{0x43}, SecretKeySize)
+	if _, err := DecryptSecret(otherKey, sealed, "org123/DB_PASSWORD"); err != ErrCorruptSecret {
+		t.Fatal("secret decrypted with wrong key:", err)
+	}
+
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := DecryptSecret(key, sealed, "org123/DB_PASSWORD"); err != ErrCorruptSecret {
+		t.Fatal("tampered secret decrypted:", err)
+	}
+
+	if _, err := EncryptSecret(key[:16], []byte("hunter2"), ""); err != ErrInvalidSecretKey {
+		t.Fatal("short key accepted:", err)
+	}
+}
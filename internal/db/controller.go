@@ -1,11 +1,17 @@
 package db
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/jackc/pgtype"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
 type Controller interface {
@@ -21,6 +27,17 @@ type Controller interface {
 	CreateMachineToken(*MachineToken) error
 	CreateLoginInfo(*LoginInfo) error
 	CreateRecord(*Record) error
+	CreateRecords([]Record) ([]error, error)
+	CreateTrigger(*Trigger) error
+	CreateTriggers([]Trigger) error
+	CreateSecret(*Secret) error
+	CreateRetentionPolicy(*RetentionPolicy) error
+	CreateEnrollmentToken(*EnrollmentToken) error
+	CreateMachineGroup(*MachineGroup) error
+	CreatePasswordReset(*PasswordReset) error
+	CreateRefreshToken(*RefreshToken) error
+	CreateTokenRevocation(*TokenRevocation) error
+	CreateSigningKey(*SigningKey) error
 	// Read
 	ReadUser(name string) (*User, error)
 	ReadMachine(name string) (*Machine, error)
@@ -28,30 +45,68 @@ type Controller interface {
 	ReadSchedule(machineName string) (*Schedule, error)
 	ReadTask(name string) (*Task, error)
 	ReadUserToken(value pgtype.UUID) (*UserToken, error)
+	ReadUserTokens(userID uint) ([]UserToken, error)
 	ReadMachineToken(value pgtype.UUID) (*MachineToken, error)
+	ReadMachineTokens(machineID uint) ([]MachineToken, error)
 	ReadLoginInfo(username string) (*LoginInfo, error)
-	ReadRecords(machineName string) ([]Record, error)
+	ReadTokenRevocations(now time.Time) ([]TokenRevocation, error)
+	ReadSigningKeys() ([]SigningKey, error)
+	ReadRecord(id uint) (*Record, error)
+	ReadRecords(machineName string, query RecordQuery) ([]Record, error)
+	ReadRecordOutput(recordID uint, step int, stream string) (*RecordOutput, error)
+	ReadTrigger(id uint) (*Trigger, error)
+	ReadTriggers(machineName string, state string) ([]Trigger, error)
+	ReadSecret(organizationID uint, name string) (*Secret, error)
+	ReadSecrets(organizationID uint) ([]Secret, error)
+	ReadRetentionPolicy(organizationID uint, taskID uint) (*RetentionPolicy, error)
+	ReadRetentionPolicies() ([]RetentionPolicy, error)
+	ReadEnrollmentTokens(organizationID uint) ([]EnrollmentToken, error)
+	ReadMachineGroup(organizationID uint, name string) (*MachineGroup, error)
+	ReadMachineGroups(organizationID uint) ([]MachineGroup, error)
+	ReadMachineGroupsOf(machineID uint) ([]MachineGroup, error)
 	// Update
 	UpdateUser(*User) error
 	UpdateMachine(*Machine) error
+	UpdateMachineHeartbeat(*Machine) error
 	UpdateOrganization(*Organization) error
 	UpdateSchedule(*Schedule) error
 	UpdateTask(*Task) error
 	UpdateUserToken(*UserToken) error
 	UpdateMachineToken(*MachineToken) error
+	UpdateUserTokenLastUsed(id uint, now time.Time) error
+	UpdateMachineTokenLastUsed(id uint, now time.Time) error
 	UpdateLoginInfo(*LoginInfo) error
 	UpdateRecord(*Record) error
+	UpdateTrigger(*Trigger) error
+	UpdateSecret(*Secret) error
+	UpdateRetentionPolicy(*RetentionPolicy) error
+	UpdateMachineGroup(*MachineGroup) error
+	AddMachineGroupMember(group *MachineGroup, machine *Machine) error
+	RemoveMachineGroupMember(group *MachineGroup, machine *Machine) error
 	// Delete
 	DeleteUser(name string) error
 	DeleteMachine(name string) error
 	DeleteOrganization(name string) error
 	DeleteSchedule(machineName string) error
 	DeleteTask(name string) error
-	DeleteUserToken(value pgtype.UUID) error
-	DeleteMachineToken(value pgtype.UUID) error
+	DeleteUserToken(userID uint, id uint) error
+	DeleteMachineToken(machineID uint, id uint) error
 	DeleteLoginInfo(username string) error
+	DeleteRefreshToken(value pgtype.UUID) error
+	DeleteRefreshTokens(loginInfoID uint) error
+	DeleteSigningKey(keyID string) error
 	DeleteRecords(machineName string) error
 	DeleteRecord(machineName string, recordID uint64) error
+	DeleteSecret(organizationID uint, name string) error
+	DeleteRetentionPolicy(organizationID uint, taskID uint) error
+	DeleteEnrollmentToken(organizationID uint, id uint) error
+	DeleteMachineGroup(organizationID uint, name string) error
+	PruneRecords(policy *RetentionPolicy, now time.Time, batchSize int) (int64, error)
+	EnrollMachine(organizationID uint, value pgtype.UUID, now time.Time, machine *Machine, token *MachineToken) error
+	ResetPassword(username string, value pgtype.UUID, now time.Time, hashedPassword string) error
+	RotateRefreshToken(value pgtype.UUID, now time.Time, next *RefreshToken) (*LoginInfo, error)
+	PruneExpiredTokens(now time.Time) (int64, error)
+	FailStaleTriggers(runningSince time.Time) (int64, error)
 }
 
 type controller struct {
@@ -73,6 +128,15 @@ func (d dbError) Error() string { return string(d) }
 var (
 	unimplemented = dbError("unimplemented")
 	noDB          = dbError("no db connection")
+
+	// ErrEnrollmentTokenInvalid is returned when enrolling with a token which doesn't exist, has expired or is used up
+	ErrEnrollmentTokenInvalid = dbError("invalid enrollment token")
+	// ErrMachineExists is returned when enrolling a machine with a name which is already taken
+	ErrMachineExists = dbError("machine already exists")
+	// ErrPasswordResetInvalid is returned when resetting password with a token which doesn't exist, has expired or is used already
+	ErrPasswordResetInvalid = dbError("invalid password reset token")
+	// ErrRefreshTokenInvalid is returned when refreshing with a token which doesn't exist, has expired or is used already
+	ErrRefreshTokenInvalid = dbError("invalid refresh token")
 )
 
 func (c *controller) LoadModel(model interface{}, id uint) error {
@@ -163,7 +227,14 @@ func (c *controller) CreateUserToken(userToken *UserToken) error {
 		return noDB
 	}
 
-	res := c.db.Create(userToken)
+	hash, err := hashToken(userToken.Value)
+	if err != nil {
+		return err
+	}
+	userToken.Hash = hash
+
+	// machines token is limited to exist already, only references to them are created
+	res := c.db.Omit("Machines.*").Create(userToken)
 	if err := res.Error; err != nil {
 		log.Println("error creating UserToken:", err)
 		return err
@@ -177,6 +248,12 @@ func (c *controller) CreateMachineToken(machineToken *MachineToken) error {
 		return noDB
 	}
 
+	hash, err := hashToken(machineToken.Value)
+	if err != nil {
+		return err
+	}
+	machineToken.Hash = hash
+
 	res := c.db.Create(machineToken)
 	if err := res.Error; err != nil {
 		log.Println("error creating MachineToken:", err)
@@ -186,6 +263,114 @@ func (c *controller) CreateMachineToken(machineToken *MachineToken) error {
 	return nil
 }
 
+func (c *controller) CreateEnrollmentToken(enrollmentToken *EnrollmentToken) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	hash, err := hashToken(enrollmentToken.Value)
+	if err != nil {
+		return err
+	}
+	enrollmentToken.Hash = hash
+
+	res := c.db.Create(enrollmentToken)
+	if err := res.Error; err != nil {
+		log.Println("error creating EnrollmentToken:", err)
+		return err
+	}
+	log.Println("inserted EnrollmentToken with ID:", enrollmentToken.ID)
+	return nil
+}
+
+func (c *controller) CreateMachineGroup(group *MachineGroup) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Omit("Machines").Create(group)
+	if err := res.Error; err != nil {
+		log.Println("error creating MachineGroup:", err)
+		return err
+	}
+	log.Println("inserted MachineGroup with ID:", group.ID)
+	return nil
+}
+
+// CreatePasswordReset stores password reset, replacing earlier resets of the same login which haven't been used
+func (c *controller) CreatePasswordReset(reset *PasswordReset) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	hash, err := hashToken(reset.Value)
+	if err != nil {
+		return err
+	}
+	reset.Hash = hash
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(`login_info_id = ?`, reset.LoginInfoID).Delete(&PasswordReset{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+	if err != nil {
+		log.Println("error creating PasswordReset:", err)
+		return err
+	}
+	log.Println("inserted PasswordReset with ID:", reset.ID)
+	return nil
+}
+
+func (c *controller) CreateRefreshToken(token *RefreshToken) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	hash, err := hashToken(token.Value)
+	if err != nil {
+		return err
+	}
+	token.Hash = hash
+
+	res := c.db.Create(token)
+	if err := res.Error; err != nil {
+		log.Println("error creating RefreshToken:", err)
+		return err
+	}
+	log.Println("inserted RefreshToken with ID:", token.ID)
+	return nil
+}
+
+func (c *controller) CreateTokenRevocation(revocation *TokenRevocation) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Create(revocation)
+	if err := res.Error; err != nil {
+		log.Println("error creating TokenRevocation:", err)
+		return err
+	}
+	log.Println("inserted TokenRevocation with ID:", revocation.ID)
+	return nil
+}
+
+func (c *controller) CreateSigningKey(key *SigningKey) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Create(key)
+	if err := res.Error; err != nil {
+		log.Println("error creating SigningKey:", err)
+		return err
+	}
+	log.Println("inserted SigningKey with ID:", key.ID)
+	return nil
+}
+
 func (c *controller) CreateLoginInfo(loginInfo *LoginInfo) error {
 	if c == nil || c.db == nil {
 		return noDB
@@ -214,6 +399,121 @@ func (c *controller) CreateRecord(record *Record) error {
 	return nil
 }
 
+// CreateRecords inserts records in a single transaction, each in its own savepoint
+// so that one failing record doesn't prevent others from being inserted.
+// Triggers referenced by records are marked done along with them.
+// Returned slice has the result of each record, second return value is set if whole transaction failed.
+func (c *controller) CreateRecords(records []Record) ([]error, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	errs := make([]error, len(records))
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		for i := range records {
+			sp := fmt.Sprintf("record%d", i)
+			if err := tx.SavePoint(sp).Error; err != nil {
+				return err
+			}
+
+			errs[i] = createRecord(tx, &records[i])
+			if errs[i] != nil {
+				log.Println("error creating Record:", errs[i])
+				if err := tx.RollbackTo(sp).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			log.Println("inserted Record with ID:", records[i].ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error creating Records:", err)
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+func createRecord(tx *gorm.DB, record *Record) error {
+	if err := tx.Create(record).Error; err != nil {
+		return err
+	}
+	if record.TriggerID == 0 {
+		return nil
+	}
+
+	return tx.Model(&Trigger{}).Where(`id = ?`, record.TriggerID).Updates(map[string]interface{}{
+		"state":     types.TriggerStateDone,
+		"record_id": record.ID,
+	}).Error
+}
+
+func (c *controller) CreateTrigger(trigger *Trigger) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Create(trigger)
+	if err := res.Error; err != nil {
+		log.Println("error creating Trigger:", err)
+		return err
+	}
+	log.Println("inserted Trigger with ID:", trigger.ID)
+	return nil
+}
+
+// CreateTriggers inserts triggers in a single transaction, so that either all or none of them are created
+func (c *controller) CreateTriggers(triggers []Trigger) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		for i := range triggers {
+			if err := tx.Create(&triggers[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error creating Triggers:", err)
+		return err
+	}
+	log.Println("inserted", len(triggers), "Trigger(s)")
+	return nil
+}
+
+func (c *controller) CreateSecret(secret *Secret) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Create(secret)
+	if err := res.Error; err != nil {
+		log.Println("error creating Secret:", err)
+		return err
+	}
+	log.Println("inserted Secret with ID:", secret.ID)
+	return nil
+}
+
+func (c *controller) CreateRetentionPolicy(policy *RetentionPolicy) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Create(policy)
+	if err := res.Error; err != nil {
+		log.Println("error creating RetentionPolicy:", err)
+		return err
+	}
+	log.Println("inserted RetentionPolicy with ID:", policy.ID)
+	return nil
+}
+
 func (c *controller) ReadUser(name string) (*User, error) {
 	if c == nil || c.db == nil {
 		return nil, noDB
@@ -304,33 +604,81 @@ func (c *controller) ReadUserToken(value pgtype.UUID) (*UserToken, error) {
 		return nil, noDB
 	}
 
-	var userToken UserToken
-	res := c.db.Preload("User").Where(`value = ?`, value).First(&userToken)
-	err := res.Error
+	hash, err := hashToken(value)
 	if err != nil {
 		return nil, err
 	}
+
+	var userToken UserToken
+	res := c.db.Preload("User").Preload("Machines", unscopedMachines).Where(`hash = ?`, hash).First(&userToken)
+	if err := res.Error; err != nil {
+		return nil, err
+	}
+	userToken.Value = value
 	log.Println("found UserToken with ID:", userToken.ID)
 
 	return &userToken, nil
 }
 
+// unscopedMachines preloads deleted machines too, so that a token limited to machines
+// doesn't become unlimited when they are deleted
+func unscopedMachines(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// ReadUserTokens returns tokens of the user, without their values
+func (c *controller) ReadUserTokens(userID uint) ([]UserToken, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var tokens []UserToken
+	res := c.db.Preload("Machines", unscopedMachines).Where(`user_id = ?`, userID).Order("id").Find(&tokens)
+	if err := res.Error; err != nil {
+		return nil, err
+	}
+	log.Printf("found %d UserToken(s) for user %d\n", len(tokens), userID)
+
+	return tokens, nil
+}
+
 func (c *controller) ReadMachineToken(value pgtype.UUID) (*MachineToken, error) {
 	if c == nil || c.db == nil {
 		return nil, noDB
 	}
 
-	var machineToken MachineToken
-	res := c.db.Preload("Machine").Where(`value = ?`, value).First(&machineToken)
-	err := res.Error
+	hash, err := hashToken(value)
 	if err != nil {
 		return nil, err
 	}
+
+	var machineToken MachineToken
+	res := c.db.Preload("Machine").Where(`hash = ?`, hash).First(&machineToken)
+	if err := res.Error; err != nil {
+		return nil, err
+	}
+	machineToken.Value = value
 	log.Println("found MachineToken with ID:", machineToken.ID)
 
 	return &machineToken, nil
 }
 
+// ReadMachineTokens returns tokens of the machine, without their values
+func (c *controller) ReadMachineTokens(machineID uint) ([]MachineToken, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var tokens []MachineToken
+	res := c.db.Where(`machine_id = ?`, machineID).Order("id").Find(&tokens)
+	if err := res.Error; err != nil {
+		return nil, err
+	}
+	log.Printf("found %d MachineToken(s) for machine %d\n", len(tokens), machineID)
+
+	return tokens, nil
+}
+
 func (c *controller) ReadLoginInfo(username string) (*LoginInfo, error) {
 	if c == nil || c.db == nil {
 		return nil, noDB
@@ -347,101 +695,419 @@ func (c *controller) ReadLoginInfo(username string) (*LoginInfo, error) {
 	return &loginInfo, nil
 }
 
-func (c *controller) ReadRecords(machineName string) ([]Record, error) {
+// ReadTokenRevocations returns revocations which are still needed as of now
+func (c *controller) ReadTokenRevocations(now time.Time) ([]TokenRevocation, error) {
 	if c == nil || c.db == nil {
 		return nil, noDB
 	}
 
-	machine, err := c.ReadMachine(machineName)
-	if err != nil {
+	var revocations []TokenRevocation
+	res := c.db.Where(`expiration > ?`, now).Find(&revocations)
+	if err := res.Error; err != nil {
 		return nil, err
 	}
+	log.Println("found", len(revocations), "TokenRevocation(s)")
 
-	var records []Record
-	res := c.db.Preload("Task").Preload("Machine").Where(`machine_id = ?`, machine.ID).Find(&records)
-	err = res.Error
-	if err != nil {
-		return nil, err
+	return revocations, nil
+}
+
+// ReadSigningKeys returns every signing key, the one activating last first
+func (c *controller) ReadSigningKeys() ([]SigningKey, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
 	}
 
-	log.Printf("found %d Record(s) for machine \"%s\"\n", len(records), machineName)
+	var keys []SigningKey
+	res := c.db.Order("activates_at desc").Order("id desc").Find(&keys)
+	if err := res.Error; err != nil {
+		return nil, err
+	}
+	log.Println("found", len(keys), "SigningKey(s)")
 
-	return records, nil
+	return keys, nil
 }
 
-func (c *controller) UpdateUser(user *User) error {
+func (c *controller) ReadRecord(id uint) (*Record, error) {
 	if c == nil || c.db == nil {
-		return noDB
+		return nil, noDB
 	}
 
-	res := c.db.Save(user)
+	var record Record
+	res := c.db.Preload("Task").Preload("Machine").First(&record, id)
 	err := res.Error
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("Saved User with ID:", user.ID)
 
-	return nil
+	log.Println("found Record with ID:", record.ID)
+
+	return &record, nil
 }
 
-func (c *controller) UpdateMachine(machine *Machine) error {
+func (c *controller) ReadRecords(machineName string, query RecordQuery) ([]Record, error) {
 	if c == nil || c.db == nil {
-		return noDB
+		return nil, noDB
 	}
 
-	res := c.db.Save(machine)
-	err := res.Error
+	machine, err := c.ReadMachine(machineName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("Saved Machine with ID:", machine.ID)
 
-	return nil
+	tx, err := recordQuery(c.db.Preload("Task").Preload("Machine").Where(`machine_id = ?`, machine.ID), query)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	res := tx.Find(&records)
+	err = res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d Record(s) for machine \"%s\"\n", len(records), machineName)
+
+	return records, nil
 }
 
-func (c *controller) UpdateOrganization(org *Organization) error {
+func (c *controller) ReadRecordOutput(recordID uint, step int, stream string) (*RecordOutput, error) {
 	if c == nil || c.db == nil {
-		return noDB
+		return nil, noDB
 	}
 
-	res := c.db.Save(org)
+	var output RecordOutput
+	res := c.db.First(&output, `record_id = ? and step = ? and stream = ?`, recordID, step, stream)
 	err := res.Error
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("Saved Organization with ID:", org.ID)
+	log.Println("found RecordOutput with ID:", output.ID)
 
-	return nil
+	return &output, nil
 }
 
-func (c *controller) UpdateSchedule(schedule *Schedule) error {
-	if c == nil || c.db == nil {
-		return noDB
+// recordQuery applies filters, ordering and limit of query to tx
+func recordQuery(tx *gorm.DB, query RecordQuery) (*gorm.DB, error) {
+	if query.TaskName != "" {
+		tx = tx.Where(`task_id IN (?)`, tx.Session(&gorm.Session{NewDB: true}).Model(&Task{}).Select("id").Where(`name = ?`, query.TaskName))
+	}
+	if len(query.Statuses) > 0 {
+		tx = tx.Where(`status IN ?`, query.Statuses)
+	}
+	if len(query.Outcomes) > 0 {
+		tx = tx.Where(`outcome IN ?`, query.Outcomes)
+	}
+	if !query.ExecutedAfter.IsZero() {
+		tx = tx.Where(`executed_at >= ?`, query.ExecutedAfter)
+	}
+	if !query.ExecutedBefore.IsZero() {
+		tx = tx.Where(`executed_at < ?`, query.ExecutedBefore)
 	}
 
-	res := c.db.Save(schedule)
-	err := res.Error
-	if err != nil {
-		return err
+	sortBy := query.SortBy
+	if sortBy == "" {
+		sortBy = RecordSortExecutedAt
+	}
+	cmp, dir := ">", "ASC"
+	if query.Descending {
+		cmp, dir = "<", "DESC"
 	}
-	log.Println("Saved Schedule with ID:", schedule.ID)
 
-	return nil
+	// ID breaks ties, so that order is stable and cursors can point between equal values
+	switch sortBy {
+	case RecordSortExecutedAt, RecordSortStatus:
+		if query.After != nil {
+			var v interface{} = query.After.ExecutedAt
+			if sortBy == RecordSortStatus {
+				v = query.After.Status
+			}
+			tx = tx.Where(fmt.Sprintf(`((%s %s ?) OR (%s = ? AND id %s ?))`, sortBy, cmp, sortBy, cmp), v, v, query.After.ID)
+		}
+		tx = tx.Order(sortBy + " " + dir).Order("id " + dir)
+	case RecordSortID:
+		if query.After != nil {
+			tx = tx.Where(`id `+cmp+` ?`, query.After.ID)
+		}
+		tx = tx.Order("id " + dir)
+	default:
+		return nil, fmt.Errorf("unknown sort field: %s", sortBy)
+	}
+
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+
+	return tx, nil
 }
 
-func (c *controller) UpdateTask(task *Task) error {
+func (c *controller) ReadTrigger(id uint) (*Trigger, error) {
 	if c == nil || c.db == nil {
-		return noDB
+		return nil, noDB
 	}
 
-	res := c.db.Save(task)
+	var trigger Trigger
+	res := c.db.Preload("Task").Preload("Machine").First(&trigger, id)
 	err := res.Error
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("Saved Task with ID:", task.ID)
+	log.Println("found Trigger with ID:", trigger.ID)
 
-	return nil
+	return &trigger, nil
+}
+
+func (c *controller) ReadTriggers(machineName string, state string) ([]Trigger, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	machine, err := c.ReadMachine(machineName)
+	if err != nil {
+		return nil, err
+	}
+
+	var triggers []Trigger
+	res := c.db.Preload("Task").Preload("Machine").Where(`machine_id = ? and state = ?`, machine.ID, state).Order("id").Find(&triggers)
+	err = res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d %s Trigger(s) for machine \"%s\"\n", len(triggers), state, machineName)
+
+	return triggers, nil
+}
+
+func (c *controller) ReadSecret(organizationID uint, name string) (*Secret, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var secret Secret
+	res := c.db.First(&secret, `organization_id = ? and name = ?`, organizationID, name)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+	log.Println("found Secret with ID:", secret.ID)
+
+	return &secret, nil
+}
+
+func (c *controller) ReadSecrets(organizationID uint) ([]Secret, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var secrets []Secret
+	res := c.db.Where(`organization_id = ?`, organizationID).Order("name").Find(&secrets)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d Secret(s) for organization %d\n", len(secrets), organizationID)
+
+	return secrets, nil
+}
+
+func (c *controller) ReadRetentionPolicy(organizationID uint, taskID uint) (*RetentionPolicy, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var policy RetentionPolicy
+	res := c.db.First(&policy, `organization_id = ? and task_id = ?`, organizationID, taskID)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+	log.Println("found RetentionPolicy with ID:", policy.ID)
+
+	return &policy, nil
+}
+
+// ReadRetentionPolicies reads policies of all organizations
+func (c *controller) ReadRetentionPolicies() ([]RetentionPolicy, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var policies []RetentionPolicy
+	res := c.db.Order("organization_id").Order("task_id").Find(&policies)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d RetentionPolicy(s)\n", len(policies))
+
+	return policies, nil
+}
+
+func (c *controller) ReadEnrollmentTokens(organizationID uint) ([]EnrollmentToken, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var tokens []EnrollmentToken
+	res := c.db.Where(`organization_id = ?`, organizationID).Order("id").Find(&tokens)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d EnrollmentToken(s) for organization %d\n", len(tokens), organizationID)
+
+	return tokens, nil
+}
+
+func (c *controller) ReadMachineGroup(organizationID uint, name string) (*MachineGroup, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var group MachineGroup
+	res := c.db.Preload("Machines").First(&group, `organization_id = ? and name = ?`, organizationID, name)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+	log.Println("found MachineGroup with ID:", group.ID)
+
+	return &group, nil
+}
+
+func (c *controller) ReadMachineGroups(organizationID uint) ([]MachineGroup, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var groups []MachineGroup
+	res := c.db.Preload("Machines").Where(`organization_id = ?`, organizationID).Order("name").Find(&groups)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d MachineGroup(s) for organization %d\n", len(groups), organizationID)
+
+	return groups, nil
+}
+
+// ReadMachineGroupsOf reads groups the machine is a member of, without their members
+func (c *controller) ReadMachineGroupsOf(machineID uint) ([]MachineGroup, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	var groups []MachineGroup
+	res := c.db.
+		Joins(`join machine_group_members on machine_group_members.machine_group_id = machine_groups.id`).
+		Where(`machine_group_members.machine_id = ?`, machineID).
+		Order("name").
+		Find(&groups)
+	err := res.Error
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("found %d MachineGroup(s) for machine %d\n", len(groups), machineID)
+
+	return groups, nil
+}
+
+func (c *controller) UpdateUser(user *User) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(user)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved User with ID:", user.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateMachine(machine *Machine) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(machine)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Machine with ID:", machine.ID)
+
+	return nil
+}
+
+// UpdateMachineHeartbeat saves only the fields updated from heartbeats,
+// so that it doesn't overwrite changes made to the machine meanwhile
+func (c *controller) UpdateMachineHeartbeat(machine *Machine) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Model(machine).Select("Status", "LastSeen", "Version", "Uptime", "ScheduleHash", "Hostname", "OS", "Arch").Updates(machine)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved heartbeat of Machine with ID:", machine.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateOrganization(org *Organization) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(org)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Organization with ID:", org.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateSchedule(schedule *Schedule) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(schedule)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Schedule with ID:", schedule.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateTask(task *Task) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(task)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Task with ID:", task.ID)
+
+	return nil
 }
 
 func (c *controller) UpdateUserToken(userToken *UserToken) error {
@@ -474,6 +1140,31 @@ func (c *controller) UpdateMachineToken(machineToken *MachineToken) error {
 	return nil
 }
 
+// UpdateUserTokenLastUsed records that token was used now.
+// It's only written if the previous use is older than lastUsedPrecision, as tokens are used on every request.
+func (c *controller) UpdateUserTokenLastUsed(id uint, now time.Time) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Model(&UserToken{}).
+		Where(`id = ? AND (last_used_at IS NULL OR last_used_at < ?)`, id, now.Add(-lastUsedPrecision)).
+		UpdateColumn("last_used_at", now)
+	return res.Error
+}
+
+// UpdateMachineTokenLastUsed works like UpdateUserTokenLastUsed
+func (c *controller) UpdateMachineTokenLastUsed(id uint, now time.Time) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Model(&MachineToken{}).
+		Where(`id = ? AND (last_used_at IS NULL OR last_used_at < ?)`, id, now.Add(-lastUsedPrecision)).
+		UpdateColumn("last_used_at", now)
+	return res.Error
+}
+
 func (c *controller) UpdateLoginInfo(loginInfo *LoginInfo) error {
 	if c == nil || c.db == nil {
 		return noDB
@@ -504,6 +1195,51 @@ func (c *controller) UpdateRecord(record *Record) error {
 	return nil
 }
 
+func (c *controller) UpdateTrigger(trigger *Trigger) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(trigger)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Trigger with ID:", trigger.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateSecret(secret *Secret) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(secret)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved Secret with ID:", secret.ID)
+
+	return nil
+}
+
+func (c *controller) UpdateRetentionPolicy(policy *RetentionPolicy) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Save(policy)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved RetentionPolicy with ID:", policy.ID)
+
+	return nil
+}
+
 func (c *controller) DeleteUser(name string) error {
 	if c == nil || c.db == nil {
 		return noDB
@@ -574,29 +1310,33 @@ func (c *controller) DeleteTask(name string) error {
 	return nil
 }
 
-func (c *controller) DeleteUserToken(value pgtype.UUID) error {
+func (c *controller) DeleteUserToken(userID uint, id uint) error {
 	if c == nil || c.db == nil {
 		return noDB
 	}
 
-	res := c.db.Where(`value = ?`, value).Delete(&UserToken{})
-	err := res.Error
-	if err != nil {
+	res := c.db.Where(`user_id = ? and id = ?`, userID, id).Delete(&UserToken{})
+	if err := res.Error; err != nil {
 		return err
 	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
-func (c *controller) DeleteMachineToken(value pgtype.UUID) error {
+func (c *controller) DeleteMachineToken(machineID uint, id uint) error {
 	if c == nil || c.db == nil {
 		return noDB
 	}
 
-	res := c.db.Where(`value = ?`, value).Delete(&MachineToken{})
-	err := res.Error
-	if err != nil {
+	res := c.db.Where(`machine_id = ? and id = ?`, machineID, id).Delete(&MachineToken{})
+	if err := res.Error; err != nil {
 		return err
 	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
@@ -612,23 +1352,70 @@ func (c *controller) DeleteLoginInfo(username string) error {
 	return nil
 }
 
-func (c *controller) DeleteRecords(machineName string) error {
+func (c *controller) DeleteRefreshToken(value pgtype.UUID) error {
 	if c == nil || c.db == nil {
 		return noDB
 	}
 
-	machine, err := c.ReadMachine(machineName)
+	hash, err := hashToken(value)
 	if err != nil {
 		return err
 	}
 
-	res := c.db.Where(`machine_id = ?`, machine.ID).Delete(&Record{})
+	res := c.db.Unscoped().Where(`hash = ?`, hash).Delete(&RefreshToken{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteRefreshTokens deletes every refresh token of the login
+func (c *controller) DeleteRefreshTokens(loginInfoID uint) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Unscoped().Where(`login_info_id = ?`, loginInfoID).Delete(&RefreshToken{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	log.Println("deleted", res.RowsAffected, "RefreshToken(s) of LoginInfo with ID:", loginInfoID)
+	return nil
+}
+
+func (c *controller) DeleteSigningKey(keyID string) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Unscoped().Where(`key_id = ?`, keyID).Delete(&SigningKey{})
 	if err := res.Error; err != nil {
 		return err
 	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
+func (c *controller) DeleteRecords(machineName string) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	machine, err := c.ReadMachine(machineName)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		return deleteRecords(tx, tx.Where(`machine_id = ?`, machine.ID))
+	})
+}
+
 func (c *controller) DeleteRecord(machineName string, recordID uint64) error {
 	if c == nil || c.db == nil {
 		return noDB
@@ -639,9 +1426,401 @@ func (c *controller) DeleteRecord(machineName string, recordID uint64) error {
 		return err
 	}
 
-	res := c.db.Where(`machine_id = ? and id = ?`, machine.ID, recordID).Delete(&Record{})
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		return deleteRecords(tx, tx.Where(`machine_id = ? and id = ?`, machine.ID, recordID))
+	})
+}
+
+// deleteRecords deletes records matching where, along with their stored output.
+// Records are only soft deleted, but their output is not worth keeping.
+func deleteRecords(tx *gorm.DB, where *gorm.DB) error {
+	ids := tx.Session(&gorm.Session{NewDB: true}).Model(&Record{}).Select("id").Where(where)
+	if err := tx.Where(`record_id IN (?)`, ids).Delete(&RecordOutput{}).Error; err != nil {
+		return err
+	}
+	return tx.Where(where).Delete(&Record{}).Error
+}
+
+func (c *controller) DeleteSecret(organizationID uint, name string) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	// encrypted values are not worth keeping around after deletion,
+	// and soft deleted row would prevent creating a secret with the same name
+	res := c.db.Unscoped().Where(`organization_id = ? and name = ?`, organizationID, name).Delete(&Secret{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *controller) DeleteRetentionPolicy(organizationID uint, taskID uint) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	// soft deleted row would prevent creating a new policy for the same task
+	res := c.db.Unscoped().Where(`organization_id = ? and task_id = ?`, organizationID, taskID).Delete(&RetentionPolicy{})
 	if err := res.Error; err != nil {
 		return err
 	}
 	return nil
 }
+
+func (c *controller) UpdateMachineGroup(group *MachineGroup) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	// members are changed one at a time with AddMachineGroupMember and RemoveMachineGroupMember
+	res := c.db.Omit("Machines").Save(group)
+	err := res.Error
+	if err != nil {
+		return err
+	}
+	log.Println("Saved MachineGroup with ID:", group.ID)
+
+	return nil
+}
+
+func (c *controller) AddMachineGroupMember(group *MachineGroup, machine *Machine) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	if err := c.db.Model(group).Association("Machines").Append(machine); err != nil {
+		log.Println("error adding Machine to MachineGroup:", err)
+		return err
+	}
+	log.Println("added Machine with ID:", machine.ID, "to MachineGroup with ID:", group.ID)
+
+	return nil
+}
+
+func (c *controller) RemoveMachineGroupMember(group *MachineGroup, machine *Machine) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	if err := c.db.Model(group).Association("Machines").Delete(machine); err != nil {
+		log.Println("error removing Machine from MachineGroup:", err)
+		return err
+	}
+	log.Println("removed Machine with ID:", machine.ID, "from MachineGroup with ID:", group.ID)
+
+	return nil
+}
+
+func (c *controller) DeleteMachineGroup(organizationID uint, name string) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		var group MachineGroup
+		if err := tx.First(&group, `organization_id = ? and name = ?`, organizationID, name).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Association("Machines").Clear(); err != nil {
+			return err
+		}
+		// soft deleted row would prevent creating a group with the same name
+		return tx.Unscoped().Delete(&group).Error
+	})
+}
+
+func (c *controller) DeleteEnrollmentToken(organizationID uint, id uint) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	res := c.db.Unscoped().Where(`organization_id = ? and id = ?`, organizationID, id).Delete(&EnrollmentToken{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PruneRecords permanently deletes up to batchSize records exceeding limits of policy, as of now.
+// Organization wide policy covers tasks of the organization without their own policy.
+// Records with a non-zero status, other than skipped runs, count as failures.
+// Returns number of deleted records, which is less than batchSize once nothing is left to prune.
+func (c *controller) PruneRecords(policy *RetentionPolicy, now time.Time, batchSize int) (int64, error) {
+	if c == nil || c.db == nil {
+		return 0, noDB
+	}
+
+	var limits []string
+	if policy.MaxAge > 0 {
+		limits = append(limits, `executed_at < @cutoff`)
+	}
+	if policy.MaxCount > 0 {
+		limits = append(limits, `n > @maxCount`)
+	}
+	if len(limits) == 0 {
+		return 0, nil
+	}
+
+	scope := `task_id = @task`
+	if policy.TaskID == 0 {
+		scope = `task_id IN (
+			SELECT id FROM tasks WHERE organization_id = @org AND deleted_at IS NULL AND id NOT IN (
+				SELECT task_id FROM retention_policies WHERE organization_id = @org AND task_id <> 0 AND deleted_at IS NULL
+			)
+		)`
+	}
+
+	// skipped runs and runs which completed with zero status are not failures
+	failed := `(outcome <> @skipped AND (outcome <> @completed OR status <> 0))`
+
+	// n numbers records of each task on each machine from newest to oldest, f does the same for failures only
+	query := `SELECT id FROM (
+		SELECT id, executed_at,
+			ROW_NUMBER() OVER (PARTITION BY task_id, machine_id ORDER BY executed_at DESC, id DESC) AS n,
+			CASE WHEN ` + failed + ` THEN
+				ROW_NUMBER() OVER (PARTITION BY task_id, machine_id, ` + failed + ` ORDER BY executed_at DESC, id DESC)
+			END AS f
+		FROM records WHERE ` + scope + ` AND deleted_at IS NULL
+	) ranked
+	WHERE (` + strings.Join(limits, ` OR `) + `) AND (f IS NULL OR f > @keepFailures)
+	LIMIT @batchSize`
+
+	var ids []uint
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Raw(query, map[string]interface{}{
+			"cutoff":       now.Add(-policy.MaxAge),
+			"maxCount":     policy.MaxCount,
+			"task":         policy.TaskID,
+			"org":          policy.OrganizationID,
+			"skipped":      types.RecordOutcomeSkipped,
+			"completed":    types.RecordOutcomeCompleted,
+			"keepFailures": policy.KeepFailures,
+			"batchSize":    batchSize,
+		}).Scan(&ids)
+		if err := res.Error; err != nil || len(ids) == 0 {
+			return err
+		}
+
+		if err := tx.Where(`record_id IN ?`, ids).Delete(&RecordOutput{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where(`id IN ?`, ids).Delete(&Record{}).Error
+	})
+	if err != nil {
+		log.Println("error pruning Records:", err)
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// EnrollMachine uses enrollment token of the organization to create machine, along with token for it to authenticate with.
+// Token is checked and its use counted in the same transaction, so that it can't be used more times than allowed.
+// ErrEnrollmentTokenInvalid is returned if the token can't be used as of now.
+// ErrMachineExists is returned if the token is valid but the machine name is taken,
+// name is only checked after the token so that names can't be probed without one.
+func (c *controller) EnrollMachine(organizationID uint, value pgtype.UUID, now time.Time, machine *Machine, token *MachineToken) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	etHash, err := hashToken(value)
+	if err != nil {
+		return ErrEnrollmentTokenInvalid
+	}
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		var et EnrollmentToken
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(`organization_id = ? and hash = ?`, organizationID, etHash).
+			Limit(1).
+			Find(&et)
+		if err := res.Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 || !et.Expiration.After(now) || et.Uses >= et.MaxUses {
+			return ErrEnrollmentTokenInvalid
+		}
+
+		var existing Machine
+		res = tx.Where(`name = ?`, machine.Name).Limit(1).Find(&existing)
+		if err := res.Error; err != nil {
+			return err
+		}
+		if res.RowsAffected != 0 {
+			return ErrMachineExists
+		}
+
+		machine.OrganizationID = organizationID
+		if err := tx.Create(machine).Error; err != nil {
+			return err
+		}
+		token.MachineID = machine.ID
+		hash, err := hashToken(token.Value)
+		if err != nil {
+			return err
+		}
+		token.Hash = hash
+		if err := tx.Omit("Machine").Create(token).Error; err != nil {
+			return err
+		}
+		token.Machine = *machine
+
+		return tx.Model(&et).Update("uses", gorm.Expr("uses + 1")).Error
+	})
+	if err != nil {
+		log.Println("error enrolling Machine:", err)
+		return err
+	}
+	log.Println("enrolled Machine with ID:", machine.ID)
+	return nil
+}
+
+// ResetPassword uses password reset of the login to replace its password with hashedPassword.
+// Reset is deleted in the same transaction, so that it can only be used once.
+// ErrPasswordResetInvalid is returned if the reset can't be used as of now.
+func (c *controller) ResetPassword(username string, value pgtype.UUID, now time.Time, hashedPassword string) error {
+	if c == nil || c.db == nil {
+		return noDB
+	}
+
+	hash, err := hashToken(value)
+	if err != nil {
+		return ErrPasswordResetInvalid
+	}
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		var loginInfo LoginInfo
+		res := tx.Where(`username = ?`, username).Limit(1).Find(&loginInfo)
+		if err := res.Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 {
+			return ErrPasswordResetInvalid
+		}
+
+		var reset PasswordReset
+		res = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(`login_info_id = ? and hash = ?`, loginInfo.ID, hash).
+			Limit(1).
+			Find(&reset)
+		if err := res.Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 || !reset.Expiration.After(now) {
+			return ErrPasswordResetInvalid
+		}
+
+		if err := tx.Unscoped().Delete(&reset).Error; err != nil {
+			return err
+		}
+		return tx.Model(&loginInfo).Updates(map[string]interface{}{
+			"password":             hashedPassword,
+			"must_change_password": false,
+		}).Error
+	})
+	if err != nil {
+		log.Println("error resetting password:", err)
+		return err
+	}
+	log.Println("reset password of LoginInfo with username:", username)
+	return nil
+}
+
+// RotateRefreshToken exchanges refresh token for next, which is stored for the same login.
+// Used token is deleted in the same transaction, so that it can only be used once.
+// Returns the login with its user, or ErrRefreshTokenInvalid if the token can't be used as of now.
+func (c *controller) RotateRefreshToken(value pgtype.UUID, now time.Time, next *RefreshToken) (*LoginInfo, error) {
+	if c == nil || c.db == nil {
+		return nil, noDB
+	}
+
+	hash, err := hashToken(value)
+	if err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	nextHash, err := hashToken(next.Value)
+	if err != nil {
+		return nil, err
+	}
+	next.Hash = nextHash
+
+	var loginInfo LoginInfo
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		var token RefreshToken
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(`hash = ?`, hash).
+			Limit(1).
+			Find(&token)
+		if err := res.Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 || !token.Expiration.After(now) {
+			return ErrRefreshTokenInvalid
+		}
+
+		if err := tx.Preload("User").First(&loginInfo, token.LoginInfoID).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&token).Error; err != nil {
+			return err
+		}
+		next.LoginInfoID = token.LoginInfoID
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		log.Println("error rotating RefreshToken:", err)
+		return nil, err
+	}
+	log.Println("inserted RefreshToken with ID:", next.ID)
+	return &loginInfo, nil
+}
+
+// PruneExpiredTokens permanently deletes refresh tokens, password resets and token revocations which have expired as of now.
+// Returns number of deleted rows.
+func (c *controller) PruneExpiredTokens(now time.Time) (int64, error) {
+	if c == nil || c.db == nil {
+		return 0, noDB
+	}
+
+	var pruned int64
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&RefreshToken{}, &PasswordReset{}, &TokenRevocation{}} {
+			res := tx.Unscoped().Where(`expiration <= ?`, now).Delete(model)
+			if err := res.Error; err != nil {
+				return err
+			}
+			pruned += res.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error pruning expired tokens:", err)
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// FailStaleTriggers marks triggers which have been running since before runningSince as failed,
+// as the machine is not going to report a result for them anymore.
+// Returns number of failed triggers.
+func (c *controller) FailStaleTriggers(runningSince time.Time) (int64, error) {
+	if c == nil || c.db == nil {
+		return 0, noDB
+	}
+
+	res := c.db.Model(&Trigger{}).
+		Where(`state = ? AND updated_at < ?`, types.TriggerStateRunning, runningSince).
+		Updates(map[string]interface{}{
+			"state": types.TriggerStateFailed,
+			"error": "no result reported by machine",
+		})
+	if err := res.Error; err != nil {
+		log.Println("error failing stale Triggers:", err)
+		return 0, err
+	}
+	return res.RowsAffected, nil
+}
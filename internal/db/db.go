@@ -34,21 +34,60 @@ func InitializeDB(db *gorm.DB) error {
 	if err := db.AutoMigrate(&User{}); err != nil {
 		return err
 	}
+	hadOutcomes := db.Migrator().HasColumn(&Record{}, "outcome")
 	if err := db.AutoMigrate(&Record{}); err != nil {
 		return err
 	}
+	if !hadOutcomes {
+		if err := migrateRecordOutcomes(db); err != nil {
+			return err
+		}
+	}
+	if err := db.AutoMigrate(&RecordOutput{}); err != nil {
+		return err
+	}
+	// replaced by idx_record_output_stream when stderr started being stored separately
+	if db.Migrator().HasIndex(&RecordOutput{}, "idx_record_output_step") {
+		if err := db.Migrator().DropIndex(&RecordOutput{}, "idx_record_output_step"); err != nil {
+			return err
+		}
+	}
 	if err := db.AutoMigrate(&Machine{}); err != nil {
 		return err
 	}
 	if err := db.AutoMigrate(&LoginInfo{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&PasswordReset{}); err != nil {
+		return err
+	}
+	if err := migrateTokenValues(db, &PasswordReset{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&RefreshToken{}); err != nil {
+		return err
+	}
+	if err := migrateTokenValues(db, &RefreshToken{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&TokenRevocation{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SigningKey{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&MachineToken{}); err != nil {
 		return err
 	}
+	if err := migrateTokenValues(db, &MachineToken{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&UserToken{}); err != nil {
 		return err
 	}
+	if err := migrateTokenValues(db, &UserToken{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&Organization{}); err != nil {
 		return err
 	}
@@ -58,6 +97,24 @@ func InitializeDB(db *gorm.DB) error {
 	if err := db.AutoMigrate(&Task{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&Trigger{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&Secret{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&RetentionPolicy{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&EnrollmentToken{}); err != nil {
+		return err
+	}
+	if err := migrateTokenValues(db, &EnrollmentToken{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&MachineGroup{}); err != nil {
+		return err
+	}
 
 	return nil
 }
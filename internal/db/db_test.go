@@ -225,6 +225,8 @@ func TestDBIntegration(t *testing.T) {
 		ExecutedAt: time.Now(),
 		Status:     3,
 		Output:     "failed",
+		Error:      "step 2 failed",
+		Steps:      StringToJSON(`[{"code":0,"output":"ok"},{"code":3,"output":"failed"}]`),
 	}
 
 	org.Tasks = append(org.Tasks, task)
@@ -254,6 +256,326 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	trigger := Trigger{
+		MachineID: machine.ID,
+		TaskID:    task.ID,
+		State:     types.TriggerStatePending,
+	}
+	t.Run("test trigger creation", func(t *testing.T) {
+		err := c.CreateTrigger(&trigger)
+		if err != nil {
+			t.Fatal("error creating Trigger:", err)
+		}
+	})
+
+	t.Run("test pending trigger read", func(t *testing.T) {
+		triggers, err := c.ReadTriggers(machine.Name, types.TriggerStatePending)
+		if err != nil {
+			t.Fatal("error reading pending Triggers:", err)
+		}
+		if len(triggers) != 1 {
+			t.Fatal("expected 1 pending trigger, got", len(triggers))
+		}
+	})
+
+	t.Run("test trigger update", func(t *testing.T) {
+		trigger.State = types.TriggerStateDone
+		trigger.RecordID = record2.ID
+		err := c.UpdateTrigger(&trigger)
+		if err != nil {
+			t.Fatal("error updating Trigger:", err)
+		}
+		tr, err := c.ReadTrigger(trigger.ID)
+		if err != nil {
+			t.Fatal("error reading Trigger:", err)
+		}
+		if tr.State != types.TriggerStateDone || tr.RecordID != record2.ID {
+			t.Fatal("trigger not updated:", tr)
+		}
+	})
+
+	t.Run("test batch record creation", func(t *testing.T) {
+		trigger2 := Trigger{
+			MachineID: machine.ID,
+			TaskID:    task.ID,
+			State:     types.TriggerStatePending,
+		}
+		if err := c.CreateTrigger(&trigger2); err != nil {
+			t.Fatal("error creating Trigger:", err)
+		}
+
+		records := []Record{
+			{MachineID: machine.ID, TaskID: task.ID, TriggerID: trigger2.ID, ExecutedAt: time.Now(), Output: "first"},
+			// refers to a machine which doesn't exist
+			{MachineID: 999999, TaskID: task.ID, ExecutedAt: time.Now(), Output: "second"},
+			{MachineID: machine.ID, TaskID: task.ID, ExecutedAt: time.Now(), Output: "third"},
+		}
+		errs, err := c.CreateRecords(records)
+		if err != nil {
+			t.Fatal("error creating Records:", err)
+		}
+		if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+			t.Fatal("unexpected results:", errs)
+		}
+		if records[0].ID == 0 || records[2].ID == 0 {
+			t.Fatal("records not assigned IDs")
+		}
+
+		tr, err := c.ReadTrigger(trigger2.ID)
+		if err != nil {
+			t.Fatal("error reading Trigger:", err)
+		}
+		if tr.State != types.TriggerStateDone || tr.RecordID != records[0].ID {
+			t.Fatal("trigger not marked done:", tr)
+		}
+	})
+
+	t.Run("test failing stale triggers", func(t *testing.T) {
+		trigger3 := Trigger{
+			MachineID: machine.ID,
+			TaskID:    task.ID,
+			State:     types.TriggerStateRunning,
+		}
+		if err := c.CreateTrigger(&trigger3); err != nil {
+			t.Fatal("error creating Trigger:", err)
+		}
+
+		if n, err := c.FailStaleTriggers(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Fatal("recently started trigger was failed:", n, err)
+		}
+		if n, err := c.FailStaleTriggers(time.Now().Add(time.Hour)); err != nil || n != 1 {
+			t.Fatal("stale trigger was not failed:", n, err)
+		}
+
+		tr, err := c.ReadTrigger(trigger3.ID)
+		if err != nil {
+			t.Fatal("error reading Trigger:", err)
+		}
+		if tr.State != types.TriggerStateFailed || tr.Error == "" {
+			t.Fatal("trigger not marked failed:", tr)
+		}
+	})
+
+	t.Run("test group trigger creation", func(t *testing.T) {
+		before, err := c.ReadTriggers(machine.Name, types.TriggerStatePending)
+		if err != nil {
+			t.Fatal("error reading pending Triggers:", err)
+		}
+
+		// either all triggers are created or none
+		triggers := []Trigger{
+			{MachineID: machine.ID, TaskID: task.ID, State: types.TriggerStatePending},
+			{MachineID: 999999, TaskID: task.ID, State: types.TriggerStatePending},
+		}
+		if err := c.CreateTriggers(triggers); err == nil {
+			t.Fatal("Trigger for a machine which doesn't exist created")
+		}
+		after, err := c.ReadTriggers(machine.Name, types.TriggerStatePending)
+		if err != nil || len(after) != len(before) {
+			t.Fatal("Triggers partially created:", err, len(after))
+		}
+
+		triggers = []Trigger{
+			{MachineID: machine.ID, TaskID: task.ID, State: types.TriggerStatePending},
+			{MachineID: machine.ID, TaskID: task.ID, State: types.TriggerStatePending},
+		}
+		if err := c.CreateTriggers(triggers); err != nil {
+			t.Fatal("error creating Triggers:", err)
+		}
+		if triggers[0].ID == 0 || triggers[1].ID == 0 {
+			t.Fatal("triggers not assigned IDs")
+		}
+		// leave no pending triggers for later tests
+		for i := range triggers {
+			triggers[i].State = types.TriggerStateFailed
+			if err := c.UpdateTrigger(&triggers[i]); err != nil {
+				t.Fatal("error updating Trigger:", err)
+			}
+		}
+	})
+
+	t.Run("test record output storage", func(t *testing.T) {
+		records := []Record{{
+			MachineID:    machine.ID,
+			TaskID:       task.ID,
+			ExecutedAt:   time.Now(),
+			Output:       "preview",
+			OutputStored: true,
+			Stderr:       "preview",
+			StderrStored: true,
+			Outputs: []RecordOutput{
+				{Step: 0, Stream: StreamStdout, Size: 1 << 20, Data: []byte("compressed output")},
+				{Step: 0, Stream: StreamStderr, Size: 1 << 10, Data: []byte("compressed stderr")},
+			},
+		}}
+		if errs, err := c.CreateRecords(records); err != nil || errs[0] != nil {
+			t.Fatal("error creating Record:", err, errs)
+		}
+
+		output, err := c.ReadRecordOutput(records[0].ID, 0, StreamStdout)
+		if err != nil {
+			t.Fatal("error reading RecordOutput:", err)
+		}
+		if output.Size != 1<<20 || string(output.Data) != "compressed output" {
+			t.Fatal("unexpected output:", output)
+		}
+		output, err = c.ReadRecordOutput(records[0].ID, 0, StreamStderr)
+		if err != nil {
+			t.Fatal("error reading RecordOutput:", err)
+		}
+		if output.Size != 1<<10 || string(output.Data) != "compressed stderr" {
+			t.Fatal("unexpected stderr:", output)
+		}
+		if _, err := c.ReadRecordOutput(records[0].ID, 1, StreamStdout); err == nil {
+			t.Fatal("found output of a step which doesn't exist")
+		}
+	})
+
+	secret := Secret{
+		Name:           "DB_PASSWORD",
+		OrganizationID: org.ID,
+		Value:          []byte("encrypted value"),
+	}
+	t.Run("test secret creation", func(t *testing.T) {
+		err := c.CreateSecret(&secret)
+		if err != nil {
+			t.Fatal("error creating Secret:", err)
+		}
+		duplicate := Secret{
+			Name:           secret.Name,
+			OrganizationID: org.ID,
+			Value:          []byte("another value"),
+		}
+		if err := c.CreateSecret(&duplicate); err == nil {
+			t.Fatal("duplicate Secret created")
+		}
+	})
+
+	t.Run("test secret update", func(t *testing.T) {
+		secret.Value = []byte("new encrypted value")
+		err := c.UpdateSecret(&secret)
+		if err != nil {
+			t.Fatal("error updating Secret:", err)
+		}
+		s, err := c.ReadSecret(org.ID, secret.Name)
+		if err != nil {
+			t.Fatal("error reading Secret:", err)
+		}
+		if string(s.Value) != "new encrypted value" {
+			t.Fatal("secret not updated:", s)
+		}
+		secrets, err := c.ReadSecrets(org.ID)
+		if err != nil || len(secrets) != 1 {
+			t.Fatal("error reading Secrets:", err, secrets)
+		}
+	})
+
+	t.Run("test secret deletion", func(t *testing.T) {
+		err := c.DeleteSecret(org.ID, secret.Name)
+		if err != nil {
+			t.Fatal("error deleting Secret:", err)
+		}
+		if _, err := c.ReadSecret(org.ID, secret.Name); err == nil {
+			t.Fatal("Secret still found after deletion")
+		}
+		// name can be reused after deletion
+		again := Secret{Name: secret.Name, OrganizationID: org.ID, Value: []byte("x")}
+		if err := c.CreateSecret(&again); err != nil {
+			t.Fatal("error recreating Secret:", err)
+		}
+	})
+
+	t.Run("test machine enrollment", func(t *testing.T) {
+		et := EnrollmentToken{
+			Value:          StringToUUID("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"),
+			Expiration:     time.Now().Add(time.Hour),
+			MaxUses:        1,
+			OrganizationID: org.ID,
+		}
+		if err := c.CreateEnrollmentToken(&et); err != nil {
+			t.Fatal("error creating EnrollmentToken:", err)
+		}
+		// only hash of the value is stored
+		var stored EnrollmentToken
+		if err := db.First(&stored, et.ID).Error; err != nil || len(stored.Hash) == 0 || stored.Value.Status == pgtype.Present {
+			t.Fatal("EnrollmentToken not stored hashed:", err, stored)
+		}
+
+		// taken name is rejected without using up the token
+		taken := Machine{Name: machine.Name}
+		takenToken := MachineToken{Value: StringToUUID("2a3b4c5d-6e7f-4801-9a2b-3c4d5e6f7a8b")}
+		if err := c.EnrollMachine(org.ID, et.Value, time.Now(), &taken, &takenToken); err != ErrMachineExists {
+			t.Fatal("taken Machine name accepted:", err)
+		}
+		if err := c.EnrollMachine(org.ID, StringToUUID("3b4c5d6e-7f80-4912-8a3b-4c5d6e7f8a9b"), time.Now(), &taken, &takenToken); err != ErrEnrollmentTokenInvalid {
+			t.Fatal("taken Machine name reported without valid EnrollmentToken:", err)
+		}
+
+		enrolled := Machine{Name: "enrolled-machine", OS: "linux", Arch: "amd64"}
+		token := MachineToken{Value: StringToUUID("1f2e3d4c-5b6a-4978-8695-a4b3c2d1e0f9")}
+		if err := c.EnrollMachine(org.ID, et.Value, time.Now(), &enrolled, &token); err != nil {
+			t.Fatal("error enrolling Machine:", err)
+		}
+		if mt, err := c.ReadMachineToken(token.Value); err != nil || mt.Machine.Name != enrolled.Name {
+			t.Fatal("token of enrolled Machine not found:", err)
+		}
+
+		// token is used up
+		another := Machine{Name: "another-machine"}
+		anotherToken := MachineToken{Value: StringToUUID("0f1e2d3c-4b5a-4697-8879-6a5b4c3d2e1f")}
+		if err := c.EnrollMachine(org.ID, et.Value, time.Now(), &another, &anotherToken); err != ErrEnrollmentTokenInvalid {
+			t.Fatal("used up EnrollmentToken accepted:", err)
+		}
+		if _, err := c.ReadMachine(another.Name); err == nil {
+			t.Fatal("Machine created with used up EnrollmentToken")
+		}
+
+		tokens, err := c.ReadEnrollmentTokens(org.ID)
+		if err != nil || len(tokens) != 1 || tokens[0].Uses != 1 {
+			t.Fatal("error reading EnrollmentTokens:", err, tokens)
+		}
+		if err := c.DeleteEnrollmentToken(org.ID, et.ID); err != nil {
+			t.Fatal("error deleting EnrollmentToken:", err)
+		}
+	})
+
+	t.Run("test machine groups", func(t *testing.T) {
+		group := MachineGroup{
+			Name:           "servers",
+			OrganizationID: org.ID,
+			Schedule:       StringToJSON(`{"cron":[{"taskID":"backup","cron":"0 0 3 * * *"}]}`),
+		}
+		if err := c.CreateMachineGroup(&group); err != nil {
+			t.Fatal("error creating MachineGroup:", err)
+		}
+		if err := c.AddMachineGroupMember(&group, &machine); err != nil {
+			t.Fatal("error adding MachineGroup member:", err)
+		}
+
+		groups, err := c.ReadMachineGroupsOf(machine.ID)
+		if err != nil || len(groups) != 1 || groups[0].Name != group.Name {
+			t.Fatal("error reading MachineGroups of Machine:", err, groups)
+		}
+		read, err := c.ReadMachineGroup(org.ID, group.Name)
+		if err != nil || len(read.Machines) != 1 || read.Machines[0].ID != machine.ID {
+			t.Fatal("error reading MachineGroup:", err, read)
+		}
+
+		if err := c.RemoveMachineGroupMember(read, &machine); err != nil {
+			t.Fatal("error removing MachineGroup member:", err)
+		}
+		if groups, err := c.ReadMachineGroupsOf(machine.ID); err != nil || len(groups) != 0 {
+			t.Fatal("Machine still in MachineGroup:", err, groups)
+		}
+
+		if err := c.DeleteMachineGroup(org.ID, group.Name); err != nil {
+			t.Fatal("error deleting MachineGroup:", err)
+		}
+		if _, err := c.ReadMachineGroup(org.ID, group.Name); err == nil {
+			t.Fatal("MachineGroup still found after deletion")
+		}
+	})
+
 	t.Run("test user read", func(t *testing.T) {
 		name := user.Name
 		u, err := c.ReadUser(name)
@@ -352,6 +674,79 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("test token metadata", func(t *testing.T) {
+		tokens, err := c.ReadUserTokens(userToken.UserID)
+		if err != nil || len(tokens) != 1 || tokens[0].ID != userToken.ID {
+			t.Fatal("error reading UserTokens:", err, tokens)
+		}
+		// only hash of the value is stored
+		if len(tokens[0].Hash) == 0 || tokens[0].Value.Status == pgtype.Present {
+			t.Fatal("UserToken not stored hashed:", tokens[0])
+		}
+		if !tokens[0].LastUsedAt.IsZero() {
+			t.Fatal("unused UserToken has last use:", tokens[0].LastUsedAt)
+		}
+
+		now := time.Now()
+		if err := c.UpdateUserTokenLastUsed(userToken.ID, now); err != nil {
+			t.Fatal("error updating UserToken last use:", err)
+		}
+		// uses within lastUsedPrecision aren't written
+		if err := c.UpdateUserTokenLastUsed(userToken.ID, now.Add(time.Second)); err != nil {
+			t.Fatal("error updating UserToken last use:", err)
+		}
+		tokens, err = c.ReadUserTokens(userToken.UserID)
+		if err != nil || len(tokens) != 1 || !compareTimeWithMilliSecondEpsilon(tokens[0].LastUsedAt, now) {
+			t.Fatal("unexpected UserToken last use:", err, tokens)
+		}
+
+		mt := MachineToken{
+			Value:     StringToUUID("6b3c2a1e-4f5d-4e8b-9a7c-1d2e3f4a5b6c"),
+			Label:     "temporary",
+			MachineID: machineToken.MachineID,
+		}
+		if err := c.CreateMachineToken(&mt); err != nil {
+			t.Fatal("error creating MachineToken:", err)
+		}
+		mtokens, err := c.ReadMachineTokens(mt.MachineID)
+		if err != nil || len(mtokens) != 2 || mtokens[1].Label != "temporary" {
+			t.Fatal("error reading MachineTokens:", err, mtokens)
+		}
+		if err := c.DeleteMachineToken(mt.MachineID+1, mt.ID); err != gorm.ErrRecordNotFound {
+			t.Fatal("MachineToken of another machine deleted:", err)
+		}
+		if err := c.DeleteMachineToken(mt.MachineID, mt.ID); err != nil {
+			t.Fatal("error deleting MachineToken:", err)
+		}
+		if _, err := c.ReadMachineToken(mt.Value); err == nil {
+			t.Fatal("deleted MachineToken still readable")
+		}
+	})
+
+	t.Run("test scoped token", func(t *testing.T) {
+		ut := UserToken{
+			Value:    StringToUUID("0d1e2f3a-4b5c-4d6e-8f7a-9b0c1d2e3f4a"),
+			Scopes:   "records:read tasks:write",
+			Machines: []Machine{machine},
+			UserID:   userToken.UserID,
+		}
+		if err := c.CreateUserToken(&ut); err != nil {
+			t.Fatal("error creating scoped UserToken:", err)
+		}
+
+		read, err := c.ReadUserToken(ut.Value)
+		if err != nil {
+			t.Fatal("error reading scoped UserToken:", err)
+		}
+		if read.Scopes != ut.Scopes || len(read.Machines) != 1 || read.Machines[0].ID != machine.ID {
+			t.Fatal("unexpected scoped UserToken:", read.Scopes, read.Machines)
+		}
+
+		if err := c.DeleteUserToken(ut.UserID, ut.ID); err != nil {
+			t.Fatal("error deleting scoped UserToken:", err)
+		}
+	})
+
 	t.Run("test login info read", func(t *testing.T) {
 		u := loginInfo.Username
 		l, err := c.ReadLoginInfo(u)
@@ -367,7 +762,7 @@ func TestDBIntegration(t *testing.T) {
 	})
 
 	t.Run("test record read", func(t *testing.T) {
-		r, err := c.ReadRecords(machine.Name)
+		r, err := c.ReadRecords(machine.Name, RecordQuery{})
 		if err != nil {
 			t.Fatal("error reading machine Records:", err)
 		}
@@ -376,6 +771,68 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("test individual record read", func(t *testing.T) {
+		r, err := c.ReadRecord(record2.ID)
+		if err != nil {
+			t.Fatal("error reading Record:", err)
+		}
+		if r.Status != record2.Status || r.Task.Name != task.Name {
+			t.Fatal("unexpected record:", r)
+		}
+	})
+
+	t.Run("test filtered record read", func(t *testing.T) {
+		r, err := c.ReadRecords(machine.Name, RecordQuery{TaskName: task.Name, Statuses: []int{3}})
+		if err != nil {
+			t.Fatal("error reading machine Records:", err)
+		}
+		if len(r) != 1 || r[0].ID != record2.ID {
+			t.Fatal("unexpected records:", r)
+		}
+
+		r, err = c.ReadRecords(machine.Name, RecordQuery{TaskName: "no such task"})
+		if err != nil {
+			t.Fatal("error reading machine Records:", err)
+		}
+		if len(r) != 0 {
+			t.Fatal("expected no records, got", len(r))
+		}
+	})
+
+	t.Run("test paginated record read", func(t *testing.T) {
+		all, err := c.ReadRecords(machine.Name, RecordQuery{SortBy: RecordSortExecutedAt, Descending: true})
+		if err != nil {
+			t.Fatal("error reading machine Records:", err)
+		}
+
+		var paged []Record
+		query := RecordQuery{SortBy: RecordSortExecutedAt, Descending: true, Limit: 2}
+		for {
+			r, err := c.ReadRecords(machine.Name, query)
+			if err != nil {
+				t.Fatal("error reading machine Records:", err)
+			}
+			if len(r) == 0 {
+				break
+			}
+			paged = append(paged, r...)
+			cursor := CursorOf(&r[len(r)-1])
+			query.After = &cursor
+		}
+
+		if len(paged) != len(all) {
+			t.Fatal("expected", len(all), "records, got", len(paged))
+		}
+		for i := range all {
+			if paged[i].ID != all[i].ID {
+				t.Fatal("pages out of order at", i)
+			}
+			if i > 0 && all[i].ExecutedAt.After(all[i-1].ExecutedAt) {
+				t.Fatal("records not sorted by executedAt")
+			}
+		}
+	})
+
 	t.Run("update user", func(t *testing.T) {
 		user.Name = "Lassi2"
 		err := c.UpdateUser(&user)
@@ -392,6 +849,25 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("update machine heartbeat", func(t *testing.T) {
+		machine.Status = "online"
+		machine.LastSeen = time.Now().UTC().Truncate(time.Microsecond)
+		machine.Version = "v1.0.0"
+		machine.Uptime = time.Hour
+		err := c.UpdateMachineHeartbeat(&machine)
+		if err != nil {
+			t.Fatal("error updating Machine heartbeat:", err)
+		}
+
+		m, err := c.ReadMachine(machine.Name)
+		if err != nil {
+			t.Fatal("error reading Machine:", err)
+		}
+		if m.Status != "online" || !m.LastSeen.Equal(machine.LastSeen) || m.Uptime != time.Hour {
+			t.Fatal("heartbeat not stored:", m)
+		}
+	})
+
 	t.Run("update organization", func(t *testing.T) {
 		org.Name = "example-org2"
 		err := c.UpdateOrganization(&org)
@@ -442,6 +918,160 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("test password reset", func(t *testing.T) {
+		expired := PasswordReset{
+			Value:       StringToUUID("5d4c3b2a-1f0e-4d9c-8b7a-695847362514"),
+			Expiration:  time.Now().Add(-time.Minute),
+			LoginInfoID: loginInfo.ID,
+		}
+		if err := c.CreatePasswordReset(&expired); err != nil {
+			t.Fatal("error creating PasswordReset:", err)
+		}
+		if err := c.ResetPassword(loginInfo.Username, expired.Value, time.Now(), "hunter2"); err != ErrPasswordResetInvalid {
+			t.Fatal("expired PasswordReset accepted:", err)
+		}
+
+		// creating a new reset invalidates earlier ones
+		reset := PasswordReset{
+			Value:       StringToUUID("a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"),
+			Expiration:  time.Now().Add(time.Hour),
+			LoginInfoID: loginInfo.ID,
+		}
+		if err := c.CreatePasswordReset(&reset); err != nil {
+			t.Fatal("error creating PasswordReset:", err)
+		}
+		// only hash of the value is stored
+		var stored PasswordReset
+		if err := db.First(&stored, reset.ID).Error; err != nil || len(stored.Hash) == 0 || stored.Value.Status == pgtype.Present {
+			t.Fatal("PasswordReset not stored hashed:", err, stored)
+		}
+		if err := c.ResetPassword(loginInfo.Username, expired.Value, time.Now().Add(-time.Hour), "hunter2"); err != ErrPasswordResetInvalid {
+			t.Fatal("replaced PasswordReset accepted:", err)
+		}
+
+		if err := c.ResetPassword(loginInfo.Username, reset.Value, time.Now(), "hunter2"); err != nil {
+			t.Fatal("error resetting password:", err)
+		}
+		if l, err := c.ReadLoginInfo(loginInfo.Username); err != nil || l.Password != "hunter2" || l.MustChangePassword {
+			t.Fatal("password not reset:", err, l)
+		}
+
+		// reset is single use
+		if err := c.ResetPassword(loginInfo.Username, reset.Value, time.Now(), "hunter3"); err != ErrPasswordResetInvalid {
+			t.Fatal("used PasswordReset accepted:", err)
+		}
+		loginInfo.Password = "hunter2"
+	})
+
+	t.Run("test refresh tokens", func(t *testing.T) {
+		token := RefreshToken{
+			Value:       StringToUUID("6b5a4938-2716-4e5d-8c3b-2a1908f7e6d5"),
+			Expiration:  time.Now().Add(time.Hour),
+			LoginInfoID: loginInfo.ID,
+		}
+		if err := c.CreateRefreshToken(&token); err != nil {
+			t.Fatal("error creating RefreshToken:", err)
+		}
+		// only hash of the value is stored
+		var stored RefreshToken
+		if err := db.First(&stored, token.ID).Error; err != nil || len(stored.Hash) == 0 || stored.Value.Status == pgtype.Present {
+			t.Fatal("RefreshToken not stored hashed:", err, stored)
+		}
+
+		next := RefreshToken{
+			Value:      StringToUUID("7c6b5a49-3827-4f6e-9d4c-3b2a1908f7e6"),
+			Expiration: time.Now().Add(time.Hour),
+		}
+		l, err := c.RotateRefreshToken(token.Value, time.Now(), &next)
+		if err != nil || l.ID != loginInfo.ID || l.User.Name != user.Name {
+			t.Fatal("error rotating RefreshToken:", err, l)
+		}
+		if next.LoginInfoID != loginInfo.ID {
+			t.Fatal("rotated RefreshToken not stored for the same login:", next)
+		}
+
+		// token is single use
+		another := RefreshToken{
+			Value:      StringToUUID("8d7c6b5a-4938-4a7f-8e5d-4c3b2a1908f7"),
+			Expiration: time.Now().Add(time.Hour),
+		}
+		if _, err := c.RotateRefreshToken(token.Value, time.Now(), &another); err != ErrRefreshTokenInvalid {
+			t.Fatal("used RefreshToken accepted:", err)
+		}
+		if _, err := c.RotateRefreshToken(next.Value, time.Now().Add(2*time.Hour), &another); err != ErrRefreshTokenInvalid {
+			t.Fatal("expired RefreshToken accepted:", err)
+		}
+
+		if err := c.DeleteRefreshTokens(loginInfo.ID); err != nil {
+			t.Fatal("error deleting RefreshTokens:", err)
+		}
+		if err := c.DeleteRefreshToken(next.Value); err != gorm.ErrRecordNotFound {
+			t.Fatal("RefreshToken not deleted:", err)
+		}
+	})
+
+	t.Run("test token revocations", func(t *testing.T) {
+		now := time.Now()
+		revocations := []TokenRevocation{
+			{TokenID: "e4d3c2b1-a098-4f7e-8d6c-5b4a39281706", Username: user.Name, Expiration: now.Add(time.Hour)},
+			{Username: user.Name, IssuedBefore: now, Expiration: now.Add(time.Minute)},
+		}
+		for i := range revocations {
+			if err := c.CreateTokenRevocation(&revocations[i]); err != nil {
+				t.Fatal("error creating TokenRevocation:", err)
+			}
+		}
+
+		if r, err := c.ReadTokenRevocations(now); err != nil || len(r) != 2 {
+			t.Fatal("error reading TokenRevocations:", err, r)
+		}
+		later := now.Add(30 * time.Minute)
+		if r, err := c.ReadTokenRevocations(later); err != nil || len(r) != 1 || r[0].TokenID != revocations[0].TokenID {
+			t.Fatal("expired TokenRevocation returned:", err, r)
+		}
+
+		if n, err := c.PruneExpiredTokens(later); err != nil || n != 1 {
+			t.Fatal("error pruning expired tokens:", err, n)
+		}
+		if n, err := c.PruneExpiredTokens(now.Add(2 * time.Hour)); err != nil || n != 1 {
+			t.Fatal("error pruning expired tokens:", err, n)
+		}
+	})
+
+	t.Run("test signing keys", func(t *testing.T) {
+		now := time.Now()
+		keys := []SigningKey{
+			{KeyID: "old", Algorithm: "EdDSA", PrivateKey: []byte("sealed old"), ActivatesAt: now.Add(-time.Hour)},
+			{KeyID: "next", Algorithm: "EdDSA", PrivateKey: []byte("sealed next"), ActivatesAt: now.Add(time.Hour)},
+			{KeyID: "current", Algorithm: "EdDSA", PrivateKey: []byte("sealed current"), ActivatesAt: now},
+		}
+		for i := range keys {
+			if err := c.CreateSigningKey(&keys[i]); err != nil {
+				t.Fatal("error creating SigningKey:", err)
+			}
+		}
+
+		read, err := c.ReadSigningKeys()
+		if err != nil || len(read) != 3 {
+			t.Fatal("error reading SigningKeys:", err, read)
+		}
+		if read[0].KeyID != "next" || read[1].KeyID != "current" || read[2].KeyID != "old" {
+			t.Fatal("SigningKeys in wrong order:", read)
+		}
+		if string(read[1].PrivateKey) != "sealed current" {
+			t.Fatal("unexpected SigningKey:", read[1])
+		}
+
+		for _, k := range keys {
+			if err := c.DeleteSigningKey(k.KeyID); err != nil {
+				t.Fatal("error deleting SigningKey:", err)
+			}
+		}
+		if err := c.DeleteSigningKey("old"); err != gorm.ErrRecordNotFound {
+			t.Fatal("SigningKey not deleted:", err)
+		}
+	})
+
 	t.Run("update record", func(t *testing.T) {
 		record.Output = "redacted"
 		err := c.UpdateRecord(&record)
@@ -466,6 +1096,99 @@ func TestDBIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("test retention policy", func(t *testing.T) {
+		task2 := Task{
+			Name:           "another task",
+			Content:        StringToJSON(`{"type":"cmd","program":"true"}`),
+			OrganizationID: org.ID,
+		}
+		if err := c.CreateTask(&task2); err != nil {
+			t.Fatal("error creating Task:", err)
+		}
+
+		// from oldest to newest: failure, success, failure, success
+		base := time.Now().Add(-4 * time.Hour)
+		var taskRecords []Record
+		for i, status := range []int{1, 0, 2, 0} {
+			taskRecords = append(taskRecords, Record{
+				MachineID:  machine.ID,
+				TaskID:     task2.ID,
+				ExecutedAt: base.Add(time.Duration(i) * time.Hour),
+				Status:     status,
+			})
+		}
+		if errs, err := c.CreateRecords(taskRecords); err != nil || errs[0] != nil {
+			t.Fatal("error creating Records:", err, errs)
+		}
+
+		taskPolicy := RetentionPolicy{
+			OrganizationID: org.ID,
+			TaskID:         task2.ID,
+			MaxCount:       1,
+			KeepFailures:   1,
+		}
+		if err := c.CreateRetentionPolicy(&taskPolicy); err != nil {
+			t.Fatal("error creating RetentionPolicy:", err)
+		}
+		orgPolicy := RetentionPolicy{
+			OrganizationID: org.ID,
+			MaxAge:         time.Minute,
+			KeepFailures:   1,
+		}
+		if err := c.CreateRetentionPolicy(&orgPolicy); err != nil {
+			t.Fatal("error creating RetentionPolicy:", err)
+		}
+
+		policies, err := c.ReadRetentionPolicies()
+		if err != nil || len(policies) != 2 {
+			t.Fatal("error reading RetentionPolicies:", err, policies)
+		}
+
+		// newest record and newest failure are kept
+		var pruned int64
+		for {
+			n, err := c.PruneRecords(&taskPolicy, time.Now(), 1)
+			if err != nil {
+				t.Fatal("error pruning Records:", err)
+			}
+			if n == 0 {
+				break
+			}
+			pruned += n
+		}
+		if pruned != 2 {
+			t.Fatal("expected 2 pruned records, got", pruned)
+		}
+		for i, kept := range []bool{false, false, true, true} {
+			_, err := c.ReadRecord(taskRecords[i].ID)
+			if kept != (err == nil) {
+				t.Fatal("unexpected retention of record", i)
+			}
+		}
+
+		// organization wide policy doesn't touch task with its own policy,
+		// and keeps the latest failure of the other task
+		if _, err := c.PruneRecords(&orgPolicy, time.Now().Add(time.Hour), 1000); err != nil {
+			t.Fatal("error pruning Records:", err)
+		}
+		if _, err := c.ReadRecord(taskRecords[3].ID); err != nil {
+			t.Fatal("record covered by task policy pruned by organization policy")
+		}
+		if _, err := c.ReadRecord(record.ID); err == nil {
+			t.Fatal("old record not pruned")
+		}
+		if _, err := c.ReadRecord(record2.ID); err != nil {
+			t.Fatal("latest failure pruned")
+		}
+
+		if err := c.DeleteRetentionPolicy(org.ID, task2.ID); err != nil {
+			t.Fatal("error deleting RetentionPolicy:", err)
+		}
+		if _, err := c.ReadRetentionPolicy(org.ID, task2.ID); err == nil {
+			t.Fatal("RetentionPolicy not deleted")
+		}
+	})
+
 	// delete records before machine, otherwise won't be able to find the records with machine name
 	t.Run("delete individual machine record", func(t *testing.T) {
 		err := c.DeleteRecord(machine.Name, uint64(record2.ID))
@@ -475,10 +1198,24 @@ func TestDBIntegration(t *testing.T) {
 	})
 
 	t.Run("delete machine records", func(t *testing.T) {
+		records := []Record{{
+			MachineID:    machine.ID,
+			TaskID:       task.ID,
+			ExecutedAt:   time.Now(),
+			OutputStored: true,
+			Outputs:      []RecordOutput{{Step: 0, Stream: StreamStdout, Data: []byte("compressed output")}},
+		}}
+		if errs, err := c.CreateRecords(records); err != nil || errs[0] != nil {
+			t.Fatal("error creating Record:", err, errs)
+		}
+
 		err := c.DeleteRecords(machine.Name)
 		if err != nil {
 			t.Fatal("error deleting machine Records:", err)
 		}
+		if _, err := c.ReadRecordOutput(records[0].ID, 0, StreamStdout); err == nil {
+			t.Fatal("RecordOutput not deleted with Record")
+		}
 	})
 
 	t.Run("delete machine", func(t *testing.T) {
@@ -503,14 +1240,14 @@ func TestDBIntegration(t *testing.T) {
 	})
 
 	t.Run("delete user token", func(t *testing.T) {
-		err := c.DeleteUserToken(userToken.Value)
+		err := c.DeleteUserToken(userToken.UserID, userToken.ID)
 		if err != nil {
 			t.Fatal("error deleting UserToken:", err)
 		}
 	})
 
 	t.Run("delete machine token", func(t *testing.T) {
-		err := c.DeleteMachineToken(machineToken.Value)
+		err := c.DeleteMachineToken(machineToken.MachineID, machineToken.ID)
 		if err != nil {
 			t.Fatal("error deleting MachineToken:", err)
 		}
@@ -2,6 +2,9 @@ package dbconverter
 
 import (
 	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgtype"
 
 	"github.com/LassiHeikkila/taskey/internal/db"
 	"github.com/LassiHeikkila/taskey/pkg/types"
@@ -35,13 +38,24 @@ func ConvertUserToDB(user *types.User) db.User {
 	}
 }
 
+// ConvertMachine converts machine, status is left for the caller to determine from heartbeats
 func ConvertMachine(dbmachine *db.Machine) types.Machine {
-	return types.Machine{
+	m := types.Machine{
 		Name:        dbmachine.Name,
 		Description: dbmachine.Description,
 		OS:          dbmachine.OS,
 		Arch:        dbmachine.Arch,
+
+		Version:      dbmachine.Version,
+		ScheduleHash: dbmachine.ScheduleHash,
+		Hostname:     dbmachine.Hostname,
+	}
+	m.Uptime.Duration = dbmachine.Uptime
+	if !dbmachine.LastSeen.IsZero() {
+		lastSeen := dbmachine.LastSeen
+		m.LastSeen = &lastSeen
 	}
+	return m
 }
 
 func ConvertMachineToDB(machine *types.Machine) db.Machine {
@@ -75,23 +89,128 @@ func ConvertTaskToDB(task *types.Task) db.Task {
 }
 
 func ConvertRecord(dbrecord *db.Record) types.Record {
-	return types.Record{
+	var steps []types.ActionResult
+	if len(dbrecord.Steps.Bytes) > 0 {
+		_ = json.Unmarshal(dbrecord.Steps.Bytes, &steps)
+	}
+
+	r := types.Record{
 		ID:          dbrecord.ID,
 		MachineName: dbrecord.Machine.Name,
 		TaskName:    dbrecord.Task.Name,
+		TriggerID:   dbrecord.TriggerID,
 		ExecutedAt:  dbrecord.ExecutedAt,
+		FinishedAt:  dbrecord.FinishedAt,
+		Outcome:     dbrecord.Outcome,
 		Status:      dbrecord.Status,
+		Signal:      dbrecord.Signal,
 		Output:      dbrecord.Output,
+		Stderr:      dbrecord.Stderr,
+		Error:       dbrecord.Error,
+		Steps:       steps,
+
+		OutputTruncated: dbrecord.OutputTruncated,
+		OutputStored:    dbrecord.OutputStored,
+		StderrTruncated: dbrecord.StderrTruncated,
+		StderrStored:    dbrecord.StderrStored,
 	}
+	r.Duration.Duration = dbrecord.Duration
+	return r
 }
 
 func ConvertRecordToDB(record *types.Record) db.Record {
-	return db.Record{
+	r := db.Record{
 		// cannot set Machine or Task
+		TriggerID:  record.TriggerID,
 		ExecutedAt: record.ExecutedAt,
+		FinishedAt: record.FinishedAt,
+		Duration:   record.Duration.Duration,
+		Outcome:    record.Outcome,
 		Status:     record.Status,
+		Signal:     record.Signal,
 		Output:     record.Output,
+		Stderr:     record.Stderr,
+		Error:      record.Error,
+
+		OutputTruncated: record.OutputTruncated,
+		OutputStored:    record.OutputStored,
+		StderrTruncated: record.StderrTruncated,
+		StderrStored:    record.StderrStored,
+	}
+	if r.Outcome == "" {
+		// older machines report outcome in place of status
+		r.Outcome = types.RecordOutcomeCompleted
+		if outcome, ok := db.LegacyOutcome(r.Status); ok {
+			r.Outcome, r.Status = outcome, 0
+		}
+	}
+	if len(record.Steps) > 0 {
+		b, _ := json.Marshal(record.Steps)
+		r.Steps = db.StringToJSON(string(b))
+	}
+	return r
+}
+
+func ConvertTrigger(dbtrigger *db.Trigger) types.Trigger {
+	return types.Trigger{
+		ID:          dbtrigger.ID,
+		MachineName: dbtrigger.Machine.Name,
+		TaskName:    dbtrigger.Task.Name,
+		State:       dbtrigger.State,
+		CreatedAt:   dbtrigger.CreatedAt,
+		Error:       dbtrigger.Error,
+	}
+}
+
+// ConvertSecret converts secret metadata, the value is left out as it is stored encrypted
+func ConvertSecret(dbsecret *db.Secret) types.Secret {
+	return types.Secret{
+		Name:      dbsecret.Name,
+		CreatedAt: dbsecret.CreatedAt,
+		UpdatedAt: dbsecret.UpdatedAt,
+	}
+}
+
+// ConvertRetentionPolicy converts policy, taskName is empty for organization wide policy
+func ConvertRetentionPolicy(dbpolicy *db.RetentionPolicy, taskName string) types.RetentionPolicy {
+	p := types.RetentionPolicy{
+		Task:         taskName,
+		MaxCount:     dbpolicy.MaxCount,
+		KeepFailures: dbpolicy.KeepFailures,
 	}
+	p.MaxAge.Duration = dbpolicy.MaxAge
+	return p
+}
+
+// ConvertEnrollmentToken converts token metadata, the value is only returned when the token is created
+func ConvertEnrollmentToken(dbtoken *db.EnrollmentToken) types.EnrollmentToken {
+	return types.EnrollmentToken{
+		ID:          dbtoken.ID,
+		Description: dbtoken.Description,
+		Expiration:  dbtoken.Expiration,
+		MaxUses:     dbtoken.MaxUses,
+		Uses:        dbtoken.Uses,
+		CreatedAt:   dbtoken.CreatedAt,
+	}
+}
+
+// ConvertMachineGroup converts group with names of its members, schedule is nil if group doesn't have one
+func ConvertMachineGroup(dbgroup *db.MachineGroup) types.MachineGroup {
+	g := types.MachineGroup{
+		Name:        dbgroup.Name,
+		Description: dbgroup.Description,
+		Machines:    make([]string, 0, len(dbgroup.Machines)),
+	}
+	for i := range dbgroup.Machines {
+		g.Machines = append(g.Machines, dbgroup.Machines[i].Name)
+	}
+	if dbgroup.Schedule.Status == pgtype.Present {
+		var s types.Schedule
+		if err := json.Unmarshal(dbgroup.Schedule.Bytes, &s); err == nil {
+			g.Schedule = &s
+		}
+	}
+	return g
 }
 
 func ConvertSchedule(dbschedule *db.Schedule) types.Schedule {
@@ -120,3 +239,33 @@ func ConvertMachineToken(dbtoken *db.MachineToken) types.MachineToken {
 	_ = dbtoken.Value.AssignTo(&s)
 	return types.MachineToken(s)
 }
+
+// ConvertUserTokenInfo converts token metadata, the value is only returned when the token is created
+func ConvertUserTokenInfo(dbtoken *db.UserToken) types.TokenInfo {
+	t := convertTokenInfo(dbtoken.ID, dbtoken.Label, dbtoken.CreatedAt, dbtoken.Expiration, dbtoken.LastUsedAt)
+	t.Scopes = types.ParseScopes(dbtoken.Scopes)
+	for i := range dbtoken.Machines {
+		t.Machines = append(t.Machines, dbtoken.Machines[i].Name)
+	}
+	return t
+}
+
+// ConvertMachineTokenInfo converts token metadata, the value is only returned when the token is created
+func ConvertMachineTokenInfo(dbtoken *db.MachineToken) types.TokenInfo {
+	return convertTokenInfo(dbtoken.ID, dbtoken.Label, dbtoken.CreatedAt, dbtoken.Expiration, dbtoken.LastUsedAt)
+}
+
+func convertTokenInfo(id uint, label string, createdAt time.Time, expiration time.Time, lastUsedAt time.Time) types.TokenInfo {
+	t := types.TokenInfo{
+		ID:        id,
+		Label:     label,
+		CreatedAt: createdAt,
+	}
+	if !expiration.IsZero() {
+		t.Expiration = &expiration
+	}
+	if !lastUsedAt.IsZero() {
+		t.LastUsedAt = &lastUsedAt
+	}
+	return t
+}
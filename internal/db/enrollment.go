@@ -0,0 +1,21 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+	"gorm.io/gorm"
+)
+
+// EnrollmentToken lets machines register themselves to an organization, a limited number of times before it expires.
+// Value is only known when the token is created, Hash of it is stored to look it up.
+type EnrollmentToken struct {
+	gorm.Model
+	Value          pgtype.UUID `gorm:"-"`
+	Hash           []byte      `gorm:"uniqueIndex"`
+	Description    string
+	Expiration     time.Time `gorm:"not null"`
+	MaxUses        int       `gorm:"not null"`
+	Uses           int       `gorm:"not null"`
+	OrganizationID uint      `gorm:"not null;index"`
+}
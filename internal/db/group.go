@@ -0,0 +1,16 @@
+package db
+
+import (
+	"github.com/jackc/pgtype"
+	"gorm.io/gorm"
+)
+
+// MachineGroup lets the same schedule be applied to several machines of an organization
+type MachineGroup struct {
+	gorm.Model
+	Name           string `gorm:"not null;uniqueIndex:idx_machine_group_org_name"`
+	OrganizationID uint   `gorm:"not null;uniqueIndex:idx_machine_group_org_name"`
+	Description    string
+	Machines       []Machine   `gorm:"many2many:machine_group_members"`
+	Schedule       pgtype.JSON // merged into schedules of member machines, null if group has no schedule
+}
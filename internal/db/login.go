@@ -1,6 +1,9 @@
 package db
 
 import (
+	"time"
+
+	"github.com/jackc/pgtype"
 	"gorm.io/gorm"
 )
 
@@ -10,4 +13,37 @@ type LoginInfo struct {
 	Password string `gorm:"not null"`
 	UserID   uint   `gorm:"not null"`
 	User     User
+	// set for new users and temporary passwords, login is refused until user sets their own password
+	MustChangePassword bool `gorm:"not null;default:false"`
+}
+
+// PasswordReset lets a user set a new password once without knowing the current one, before it expires.
+// Value is only known when the reset is created, Hash of it is stored to look it up.
+type PasswordReset struct {
+	gorm.Model
+	Value       pgtype.UUID `gorm:"-"`
+	Hash        []byte      `gorm:"uniqueIndex"`
+	Expiration  time.Time   `gorm:"not null"`
+	LoginInfoID uint        `gorm:"not null;index"`
+}
+
+// RefreshToken can be exchanged once for a new access token and refresh token, before it expires.
+// Value is only known when the token is created, Hash of it is stored to look it up.
+type RefreshToken struct {
+	gorm.Model
+	Value       pgtype.UUID `gorm:"-"`
+	Hash        []byte      `gorm:"uniqueIndex"`
+	Expiration  time.Time   `gorm:"not null"`
+	LoginInfoID uint        `gorm:"not null;index"`
+}
+
+// TokenRevocation rejects the user access token identified by TokenID.
+// Without TokenID, every access token of the user issued before IssuedBefore is rejected.
+// Revocation is needed only until the revoked tokens would have expired anyway.
+type TokenRevocation struct {
+	gorm.Model
+	TokenID      string `gorm:"index"`
+	Username     string `gorm:"not null"`
+	IssuedBefore time.Time
+	Expiration   time.Time `gorm:"not null;index"`
 }
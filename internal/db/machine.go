@@ -1,6 +1,8 @@
 package db
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,4 +14,12 @@ type Machine struct {
 	Arch           string
 	OrganizationID uint     `gorm:"not null"`
 	Records        []Record `gorm:"foreignKey:MachineID"`
+
+	// updated from heartbeats of the machine
+	Status       string // as reported by the machine
+	LastSeen     time.Time
+	Version      string
+	Uptime       time.Duration
+	ScheduleHash string
+	Hostname     string
 }
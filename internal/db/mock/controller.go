@@ -6,6 +6,7 @@ package mock_db
 
 import (
 	reflect "reflect"
+	time "time"
 
 	db "github.com/LassiHeikkila/taskey/internal/db"
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,34 @@ func (m *MockController) EXPECT() *MockControllerMockRecorder {
 	return m.recorder
 }
 
+// AddMachineGroupMember mocks base method.
+func (m *MockController) AddMachineGroupMember(arg0 *db.MachineGroup, arg1 *db.Machine) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddMachineGroupMember", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddMachineGroupMember indicates an expected call of AddMachineGroupMember.
+func (mr *MockControllerMockRecorder) AddMachineGroupMember(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMachineGroupMember", reflect.TypeOf((*MockController)(nil).AddMachineGroupMember), arg0, arg1)
+}
+
+// CreateEnrollmentToken mocks base method.
+func (m *MockController) CreateEnrollmentToken(arg0 *db.EnrollmentToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateEnrollmentToken", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateEnrollmentToken indicates an expected call of CreateEnrollmentToken.
+func (mr *MockControllerMockRecorder) CreateEnrollmentToken(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEnrollmentToken", reflect.TypeOf((*MockController)(nil).CreateEnrollmentToken), arg0)
+}
+
 // CreateLoginInfo mocks base method.
 func (m *MockController) CreateLoginInfo(arg0 *db.LoginInfo) error {
 	m.ctrl.T.Helper()
@@ -63,6 +92,20 @@ func (mr *MockControllerMockRecorder) CreateMachine(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMachine", reflect.TypeOf((*MockController)(nil).CreateMachine), arg0)
 }
 
+// CreateMachineGroup mocks base method.
+func (m *MockController) CreateMachineGroup(arg0 *db.MachineGroup) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMachineGroup", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateMachineGroup indicates an expected call of CreateMachineGroup.
+func (mr *MockControllerMockRecorder) CreateMachineGroup(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMachineGroup", reflect.TypeOf((*MockController)(nil).CreateMachineGroup), arg0)
+}
+
 // CreateMachineToken mocks base method.
 func (m *MockController) CreateMachineToken(arg0 *db.MachineToken) error {
 	m.ctrl.T.Helper()
@@ -91,6 +134,20 @@ func (mr *MockControllerMockRecorder) CreateOrganization(arg0 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrganization", reflect.TypeOf((*MockController)(nil).CreateOrganization), arg0)
 }
 
+// CreatePasswordReset mocks base method.
+func (m *MockController) CreatePasswordReset(arg0 *db.PasswordReset) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePasswordReset", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreatePasswordReset indicates an expected call of CreatePasswordReset.
+func (mr *MockControllerMockRecorder) CreatePasswordReset(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePasswordReset", reflect.TypeOf((*MockController)(nil).CreatePasswordReset), arg0)
+}
+
 // CreateRecord mocks base method.
 func (m *MockController) CreateRecord(arg0 *db.Record) error {
 	m.ctrl.T.Helper()
@@ -105,6 +162,49 @@ func (mr *MockControllerMockRecorder) CreateRecord(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRecord", reflect.TypeOf((*MockController)(nil).CreateRecord), arg0)
 }
 
+// CreateRecords mocks base method.
+func (m *MockController) CreateRecords(arg0 []db.Record) ([]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRecords", arg0)
+	ret0, _ := ret[0].([]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateRecords indicates an expected call of CreateRecords.
+func (mr *MockControllerMockRecorder) CreateRecords(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRecords", reflect.TypeOf((*MockController)(nil).CreateRecords), arg0)
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockController) CreateRefreshToken(arg0 *db.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockControllerMockRecorder) CreateRefreshToken(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockController)(nil).CreateRefreshToken), arg0)
+}
+
+// CreateRetentionPolicy mocks base method.
+func (m *MockController) CreateRetentionPolicy(arg0 *db.RetentionPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRetentionPolicy", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRetentionPolicy indicates an expected call of CreateRetentionPolicy.
+func (mr *MockControllerMockRecorder) CreateRetentionPolicy(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRetentionPolicy", reflect.TypeOf((*MockController)(nil).CreateRetentionPolicy), arg0)
+}
+
 // CreateSchedule mocks base method.
 func (m *MockController) CreateSchedule(arg0 *db.Schedule) error {
 	m.ctrl.T.Helper()
@@ -119,6 +219,34 @@ func (mr *MockControllerMockRecorder) CreateSchedule(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSchedule", reflect.TypeOf((*MockController)(nil).CreateSchedule), arg0)
 }
 
+// CreateSecret mocks base method.
+func (m *MockController) CreateSecret(arg0 *db.Secret) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSecret", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSecret indicates an expected call of CreateSecret.
+func (mr *MockControllerMockRecorder) CreateSecret(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSecret", reflect.TypeOf((*MockController)(nil).CreateSecret), arg0)
+}
+
+// CreateSigningKey mocks base method.
+func (m *MockController) CreateSigningKey(arg0 *db.SigningKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSigningKey", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSigningKey indicates an expected call of CreateSigningKey.
+func (mr *MockControllerMockRecorder) CreateSigningKey(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSigningKey", reflect.TypeOf((*MockController)(nil).CreateSigningKey), arg0)
+}
+
 // CreateTask mocks base method.
 func (m *MockController) CreateTask(arg0 *db.Task) error {
 	m.ctrl.T.Helper()
@@ -133,6 +261,48 @@ func (mr *MockControllerMockRecorder) CreateTask(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTask", reflect.TypeOf((*MockController)(nil).CreateTask), arg0)
 }
 
+// CreateTokenRevocation mocks base method.
+func (m *MockController) CreateTokenRevocation(arg0 *db.TokenRevocation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTokenRevocation", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateTokenRevocation indicates an expected call of CreateTokenRevocation.
+func (mr *MockControllerMockRecorder) CreateTokenRevocation(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTokenRevocation", reflect.TypeOf((*MockController)(nil).CreateTokenRevocation), arg0)
+}
+
+// CreateTrigger mocks base method.
+func (m *MockController) CreateTrigger(arg0 *db.Trigger) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTrigger", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateTrigger indicates an expected call of CreateTrigger.
+func (mr *MockControllerMockRecorder) CreateTrigger(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTrigger", reflect.TypeOf((*MockController)(nil).CreateTrigger), arg0)
+}
+
+// CreateTriggers mocks base method.
+func (m *MockController) CreateTriggers(arg0 []db.Trigger) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTriggers", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateTriggers indicates an expected call of CreateTriggers.
+func (mr *MockControllerMockRecorder) CreateTriggers(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTriggers", reflect.TypeOf((*MockController)(nil).CreateTriggers), arg0)
+}
+
 // CreateUser mocks base method.
 func (m *MockController) CreateUser(arg0 *db.User) error {
 	m.ctrl.T.Helper()
@@ -161,6 +331,20 @@ func (mr *MockControllerMockRecorder) CreateUserToken(arg0 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUserToken", reflect.TypeOf((*MockController)(nil).CreateUserToken), arg0)
 }
 
+// DeleteEnrollmentToken mocks base method.
+func (m *MockController) DeleteEnrollmentToken(arg0, arg1 uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteEnrollmentToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteEnrollmentToken indicates an expected call of DeleteEnrollmentToken.
+func (mr *MockControllerMockRecorder) DeleteEnrollmentToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteEnrollmentToken", reflect.TypeOf((*MockController)(nil).DeleteEnrollmentToken), arg0, arg1)
+}
+
 // DeleteLoginInfo mocks base method.
 func (m *MockController) DeleteLoginInfo(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -189,18 +373,32 @@ func (mr *MockControllerMockRecorder) DeleteMachine(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMachine", reflect.TypeOf((*MockController)(nil).DeleteMachine), arg0)
 }
 
+// DeleteMachineGroup mocks base method.
+func (m *MockController) DeleteMachineGroup(arg0 uint, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMachineGroup", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMachineGroup indicates an expected call of DeleteMachineGroup.
+func (mr *MockControllerMockRecorder) DeleteMachineGroup(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMachineGroup", reflect.TypeOf((*MockController)(nil).DeleteMachineGroup), arg0, arg1)
+}
+
 // DeleteMachineToken mocks base method.
-func (m *MockController) DeleteMachineToken(arg0 pgtype.UUID) error {
+func (m *MockController) DeleteMachineToken(arg0, arg1 uint) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteMachineToken", arg0)
+	ret := m.ctrl.Call(m, "DeleteMachineToken", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteMachineToken indicates an expected call of DeleteMachineToken.
-func (mr *MockControllerMockRecorder) DeleteMachineToken(arg0 interface{}) *gomock.Call {
+func (mr *MockControllerMockRecorder) DeleteMachineToken(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMachineToken", reflect.TypeOf((*MockController)(nil).DeleteMachineToken), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMachineToken", reflect.TypeOf((*MockController)(nil).DeleteMachineToken), arg0, arg1)
 }
 
 // DeleteOrganization mocks base method.
@@ -245,6 +443,48 @@ func (mr *MockControllerMockRecorder) DeleteRecords(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRecords", reflect.TypeOf((*MockController)(nil).DeleteRecords), arg0)
 }
 
+// DeleteRefreshToken mocks base method.
+func (m *MockController) DeleteRefreshToken(arg0 pgtype.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRefreshToken", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRefreshToken indicates an expected call of DeleteRefreshToken.
+func (mr *MockControllerMockRecorder) DeleteRefreshToken(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRefreshToken", reflect.TypeOf((*MockController)(nil).DeleteRefreshToken), arg0)
+}
+
+// DeleteRefreshTokens mocks base method.
+func (m *MockController) DeleteRefreshTokens(arg0 uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRefreshTokens", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRefreshTokens indicates an expected call of DeleteRefreshTokens.
+func (mr *MockControllerMockRecorder) DeleteRefreshTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRefreshTokens", reflect.TypeOf((*MockController)(nil).DeleteRefreshTokens), arg0)
+}
+
+// DeleteRetentionPolicy mocks base method.
+func (m *MockController) DeleteRetentionPolicy(arg0, arg1 uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRetentionPolicy", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRetentionPolicy indicates an expected call of DeleteRetentionPolicy.
+func (mr *MockControllerMockRecorder) DeleteRetentionPolicy(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRetentionPolicy", reflect.TypeOf((*MockController)(nil).DeleteRetentionPolicy), arg0, arg1)
+}
+
 // DeleteSchedule mocks base method.
 func (m *MockController) DeleteSchedule(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -259,6 +499,34 @@ func (mr *MockControllerMockRecorder) DeleteSchedule(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSchedule", reflect.TypeOf((*MockController)(nil).DeleteSchedule), arg0)
 }
 
+// DeleteSecret mocks base method.
+func (m *MockController) DeleteSecret(arg0 uint, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSecret", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSecret indicates an expected call of DeleteSecret.
+func (mr *MockControllerMockRecorder) DeleteSecret(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSecret", reflect.TypeOf((*MockController)(nil).DeleteSecret), arg0, arg1)
+}
+
+// DeleteSigningKey mocks base method.
+func (m *MockController) DeleteSigningKey(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSigningKey", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSigningKey indicates an expected call of DeleteSigningKey.
+func (mr *MockControllerMockRecorder) DeleteSigningKey(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSigningKey", reflect.TypeOf((*MockController)(nil).DeleteSigningKey), arg0)
+}
+
 // DeleteTask mocks base method.
 func (m *MockController) DeleteTask(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -288,17 +556,46 @@ func (mr *MockControllerMockRecorder) DeleteUser(arg0 interface{}) *gomock.Call
 }
 
 // DeleteUserToken mocks base method.
-func (m *MockController) DeleteUserToken(arg0 pgtype.UUID) error {
+func (m *MockController) DeleteUserToken(arg0, arg1 uint) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteUserToken", arg0)
+	ret := m.ctrl.Call(m, "DeleteUserToken", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteUserToken indicates an expected call of DeleteUserToken.
-func (mr *MockControllerMockRecorder) DeleteUserToken(arg0 interface{}) *gomock.Call {
+func (mr *MockControllerMockRecorder) DeleteUserToken(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserToken", reflect.TypeOf((*MockController)(nil).DeleteUserToken), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserToken", reflect.TypeOf((*MockController)(nil).DeleteUserToken), arg0, arg1)
+}
+
+// EnrollMachine mocks base method.
+func (m *MockController) EnrollMachine(arg0 uint, arg1 pgtype.UUID, arg2 time.Time, arg3 *db.Machine, arg4 *db.MachineToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnrollMachine", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnrollMachine indicates an expected call of EnrollMachine.
+func (mr *MockControllerMockRecorder) EnrollMachine(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnrollMachine", reflect.TypeOf((*MockController)(nil).EnrollMachine), arg0, arg1, arg2, arg3, arg4)
+}
+
+// FailStaleTriggers mocks base method.
+func (m *MockController) FailStaleTriggers(arg0 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailStaleTriggers", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailStaleTriggers indicates an expected call of FailStaleTriggers.
+func (mr *MockControllerMockRecorder) FailStaleTriggers(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailStaleTriggers", reflect.TypeOf((*MockController)(nil).FailStaleTriggers), arg0)
 }
 
 // LoadModel mocks base method.
@@ -315,6 +612,51 @@ func (mr *MockControllerMockRecorder) LoadModel(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadModel", reflect.TypeOf((*MockController)(nil).LoadModel), arg0, arg1)
 }
 
+// PruneExpiredTokens mocks base method.
+func (m *MockController) PruneExpiredTokens(arg0 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneExpiredTokens", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneExpiredTokens indicates an expected call of PruneExpiredTokens.
+func (mr *MockControllerMockRecorder) PruneExpiredTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneExpiredTokens", reflect.TypeOf((*MockController)(nil).PruneExpiredTokens), arg0)
+}
+
+// PruneRecords mocks base method.
+func (m *MockController) PruneRecords(arg0 *db.RetentionPolicy, arg1 time.Time, arg2 int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneRecords", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneRecords indicates an expected call of PruneRecords.
+func (mr *MockControllerMockRecorder) PruneRecords(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneRecords", reflect.TypeOf((*MockController)(nil).PruneRecords), arg0, arg1, arg2)
+}
+
+// ReadEnrollmentTokens mocks base method.
+func (m *MockController) ReadEnrollmentTokens(arg0 uint) ([]db.EnrollmentToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadEnrollmentTokens", arg0)
+	ret0, _ := ret[0].([]db.EnrollmentToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadEnrollmentTokens indicates an expected call of ReadEnrollmentTokens.
+func (mr *MockControllerMockRecorder) ReadEnrollmentTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadEnrollmentTokens", reflect.TypeOf((*MockController)(nil).ReadEnrollmentTokens), arg0)
+}
+
 // ReadLoginInfo mocks base method.
 func (m *MockController) ReadLoginInfo(arg0 string) (*db.LoginInfo, error) {
 	m.ctrl.T.Helper()
@@ -345,6 +687,51 @@ func (mr *MockControllerMockRecorder) ReadMachine(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachine", reflect.TypeOf((*MockController)(nil).ReadMachine), arg0)
 }
 
+// ReadMachineGroup mocks base method.
+func (m *MockController) ReadMachineGroup(arg0 uint, arg1 string) (*db.MachineGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMachineGroup", arg0, arg1)
+	ret0, _ := ret[0].(*db.MachineGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMachineGroup indicates an expected call of ReadMachineGroup.
+func (mr *MockControllerMockRecorder) ReadMachineGroup(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachineGroup", reflect.TypeOf((*MockController)(nil).ReadMachineGroup), arg0, arg1)
+}
+
+// ReadMachineGroups mocks base method.
+func (m *MockController) ReadMachineGroups(arg0 uint) ([]db.MachineGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMachineGroups", arg0)
+	ret0, _ := ret[0].([]db.MachineGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMachineGroups indicates an expected call of ReadMachineGroups.
+func (mr *MockControllerMockRecorder) ReadMachineGroups(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachineGroups", reflect.TypeOf((*MockController)(nil).ReadMachineGroups), arg0)
+}
+
+// ReadMachineGroupsOf mocks base method.
+func (m *MockController) ReadMachineGroupsOf(arg0 uint) ([]db.MachineGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMachineGroupsOf", arg0)
+	ret0, _ := ret[0].([]db.MachineGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMachineGroupsOf indicates an expected call of ReadMachineGroupsOf.
+func (mr *MockControllerMockRecorder) ReadMachineGroupsOf(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachineGroupsOf", reflect.TypeOf((*MockController)(nil).ReadMachineGroupsOf), arg0)
+}
+
 // ReadMachineToken mocks base method.
 func (m *MockController) ReadMachineToken(arg0 pgtype.UUID) (*db.MachineToken, error) {
 	m.ctrl.T.Helper()
@@ -360,6 +747,21 @@ func (mr *MockControllerMockRecorder) ReadMachineToken(arg0 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachineToken", reflect.TypeOf((*MockController)(nil).ReadMachineToken), arg0)
 }
 
+// ReadMachineTokens mocks base method.
+func (m *MockController) ReadMachineTokens(arg0 uint) ([]db.MachineToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMachineTokens", arg0)
+	ret0, _ := ret[0].([]db.MachineToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMachineTokens indicates an expected call of ReadMachineTokens.
+func (mr *MockControllerMockRecorder) ReadMachineTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMachineTokens", reflect.TypeOf((*MockController)(nil).ReadMachineTokens), arg0)
+}
+
 // ReadOrganization mocks base method.
 func (m *MockController) ReadOrganization(arg0 string) (*db.Organization, error) {
 	m.ctrl.T.Helper()
@@ -375,19 +777,79 @@ func (mr *MockControllerMockRecorder) ReadOrganization(arg0 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadOrganization", reflect.TypeOf((*MockController)(nil).ReadOrganization), arg0)
 }
 
+// ReadRecord mocks base method.
+func (m *MockController) ReadRecord(arg0 uint) (*db.Record, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadRecord", arg0)
+	ret0, _ := ret[0].(*db.Record)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadRecord indicates an expected call of ReadRecord.
+func (mr *MockControllerMockRecorder) ReadRecord(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRecord", reflect.TypeOf((*MockController)(nil).ReadRecord), arg0)
+}
+
+// ReadRecordOutput mocks base method.
+func (m *MockController) ReadRecordOutput(arg0 uint, arg1 int, arg2 string) (*db.RecordOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadRecordOutput", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*db.RecordOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadRecordOutput indicates an expected call of ReadRecordOutput.
+func (mr *MockControllerMockRecorder) ReadRecordOutput(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRecordOutput", reflect.TypeOf((*MockController)(nil).ReadRecordOutput), arg0, arg1, arg2)
+}
+
 // ReadRecords mocks base method.
-func (m *MockController) ReadRecords(arg0 string) ([]db.Record, error) {
+func (m *MockController) ReadRecords(arg0 string, arg1 db.RecordQuery) ([]db.Record, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ReadRecords", arg0)
+	ret := m.ctrl.Call(m, "ReadRecords", arg0, arg1)
 	ret0, _ := ret[0].([]db.Record)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ReadRecords indicates an expected call of ReadRecords.
-func (mr *MockControllerMockRecorder) ReadRecords(arg0 interface{}) *gomock.Call {
+func (mr *MockControllerMockRecorder) ReadRecords(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRecords", reflect.TypeOf((*MockController)(nil).ReadRecords), arg0, arg1)
+}
+
+// ReadRetentionPolicies mocks base method.
+func (m *MockController) ReadRetentionPolicies() ([]db.RetentionPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadRetentionPolicies")
+	ret0, _ := ret[0].([]db.RetentionPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadRetentionPolicies indicates an expected call of ReadRetentionPolicies.
+func (mr *MockControllerMockRecorder) ReadRetentionPolicies() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRecords", reflect.TypeOf((*MockController)(nil).ReadRecords), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRetentionPolicies", reflect.TypeOf((*MockController)(nil).ReadRetentionPolicies))
+}
+
+// ReadRetentionPolicy mocks base method.
+func (m *MockController) ReadRetentionPolicy(arg0, arg1 uint) (*db.RetentionPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadRetentionPolicy", arg0, arg1)
+	ret0, _ := ret[0].(*db.RetentionPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadRetentionPolicy indicates an expected call of ReadRetentionPolicy.
+func (mr *MockControllerMockRecorder) ReadRetentionPolicy(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadRetentionPolicy", reflect.TypeOf((*MockController)(nil).ReadRetentionPolicy), arg0, arg1)
 }
 
 // ReadSchedule mocks base method.
@@ -405,6 +867,51 @@ func (mr *MockControllerMockRecorder) ReadSchedule(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadSchedule", reflect.TypeOf((*MockController)(nil).ReadSchedule), arg0)
 }
 
+// ReadSecret mocks base method.
+func (m *MockController) ReadSecret(arg0 uint, arg1 string) (*db.Secret, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadSecret", arg0, arg1)
+	ret0, _ := ret[0].(*db.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadSecret indicates an expected call of ReadSecret.
+func (mr *MockControllerMockRecorder) ReadSecret(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadSecret", reflect.TypeOf((*MockController)(nil).ReadSecret), arg0, arg1)
+}
+
+// ReadSecrets mocks base method.
+func (m *MockController) ReadSecrets(arg0 uint) ([]db.Secret, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadSecrets", arg0)
+	ret0, _ := ret[0].([]db.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadSecrets indicates an expected call of ReadSecrets.
+func (mr *MockControllerMockRecorder) ReadSecrets(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadSecrets", reflect.TypeOf((*MockController)(nil).ReadSecrets), arg0)
+}
+
+// ReadSigningKeys mocks base method.
+func (m *MockController) ReadSigningKeys() ([]db.SigningKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadSigningKeys")
+	ret0, _ := ret[0].([]db.SigningKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadSigningKeys indicates an expected call of ReadSigningKeys.
+func (mr *MockControllerMockRecorder) ReadSigningKeys() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadSigningKeys", reflect.TypeOf((*MockController)(nil).ReadSigningKeys))
+}
+
 // ReadTask mocks base method.
 func (m *MockController) ReadTask(arg0 string) (*db.Task, error) {
 	m.ctrl.T.Helper()
@@ -420,6 +927,51 @@ func (mr *MockControllerMockRecorder) ReadTask(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadTask", reflect.TypeOf((*MockController)(nil).ReadTask), arg0)
 }
 
+// ReadTokenRevocations mocks base method.
+func (m *MockController) ReadTokenRevocations(arg0 time.Time) ([]db.TokenRevocation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadTokenRevocations", arg0)
+	ret0, _ := ret[0].([]db.TokenRevocation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadTokenRevocations indicates an expected call of ReadTokenRevocations.
+func (mr *MockControllerMockRecorder) ReadTokenRevocations(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadTokenRevocations", reflect.TypeOf((*MockController)(nil).ReadTokenRevocations), arg0)
+}
+
+// ReadTrigger mocks base method.
+func (m *MockController) ReadTrigger(arg0 uint) (*db.Trigger, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadTrigger", arg0)
+	ret0, _ := ret[0].(*db.Trigger)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadTrigger indicates an expected call of ReadTrigger.
+func (mr *MockControllerMockRecorder) ReadTrigger(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadTrigger", reflect.TypeOf((*MockController)(nil).ReadTrigger), arg0)
+}
+
+// ReadTriggers mocks base method.
+func (m *MockController) ReadTriggers(arg0, arg1 string) ([]db.Trigger, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadTriggers", arg0, arg1)
+	ret0, _ := ret[0].([]db.Trigger)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadTriggers indicates an expected call of ReadTriggers.
+func (mr *MockControllerMockRecorder) ReadTriggers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadTriggers", reflect.TypeOf((*MockController)(nil).ReadTriggers), arg0, arg1)
+}
+
 // ReadUser mocks base method.
 func (m *MockController) ReadUser(arg0 string) (*db.User, error) {
 	m.ctrl.T.Helper()
@@ -450,6 +1002,64 @@ func (mr *MockControllerMockRecorder) ReadUserToken(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadUserToken", reflect.TypeOf((*MockController)(nil).ReadUserToken), arg0)
 }
 
+// ReadUserTokens mocks base method.
+func (m *MockController) ReadUserTokens(arg0 uint) ([]db.UserToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadUserTokens", arg0)
+	ret0, _ := ret[0].([]db.UserToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadUserTokens indicates an expected call of ReadUserTokens.
+func (mr *MockControllerMockRecorder) ReadUserTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadUserTokens", reflect.TypeOf((*MockController)(nil).ReadUserTokens), arg0)
+}
+
+// RemoveMachineGroupMember mocks base method.
+func (m *MockController) RemoveMachineGroupMember(arg0 *db.MachineGroup, arg1 *db.Machine) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveMachineGroupMember", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveMachineGroupMember indicates an expected call of RemoveMachineGroupMember.
+func (mr *MockControllerMockRecorder) RemoveMachineGroupMember(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveMachineGroupMember", reflect.TypeOf((*MockController)(nil).RemoveMachineGroupMember), arg0, arg1)
+}
+
+// ResetPassword mocks base method.
+func (m *MockController) ResetPassword(arg0 string, arg1 pgtype.UUID, arg2 time.Time, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetPassword", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetPassword indicates an expected call of ResetPassword.
+func (mr *MockControllerMockRecorder) ResetPassword(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetPassword", reflect.TypeOf((*MockController)(nil).ResetPassword), arg0, arg1, arg2, arg3)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockController) RotateRefreshToken(arg0 pgtype.UUID, arg1 time.Time, arg2 *db.RefreshToken) (*db.LoginInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*db.LoginInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockControllerMockRecorder) RotateRefreshToken(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockController)(nil).RotateRefreshToken), arg0, arg1, arg2)
+}
+
 // UpdateLoginInfo mocks base method.
 func (m *MockController) UpdateLoginInfo(arg0 *db.LoginInfo) error {
 	m.ctrl.T.Helper()
@@ -478,6 +1088,34 @@ func (mr *MockControllerMockRecorder) UpdateMachine(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachine", reflect.TypeOf((*MockController)(nil).UpdateMachine), arg0)
 }
 
+// UpdateMachineGroup mocks base method.
+func (m *MockController) UpdateMachineGroup(arg0 *db.MachineGroup) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMachineGroup", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMachineGroup indicates an expected call of UpdateMachineGroup.
+func (mr *MockControllerMockRecorder) UpdateMachineGroup(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachineGroup", reflect.TypeOf((*MockController)(nil).UpdateMachineGroup), arg0)
+}
+
+// UpdateMachineHeartbeat mocks base method.
+func (m *MockController) UpdateMachineHeartbeat(arg0 *db.Machine) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMachineHeartbeat", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMachineHeartbeat indicates an expected call of UpdateMachineHeartbeat.
+func (mr *MockControllerMockRecorder) UpdateMachineHeartbeat(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachineHeartbeat", reflect.TypeOf((*MockController)(nil).UpdateMachineHeartbeat), arg0)
+}
+
 // UpdateMachineToken mocks base method.
 func (m *MockController) UpdateMachineToken(arg0 *db.MachineToken) error {
 	m.ctrl.T.Helper()
@@ -492,6 +1130,20 @@ func (mr *MockControllerMockRecorder) UpdateMachineToken(arg0 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachineToken", reflect.TypeOf((*MockController)(nil).UpdateMachineToken), arg0)
 }
 
+// UpdateMachineTokenLastUsed mocks base method.
+func (m *MockController) UpdateMachineTokenLastUsed(arg0 uint, arg1 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMachineTokenLastUsed", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMachineTokenLastUsed indicates an expected call of UpdateMachineTokenLastUsed.
+func (mr *MockControllerMockRecorder) UpdateMachineTokenLastUsed(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachineTokenLastUsed", reflect.TypeOf((*MockController)(nil).UpdateMachineTokenLastUsed), arg0, arg1)
+}
+
 // UpdateOrganization mocks base method.
 func (m *MockController) UpdateOrganization(arg0 *db.Organization) error {
 	m.ctrl.T.Helper()
@@ -520,6 +1172,20 @@ func (mr *MockControllerMockRecorder) UpdateRecord(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRecord", reflect.TypeOf((*MockController)(nil).UpdateRecord), arg0)
 }
 
+// UpdateRetentionPolicy mocks base method.
+func (m *MockController) UpdateRetentionPolicy(arg0 *db.RetentionPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRetentionPolicy", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateRetentionPolicy indicates an expected call of UpdateRetentionPolicy.
+func (mr *MockControllerMockRecorder) UpdateRetentionPolicy(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRetentionPolicy", reflect.TypeOf((*MockController)(nil).UpdateRetentionPolicy), arg0)
+}
+
 // UpdateSchedule mocks base method.
 func (m *MockController) UpdateSchedule(arg0 *db.Schedule) error {
 	m.ctrl.T.Helper()
@@ -534,6 +1200,20 @@ func (mr *MockControllerMockRecorder) UpdateSchedule(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSchedule", reflect.TypeOf((*MockController)(nil).UpdateSchedule), arg0)
 }
 
+// UpdateSecret mocks base method.
+func (m *MockController) UpdateSecret(arg0 *db.Secret) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateSecret", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateSecret indicates an expected call of UpdateSecret.
+func (mr *MockControllerMockRecorder) UpdateSecret(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecret", reflect.TypeOf((*MockController)(nil).UpdateSecret), arg0)
+}
+
 // UpdateTask mocks base method.
 func (m *MockController) UpdateTask(arg0 *db.Task) error {
 	m.ctrl.T.Helper()
@@ -548,6 +1228,20 @@ func (mr *MockControllerMockRecorder) UpdateTask(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTask", reflect.TypeOf((*MockController)(nil).UpdateTask), arg0)
 }
 
+// UpdateTrigger mocks base method.
+func (m *MockController) UpdateTrigger(arg0 *db.Trigger) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTrigger", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateTrigger indicates an expected call of UpdateTrigger.
+func (mr *MockControllerMockRecorder) UpdateTrigger(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTrigger", reflect.TypeOf((*MockController)(nil).UpdateTrigger), arg0)
+}
+
 // UpdateUser mocks base method.
 func (m *MockController) UpdateUser(arg0 *db.User) error {
 	m.ctrl.T.Helper()
@@ -575,3 +1269,17 @@ func (mr *MockControllerMockRecorder) UpdateUserToken(arg0 interface{}) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserToken", reflect.TypeOf((*MockController)(nil).UpdateUserToken), arg0)
 }
+
+// UpdateUserTokenLastUsed mocks base method.
+func (m *MockController) UpdateUserTokenLastUsed(arg0 uint, arg1 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserTokenLastUsed", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserTokenLastUsed indicates an expected call of UpdateUserTokenLastUsed.
+func (mr *MockControllerMockRecorder) UpdateUserTokenLastUsed(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserTokenLastUsed", reflect.TypeOf((*MockController)(nil).UpdateUserTokenLastUsed), arg0, arg1)
+}
@@ -3,16 +3,126 @@ package db
 import (
 	"time"
 
+	"github.com/jackc/pgtype"
 	"gorm.io/gorm"
+
+	"github.com/LassiHeikkila/taskey/pkg/types"
 )
 
 type Record struct {
 	gorm.Model
-	MachineID  uint `gorm:"not null"`
+	MachineID  uint `gorm:"not null;index:idx_record_machine_executed_at,priority:1"`
 	Machine    Machine
 	TaskID     uint `gorm:"not null"`
 	Task       Task
+	TriggerID  uint      // zero unless run was triggered manually
+	ExecutedAt time.Time `gorm:"index:idx_record_machine_executed_at,priority:2"` // when run started
+	FinishedAt time.Time
+	Duration   time.Duration
+	Outcome    string `gorm:"not null;default:completed"` // one of types.RecordOutcome constants
+	Status     int    // exit code, only meaningful when run completed
+	Signal     int    // signal which terminated the process, if any
+	Output     string // stdout, or stdout and stderr interleaved
+	Stderr     string
+	Error      string
+	Steps      pgtype.JSON `gorm:"type:json"`
+
+	OutputTruncated int64
+	OutputStored    bool // Output is a preview of output kept in Outputs
+	StderrTruncated int64
+	StderrStored    bool           // Stderr is a preview of output kept in Outputs
+	Outputs         []RecordOutput `gorm:"foreignKey:RecordID;constraint:OnDelete:CASCADE"`
+}
+
+// Output streams kept in RecordOutput
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// RecordOutput holds gzip compressed output which is too large to keep in the record itself
+type RecordOutput struct {
+	ID       uint   `gorm:"primarykey"`
+	RecordID uint   `gorm:"not null;uniqueIndex:idx_record_output_stream"`
+	Step     int    `gorm:"not null;uniqueIndex:idx_record_output_stream"` // zero for output of the record, otherwise number of the step
+	Stream   string `gorm:"not null;default:stdout;uniqueIndex:idx_record_output_stream"`
+	Size     int64  // uncompressed size
+	Data     []byte
+}
+
+// Columns records can be sorted by
+const (
+	RecordSortExecutedAt = "executed_at"
+	RecordSortStatus     = "status"
+	RecordSortID         = "id"
+)
+
+// RecordQuery selects which records of a machine are read, and in which order.
+// Zero value reads all records, oldest first.
+type RecordQuery struct {
+	TaskName       string    // only records of this task
+	Statuses       []int     // only records with one of these statuses
+	Outcomes       []string  // only records with one of these outcomes
+	ExecutedAfter  time.Time // only records executed at or after this time
+	ExecutedBefore time.Time // only records executed before this time
+
+	SortBy     string // one of RecordSort constants, RecordSortExecutedAt if empty
+	Descending bool
+
+	// After continues reading from the record following the cursor in the selected order
+	After *RecordCursor
+	Limit int // zero for no limit
+}
+
+// RecordCursor identifies position of a record in results of RecordQuery.
+// Only the field used for sorting and ID need to be set.
+type RecordCursor struct {
 	ExecutedAt time.Time
 	Status     int
-	Output     string
+	ID         uint
+}
+
+// statuses older machines reported in place of an outcome
+const (
+	legacyStatusSkipped  = -1
+	legacyStatusTimedOut = -2
+)
+
+// LegacyOutcome returns the outcome an older machine reported as status of a record,
+// ok is false if status is an actual exit code.
+func LegacyOutcome(status int) (outcome string, ok bool) {
+	switch status {
+	case legacyStatusSkipped:
+		return types.RecordOutcomeSkipped, true
+	case legacyStatusTimedOut:
+		return types.RecordOutcomeTimedOut, true
+	default:
+		return "", false
+	}
+}
+
+// migrateRecordOutcomes moves outcomes of records stored before outcome had its own column out of status
+func migrateRecordOutcomes(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, status := range []int{legacyStatusSkipped, legacyStatusTimedOut} {
+			outcome, _ := LegacyOutcome(status)
+			err := tx.Unscoped().Model(&Record{}).Where(`status = ?`, status).UpdateColumns(map[string]interface{}{
+				"outcome": outcome,
+				"status":  0,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CursorOf returns cursor pointing at record r, for continuing after it
+func CursorOf(r *Record) RecordCursor {
+	return RecordCursor{
+		ExecutedAt: r.ExecutedAt,
+		Status:     r.Status,
+		ID:         r.ID,
+	}
 }
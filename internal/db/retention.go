@@ -0,0 +1,19 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy limits how long records are kept.
+// Policy with zero TaskID applies to all tasks of the organization which don't have their own policy.
+// Limits apply to records of each task on each machine separately, and zero value disables a limit.
+type RetentionPolicy struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;uniqueIndex:idx_retention_org_task"`
+	TaskID         uint `gorm:"uniqueIndex:idx_retention_org_task"`
+	MaxAge         time.Duration
+	MaxCount       int
+	KeepFailures   int // latest failed records to keep regardless of other limits
+}
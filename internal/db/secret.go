@@ -0,0 +1,12 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+type Secret struct {
+	gorm.Model
+	Name           string `gorm:"not null;uniqueIndex:idx_secret_org_name"`
+	OrganizationID uint   `gorm:"not null;uniqueIndex:idx_secret_org_name"`
+	Value          []byte `gorm:"not null"` // encrypted, see auth.EncryptSecret
+}
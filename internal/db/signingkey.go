@@ -0,0 +1,17 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKey is an asymmetric key for signing access tokens.
+// PrivateKey is encrypted, and KeyID is the kid tokens signed with it carry in their header.
+type SigningKey struct {
+	gorm.Model
+	KeyID       string    `gorm:"not null;uniqueIndex"`
+	Algorithm   string    `gorm:"not null"`
+	PrivateKey  []byte    `gorm:"not null"`
+	ActivatesAt time.Time `gorm:"not null"`
+}
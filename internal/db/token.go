@@ -1,30 +1,94 @@
 package db
 
 import (
+	"crypto/sha256"
 	"time"
 
 	"github.com/jackc/pgtype"
 	"gorm.io/gorm"
 )
 
+// MachineToken authenticates a machine.
+// Value is only known when the token is created, Hash of it is stored to look it up.
 type MachineToken struct {
 	gorm.Model
-	Value      pgtype.UUID `gorm:"type:uuid"`
-	Expiration time.Time
+	Value      pgtype.UUID `gorm:"-"`
+	Hash       []byte      `gorm:"uniqueIndex"`
+	Label      string
+	Expiration time.Time // zero time means no expiry
+	LastUsedAt time.Time
 	MachineID  uint
 	Machine    Machine
 }
 
+// UserToken authenticates a user, like MachineToken does a machine.
+// Token with Scopes can only be used for what they allow, and if it has Machines, only for those machines.
 type UserToken struct {
 	gorm.Model
-	Value      pgtype.UUID `gorm:"type:uuid"`
-	Expiration time.Time
+	Value      pgtype.UUID `gorm:"-"`
+	Hash       []byte      `gorm:"uniqueIndex"`
+	Label      string
+	Expiration time.Time // zero time means no expiry
+	LastUsedAt time.Time
+	Scopes     string    // space separated, empty means token has all permissions of its user
+	Machines   []Machine `gorm:"many2many:user_token_machines"`
 	UserID     uint
 	User       User
 }
 
+// lastUsedPrecision limits how often last use of a token is written
+const lastUsedPrecision = time.Minute
+
+var errInvalidTokenValue = dbError("invalid token value")
+
 func StringToUUID(s string) pgtype.UUID {
 	u := pgtype.UUID{}
 	u.Set(s)
 	return u
 }
+
+// hashToken hashes canonical string form of token value.
+// Tokens are random UUIDs, so plain SHA-256 is enough to make a leaked database useless for authenticating.
+func hashToken(value pgtype.UUID) ([]byte, error) {
+	if value.Status != pgtype.Present {
+		return nil, errInvalidTokenValue
+	}
+	var s string
+	if err := value.AssignTo(&s); err != nil {
+		return nil, err
+	}
+	return hashTokenString(s), nil
+}
+
+func hashTokenString(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+// migrateTokenValues hashes tokens stored before only hashes were, and drops the plain values
+func migrateTokenValues(db *gorm.DB, model interface{}) error {
+	if !db.Migrator().HasColumn(model, "value") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID    uint
+			Value string
+		}
+		err := tx.Unscoped().Model(model).
+			Select(`id, value::text AS value`).
+			Where(`hash IS NULL AND value IS NOT NULL`).
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			err := tx.Unscoped().Model(model).Where(`id = ?`, r.ID).UpdateColumn("hash", hashTokenString(r.Value)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropColumn(model, "value")
+	})
+}
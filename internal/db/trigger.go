@@ -0,0 +1,16 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+type Trigger struct {
+	gorm.Model
+	MachineID uint `gorm:"not null"`
+	Machine   Machine
+	TaskID    uint `gorm:"not null"`
+	Task      Task
+	State     string `gorm:"not null"`
+	RecordID  uint   // zero until machine reports a result
+	Error     string // set when trigger is failed without a result
+}
@@ -3,6 +3,8 @@ package schedule
 import (
 	"context"
 	"errors"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,24 +15,72 @@ import (
 
 type Executor interface {
 	SetSchedule(schedule types.Schedule) error
+	AddEntry(entry interface{}) (EntryID, error)
+	RemoveEntry(id EntryID) error
+	Entries() []EntryInfo
 	ConfigureTask(name string, task func()) error
+	RunTask(name string, task func()) error
 	Start(ctx context.Context) error
 	Stop() error
 	Restart(ctx context.Context) error
 }
 
-func NewExecutor() (Executor, error) {
+// EntryID identifies a single entry of the schedule,
+// it stays the same for as long as the entry is scheduled
+type EntryID int
+
+// EntryInfo describes a scheduled entry
+type EntryInfo struct {
+	ID EntryID
+	// Entry is the types.CronTask, types.PeriodicTask or types.SingleshotTask the entry was created from
+	Entry interface{}
+	Task  string
+	// Next is when the entry fires next, zero if executor is not running or entry won't fire again
+	Next time.Time
+}
+
+var (
+	ErrUnknownEntry     = errors.New("unknown entry")
+	ErrUnsupportedEntry = errors.New("unsupported entry type")
+	ErrInvalidInterval  = errors.New("interval must be positive")
+	ErrInvalidPolicy    = errors.New("invalid concurrency policy")
+	ErrAlreadyRunning   = errors.New("executor already running")
+	ErrNotRunning       = errors.New("executor not running")
+	ErrSkipped          = errors.New("run skipped because of concurrency policy")
+	ErrDrainTimeout     = errors.New("timed out waiting for running tasks to finish")
+)
+
+// DefaultDrainTimeout is how long Stop waits for running tasks by default
+const DefaultDrainTimeout = 30 * time.Second
+
+// Option configures optional behaviour of the executor
+type Option func(*executor)
+
+// WithDrainTimeout sets how long Stop waits for running tasks to finish
+func WithDrainTimeout(d time.Duration) Option {
+	return func(e *executor) {
+		e.drainTimeout = d
+	}
+}
+
+// WithSkipHandler sets a function to be called with the task name
+// whenever a run is skipped because of the concurrency policy of its entry
+func WithSkipHandler(handler func(task string)) Option {
+	return func(e *executor) {
+		e.onSkip = handler
+	}
+}
+
+func NewExecutor(opts ...Option) (Executor, error) {
 	e := &executor{
 		ctx:          context.Background(),
-		cronExecutor: cron.New(),
-		scheduleConfig: scheduleConfig{
-			cronConfig:       make(map[taskInstance]cron.Schedule),
-			singleshotConfig: make(map[taskInstance]time.Time),
-			periodicConfig:   make(map[taskInstance]time.Duration),
-		},
-		singleshotTimers: make(map[taskInstance]*time.Timer),
-		periodicTickers:  make(map[taskInstance]*time.Ticker),
-		tasks:            make(map[string]func()),
+		entries:      make(map[EntryID]*entry),
+		tasks:        make(map[string]func()),
+		drainTimeout: DefaultDrainTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(e)
 	}
 
 	return e, nil
@@ -39,92 +89,319 @@ func NewExecutor() (Executor, error) {
 type executor struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
+	started   bool
 
-	scheduleConfig scheduleConfig
-
-	cronExecutor     *cron.Cron
-	singleshotTimers map[taskInstance]*time.Timer
-	periodicTickers  map[taskInstance]*time.Ticker
+	entries map[EntryID]*entry
+	nextID  EntryID
 
 	scheduleChangeMutex sync.Mutex
 	tasks               map[string]func()
+
+	// running keeps track of task functions in progress.
+	// Each start gets a new one, as Stop may give up waiting for the previous one.
+	running      *sync.WaitGroup
+	drainTimeout time.Duration
+
+	onSkip func(task string)
+}
+
+// entry is a single task scheduled to be run at times defined by schedule.
+// a schedule may define same task to be run several times with different entries
+type entry struct {
+	def      interface{}
+	task     string
+	schedule cron.Schedule
+	next     time.Time
+
+	policy types.ConcurrencyPolicy
+	// whether a run of the entry is in progress, and how many are waiting for it to finish.
+	// only tracked when policy is not ConcurrencyAllow
+	active bool
+	queued int
+	// runs requested with RunTask while a run was active, each with its own task function
+	extra []func()
+
+	// stops the goroutine triggering the entry, nil if executor is not running
+	cancel context.CancelFunc
+}
+
+// periodicSchedule implements cron.Schedule for PeriodicTask entries
+type periodicSchedule struct {
+	interval time.Duration
+}
+
+func (s periodicSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
 }
 
-type scheduleConfig struct {
-	cronConfig       map[taskInstance]cron.Schedule
-	singleshotConfig map[taskInstance]time.Time
-	periodicConfig   map[taskInstance]time.Duration
+// singleshotSchedule implements cron.Schedule for SingleshotTask entries
+type singleshotSchedule struct {
+	when time.Time
 }
 
-// needed because a schedule may define same task
-// to be run several times with periodic or singleshot timers
-type taskInstance struct {
-	name  string
-	index int
+func (s singleshotSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.when) {
+		return s.when
+	}
+	// we don't want expired singleshot tasks to run
+	return time.Time{}
 }
 
+// SetSchedule replaces all entries of the executor with ones defined in schedule.
+// Entries which are identical in the old and new schedule keep their IDs and are not interrupted.
+// If any entry of the schedule is invalid, an error is returned and the old schedule is left untouched.
 func (e *executor) SetSchedule(schedule types.Schedule) error {
+	wanted := make([]*entry, 0, len(schedule.CronTasks)+len(schedule.PeriodicTasks)+len(schedule.SingleshotTasks))
+
+	for _, ct := range schedule.CronTasks {
+		en, err := newEntry(ct)
+		if err != nil {
+			return err
+		}
+		wanted = append(wanted, en)
+	}
+
+	for _, pt := range schedule.PeriodicTasks {
+		en, err := newEntry(pt)
+		if err != nil {
+			return err
+		}
+		wanted = append(wanted, en)
+	}
+
+	for _, st := range schedule.SingleshotTasks {
+		en, err := newEntry(st)
+		if err != nil {
+			return err
+		}
+		wanted = append(wanted, en)
+	}
+
 	e.scheduleChangeMutex.Lock()
 	defer e.scheduleChangeMutex.Unlock()
 
-	if len(schedule.CronTasks) > 0 {
-		for _, ct := range schedule.CronTasks {
-			if err := e.scheduleCronTask(ct); err != nil {
-				return err
-			}
+	kept := make(map[EntryID]bool, len(e.entries))
+	for _, en := range wanted {
+		if id, found := e.findEntry(en.def, kept); found {
+			kept[id] = true
+			continue
 		}
+		kept[e.insertEntry(en)] = true
 	}
 
-	if len(schedule.PeriodicTasks) > 0 {
-		for _, pt := range schedule.PeriodicTasks {
-			if err := e.schedulePeriodicTask(pt); err != nil {
-				return err
-			}
+	for id, en := range e.entries {
+		if kept[id] {
+			continue
+		}
+		if en.cancel != nil {
+			en.cancel()
 		}
+		delete(e.entries, id)
 	}
 
-	if len(schedule.SingleshotTasks) > 0 {
-		for _, st := range schedule.SingleshotTasks {
-			if err := e.scheduleSingleshotTask(st); err != nil {
-				return err
-			}
+	return nil
+}
+
+// findEntry looks for an entry with given definition, ignoring ones in skip.
+// must be called with scheduleChangeMutex held
+func (e *executor) findEntry(def interface{}, skip map[EntryID]bool) (EntryID, bool) {
+	for id, en := range e.entries {
+		if skip[id] {
+			continue
+		}
+		if reflect.DeepEqual(en.def, def) {
+			return id, true
 		}
 	}
+	return 0, false
+}
 
-	return nil
+// Entries returns information about all scheduled entries, ordered by ID
+func (e *executor) Entries() []EntryInfo {
+	e.scheduleChangeMutex.Lock()
+	defer e.scheduleChangeMutex.Unlock()
+
+	infos := make([]EntryInfo, 0, len(e.entries))
+	for id, en := range e.entries {
+		info := EntryInfo{
+			ID:    id,
+			Entry: en.def,
+			Task:  en.task,
+		}
+		if en.cancel != nil {
+			info.Next = en.next
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+
+	return infos
 }
 
-func (e *executor) scheduleCronTask(task types.CronTask) error {
-	cs, err := cron.Parse(task.When)
+// AddEntry schedules a single types.CronTask, types.PeriodicTask or types.SingleshotTask.
+// If executor is running, the entry takes effect immediately.
+func (e *executor) AddEntry(entry interface{}) (EntryID, error) {
+	e.scheduleChangeMutex.Lock()
+	defer e.scheduleChangeMutex.Unlock()
+
+	en, err := newEntry(entry)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	return e.insertEntry(en), nil
+}
+
+// RemoveEntry unschedules an entry previously added with AddEntry or SetSchedule.
+// Task runs already in progress are not interrupted.
+func (e *executor) RemoveEntry(id EntryID) error {
+	e.scheduleChangeMutex.Lock()
+	defer e.scheduleChangeMutex.Unlock()
+
+	en, found := e.entries[id]
+	if !found {
+		return ErrUnknownEntry
 	}
-	ti := taskInstance{
-		name:  task.What,
-		index: getTaskInstance(e.scheduleConfig.cronConfig, task.What),
+	if en.cancel != nil {
+		en.cancel()
 	}
-	e.scheduleConfig.cronConfig[ti] = cs
+	delete(e.entries, id)
+
 	return nil
 }
 
-func (e *executor) scheduleSingleshotTask(task types.SingleshotTask) error {
-	ti := taskInstance{
-		name:  task.What,
-		index: getTaskInstance(e.scheduleConfig.singleshotConfig, task.What),
+func newEntry(v interface{}) (*entry, error) {
+	en := &entry{def: v}
+
+	switch task := v.(type) {
+	case types.CronTask:
+		cs, err := cron.Parse(task.When)
+		if err != nil {
+			return nil, err
+		}
+		en.task = task.What
+		en.schedule = cs
+		en.policy = task.ConcurrencyPolicy
+	case types.PeriodicTask:
+		if task.Interval.Duration <= 0 {
+			return nil, ErrInvalidInterval
+		}
+		en.task = task.What
+		en.schedule = periodicSchedule{interval: task.Interval.Duration}
+		en.policy = task.ConcurrencyPolicy
+	case types.SingleshotTask:
+		en.task = task.What
+		en.schedule = singleshotSchedule{when: task.When}
+		en.policy = task.ConcurrencyPolicy
+	default:
+		return nil, ErrUnsupportedEntry
 	}
-	e.scheduleConfig.singleshotConfig[ti] = task.When
-	return nil
+
+	switch en.policy.Concurrency {
+	case "", types.ConcurrencyAllow, types.ConcurrencyForbid:
+	case types.ConcurrencyQueue:
+		if en.policy.MaxQueue < 0 {
+			return nil, ErrInvalidPolicy
+		}
+		if en.policy.MaxQueue == 0 {
+			en.policy.MaxQueue = 1
+		}
+	default:
+		return nil, ErrInvalidPolicy
+	}
+
+	return en, nil
+}
+
+// insertEntry must be called with scheduleChangeMutex held
+func (e *executor) insertEntry(en *entry) EntryID {
+	// IDs are never reused, so a stale ID can't refer to a different entry
+	id := e.nextID
+	e.nextID++
+	e.entries[id] = en
+
+	if e.started {
+		e.startEntry(en)
+	}
+
+	return id
 }
 
-func (e *executor) schedulePeriodicTask(task types.PeriodicTask) error {
-	ti := taskInstance{
-		name:  task.What,
-		index: getTaskInstance(e.scheduleConfig.periodicConfig, task.What),
+// RunTask runs task once right away, as an unscheduled run of the named task.
+// Concurrency policy of the task's entries applies as if one of them fired:
+// if a run is in progress and the policy doesn't allow another one, ErrSkipped is returned and task is not run.
+// Queued runs are dropped if the executor is stopped before they start.
+func (e *executor) RunTask(name string, task func()) error {
+	e.scheduleChangeMutex.Lock()
+	if !e.started {
+		e.scheduleChangeMutex.Unlock()
+		return ErrNotRunning
+	}
+
+	en := e.limitingEntry(name)
+	if en == nil {
+		wg := e.running
+		wg.Add(1)
+		e.scheduleChangeMutex.Unlock()
+		go func() {
+			defer wg.Done()
+			task()
+		}()
+		return nil
+	}
+
+	if en.active {
+		if en.policy.Concurrency == types.ConcurrencyQueue && en.queued+len(en.extra) < en.policy.MaxQueue {
+			en.extra = append(en.extra, task)
+			e.scheduleChangeMutex.Unlock()
+			return nil
+		}
+		e.scheduleChangeMutex.Unlock()
+		return ErrSkipped
 	}
-	e.scheduleConfig.periodicConfig[ti] = time.Duration(task.Interval.Duration)
+	en.active = true
+	e.running.Add(1)
+	wg := e.running
+	e.scheduleChangeMutex.Unlock()
+
+	go e.run(en, task, wg)
 	return nil
 }
 
+// limitingEntry returns the entry of task whose concurrency policy applies to unscheduled runs:
+// the first one with a run in progress, or the first one limiting concurrency if none are running.
+// nil if no entry of task limits concurrency.
+// must be called with scheduleChangeMutex held
+func (e *executor) limitingEntry(task string) *entry {
+	ids := make([]EntryID, 0, len(e.entries))
+	for id, en := range e.entries {
+		if en.task == task && en.limited() {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		if e.entries[id].active {
+			return e.entries[id]
+		}
+	}
+	return e.entries[ids[0]]
+}
+
+// limited tells if runs of the entry are tracked to enforce its concurrency policy
+func (en *entry) limited() bool {
+	return en.policy.Concurrency == types.ConcurrencyForbid || en.policy.Concurrency == types.ConcurrencyQueue
+}
+
 func (e *executor) ConfigureTask(name string, task func()) error {
 	e.scheduleChangeMutex.Lock()
 	defer e.scheduleChangeMutex.Unlock()
@@ -134,94 +411,177 @@ func (e *executor) ConfigureTask(name string, task func()) error {
 }
 
 func (e *executor) Start(ctx context.Context) error {
+	e.scheduleChangeMutex.Lock()
+	defer e.scheduleChangeMutex.Unlock()
+
+	if e.started {
+		return ErrAlreadyRunning
+	}
+
 	e.ctx, e.ctxCancel = context.WithCancel(ctx)
+	e.started = true
+	e.running = &sync.WaitGroup{}
 
-	for k, v := range e.scheduleConfig.cronConfig {
-		job := cron.FuncJob(func() {
-			t, defined := e.tasks[k.name]
-			if !defined {
-				// TODO: log something?
-				// TODO: continue instead of return to keep trying?
-				return
-			}
-			t()
-		})
-		e.cronExecutor.Schedule(v, job)
+	for _, en := range e.entries {
+		e.startEntry(en)
 	}
-	e.cronExecutor.Start()
 
-	for k, v := range e.scheduleConfig.singleshotConfig {
-		d := time.Until(v)
-		if d < 0 {
-			// we don't want expired singleshot tasks to run
-			// TODO: automatically remove singleshot tasks from schedule when they are executed and/or have expired
-			continue
+	return nil
+}
+
+// startEntry must be called with scheduleChangeMutex held
+func (e *executor) startEntry(en *entry) {
+	ctx, cancel := context.WithCancel(e.ctx)
+	en.cancel = cancel
+
+	go e.triggerEntry(ctx, en)
+}
+
+func (e *executor) triggerEntry(ctx context.Context, en *entry) {
+	for {
+		next := en.schedule.Next(time.Now())
+
+		e.scheduleChangeMutex.Lock()
+		en.next = next
+		e.scheduleChangeMutex.Unlock()
+
+		if next.IsZero() {
+			return
 		}
-		t := time.NewTimer(d)
-		e.singleshotTimers[k] = t
-		go func() {
-			select {
-			case <-e.ctx.Done():
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+			e.runTask(ctx, en)
+		}
+	}
+}
+
+func (e *executor) runTask(ctx context.Context, en *entry) {
+	e.scheduleChangeMutex.Lock()
+	if !e.started || ctx.Err() != nil {
+		// trigger raced with Stop, or with removal of the entry
+		e.scheduleChangeMutex.Unlock()
+		return
+	}
+	t, defined := e.tasks[en.task]
+	if !defined || t == nil {
+		e.scheduleChangeMutex.Unlock()
+		// TODO: log something?
+		return
+	}
+
+	if en.limited() {
+		if en.active {
+			if en.policy.Concurrency == types.ConcurrencyQueue && en.queued+len(en.extra) < en.policy.MaxQueue {
+				// the goroutine running the entry picks it up once previous run is done
+				en.queued++
+				e.scheduleChangeMutex.Unlock()
 				return
-			case <-t.C:
-				t, defined := e.tasks[k.name]
-				if !defined {
-					// TODO: log something?
-					// TODO: continue instead of return to keep trying?
-					return
-				}
-				t()
 			}
-		}()
+			e.scheduleChangeMutex.Unlock()
+			e.skip(en.task)
+			return
+		}
+		en.active = true
 	}
 
-	for k, v := range e.scheduleConfig.periodicConfig {
-		t := time.NewTicker(v)
-		e.periodicTickers[k] = t
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-t.C:
-					t, defined := e.tasks[k.name]
-					if !defined {
-						// TODO: log something?
-						// TODO: continue instead of return to keep trying?
-						return
-					}
-					t()
-				}
-			}
-		}()
+	// must be done while holding the lock so that Stop can't start waiting in between
+	e.running.Add(1)
+	wg := e.running
+	e.scheduleChangeMutex.Unlock()
+
+	// run in a separate goroutine so that slow tasks don't delay the next trigger
+	go e.run(en, t, wg)
+}
+
+// run executes the task of the entry, followed by any runs queued meanwhile.
+// wg is the WaitGroup the run was added to.
+func (e *executor) run(en *entry, t func(), wg *sync.WaitGroup) {
+	defer func() { wg.Done() }()
+
+	for {
+		t()
+
+		e.scheduleChangeMutex.Lock()
+		if e.started && e.running != wg {
+			// executor was restarted meanwhile, so further runs are waited for by the new start
+			e.running.Add(1)
+			wg.Done()
+			wg = e.running
+		}
+		if len(en.extra) > 0 && e.started {
+			t = en.extra[0]
+			en.extra = en.extra[1:]
+			e.scheduleChangeMutex.Unlock()
+			continue
+		}
+		// use latest definition of the task for queued runs
+		t = e.tasks[en.task]
+		if en.queued == 0 || !e.started || t == nil {
+			// runs still in queue when stopping or after task was removed are dropped
+			en.active = false
+			en.queued = 0
+			en.extra = nil
+			e.scheduleChangeMutex.Unlock()
+			return
+		}
+		en.queued--
+		e.scheduleChangeMutex.Unlock()
 	}
+}
 
-	return nil
+func (e *executor) skip(task string) {
+	if e.onSkip != nil {
+		go e.onSkip(task)
+	}
 }
 
+// Stop halts all triggers and waits for task functions in progress to finish.
+// If they don't finish within the drain timeout, ErrDrainTimeout is returned,
+// but the executor is stopped nonetheless. Stopping an executor that is not running does nothing.
 func (e *executor) Stop() error {
-	if e.ctxCancel != nil {
-		e.ctxCancel()
+	e.scheduleChangeMutex.Lock()
+	if !e.started {
+		e.scheduleChangeMutex.Unlock()
+		return nil
 	}
-	return errors.New("unimplemented")
-}
 
-func (e *executor) Restart(ctx context.Context) error {
-	if err := e.Stop(); err != nil {
-		return err
+	e.started = false
+	e.ctxCancel()
+	for _, en := range e.entries {
+		en.cancel = nil
 	}
-	if err := e.Start(ctx); err != nil {
-		return err
+	running := e.running
+	e.scheduleChangeMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	t := time.NewTimer(e.drainTimeout)
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-t.C:
+		return ErrDrainTimeout
 	}
-	return nil
 }
 
-func getTaskInstance[V interface{}](m map[taskInstance]V, task string) int {
-	i := 0
-	for k := range m {
-		if k.name == task {
-			i++
-		}
+// Restart stops the executor and starts it again with the same entries and tasks.
+// The executor is started even if Stop timed out waiting for tasks to finish,
+// in which case ErrDrainTimeout is returned.
+func (e *executor) Restart(ctx context.Context) error {
+	stopErr := e.Stop()
+	if err := e.Start(ctx); err != nil {
+		return err
 	}
-	return i
+	return stopErr
 }
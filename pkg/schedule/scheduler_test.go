@@ -0,0 +1,455 @@
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+	"github.com/LassiHeikkila/taskey/pkg/types"
+)
+
+// check that public interface is implemented
+var _ Executor = &executor{}
+
+func periodic(what string, interval time.Duration) types.PeriodicTask {
+	return types.PeriodicTask{
+		What:     what,
+		Interval: json.Duration{Duration: interval},
+	}
+}
+
+func TestStopHaltsTriggers(t *testing.T) {
+	e, _ := NewExecutor()
+
+	var count int32
+	_ = e.ConfigureTask("task", func() { atomic.AddInt32(&count, 1) })
+	if _, err := e.AddEntry(periodic("task", 10*time.Millisecond)); err != nil {
+		t.Fatal("error adding entry:", err)
+	}
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal("error starting executor:", err)
+	}
+	time.Sleep(55 * time.Millisecond)
+
+	if err := e.Stop(); err != nil {
+		t.Fatal("error stopping executor:", err)
+	}
+	stopped := atomic.LoadInt32(&count)
+	if stopped == 0 {
+		t.Fatal("task never ran")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&count); got != stopped {
+		t.Fatalf("task ran %d times after stopping", got-stopped)
+	}
+}
+
+func TestStopWaitsForRunningTasks(t *testing.T) {
+	e, _ := NewExecutor(WithDrainTimeout(time.Second))
+
+	var finished int32
+	started := make(chan struct{}, 1)
+	_ = e.ConfigureTask("slow", func() {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+	_, _ = e.AddEntry(periodic("slow", 10*time.Millisecond))
+
+	_ = e.Start(context.Background())
+	<-started
+
+	if err := e.Stop(); err != nil {
+		t.Fatal("error stopping executor:", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("Stop returned before running task finished")
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	e, _ := NewExecutor(WithDrainTimeout(20 * time.Millisecond))
+
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{}, 1)
+	_ = e.ConfigureTask("stuck", func() {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	_, _ = e.AddEntry(periodic("stuck", 10*time.Millisecond))
+
+	_ = e.Start(context.Background())
+	<-started
+
+	start := time.Now()
+	if err := e.Stop(); err != ErrDrainTimeout {
+		t.Fatal("expected drain timeout, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatal("Stop waited too long:", elapsed)
+	}
+}
+
+func TestRestartAfterDrainTimeout(t *testing.T) {
+	e, _ := NewExecutor(WithDrainTimeout(100 * time.Millisecond))
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	_ = e.ConfigureTask("stuck", func() {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	id, _ := e.AddEntry(periodic("stuck", 10*time.Millisecond))
+
+	_ = e.Start(context.Background())
+	<-started
+	_ = e.RemoveEntry(id)
+
+	if err := e.Restart(context.Background()); err != ErrDrainTimeout {
+		t.Fatal("expected drain timeout, got:", err)
+	}
+
+	// runs of the new start are waited for
+	var count int32
+	finish := make(chan struct{})
+	if err := e.RunTask("other", func() {
+		<-finish
+		atomic.AddInt32(&count, 1)
+	}); err != nil {
+		t.Fatal("error running task after restart:", err)
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- e.Stop() }()
+	select {
+	case err := <-stopped:
+		t.Fatal("Stop returned before running task finished:", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(finish)
+
+	// but not the run left behind by the previous start, which is still stuck
+	if err := <-stopped; err != nil || atomic.LoadInt32(&count) != 1 {
+		t.Fatal("unexpected result of Stop:", err, atomic.LoadInt32(&count))
+	}
+	close(release)
+
+	// and the executor can be restarted again
+	if err := e.Restart(context.Background()); err != nil {
+		t.Fatal("error restarting executor:", err)
+	}
+	_ = e.Stop()
+}
+
+func TestRestart(t *testing.T) {
+	e, _ := NewExecutor()
+
+	var count int32
+	_ = e.ConfigureTask("task", func() { atomic.AddInt32(&count, 1) })
+	_ = e.SetSchedule(types.Schedule{
+		PeriodicTasks: []types.PeriodicTask{periodic("task", 20*time.Millisecond)},
+	})
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal("error starting executor:", err)
+	}
+	if err := e.Start(context.Background()); err != ErrAlreadyRunning {
+		t.Fatal("expected second Start to fail, got:", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := e.Restart(context.Background()); err != nil {
+			t.Fatal("error restarting executor:", err)
+		}
+	}
+
+	atomic.StoreInt32(&count, 0)
+	time.Sleep(110 * time.Millisecond)
+	_ = e.Stop()
+
+	// with a single entry, there should be roughly 5 runs.
+	// duplicated entries would produce a multiple of that.
+	if got := atomic.LoadInt32(&count); got < 3 || got > 6 {
+		t.Fatal("unexpected number of runs after restarts:", got)
+	}
+}
+
+func TestStopNotRunning(t *testing.T) {
+	e, _ := NewExecutor()
+
+	if err := e.Stop(); err != nil {
+		t.Fatal("stopping executor that was never started failed:", err)
+	}
+}
+
+func TestSetScheduleReplaces(t *testing.T) {
+	e, _ := NewExecutor()
+
+	sched := types.Schedule{
+		PeriodicTasks: []types.PeriodicTask{
+			periodic("task1", time.Minute),
+			periodic("task2", time.Hour),
+		},
+		CronTasks: []types.CronTask{
+			{What: "task3", When: "0 */5 * * * *"},
+		},
+	}
+
+	if err := e.SetSchedule(sched); err != nil {
+		t.Fatal("error setting schedule:", err)
+	}
+	if err := e.SetSchedule(sched); err != nil {
+		t.Fatal("error setting schedule:", err)
+	}
+
+	before := e.Entries()
+	if len(before) != 3 {
+		t.Fatal("expected 3 entries, got:", len(before))
+	}
+
+	// change one entry, drop one and keep one
+	sched.PeriodicTasks = []types.PeriodicTask{
+		periodic("task1", time.Minute),
+		periodic("task2", 2*time.Hour),
+	}
+	sched.CronTasks = nil
+
+	if err := e.SetSchedule(sched); err != nil {
+		t.Fatal("error setting schedule:", err)
+	}
+
+	after := e.Entries()
+	if len(after) != 2 {
+		t.Fatal("expected 2 entries, got:", len(after))
+	}
+	// cron entries are added first, so task1 is the second one
+	if after[0].ID != before[1].ID || after[0].Task != "task1" {
+		t.Fatal("unchanged entry did not keep its ID:", after[0])
+	}
+	for _, info := range before {
+		if after[1].ID == info.ID {
+			t.Fatal("changed entry kept old ID:", after[1])
+		}
+	}
+}
+
+func TestSetScheduleInvalid(t *testing.T) {
+	e, _ := NewExecutor()
+
+	_ = e.SetSchedule(types.Schedule{
+		PeriodicTasks: []types.PeriodicTask{periodic("task1", time.Minute)},
+	})
+
+	err := e.SetSchedule(types.Schedule{
+		CronTasks: []types.CronTask{{What: "task2", When: "not a cron expression"}},
+	})
+	if err == nil {
+		t.Fatal("invalid schedule accepted")
+	}
+
+	if entries := e.Entries(); len(entries) != 1 || entries[0].Task != "task1" {
+		t.Fatal("old schedule modified by invalid schedule:", entries)
+	}
+}
+
+func TestAddRemoveEntry(t *testing.T) {
+	e, _ := NewExecutor()
+
+	var count int32
+	_ = e.ConfigureTask("task", func() { atomic.AddInt32(&count, 1) })
+
+	_ = e.Start(context.Background())
+	defer e.Stop()
+
+	id, err := e.AddEntry(periodic("task", 10*time.Millisecond))
+	if err != nil {
+		t.Fatal("error adding entry:", err)
+	}
+	if _, err := e.AddEntry(periodic("task", 0)); err != ErrInvalidInterval {
+		t.Fatal("expected invalid interval error, got:", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	entries := e.Entries()
+	if len(entries) != 1 || entries[0].ID != id {
+		t.Fatal("unexpected entries:", entries)
+	}
+	if entries[0].Next.IsZero() || time.Until(entries[0].Next) > 10*time.Millisecond {
+		t.Fatal("unexpected next fire time:", entries[0].Next)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := e.RemoveEntry(id); err != nil {
+		t.Fatal("error removing entry:", err)
+	}
+	if err := e.RemoveEntry(id); err != ErrUnknownEntry {
+		t.Fatal("expected unknown entry error, got:", err)
+	}
+
+	removed := atomic.LoadInt32(&count)
+	if removed == 0 {
+		t.Fatal("task never ran")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got := atomic.LoadInt32(&count); got != removed {
+		t.Fatal("removed entry kept running")
+	}
+}
+
+func TestConcurrencyPolicy(t *testing.T) {
+	tests := []struct {
+		policy      types.ConcurrencyPolicy
+		wantMaxRuns int32
+		wantSkipped bool
+	}{
+		{policy: types.ConcurrencyPolicy{Concurrency: types.ConcurrencyAllow}, wantMaxRuns: 2, wantSkipped: false},
+		{policy: types.ConcurrencyPolicy{Concurrency: types.ConcurrencyForbid}, wantMaxRuns: 1, wantSkipped: true},
+		{policy: types.ConcurrencyPolicy{Concurrency: types.ConcurrencyQueue, MaxQueue: 1}, wantMaxRuns: 1, wantSkipped: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.policy.Concurrency, func(t *testing.T) {
+			var skipped int32
+			e, _ := NewExecutor(WithSkipHandler(func(task string) {
+				atomic.AddInt32(&skipped, 1)
+			}))
+
+			var running, maxRunning, runs int32
+			_ = e.ConfigureTask("slow", func() {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(45 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&runs, 1)
+			})
+
+			entry := periodic("slow", 10*time.Millisecond)
+			entry.ConcurrencyPolicy = tc.policy
+			if _, err := e.AddEntry(entry); err != nil {
+				t.Fatal("error adding entry:", err)
+			}
+
+			_ = e.Start(context.Background())
+			time.Sleep(100 * time.Millisecond)
+			_ = e.Stop()
+
+			if got := atomic.LoadInt32(&maxRunning); tc.wantMaxRuns == 1 && got != 1 {
+				t.Fatal("runs overlapped:", got)
+			} else if got < tc.wantMaxRuns {
+				t.Fatal("runs did not overlap:", got)
+			}
+			if got := atomic.LoadInt32(&skipped) > 0; got != tc.wantSkipped {
+				t.Fatal("unexpected skips:", atomic.LoadInt32(&skipped))
+			}
+		})
+	}
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	e, _ := NewExecutor()
+
+	release := make(chan struct{})
+	var runs int32
+	_ = e.ConfigureTask("task", func() {
+		atomic.AddInt32(&runs, 1)
+		<-release
+	})
+
+	entry := periodic("task", 10*time.Millisecond)
+	entry.ConcurrencyPolicy = types.ConcurrencyPolicy{Concurrency: types.ConcurrencyQueue, MaxQueue: 2}
+	_, _ = e.AddEntry(entry)
+
+	_ = e.Start(context.Background())
+	// first run blocks, next two are queued, rest are skipped
+	time.Sleep(75 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Fatal("queued runs started too early:", got)
+	}
+
+	// remove entry so that no more runs are queued, and let the queue drain
+	_ = e.RemoveEntry(0)
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	_ = e.Stop()
+
+	if got := atomic.LoadInt32(&runs); got != 3 {
+		t.Fatal("unexpected number of runs:", got)
+	}
+}
+
+func TestRunTask(t *testing.T) {
+	e, _ := NewExecutor()
+
+	release := make(chan struct{})
+	var runs int32
+	run := func() {
+		atomic.AddInt32(&runs, 1)
+		<-release
+	}
+
+	if err := e.RunTask("task", run); err != ErrNotRunning {
+		t.Fatal("task run on stopped executor:", err)
+	}
+
+	// entry never fires during the test, only its policy matters
+	entry := periodic("task", time.Hour)
+	entry.ConcurrencyPolicy = types.ConcurrencyPolicy{Concurrency: types.ConcurrencyQueue, MaxQueue: 1}
+	_, _ = e.AddEntry(entry)
+	_ = e.Start(context.Background())
+
+	if err := e.RunTask("task", run); err != nil {
+		t.Fatal("error running task:", err)
+	}
+	if err := e.RunTask("task", run); err != nil {
+		t.Fatal("error queueing run:", err)
+	}
+	if err := e.RunTask("task", run); err != ErrSkipped {
+		t.Fatal("run beyond queue not skipped:", err)
+	}
+	// runs of other tasks are not limited
+	if err := e.RunTask("other", func() {}); err != nil {
+		t.Fatal("error running other task:", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Fatal("queued run started too early:", got)
+	}
+	close(release)
+	time.Sleep(10 * time.Millisecond)
+	_ = e.Stop()
+
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Fatal("unexpected number of runs:", got)
+	}
+}
+
+func TestInvalidConcurrencyPolicy(t *testing.T) {
+	e, _ := NewExecutor()
+
+	entry := periodic("task", time.Minute)
+	entry.Concurrency = "sometimes"
+	if _, err := e.AddEntry(entry); err != ErrInvalidPolicy {
+		t.Fatal("expected invalid policy error, got:", err)
+	}
+}
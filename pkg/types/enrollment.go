@@ -0,0 +1,42 @@
+package types
+
+import (
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
+// EnrollmentToken lets machines register themselves to an organization.
+// It can be used MaxUses times before it expires.
+type EnrollmentToken struct {
+	ID          uint      `json:"id"`
+	Value       string    `json:"value,omitempty"` // only returned when the token is created
+	Description string    `json:"description,omitempty"`
+	Expiration  time.Time `json:"expiration"`
+	MaxUses     int       `json:"maxUses"`
+	Uses        int       `json:"uses"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// EnrollmentTokenRequest asks for a new enrollment token, zero values get defaults
+type EnrollmentTokenRequest struct {
+	Description string        `json:"description,omitempty"`
+	ValidFor    json.Duration `json:"validFor"`
+	MaxUses     int           `json:"maxUses"`
+}
+
+// Enrollment is sent by a machine registering itself with an enrollment token
+type Enrollment struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	Hostname    string `json:"hostname,omitempty"`
+}
+
+// MachineCredential is returned to an enrolled machine, for it to authenticate with from then on
+type MachineCredential struct {
+	Machine      string       `json:"machine"`
+	Organization string       `json:"organization"`
+	AccessToken  MachineToken `json:"accessToken"`
+}
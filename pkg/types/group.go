@@ -0,0 +1,10 @@
+package types
+
+// MachineGroup is a set of machines of an organization, which all run the schedule of the group
+// in addition to their own
+type MachineGroup struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Machines    []string  `json:"machines"`
+	Schedule    *Schedule `json:"schedule,omitempty"`
+}
@@ -1,6 +1,39 @@
 package types
 
+import (
+	"time"
+)
+
 type LoginInfo struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+// PasswordChange is sent by a user replacing their current password
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// PasswordReset lets a user set a new password once, without knowing the current one.
+// Token is given out when the reset is created, and sent back along with the new password.
+type PasswordReset struct {
+	Username    string    `json:"username,omitempty"`
+	Token       string    `json:"token"`
+	Expiration  time.Time `json:"expiration,omitempty"`
+	NewPassword string    `json:"newPassword,omitempty"`
+}
+
+// Session is returned when logging in or refreshing.
+// Token is a short lived access token, and RefreshToken can be exchanged once for a new session before it expires.
+type Session struct {
+	Token             string    `json:"token"`
+	Expiration        time.Time `json:"expiration"`
+	RefreshToken      string    `json:"refreshToken"`
+	RefreshExpiration time.Time `json:"refreshExpiration"`
+}
+
+// SessionRefresh is sent to get a new session, or to end the session on logout
+type SessionRefresh struct {
+	RefreshToken string `json:"refreshToken"`
+}
@@ -1,8 +1,40 @@
 package types
 
+import (
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
+// Presence of a machine, based on its heartbeats
+const (
+	MachineStatusOnline  = "online"  // machine has sent a heartbeat recently
+	MachineStatusStale   = "stale"   // machine has missed heartbeats, but may still be running
+	MachineStatusOffline = "offline" // machine has shut down, or hasn't been heard from in a long time
+)
+
 type Machine struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	OS          string `json:"os"`
 	Arch        string `json:"arch"`
+
+	// reported by the machine itself, ignored when creating or updating the machine
+	Status       string        `json:"status,omitempty"`
+	LastSeen     *time.Time    `json:"lastSeen,omitempty"`
+	Version      string        `json:"version,omitempty"`
+	Uptime       json.Duration `json:"uptime"`
+	ScheduleHash string        `json:"scheduleHash,omitempty"`
+	Hostname     string        `json:"hostname,omitempty"`
+}
+
+// Heartbeat is sent periodically by a running machine
+type Heartbeat struct {
+	Status       string        `json:"status"` // MachineStatusOnline, or MachineStatusOffline when shutting down
+	Version      string        `json:"version"`
+	Uptime       json.Duration `json:"uptime"`
+	ScheduleHash string        `json:"scheduleHash"` // hash of schedule and tasks the machine is running
+	Hostname     string        `json:"hostname"`
+	OS           string        `json:"os"`
+	Arch         string        `json:"arch"`
 }
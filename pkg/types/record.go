@@ -2,13 +2,76 @@ package types
 
 import (
 	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
+// Outcomes of a run. Status of a record is only meaningful when the run completed.
+const (
+	// RecordOutcomeCompleted is the outcome of a run which ran to completion, successfully or not
+	RecordOutcomeCompleted = "completed"
+	// RecordOutcomeTimedOut is the outcome of a run which was terminated because it exceeded its timeout
+	RecordOutcomeTimedOut = "timedOut"
+	// RecordOutcomeSkipped is the outcome of a scheduled run which was not executed
+	// because its concurrency policy did not allow it
+	RecordOutcomeSkipped = "skipped"
+	// RecordOutcomeError is the outcome of a run which could not be executed, Error of the record tells why
+	RecordOutcomeError = "error"
 )
 
+// ValidRecordOutcome tells if outcome is one of RecordOutcome constants
+func ValidRecordOutcome(outcome string) bool {
+	switch outcome {
+	case RecordOutcomeCompleted, RecordOutcomeTimedOut, RecordOutcomeSkipped, RecordOutcomeError:
+		return true
+	default:
+		return false
+	}
+}
+
 type Record struct {
-	ID          uint      `json:"id"`
-	MachineName string    `json:"machineName,omitempty"`
-	TaskName    string    `json:"taskName"`
-	ExecutedAt  time.Time `json:"executedAt"`
-	Status      int       `json:"status"`
-	Output      string    `json:"output"`
+	ID          uint          `json:"id"`
+	MachineName string        `json:"machineName,omitempty"`
+	TaskName    string        `json:"taskName"`
+	TriggerID   uint          `json:"triggerId,omitempty"` // set when run was triggered manually
+	RunID       string        `json:"runId,omitempty"`     // set when output of the run was streamed live
+	ExecutedAt  time.Time     `json:"executedAt"`          // when run started
+	FinishedAt  time.Time     `json:"finishedAt"`
+	Duration    json.Duration `json:"duration"`
+	Outcome     string        `json:"outcome"`          // one of RecordOutcome constants
+	Status      int           `json:"status"`           // exit code, or HTTP status code for http tasks
+	Signal      int           `json:"signal,omitempty"` // signal which terminated the process, if any
+	Output      string        `json:"output"`           // stdout, or stdout and stderr interleaved if task combines them
+	Stderr      string        `json:"stderr,omitempty"`
+	Error       string        `json:"error,omitempty"`
+
+	OutputTruncated int64 `json:"outputTruncated,omitempty"` // bytes left out of the middle of output by the machine
+	OutputStored    bool  `json:"outputStored,omitempty"`    // output is only a preview, full output can be downloaded separately
+	StderrTruncated int64 `json:"stderrTruncated,omitempty"`
+	StderrStored    bool  `json:"stderrStored,omitempty"`
+
+	Steps []ActionResult `json:"steps,omitempty"` // one per executed action of a multi-action task
+}
+
+type ActionResult struct {
+	Outcome  string        `json:"outcome,omitempty"` // one of RecordOutcome constants, completed if empty
+	Code     int           `json:"code"`
+	Signal   int           `json:"signal,omitempty"`
+	Duration json.Duration `json:"duration"`
+	Output   string        `json:"output"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	OutputTruncated int64 `json:"outputTruncated,omitempty"`
+	OutputStored    bool  `json:"outputStored,omitempty"`
+	StderrTruncated int64 `json:"stderrTruncated,omitempty"`
+	StderrStored    bool  `json:"stderrStored,omitempty"`
+}
+
+// RecordResult is the outcome of a single record in a batch upload.
+// Code and Message have the same meaning as in a response to uploading the record alone.
+type RecordResult struct {
+	ID      uint   `json:"id,omitempty"` // ID of created record, set on success
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
 }
@@ -0,0 +1,15 @@
+package types
+
+import (
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
+// RetentionPolicy limits how long records are kept.
+// Limits apply to records of each task on each machine separately, and zero value disables a limit.
+// Policy of a task replaces the organization wide policy, so a policy without limits keeps all records of the task.
+type RetentionPolicy struct {
+	Task         string        `json:"task,omitempty"` // empty for organization wide policy
+	MaxAge       json.Duration `json:"maxAge"`
+	MaxCount     int           `json:"maxCount"`
+	KeepFailures int           `json:"keepFailures"` // latest failed records to keep regardless of other limits
+}
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"time"
+)
+
+// Run is an execution of a task in progress, whose output can be followed live
+type Run struct {
+	ID          string    `json:"id"`
+	MachineName string    `json:"machineName,omitempty"`
+	TaskName    string    `json:"taskName"`
+	TriggerID   uint      `json:"triggerId,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// Output streams of a run
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputChunk is a piece of output of a run, in the order it was produced
+type OutputChunk struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// RunEnd tells how a run ended, once its record has been received
+type RunEnd struct {
+	RecordID uint   `json:"recordId"`
+	Outcome  string `json:"outcome"`
+	Status   int    `json:"status"`
+}
@@ -12,17 +12,78 @@ type Schedule struct {
 	CronTasks       []CronTask       `json:"cron"`
 }
 
+const (
+	ConcurrencyAllow  = "allow"  // runs may overlap, default
+	ConcurrencyForbid = "forbid" // run is skipped if previous run of the entry is still in progress
+	ConcurrencyQueue  = "queue"  // run waits for previous run of the entry to finish, skipped if queue is full
+)
+
+// ConcurrencyPolicy defines what happens when a schedule entry fires while its previous run is still in progress
+type ConcurrencyPolicy struct {
+	Concurrency string `json:"concurrency,omitempty"` // ConcurrencyAllow | ConcurrencyForbid | ConcurrencyQueue
+	MaxQueue    int    `json:"maxQueue,omitempty"`    // how many runs may wait with ConcurrencyQueue, defaults to 1
+}
+
 type SingleshotTask struct {
 	When time.Time `json:"when"` // time.Parse(time.RFC3339Nano, ...)
 	What string    `json:"taskID"`
+	ConcurrencyPolicy
 }
 
 type PeriodicTask struct {
 	Interval json.Duration `json:"every"` // anything supported by http://golang.org/pkg/time/#ParseDuration
 	What     string        `json:"taskID"`
+	ConcurrencyPolicy
 }
 
 type CronTask struct {
 	When string `json:"cron"` // anything supported by default by https://github.com/robfig/cron
 	What string `json:"taskID"`
+	ConcurrencyPolicy
+}
+
+// Merge returns schedule with entries of both s and other.
+// Entries of other which are identical to an entry already in the schedule are left out,
+// so that a task scheduled the same way in both doesn't run twice.
+func (s Schedule) Merge(other Schedule) Schedule {
+	merged := Schedule{
+		SingleshotTasks: append([]SingleshotTask(nil), s.SingleshotTasks...),
+		PeriodicTasks:   append([]PeriodicTask(nil), s.PeriodicTasks...),
+		CronTasks:       append([]CronTask(nil), s.CronTasks...),
+	}
+	for _, t := range other.SingleshotTasks {
+		if !containsEntry(merged.SingleshotTasks, func(e SingleshotTask) bool {
+			// same instant may be given in different time zones
+			return e.What == t.What && e.When.Equal(t.When) && e.ConcurrencyPolicy == t.ConcurrencyPolicy
+		}) {
+			merged.SingleshotTasks = append(merged.SingleshotTasks, t)
+		}
+	}
+	for _, t := range other.PeriodicTasks {
+		if !containsEntry(merged.PeriodicTasks, func(e PeriodicTask) bool { return e == t }) {
+			merged.PeriodicTasks = append(merged.PeriodicTasks, t)
+		}
+	}
+	for _, t := range other.CronTasks {
+		if !containsEntry(merged.CronTasks, func(e CronTask) bool { return e == t }) {
+			merged.CronTasks = append(merged.CronTasks, t)
+		}
+	}
+	return merged
+}
+
+// Includes tells if any entry of the schedule runs task
+func (s Schedule) Includes(task string) bool {
+	return containsEntry(s.SingleshotTasks, func(e SingleshotTask) bool { return e.What == task }) ||
+		containsEntry(s.PeriodicTasks, func(e PeriodicTask) bool { return e.What == task }) ||
+		containsEntry(s.CronTasks, func(e CronTask) bool { return e.What == task })
+}
+
+func containsEntry[T any](entries []T, match func(T) bool) bool {
+	for _, e := range entries {
+		if match(e) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
+func TestScheduleMerge(t *testing.T) {
+	when := time.Date(2022, 4, 30, 10, 4, 0, 0, time.UTC)
+	own := Schedule{
+		SingleshotTasks: []SingleshotTask{{What: "task123", When: when}},
+		CronTasks:       []CronTask{{What: "task789", When: "0 */5 * * * *"}},
+	}
+	group := Schedule{
+		// same instant in another time zone
+		SingleshotTasks: []SingleshotTask{{What: "task123", When: when.In(time.FixedZone("EEST", 3*60*60))}},
+		PeriodicTasks:   []PeriodicTask{{What: "task456", Interval: json.Duration{Duration: time.Minute}}},
+		CronTasks: []CronTask{
+			{What: "task789", When: "0 */5 * * * *"},
+			{What: "task789", When: "0 */5 * * * *", ConcurrencyPolicy: ConcurrencyPolicy{Concurrency: ConcurrencyForbid}},
+		},
+	}
+
+	want := Schedule{
+		SingleshotTasks: []SingleshotTask{{What: "task123", When: when}},
+		PeriodicTasks:   []PeriodicTask{{What: "task456", Interval: json.Duration{Duration: time.Minute}}},
+		CronTasks: []CronTask{
+			{What: "task789", When: "0 */5 * * * *"},
+			{What: "task789", When: "0 */5 * * * *", ConcurrencyPolicy: ConcurrencyPolicy{Concurrency: ConcurrencyForbid}},
+		},
+	}
+	if got := own.Merge(group); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected merged schedule:\n%+v\nwant:\n%+v", got, want)
+	}
+	if len(own.CronTasks) != 1 {
+		t.Fatal("merge modified original schedule")
+	}
+}
+
+func TestScheduleIncludes(t *testing.T) {
+	s := Schedule{
+		PeriodicTasks: []PeriodicTask{{What: "task456", Interval: json.Duration{Duration: time.Minute}}},
+		CronTasks:     []CronTask{{What: "task789", When: "0 */5 * * * *"}},
+	}
+	if !s.Includes("task456") || !s.Includes("task789") {
+		t.Fatal("scheduled task not included")
+	}
+	if s.Includes("task123") {
+		t.Fatal("unscheduled task included")
+	}
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"strings"
+)
+
+// Scope limits what a user token can be used for, in addition to the role of its user.
+// Scopes are of form resource:action, and write access to a resource includes read access.
+type Scope string
+
+const (
+	ScopeOrganizationRead Scope = "organization:read"
+	ScopeUsersRead        Scope = "users:read"
+	ScopeMachinesRead     Scope = "machines:read"
+	ScopeMachinesWrite    Scope = "machines:write"
+	ScopeGroupsRead       Scope = "groups:read"
+	ScopeGroupsWrite      Scope = "groups:write"
+	ScopeSchedulesRead    Scope = "schedules:read"
+	ScopeSchedulesWrite   Scope = "schedules:write"
+	ScopeRecordsRead      Scope = "records:read"
+	ScopeRecordsWrite     Scope = "records:write"
+	ScopeTriggersRead     Scope = "triggers:read"
+	ScopeTriggersWrite    Scope = "triggers:write"
+	ScopeTasksRead        Scope = "tasks:read"
+	ScopeTasksWrite       Scope = "tasks:write"
+	ScopeSecretsRead      Scope = "secrets:read"
+	ScopeSecretsWrite     Scope = "secrets:write"
+)
+
+const (
+	scopeActionRead  = "read"
+	scopeActionWrite = "write"
+)
+
+var scopes = map[Scope]struct{}{
+	ScopeOrganizationRead: {},
+	ScopeUsersRead:        {},
+	ScopeMachinesRead:     {},
+	ScopeMachinesWrite:    {},
+	ScopeGroupsRead:       {},
+	ScopeGroupsWrite:      {},
+	ScopeSchedulesRead:    {},
+	ScopeSchedulesWrite:   {},
+	ScopeRecordsRead:      {},
+	ScopeRecordsWrite:     {},
+	ScopeTriggersRead:     {},
+	ScopeTriggersWrite:    {},
+	ScopeTasksRead:        {},
+	ScopeTasksWrite:       {},
+	ScopeSecretsRead:      {},
+	ScopeSecretsWrite:     {},
+}
+
+// Valid tells if s is one of the scopes tokens can be given
+func (s Scope) Valid() bool {
+	_, ok := scopes[s]
+	return ok
+}
+
+// Resource returns the part of s before colon, e.g. records for records:read
+func (s Scope) Resource() string {
+	resource, _ := s.split()
+	return resource
+}
+
+func (s Scope) split() (resource string, action string) {
+	i := strings.IndexByte(string(s), ':')
+	if i < 0 {
+		return string(s), ""
+	}
+	return string(s[:i]), string(s[i+1:])
+}
+
+// Allows tells if token with scope s can be used where required is needed
+func (s Scope) Allows(required Scope) bool {
+	if s == required {
+		return true
+	}
+	resource, action := s.split()
+	requiredResource, requiredAction := required.split()
+	return resource == requiredResource && action == scopeActionWrite && requiredAction == scopeActionRead
+}
+
+// ScopesAllow tells if any of granted allows required
+func ScopesAllow(granted []Scope, required Scope) bool {
+	for _, s := range granted {
+		if s.Allows(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatScopes joins scopes with spaces, like OAuth scope parameter
+func FormatScopes(s []Scope) string {
+	parts := make([]string, 0, len(s))
+	for _, scope := range s {
+		parts = append(parts, string(scope))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseScopes reverses FormatScopes
+func ParseScopes(s string) []Scope {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil
+	}
+	parsed := make([]Scope, 0, len(fields))
+	for _, f := range fields {
+		parsed = append(parsed, Scope(f))
+	}
+	return parsed
+}
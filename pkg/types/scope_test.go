@@ -0,0 +1,85 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := map[string]struct {
+		granted  []Scope
+		required Scope
+		want     bool
+	}{
+		"same scope": {
+			granted:  []Scope{ScopeRecordsRead},
+			required: ScopeRecordsRead,
+			want:     true,
+		},
+		"write includes read": {
+			granted:  []Scope{ScopeTasksWrite},
+			required: ScopeTasksRead,
+			want:     true,
+		},
+		"read doesn't include write": {
+			granted:  []Scope{ScopeRecordsRead},
+			required: ScopeRecordsWrite,
+			want:     false,
+		},
+		"other resource": {
+			granted:  []Scope{ScopeRecordsWrite, ScopeTasksRead},
+			required: ScopeMachinesRead,
+			want:     false,
+		},
+		"one of many": {
+			granted:  []Scope{ScopeRecordsRead, ScopeSchedulesWrite},
+			required: ScopeSchedulesRead,
+			want:     true,
+		},
+		"empty requirement": {
+			granted:  []Scope{ScopeRecordsWrite},
+			required: "",
+			want:     false,
+		},
+		"no scopes": {
+			granted:  nil,
+			required: ScopeRecordsRead,
+			want:     false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := ScopesAllow(tc.granted, tc.required); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScopeValid(t *testing.T) {
+	for _, s := range []Scope{ScopeRecordsRead, ScopeTasksWrite, ScopeSchedulesWrite} {
+		if !s.Valid() {
+			t.Fatal("valid scope refused:", s)
+		}
+	}
+	for _, s := range []Scope{"", "records", "records:delete", "users:write", "organization:write"} {
+		if s.Valid() {
+			t.Fatal("invalid scope accepted:", s)
+		}
+	}
+}
+
+func TestFormatScopes(t *testing.T) {
+	s := []Scope{ScopeRecordsRead, ScopeTasksWrite}
+	formatted := FormatScopes(s)
+	if formatted != "records:read tasks:write" {
+		t.Fatal("unexpected format:", formatted)
+	}
+	if parsed := ParseScopes(formatted); !reflect.DeepEqual(parsed, s) {
+		t.Fatal("unexpected scopes:", parsed)
+	}
+	if parsed := ParseScopes(""); parsed != nil {
+		t.Fatal("unexpected scopes:", parsed)
+	}
+}
@@ -0,0 +1,13 @@
+package types
+
+import (
+	"time"
+)
+
+// Secret is a named value which tasks can reference, and receive as an environment variable when run
+type Secret struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value,omitempty"` // never returned to users, only to machines running a task referencing it
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
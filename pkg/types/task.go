@@ -1,19 +1,34 @@
 package types
 
+import (
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
 type Task struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
-	Content     interface{} `json:"content"` // CmdTask | ScriptTask
+	Content     interface{} `json:"content"` // CmdTask | ScriptTask | HttpTask | MultiTask
 }
 
 const (
 	TaskTypeCmd    = "cmd"
 	TaskTypeScript = "script"
+	TaskTypeHttp   = "http"
+	TaskTypeMulti  = "multi"
 )
 
 type TaskProperties struct {
-	Type           string `json:"type"`
-	CombinedOutput bool   `json:"combinedOutput"`
+	Type            string        `json:"type"`
+	CombinedOutput  bool          `json:"combinedOutput"`
+	ContinueOnError bool          `json:"continueOnError,omitempty"` // only meaningful for actions of a MultiTask
+	Timeout         json.Duration `json:"timeout"`                   // zero means no timeout
+
+	// following are only meaningful for CmdTask and ScriptTask
+	Env        map[string]string `json:"env,omitempty"`        // added to environment inherited from taskeyd
+	WorkingDir string            `json:"workingDir,omitempty"` // absolute path, defaults to working directory of taskeyd
+	User       string            `json:"user,omitempty"`       // name or uid to run as, taskeyd must be running as root
+	Group      string            `json:"group,omitempty"`      // name or gid to run as, defaults to primary group of user
+	Secrets    []string          `json:"secrets,omitempty"`    // names of secrets set as environment variables when run
 }
 
 type CmdTask struct {
@@ -27,3 +42,20 @@ type ScriptTask struct {
 	Interpreter string `json:"interpreter"` // sh, bash, zsh, python, etc.
 	Script      string `json:"script"`
 }
+
+type HttpTask struct {
+	TaskProperties
+	Method         string            `json:"method"` // GET, POST, PUT, DELETE, etc.
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Form           map[string]string `json:"form,omitempty"` // sent url encoded, cannot be combined with body
+	Body           string            `json:"body,omitempty"`
+	ExpectedStatus []int             `json:"expectedStatus,omitempty"` // any 2xx if empty
+}
+
+// MultiTask runs its actions in order, stopping at the first failing action
+// unless that action has ContinueOnError set
+type MultiTask struct {
+	TaskProperties
+	Actions []interface{} `json:"actions"` // CmdTask | ScriptTask | HttpTask
+}
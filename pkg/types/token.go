@@ -1,5 +1,32 @@
 package types
 
+import (
+	"time"
+
+	"github.com/LassiHeikkila/taskey/pkg/json"
+)
+
 type MachineToken string
 
 type UserToken string
+
+// TokenInfo describes a user or machine token.
+// Value of the token is only returned when it's created, so it isn't included.
+type TokenInfo struct {
+	ID         uint       `json:"id"`
+	Label      string     `json:"label,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Expiration *time.Time `json:"expiration,omitempty"` // nil if token doesn't expire
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"` // nil if token hasn't been used
+	Scopes     []Scope    `json:"scopes,omitempty"`     // empty if token has all permissions of its user
+	Machines   []string   `json:"machines,omitempty"`   // names of machines token is limited to, empty if it isn't
+}
+
+// TokenRequest asks for a new user or machine token, which doesn't expire without ValidFor.
+// User tokens can be limited to Scopes, and further to Machines given by name.
+type TokenRequest struct {
+	Label    string        `json:"label,omitempty"`
+	ValidFor json.Duration `json:"validFor"`
+	Scopes   []Scope       `json:"scopes,omitempty"`
+	Machines []string      `json:"machines,omitempty"`
+}
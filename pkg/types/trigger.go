@@ -0,0 +1,22 @@
+package types
+
+import (
+	"time"
+)
+
+const (
+	TriggerStatePending = "pending" // waiting for machine to pick it up
+	TriggerStateRunning = "running" // machine has picked it up
+	TriggerStateDone    = "done"    // machine has reported a result
+	TriggerStateFailed  = "failed"  // machine never reported a result, see Trigger.Error
+)
+
+type Trigger struct {
+	ID          uint      `json:"id"`
+	MachineName string    `json:"machineName,omitempty"`
+	TaskName    string    `json:"taskName"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Record      *Record   `json:"record,omitempty"` // set once state is done
+	Error       string    `json:"error,omitempty"`  // why the trigger failed
+}